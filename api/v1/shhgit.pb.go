@@ -0,0 +1,538 @@
+// The gRPC API served on grpc.listen. The Go code in api/v1 is generated
+// from it with protoc-gen-go and protoc-gen-go-grpc:
+// protoc --go_out=. --go_opt=module=github.com/eth0izzle/shhgit --go-grpc_out=. --go-grpc_opt=module=github.com/eth0izzle/shhgit shhgit.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: shhgit.proto
+
+package shhgit
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Source int32
+
+const (
+	// for ScanTarget, infer the source from the URL's host
+	Source_LOCAL          Source = 0
+	Source_GITHUB         Source = 1
+	Source_GITHUB_COMMENT Source = 2
+	Source_GIST           Source = 3
+	Source_BITBUCKET      Source = 4
+	Source_GITLAB         Source = 5
+	Source_DOCKER         Source = 6
+	Source_GITEA          Source = 7
+	Source_AZURE_DEVOPS   Source = 8
+	Source_PASTE          Source = 9
+	Source_PACKAGE        Source = 10
+	Source_SVN            Source = 11
+	Source_ARCHIVE        Source = 12
+	Source_BUCKET         Source = 13
+)
+
+// Enum value maps for Source.
+var (
+	Source_name = map[int32]string{
+		0:  "LOCAL",
+		1:  "GITHUB",
+		2:  "GITHUB_COMMENT",
+		3:  "GIST",
+		4:  "BITBUCKET",
+		5:  "GITLAB",
+		6:  "DOCKER",
+		7:  "GITEA",
+		8:  "AZURE_DEVOPS",
+		9:  "PASTE",
+		10: "PACKAGE",
+		11: "SVN",
+		12: "ARCHIVE",
+		13: "BUCKET",
+	}
+	Source_value = map[string]int32{
+		"LOCAL":          0,
+		"GITHUB":         1,
+		"GITHUB_COMMENT": 2,
+		"GIST":           3,
+		"BITBUCKET":      4,
+		"GITLAB":         5,
+		"DOCKER":         6,
+		"GITEA":          7,
+		"AZURE_DEVOPS":   8,
+		"PASTE":          9,
+		"PACKAGE":        10,
+		"SVN":            11,
+		"ARCHIVE":        12,
+		"BUCKET":         13,
+	}
+)
+
+func (x Source) Enum() *Source {
+	p := new(Source)
+	*p = x
+	return p
+}
+
+func (x Source) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Source) Descriptor() protoreflect.EnumDescriptor {
+	return file_shhgit_proto_enumTypes[0].Descriptor()
+}
+
+func (Source) Type() protoreflect.EnumType {
+	return &file_shhgit_proto_enumTypes[0]
+}
+
+func (x Source) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Source.Descriptor instead.
+func (Source) EnumDescriptor() ([]byte, []int) {
+	return file_shhgit_proto_rawDescGZIP(), []int{0}
+}
+
+type StreamFindingsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// only stream findings for these signature names, or all if empty
+	Signatures []string `protobuf:"bytes,1,rep,name=signatures,proto3" json:"signatures,omitempty"`
+	// only stream findings of at least this severity, or all if empty
+	MinimumSeverity string `protobuf:"bytes,2,opt,name=minimum_severity,json=minimumSeverity,proto3" json:"minimum_severity,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *StreamFindingsRequest) Reset() {
+	*x = StreamFindingsRequest{}
+	mi := &file_shhgit_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamFindingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamFindingsRequest) ProtoMessage() {}
+
+func (x *StreamFindingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_shhgit_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamFindingsRequest.ProtoReflect.Descriptor instead.
+func (*StreamFindingsRequest) Descriptor() ([]byte, []int) {
+	return file_shhgit_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StreamFindingsRequest) GetSignatures() []string {
+	if x != nil {
+		return x.Signatures
+	}
+	return nil
+}
+
+func (x *StreamFindingsRequest) GetMinimumSeverity() string {
+	if x != nil {
+		return x.MinimumSeverity
+	}
+	return ""
+}
+
+type Finding struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Signature string                 `protobuf:"bytes,1,opt,name=signature,proto3" json:"signature,omitempty"`
+	Url       string                 `protobuf:"bytes,2,opt,name=url,proto3" json:"url,omitempty"`
+	File      string                 `protobuf:"bytes,3,opt,name=file,proto3" json:"file,omitempty"`
+	Matches   []string               `protobuf:"bytes,4,rep,name=matches,proto3" json:"matches,omitempty"`
+	Lines     []int32                `protobuf:"varint,5,rep,packed,name=lines,proto3" json:"lines,omitempty"`
+	Source    Source                 `protobuf:"varint,6,opt,name=source,proto3,enum=shhgit.v1.Source" json:"source,omitempty"`
+	Stars     int32                  `protobuf:"varint,7,opt,name=stars,proto3" json:"stars,omitempty"`
+	Verified  bool                   `protobuf:"varint,8,opt,name=verified,proto3" json:"verified,omitempty"`
+	Commit    string                 `protobuf:"bytes,9,opt,name=commit,proto3" json:"commit,omitempty"`
+	Entropy   float64                `protobuf:"fixed64,10,opt,name=entropy,proto3" json:"entropy,omitempty"`
+	// critical, high, medium or low
+	Severity string `protobuf:"bytes,11,opt,name=severity,proto3" json:"severity,omitempty"`
+	// high, medium or low. Verified matches are always high
+	Confidence string `protobuf:"bytes,12,opt,name=confidence,proto3" json:"confidence,omitempty"`
+	// the 1-based byte column and 0-based byte offset in the file of each
+	// match, alongside lines
+	Columns       []int32 `protobuf:"varint,13,rep,packed,name=columns,proto3" json:"columns,omitempty"`
+	Offsets       []int64 `protobuf:"varint,14,rep,packed,name=offsets,proto3" json:"offsets,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Finding) Reset() {
+	*x = Finding{}
+	mi := &file_shhgit_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Finding) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Finding) ProtoMessage() {}
+
+func (x *Finding) ProtoReflect() protoreflect.Message {
+	mi := &file_shhgit_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Finding.ProtoReflect.Descriptor instead.
+func (*Finding) Descriptor() ([]byte, []int) {
+	return file_shhgit_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Finding) GetSignature() string {
+	if x != nil {
+		return x.Signature
+	}
+	return ""
+}
+
+func (x *Finding) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Finding) GetFile() string {
+	if x != nil {
+		return x.File
+	}
+	return ""
+}
+
+func (x *Finding) GetMatches() []string {
+	if x != nil {
+		return x.Matches
+	}
+	return nil
+}
+
+func (x *Finding) GetLines() []int32 {
+	if x != nil {
+		return x.Lines
+	}
+	return nil
+}
+
+func (x *Finding) GetSource() Source {
+	if x != nil {
+		return x.Source
+	}
+	return Source_LOCAL
+}
+
+func (x *Finding) GetStars() int32 {
+	if x != nil {
+		return x.Stars
+	}
+	return 0
+}
+
+func (x *Finding) GetVerified() bool {
+	if x != nil {
+		return x.Verified
+	}
+	return false
+}
+
+func (x *Finding) GetCommit() string {
+	if x != nil {
+		return x.Commit
+	}
+	return ""
+}
+
+func (x *Finding) GetEntropy() float64 {
+	if x != nil {
+		return x.Entropy
+	}
+	return 0
+}
+
+func (x *Finding) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+func (x *Finding) GetConfidence() string {
+	if x != nil {
+		return x.Confidence
+	}
+	return ""
+}
+
+func (x *Finding) GetColumns() []int32 {
+	if x != nil {
+		return x.Columns
+	}
+	return nil
+}
+
+func (x *Finding) GetOffsets() []int64 {
+	if x != nil {
+		return x.Offsets
+	}
+	return nil
+}
+
+type ScanTarget struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Url           string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	Ref           string                 `protobuf:"bytes,2,opt,name=ref,proto3" json:"ref,omitempty"`
+	Source        Source                 `protobuf:"varint,3,opt,name=source,proto3,enum=shhgit.v1.Source" json:"source,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanTarget) Reset() {
+	*x = ScanTarget{}
+	mi := &file_shhgit_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanTarget) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanTarget) ProtoMessage() {}
+
+func (x *ScanTarget) ProtoReflect() protoreflect.Message {
+	mi := &file_shhgit_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanTarget.ProtoReflect.Descriptor instead.
+func (*ScanTarget) Descriptor() ([]byte, []int) {
+	return file_shhgit_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ScanTarget) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *ScanTarget) GetRef() string {
+	if x != nil {
+		return x.Ref
+	}
+	return ""
+}
+
+func (x *ScanTarget) GetSource() Source {
+	if x != nil {
+		return x.Source
+	}
+	return Source_LOCAL
+}
+
+type SubmitScanTargetResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Url           string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitScanTargetResponse) Reset() {
+	*x = SubmitScanTargetResponse{}
+	mi := &file_shhgit_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitScanTargetResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitScanTargetResponse) ProtoMessage() {}
+
+func (x *SubmitScanTargetResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_shhgit_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitScanTargetResponse.ProtoReflect.Descriptor instead.
+func (*SubmitScanTargetResponse) Descriptor() ([]byte, []int) {
+	return file_shhgit_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SubmitScanTargetResponse) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+var File_shhgit_proto protoreflect.FileDescriptor
+
+const file_shhgit_proto_rawDesc = "" +
+	"\n" +
+	"\fshhgit.proto\x12\tshhgit.v1\"b\n" +
+	"\x15StreamFindingsRequest\x12\x1e\n" +
+	"\n" +
+	"signatures\x18\x01 \x03(\tR\n" +
+	"signatures\x12)\n" +
+	"\x10minimum_severity\x18\x02 \x01(\tR\x0fminimumSeverity\"\xfc\x02\n" +
+	"\aFinding\x12\x1c\n" +
+	"\tsignature\x18\x01 \x01(\tR\tsignature\x12\x10\n" +
+	"\x03url\x18\x02 \x01(\tR\x03url\x12\x12\n" +
+	"\x04file\x18\x03 \x01(\tR\x04file\x12\x18\n" +
+	"\amatches\x18\x04 \x03(\tR\amatches\x12\x14\n" +
+	"\x05lines\x18\x05 \x03(\x05R\x05lines\x12)\n" +
+	"\x06source\x18\x06 \x01(\x0e2\x11.shhgit.v1.SourceR\x06source\x12\x14\n" +
+	"\x05stars\x18\a \x01(\x05R\x05stars\x12\x1a\n" +
+	"\bverified\x18\b \x01(\bR\bverified\x12\x16\n" +
+	"\x06commit\x18\t \x01(\tR\x06commit\x12\x18\n" +
+	"\aentropy\x18\n" +
+	" \x01(\x01R\aentropy\x12\x1a\n" +
+	"\bseverity\x18\v \x01(\tR\bseverity\x12\x1e\n" +
+	"\n" +
+	"confidence\x18\f \x01(\tR\n" +
+	"confidence\x12\x18\n" +
+	"\acolumns\x18\r \x03(\x05R\acolumns\x12\x18\n" +
+	"\aoffsets\x18\x0e \x03(\x03R\aoffsets\"[\n" +
+	"\n" +
+	"ScanTarget\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12\x10\n" +
+	"\x03ref\x18\x02 \x01(\tR\x03ref\x12)\n" +
+	"\x06source\x18\x03 \x01(\x0e2\x11.shhgit.v1.SourceR\x06source\",\n" +
+	"\x18SubmitScanTargetResponse\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url*\xbb\x01\n" +
+	"\x06Source\x12\t\n" +
+	"\x05LOCAL\x10\x00\x12\n" +
+	"\n" +
+	"\x06GITHUB\x10\x01\x12\x12\n" +
+	"\x0eGITHUB_COMMENT\x10\x02\x12\b\n" +
+	"\x04GIST\x10\x03\x12\r\n" +
+	"\tBITBUCKET\x10\x04\x12\n" +
+	"\n" +
+	"\x06GITLAB\x10\x05\x12\n" +
+	"\n" +
+	"\x06DOCKER\x10\x06\x12\t\n" +
+	"\x05GITEA\x10\a\x12\x10\n" +
+	"\fAZURE_DEVOPS\x10\b\x12\t\n" +
+	"\x05PASTE\x10\t\x12\v\n" +
+	"\aPACKAGE\x10\n" +
+	"\x12\a\n" +
+	"\x03SVN\x10\v\x12\v\n" +
+	"\aARCHIVE\x10\f\x12\n" +
+	"\n" +
+	"\x06BUCKET\x10\r2\xa2\x01\n" +
+	"\x06Shhgit\x12H\n" +
+	"\x0eStreamFindings\x12 .shhgit.v1.StreamFindingsRequest\x1a\x12.shhgit.v1.Finding0\x01\x12N\n" +
+	"\x10SubmitScanTarget\x12\x15.shhgit.v1.ScanTarget\x1a#.shhgit.v1.SubmitScanTargetResponseB+Z)github.com/eth0izzle/shhgit/api/v1;shhgitb\x06proto3"
+
+var (
+	file_shhgit_proto_rawDescOnce sync.Once
+	file_shhgit_proto_rawDescData []byte
+)
+
+func file_shhgit_proto_rawDescGZIP() []byte {
+	file_shhgit_proto_rawDescOnce.Do(func() {
+		file_shhgit_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_shhgit_proto_rawDesc), len(file_shhgit_proto_rawDesc)))
+	})
+	return file_shhgit_proto_rawDescData
+}
+
+var file_shhgit_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_shhgit_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_shhgit_proto_goTypes = []any{
+	(Source)(0),                      // 0: shhgit.v1.Source
+	(*StreamFindingsRequest)(nil),    // 1: shhgit.v1.StreamFindingsRequest
+	(*Finding)(nil),                  // 2: shhgit.v1.Finding
+	(*ScanTarget)(nil),               // 3: shhgit.v1.ScanTarget
+	(*SubmitScanTargetResponse)(nil), // 4: shhgit.v1.SubmitScanTargetResponse
+}
+var file_shhgit_proto_depIdxs = []int32{
+	0, // 0: shhgit.v1.Finding.source:type_name -> shhgit.v1.Source
+	0, // 1: shhgit.v1.ScanTarget.source:type_name -> shhgit.v1.Source
+	1, // 2: shhgit.v1.Shhgit.StreamFindings:input_type -> shhgit.v1.StreamFindingsRequest
+	3, // 3: shhgit.v1.Shhgit.SubmitScanTarget:input_type -> shhgit.v1.ScanTarget
+	2, // 4: shhgit.v1.Shhgit.StreamFindings:output_type -> shhgit.v1.Finding
+	4, // 5: shhgit.v1.Shhgit.SubmitScanTarget:output_type -> shhgit.v1.SubmitScanTargetResponse
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_shhgit_proto_init() }
+func file_shhgit_proto_init() {
+	if File_shhgit_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_shhgit_proto_rawDesc), len(file_shhgit_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_shhgit_proto_goTypes,
+		DependencyIndexes: file_shhgit_proto_depIdxs,
+		EnumInfos:         file_shhgit_proto_enumTypes,
+		MessageInfos:      file_shhgit_proto_msgTypes,
+	}.Build()
+	File_shhgit_proto = out.File
+	file_shhgit_proto_goTypes = nil
+	file_shhgit_proto_depIdxs = nil
+}
@@ -0,0 +1,177 @@
+// The gRPC API served on grpc.listen. The Go code in api/v1 is generated
+// from it with protoc-gen-go and protoc-gen-go-grpc:
+// protoc --go_out=. --go_opt=module=github.com/eth0izzle/shhgit --go-grpc_out=. --go-grpc_opt=module=github.com/eth0izzle/shhgit shhgit.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: shhgit.proto
+
+package shhgit
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Shhgit_StreamFindings_FullMethodName   = "/shhgit.v1.Shhgit/StreamFindings"
+	Shhgit_SubmitScanTarget_FullMethodName = "/shhgit.v1.Shhgit/SubmitScanTarget"
+)
+
+// ShhgitClient is the client API for Shhgit service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ShhgitClient interface {
+	// StreamFindings sends each new finding until the call is cancelled. A
+	// client that stops reading for grpc.send_timeout seconds has its stream
+	// ended with RESOURCE_EXHAUSTED
+	StreamFindings(ctx context.Context, in *StreamFindingsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Finding], error)
+	// SubmitScanTarget queues a repository, gist, image, package or archive
+	// to be scanned. Only available when watching public sources
+	SubmitScanTarget(ctx context.Context, in *ScanTarget, opts ...grpc.CallOption) (*SubmitScanTargetResponse, error)
+}
+
+type shhgitClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewShhgitClient(cc grpc.ClientConnInterface) ShhgitClient {
+	return &shhgitClient{cc}
+}
+
+func (c *shhgitClient) StreamFindings(ctx context.Context, in *StreamFindingsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Finding], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Shhgit_ServiceDesc.Streams[0], Shhgit_StreamFindings_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamFindingsRequest, Finding]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Shhgit_StreamFindingsClient = grpc.ServerStreamingClient[Finding]
+
+func (c *shhgitClient) SubmitScanTarget(ctx context.Context, in *ScanTarget, opts ...grpc.CallOption) (*SubmitScanTargetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitScanTargetResponse)
+	err := c.cc.Invoke(ctx, Shhgit_SubmitScanTarget_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ShhgitServer is the server API for Shhgit service.
+// All implementations must embed UnimplementedShhgitServer
+// for forward compatibility.
+type ShhgitServer interface {
+	// StreamFindings sends each new finding until the call is cancelled. A
+	// client that stops reading for grpc.send_timeout seconds has its stream
+	// ended with RESOURCE_EXHAUSTED
+	StreamFindings(*StreamFindingsRequest, grpc.ServerStreamingServer[Finding]) error
+	// SubmitScanTarget queues a repository, gist, image, package or archive
+	// to be scanned. Only available when watching public sources
+	SubmitScanTarget(context.Context, *ScanTarget) (*SubmitScanTargetResponse, error)
+	mustEmbedUnimplementedShhgitServer()
+}
+
+// UnimplementedShhgitServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedShhgitServer struct{}
+
+func (UnimplementedShhgitServer) StreamFindings(*StreamFindingsRequest, grpc.ServerStreamingServer[Finding]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamFindings not implemented")
+}
+func (UnimplementedShhgitServer) SubmitScanTarget(context.Context, *ScanTarget) (*SubmitScanTargetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitScanTarget not implemented")
+}
+func (UnimplementedShhgitServer) mustEmbedUnimplementedShhgitServer() {}
+func (UnimplementedShhgitServer) testEmbeddedByValue()                {}
+
+// UnsafeShhgitServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ShhgitServer will
+// result in compilation errors.
+type UnsafeShhgitServer interface {
+	mustEmbedUnimplementedShhgitServer()
+}
+
+func RegisterShhgitServer(s grpc.ServiceRegistrar, srv ShhgitServer) {
+	// If the following call pancis, it indicates UnimplementedShhgitServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Shhgit_ServiceDesc, srv)
+}
+
+func _Shhgit_StreamFindings_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamFindingsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ShhgitServer).StreamFindings(m, &grpc.GenericServerStream[StreamFindingsRequest, Finding]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Shhgit_StreamFindingsServer = grpc.ServerStreamingServer[Finding]
+
+func _Shhgit_SubmitScanTarget_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanTarget)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShhgitServer).SubmitScanTarget(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shhgit_SubmitScanTarget_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShhgitServer).SubmitScanTarget(ctx, req.(*ScanTarget))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Shhgit_ServiceDesc is the grpc.ServiceDesc for Shhgit service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Shhgit_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "shhgit.v1.Shhgit",
+	HandlerType: (*ShhgitServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitScanTarget",
+			Handler:    _Shhgit_SubmitScanTarget_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamFindings",
+			Handler:       _Shhgit_StreamFindings_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "shhgit.proto",
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eth0izzle/shhgit/core"
+)
+
+// runBench scans --corpus, or a synthetic corpus, and prints how fast the
+// signatures got through it, so changes to them or to the matching engine
+// can be measured. Returns the exit code
+func runBench() int {
+	var files []core.MatchFile
+	if *session.Options.Corpus != "" {
+		files = core.GetMatchingFiles(*session.Options.Corpus)
+		if len(files) == 0 {
+			session.Log.Error("No files to scan in %s", *session.Options.Corpus)
+			return 2
+		}
+	} else {
+		files = core.SyntheticCorpus(int64(*session.Options.CorpusSize) * 1024 * 1024)
+	}
+
+	result := session.Bench(files, *session.Options.Threads)
+
+	if *session.Options.Json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			session.Log.Error("Failed to write results: %s", err)
+			return 2
+		}
+
+		return 0
+	}
+
+	mb := float64(result.Bytes) / 1024 / 1024
+	fmt.Printf("Scanned %d files (%.1f MB) with %d signatures on %d %s in %s, using the %s backend\n\n", result.Files, mb, len(session.CurrentSignatures()), result.Threads, core.Pluralize(result.Threads, "thread", "threads"), result.Duration, result.Backend)
+	fmt.Printf("  %.0f files/sec\n", result.FilesPerSecond)
+	fmt.Printf("  %.2f MB/sec\n", result.MBPerSecond)
+	fmt.Printf("  %d matches\n", result.Matches)
+	fmt.Printf("  %d allocations (%.1f MB), %.0f per file\n\n", result.Allocations, float64(result.AllocatedBytes)/1024/1024, float64(result.Allocations)/float64(result.Files))
+
+	fmt.Printf("Each signature on its own, slowest first. Path signatures took %s together\n\n", result.PathSignatures)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIGNATURE\tTIME\tSHARE\tMB/SEC\tMATCHES\tALLOCATIONS")
+
+	for _, signature := range result.SignatureTimes {
+		speed := 0.0
+		if seconds := signature.Duration.Seconds(); seconds > 0 {
+			speed = mb / seconds
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%.1f%%\t%.2f\t%d\t%d\n", signature.Signature, signature.Duration, signature.Share*100, speed, signature.Matches, signature.Allocations)
+	}
+
+	w.Flush()
+	return 0
+}
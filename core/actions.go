@@ -0,0 +1,86 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const FormatGitHubActions = "github-actions"
+
+var (
+	actionsMessageEscaper  = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	actionsPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// GitHubActionsWriter prints a workflow command per match to stdout, which
+// GitHub Actions turns in to an annotation on the line of the file, shown in
+// the pull request diff. Paths are relative to the scanned directory, so scan
+// the root of the checkout
+type GitHubActionsWriter struct {
+	sync.Mutex
+
+	out io.Writer
+}
+
+func NewGitHubActionsWriter() *GitHubActionsWriter {
+	return &GitHubActionsWriter{out: os.Stdout}
+}
+
+func (w *GitHubActionsWriter) Write(event *MatchEvent) error {
+	path := strings.TrimPrefix(event.File, "/")
+	level := actionsLevel(event.Severity)
+	title := fmt.Sprintf("%s (%s severity)", event.Signature, event.Severity)
+
+	// matches are only redacted already with --redact-secrets, but workflow
+	// logs are seen by anyone who can read the repository
+	redact := !*session.Options.RedactSecrets
+
+	var commands strings.Builder
+	for i := 0; i == 0 || i < len(event.Matches); i++ {
+		properties := []string{"file=" + actionsPropertyEscaper.Replace(path)}
+		message := fmt.Sprintf("%s matches %s", path, event.Signature)
+
+		if i < len(event.Lines) && event.Lines[i] > 0 {
+			properties = append(properties, fmt.Sprintf("line=%d", event.Lines[i]))
+			if i < len(event.Columns) && event.Columns[i] > 0 {
+				properties = append(properties, fmt.Sprintf("col=%d", event.Columns[i]))
+			}
+		}
+
+		if i < len(event.Matches) {
+			match := event.Matches[i]
+			if redact {
+				match = Redact(match)
+			}
+			message = fmt.Sprintf("%s found: %s", event.Signature, match)
+		}
+
+		if event.Verified {
+			message += ". The secret is live, revoke it"
+		}
+
+		properties = append(properties, "title="+actionsPropertyEscaper.Replace(title))
+		fmt.Fprintf(&commands, "::%s %s::%s\n", level, strings.Join(properties, ","), actionsMessageEscaper.Replace(message))
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	_, err := io.WriteString(w.out, commands.String())
+	return err
+}
+
+// actionsLevel maps a severity to an annotation level
+func actionsLevel(severity string) string {
+	switch severity {
+	case SeverityCritical, SeverityHigh:
+		return "error"
+	case SeverityLow:
+		return "notice"
+	}
+
+	return "warning"
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	AllowlistFilename = ".shhgitignore"
+
+	allowlistFingerprintPrefix = "fingerprint:"
+	allowlistRegexPrefix       = "regex:"
+)
+
+// Allowlist suppresses known false positives. It is read from a
+// .shhgitignore-style file where each line is one of:
+//
+//	fingerprint:<hex>  a single finding, as written to a --baseline file
+//	regex:<pattern>    any matched value the pattern matches
+//	<glob>             files matching the glob. Globs without a slash match
+//	                   the file name anywhere, a trailing slash a directory
+type Allowlist struct {
+	Fingerprints map[string]bool
+	Globs        []string
+	Regexes      []*regexp.Regexp
+}
+
+func NewAllowlist() *Allowlist {
+	return &Allowlist{Fingerprints: make(map[string]bool)}
+}
+
+// Load adds the entries in the file at path to the allowlist
+func (a *Allowlist) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, allowlistFingerprintPrefix):
+			fingerprint := strings.Fields(strings.TrimPrefix(line, allowlistFingerprintPrefix))
+			if len(fingerprint) > 0 {
+				a.Fingerprints[fingerprint[0]] = true
+			}
+		case strings.HasPrefix(line, allowlistRegexPrefix):
+			regex, err := regexp.Compile(strings.TrimPrefix(line, allowlistRegexPrefix))
+			if err != nil {
+				return fmt.Errorf("%s:%d: %s", path, lineNumber, err)
+			}
+			a.Regexes = append(a.Regexes, regex)
+		default:
+			a.Globs = append(a.Globs, line)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// IsAllowed reports whether a match in the file at path, relative to the
+// repository root, is suppressed. A nil allowlist allows nothing
+func (a *Allowlist) IsAllowed(filePath string, match string) bool {
+	if a == nil {
+		return false
+	}
+
+	if a.Fingerprints[Fingerprint(filePath, match)] {
+		return true
+	}
+
+	for _, regex := range a.Regexes {
+		if regex.MatchString(match) {
+			return true
+		}
+	}
+
+	filePath = strings.TrimPrefix(filePath, "/")
+	for _, glob := range a.Globs {
+		if matchAllowlistGlob(glob, filePath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchAllowlistGlob(glob string, filePath string) bool {
+	glob = strings.TrimPrefix(glob, "/")
+
+	if strings.HasSuffix(glob, "/") {
+		for dir := path.Dir(filePath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if matched, _ := path.Match(strings.TrimSuffix(glob, "/"), dir); matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !strings.Contains(glob, "/") {
+		matched, _ := path.Match(glob, path.Base(filePath))
+		return matched
+	}
+
+	matched, _ := path.Match(glob, filePath)
+	return matched
+}
+
+// Fingerprint identifies a finding by the matched value and the path of the
+// file within the repository, so it is stable across clones and forks
+func Fingerprint(filePath string, match string) string {
+	return dedupKey(filePath, match)
+}
+
+// Fingerprints returns the fingerprint of each match in a file
+func Fingerprints(filePath string, matches []string) []string {
+	fingerprints := make([]string, len(matches))
+	for i, match := range matches {
+		fingerprints[i] = Fingerprint(filePath, match)
+	}
+
+	return fingerprints
+}
+
+// BaselineWriter records the fingerprint of every finding when creating a
+// --baseline file, in the same format as an allowlist
+type BaselineWriter struct {
+	sync.Mutex
+
+	file *os.File
+	seen map[string]bool
+}
+
+func NewBaselineWriter(path string) (*BaselineWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(file, "# %s baseline: findings present when it was created are not alerted on\n", Name)
+	return &BaselineWriter{file: file, seen: make(map[string]bool)}, nil
+}
+
+func (b *BaselineWriter) Add(filePath string, match string) {
+	b.Lock()
+	defer b.Unlock()
+
+	fingerprint := Fingerprint(filePath, match)
+	if b.seen[fingerprint] {
+		return
+	}
+
+	b.seen[fingerprint] = true
+	fmt.Fprintf(b.file, "%s%s %s\n", allowlistFingerprintPrefix, fingerprint, filePath)
+}
+
+func (b *BaselineWriter) Count() int {
+	b.Lock()
+	defer b.Unlock()
+
+	return len(b.seen)
+}
+
+// allowlistRoot is the directory to look for a .shhgitignore file in: the
+// scanned directory for --local or the repository for hooks. Public
+// repositories are never trusted to suppress their own findings
+func allowlistRoot(options *Options) string {
+	if options.Command == CommandHook {
+		return GetRepositoryRoot()
+	}
+
+	if info, err := os.Stat(*options.Local); err == nil && info.IsDir() {
+		return *options.Local
+	}
+
+	return ""
+}
+
+func (s *Session) InitAllowlist() {
+	s.Allowlist = NewAllowlist()
+
+	allowlistPath := *s.Options.Allowlist
+	if allowlistPath == "" {
+		if root := allowlistRoot(s.Options); root != "" && PathExists(filepath.Join(root, AllowlistFilename)) {
+			allowlistPath = filepath.Join(root, AllowlistFilename)
+		}
+	}
+
+	if allowlistPath != "" {
+		if err := s.Allowlist.Load(allowlistPath); err != nil {
+			s.Log.Fatal("Failed to load allowlist %s: %s", allowlistPath, err)
+		}
+	}
+
+	baselinePath := *s.Options.Baseline
+	if baselinePath == "" {
+		return
+	}
+
+	if PathExists(baselinePath) {
+		if err := s.Allowlist.Load(baselinePath); err != nil {
+			s.Log.Fatal("Failed to load baseline %s: %s", baselinePath, err)
+		}
+		return
+	}
+
+	baseline, err := NewBaselineWriter(baselinePath)
+	if err != nil {
+		s.Log.Fatal("Failed to create baseline %s: %s", baselinePath, err)
+	}
+
+	s.Baseline = baseline
+}
@@ -0,0 +1,688 @@
+package core
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix            = "/api/v1"
+	defaultApiPageSize   = 100
+	maximumApiPageSize   = 1000
+	apiFindingsPath      = apiPrefix + "/findings"
+	apiFindingStatusPath = "/status"
+	apiDateFormat        = "2006-01-02"
+	apiSessionPath       = apiPrefix + "/session"
+)
+
+// ScanSources are the names accepted for the source of an ad-hoc scan
+var ScanSources = map[string]GitResourceType{
+	"github":       GITHUB_SOURCE,
+	"gist":         GIST_SOURCE,
+	"gitlab":       GITLAB_SOURCE,
+	"bitbucket":    BITBUCKET_SOURCE,
+	"gitea":        GITEA_SOURCE,
+	"azure_devops": AZURE_DEVOPS_SOURCE,
+	"docker":       DOCKER_SOURCE,
+	"package":      PACKAGE_SOURCE,
+	"svn":          SVN_SOURCE,
+	"archive":      ARCHIVE_SOURCE,
+	"bucket":       BUCKET_SOURCE,
+}
+
+var (
+	ErrScanNotPublic = errors.New("scans can only be queued when watching public sources")
+	ErrScanQueueFull = errors.New("the queue is full, try again later")
+	errScanSource    = errors.New("source must be one of " + strings.Join(scanSourceNames(), ", "))
+)
+
+func scanSourceNames() []string {
+	names := make([]string, 0, len(ScanSources))
+	for name := range ScanSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// scanSourceHosts infers the source of an ad-hoc scan from its URL
+var scanSourceHosts = map[string]GitResourceType{
+	"github.com":      GITHUB_SOURCE,
+	"gist.github.com": GIST_SOURCE,
+	"gitlab.com":      GITLAB_SOURCE,
+	"bitbucket.org":   BITBUCKET_SOURCE,
+	"dev.azure.com":   AZURE_DEVOPS_SOURCE,
+}
+
+type ApiStats struct {
+	Uptime             float64
+	RepositoriesCloned float64
+	CloneFailures      float64
+	FilesScanned       float64
+	BytesProcessed     float64
+	Matches            float64
+	MatchesBySignature map[string]float64
+	Queued             map[string]int
+	Findings           map[string]int `json:",omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeApiError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// InitApi serves the API alongside /metrics. When api_tokens or web_auth
+// users are configured every request must carry a token or be logged in,
+// and may only do what the user's role allows
+func (s *Session) InitApi() {
+	if *s.Options.Listen != "" && !s.IsAuthRequired() {
+		s.Log.Warn("No api_tokens or web_auth configured. The API on %s is unauthenticated.", *s.Options.Listen)
+	}
+
+	s.ScanRequests = NewScanRequests()
+
+	s.Server.Handle(apiFindingsPath, s.authenticate(WebRoleViewer, s.requireDatabase(s.handleFindings)))
+	s.Server.Handle(apiFindingsPath+"/", s.authenticate(WebRoleTriager, s.requireDatabase(s.handleFindingStatus)))
+	s.Server.Handle(apiPrefix+"/signatures", s.authenticate(WebRoleViewer, s.handleSignatures))
+	s.Server.Handle(apiPrefix+"/signatures/stats", s.authenticate(WebRoleViewer, s.requireDatabase(s.handleSignatureStats)))
+	s.Server.Handle(apiPrefix+"/stats", s.authenticate(WebRoleViewer, s.handleStats))
+	s.Server.Handle(apiPrefix+"/stats/findings", s.authenticate(WebRoleViewer, s.requireDatabase(s.handleDashboardStats)))
+	s.Server.Handle(apiScanPath, s.authenticate(WebRoleAdmin, s.handleScan))
+	s.Server.Handle(apiScanPath+"/", s.authenticate(WebRoleViewer, s.handleScanRequest))
+	s.Server.HandleFunc(apiSessionPath, s.handleSession)
+	s.Server.HandleFunc(apiSessionPath+"/oidc", s.handleOidcLogin)
+	s.Server.HandleFunc(apiSessionPath+"/oidc/callback", s.handleOidcCallback)
+}
+
+// authenticate only lets users with at least role through
+func (s *Session) authenticate(role string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := s.RequestUser(r)
+		if user == nil {
+			s.writeUnauthorized(w)
+			return
+		} else if !user.Allows(role) {
+			writeApiError(w, http.StatusForbidden, "this needs the "+role+" role")
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+func (s *Session) writeUnauthorized(w http.ResponseWriter) {
+	methods := s.WebAuth.Methods()
+	if len(s.Config.ApiTokens) > 0 {
+		methods = append(methods, "token")
+	}
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="`+Name+`"`)
+	writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+		"error":   "log in or send a valid API token",
+		"methods": methods,
+	})
+}
+
+// IsAuthRequired reports whether the API needs a token or a logged in user
+func (s *Session) IsAuthRequired() bool {
+	return len(s.Config.ApiTokens) > 0 || s.Config.WebAuth.IsEnabled()
+}
+
+// RequestUser returns who made a request: the user logged in to the web UI,
+// or an admin if it carries one of the api_tokens or nothing is required.
+// nil if it's neither
+func (s *Session) RequestUser(r *http.Request) *WebUser {
+	if !s.IsAuthRequired() {
+		return &WebUser{Username: "anonymous", Role: WebRoleAdmin, Method: "none"}
+	}
+
+	if len(s.Config.ApiTokens) > 0 && s.IsAuthorized(r.Header.Get("Authorization")) {
+		return &WebUser{Username: "api", Role: WebRoleAdmin, Method: "token"}
+	}
+
+	return s.WebAuth.User(r)
+}
+
+// IsAuthorized checks an Authorization header carries one of the api_tokens,
+// or that none are configured
+func (s *Session) IsAuthorized(authorization string) bool {
+	if len(s.Config.ApiTokens) == 0 {
+		return true
+	}
+
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	for _, apiToken := range s.Config.ApiTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Session) requireDatabase(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Database == nil {
+			writeApiError(w, http.StatusServiceUnavailable, "findings are only stored with --database-url")
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// handleFindings lists findings newest first. ?status=, ?signature=,
+// ?severity=, ?source=, ?repository=, ?q=, ?since= and ?until= filter them,
+// and ?limit= and ?offset= page through them. The number of findings
+// matching the filters is returned in X-Total-Count
+func (s *Session) handleFindings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query, err := parseFindingsQuery(r.URL.Query())
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	findings, err := s.Database.GetFindings(query)
+	if err == nil {
+		var total int
+		if total, err = s.Database.CountMatchingFindings(query); err == nil {
+			w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		}
+	}
+
+	if err != nil {
+		s.Log.Error("Failed to get findings from database: %s", err)
+		writeApiError(w, http.StatusInternalServerError, "failed to get findings")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, findings)
+}
+
+// parseFindingsQuery reads the filters and page of a request for findings.
+// Dates are RFC 3339 or whole days, in which case until includes the day
+func parseFindingsQuery(values url.Values) (FindingsQuery, error) {
+	query := FindingsQuery{
+		Status:     values.Get("status"),
+		Signature:  values.Get("signature"),
+		Severity:   values.Get("severity"),
+		Repository: strings.TrimSpace(values.Get("repository")),
+		Search:     strings.TrimSpace(values.Get("q")),
+	}
+
+	if query.Status != "" && !IsFindingStatus(query.Status) {
+		return query, errors.New("status must be one of " + strings.Join(FindingStatuses, ", "))
+	}
+
+	if query.Severity != "" && !IsSeverity(query.Severity) {
+		return query, errors.New("severity must be one of " + strings.Join(Severities, ", "))
+	}
+
+	if name := values.Get("source"); name != "" {
+		for source, sourceName := range SourceNames {
+			if sourceName == name {
+				source := source
+				query.Source = &source
+			}
+		}
+
+		if query.Source == nil {
+			return query, errors.New("unknown source " + name)
+		}
+	}
+
+	for _, date := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		value := values.Get(date.name)
+		if value == "" {
+			continue
+		}
+
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			*date.value = t
+		} else if t, err := time.Parse(apiDateFormat, value); err == nil {
+			if date.name == "until" {
+				t = t.AddDate(0, 0, 1)
+			}
+			*date.value = t
+		} else {
+			return query, errors.New(date.name + " must be a date, i.e. 2020-01-31, or an RFC 3339 time")
+		}
+	}
+
+	query.Limit, _ = strconv.Atoi(values.Get("limit"))
+	if query.Limit <= 0 {
+		query.Limit = defaultApiPageSize
+	} else if query.Limit > maximumApiPageSize {
+		query.Limit = maximumApiPageSize
+	}
+
+	query.Offset, _ = strconv.Atoi(values.Get("offset"))
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	return query, nil
+}
+
+// handleFindingStatus triages a finding: PUT /api/v1/findings/<id>/status
+// with {"status": "confirmed"}
+func (s *Session) handleFindingStatus(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, apiFindingsPath+"/")
+	if !strings.HasSuffix(path, apiFindingStatusPath) {
+		writeApiError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimSuffix(path, apiFindingStatusPath), 10, 64)
+	if err != nil {
+		writeApiError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	body := struct {
+		Status string `json:"status"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !IsFindingStatus(body.Status) {
+		writeApiError(w, http.StatusBadRequest, "status must be one of "+strings.Join(FindingStatuses, ", "))
+		return
+	}
+
+	found, err := s.Database.SetFindingStatus(id, body.Status)
+	if err != nil {
+		s.Log.Error("Failed to set status of finding %d: %s", id, err)
+		writeApiError(w, http.StatusInternalServerError, "failed to set status")
+		return
+	} else if !found {
+		writeApiError(w, http.StatusNotFound, "finding not found")
+		return
+	}
+
+	s.Log.Info("Finding %d marked as %s", id, body.Status)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Session) handleSignatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.reloading.RLock()
+	signatures := s.Config.Signatures
+	s.reloading.RUnlock()
+
+	writeJSON(w, http.StatusOK, signatures)
+}
+
+// handleSignatureStats is the report of shhgit tune. ?days= sets the
+// period, 30 days by default
+func (s *Session) handleSignatureStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days < 0 {
+		days = defaultTuneDays
+	}
+
+	stats, err := s.Database.GetSignatureStats(days)
+	if err != nil {
+		s.Log.Error("Failed to get signature stats from database: %s", err)
+		writeApiError(w, http.StatusInternalServerError, "failed to get signature stats")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TuneSignatures(stats, s.CurrentSignatures()))
+}
+
+func (s *Session) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	stats := ApiStats{
+		Uptime:             time.Since(s.StartedAt).Seconds(),
+		RepositoriesCloned: s.Metrics.Total(MetricRepositoriesCloned),
+		CloneFailures:      s.Metrics.Total(MetricCloneFailures),
+		FilesScanned:       s.Metrics.Total(MetricFilesScanned),
+		BytesProcessed:     s.Metrics.Total(MetricBytesProcessed),
+		Matches:            s.Metrics.Total(MetricMatches),
+		MatchesBySignature: s.Metrics.ByLabel(MetricMatches, "signature"),
+		Queued: map[string]int{
+			"repositories": s.QueuedRepositories(),
+			"scans":        len(s.ScanJobs),
+			"findings":     len(s.findings),
+		},
+	}
+
+	if s.Database != nil {
+		counts, err := s.Database.CountFindings()
+		if err != nil {
+			s.Log.Error("Failed to count findings in database: %s", err)
+		}
+		stats.Findings = counts
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// handleDashboardStats aggregates the findings of the last ?days= (30 by
+// default) by ?interval=, hour or day. Hourly is the default for two days or
+// less
+func (s *Session) handleDashboardStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	days := defaultDashboardDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		var err error
+		if days, err = strconv.Atoi(value); err != nil || days < 1 || days > maximumDashboardDays {
+			writeApiError(w, http.StatusBadRequest, "days must be between 1 and "+strconv.Itoa(maximumDashboardDays))
+			return
+		}
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = DashboardDay
+		if days <= 2 {
+			interval = DashboardHour
+		}
+	}
+
+	if interval != DashboardHour && interval != DashboardDay {
+		writeApiError(w, http.StatusBadRequest, "interval must be hour or day")
+		return
+	} else if interval == DashboardHour && days > maximumDashboardHourlyDays {
+		writeApiError(w, http.StatusBadRequest, "hourly stats are only kept for up to "+strconv.Itoa(maximumDashboardHourlyDays)+" days")
+		return
+	}
+
+	stats, err := s.Database.GetDashboardStats(days, interval)
+	if err != nil {
+		s.Log.Error("Failed to get dashboard stats from database: %s", err)
+		writeApiError(w, http.StatusInternalServerError, "failed to get dashboard stats")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// handleScan queues an ad-hoc scan: POST /api/v1/scan with
+// {"url": "https://github.com/org/repo.git", "ref": "refs/heads/main"},
+// {"paste": "..."} to scan text, or a multipart form with the file of an
+// archive in "archive". source is required when it can't be told from the
+// URL's host. It returns the request, to poll for what it finds
+func (s *Session) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var (
+		request *ScanRequest
+		err     error
+	)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		request, err = s.queueScanUpload(w, r)
+	} else {
+		body := struct {
+			Url    string `json:"url"`
+			Ref    string `json:"ref"`
+			Source string `json:"source"`
+			Paste  string `json:"paste"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || (body.Url == "" && body.Paste == "") {
+			writeApiError(w, http.StatusBadRequest, "url or paste is required")
+			return
+		}
+
+		source, ok := ScanSources[body.Source]
+		if !ok && body.Source != "" {
+			writeApiError(w, http.StatusBadRequest, errScanSource.Error())
+			return
+		}
+
+		if body.Paste != "" {
+			request, err = s.QueueScanFiles(PASTE_SOURCE, "paste.ignore", []byte(body.Paste))
+		} else {
+			request, err = s.QueueScanTarget(GitResource{Type: source, Url: body.Url, Ref: body.Ref})
+		}
+	}
+
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusAccepted, request)
+	case ErrScanNotPublic:
+		writeApiError(w, http.StatusConflict, err.Error())
+	case ErrScanQueueFull:
+		writeApiError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeApiError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// queueScanUpload queues the archive uploaded with a scan request. Uploads
+// are held to --maximum-repository-size, as downloaded archives are
+func (s *Session) queueScanUpload(w http.ResponseWriter, r *http.Request) (*ScanRequest, error) {
+	maxSize := int64(*s.Options.MaximumRepositorySize) * 1024
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1024*1024)
+
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		return nil, errors.New("archive must be an uploaded file, of at most " + strconv.FormatInt(maxSize/1024, 10) + " KB")
+	}
+	defer file.Close()
+
+	if !IsArchive(header.Filename) {
+		return nil, errors.New("archive must be one of " + strings.Join(archiveExtensions, ", "))
+	}
+
+	contents, err := ioutil.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(contents)) > maxSize {
+		return nil, errors.New("archive exceeds the maximum size of " + strconv.FormatInt(maxSize/1024, 10) + " KB")
+	}
+
+	return s.QueueScanFiles(ARCHIVE_SOURCE, header.Filename, contents)
+}
+
+// handleScanRequest is what a scan request has found so far: GET
+// /api/v1/scan/<id>
+func (s *Session) handleScanRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	request, ok := s.ScanRequests.Get(strings.TrimPrefix(r.URL.Path, apiScanPath+"/"))
+	if !ok {
+		writeApiError(w, http.StatusNotFound, "no such scan request, or it finished over a day ago")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, request)
+}
+
+// QueueScanTarget queues a repository, gist, image, package or archive to be
+// scanned without waiting for the queue, returning the request to follow it
+// by. A LOCAL_SOURCE target has its source inferred from its URL
+func (s *Session) QueueScanTarget(target GitResource) (*ScanRequest, error) {
+	if !s.Options.IsPublicMode() {
+		return nil, ErrScanNotPublic
+	}
+
+	if target.Type == LOCAL_SOURCE {
+		target.Type = ScanTargetType(target.Url)
+	}
+
+	if !isScanSource(target.Type) {
+		return nil, errScanSource
+	}
+
+	request := newScanRequest(target.Url, target.Type)
+	s.ScanRequests.Add(request)
+
+	target.Priority = PriorityOnDemand
+	if !s.TryQueueRepository(target) {
+		s.ScanRequests.remove(request)
+		return nil, ErrScanQueueFull
+	}
+
+	s.Log.Info("Queued %s for scanning via the API", target.Url)
+	return request, nil
+}
+
+// ScanTargetType infers the source of a repository, gist, image, package or
+// archive to scan from its URL, LOCAL_SOURCE if it can't be told
+func ScanTargetType(targetUrl string) GitResourceType {
+	u, err := url.Parse(targetUrl)
+	if err != nil {
+		return LOCAL_SOURCE
+	}
+
+	switch {
+	case IsArchive(u.Path):
+		return ARCHIVE_SOURCE
+	case u.Scheme == "svn" || u.Scheme == "svn+ssh":
+		return SVN_SOURCE
+	case IsBucketUrl(targetUrl):
+		return BUCKET_SOURCE
+	default:
+		return scanSourceHosts[strings.ToLower(u.Hostname())]
+	}
+}
+
+// isScanSource reports whether an ad-hoc scan can be of the source
+func isScanSource(source GitResourceType) bool {
+	for _, s := range ScanSources {
+		if s == source {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleSession is who is logged in: GET returns the user, or a 401 listing
+// how to log in, POST with {"username": "...", "password": "..."} logs a
+// local user in, or with {"token": "..."} one of the api_tokens, and DELETE
+// logs them out. The session is kept in a cookie, which the live feed can
+// be authorized with too
+func (s *Session) handleSession(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if user := s.RequestUser(r); user != nil {
+			writeJSON(w, http.StatusOK, user)
+		} else {
+			s.writeUnauthorized(w)
+		}
+	case http.MethodPost:
+		body := struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Token    string `json:"token"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || (body.Username == "" && body.Token == "") {
+			writeApiError(w, http.StatusBadRequest, "username and password, or token, are required")
+			return
+		}
+
+		var user *WebUser
+		if body.Token != "" {
+			if len(s.Config.ApiTokens) > 0 && s.IsAuthorized("Bearer "+body.Token) {
+				user = s.WebAuth.newUser("api", WebRoleAdmin, "token")
+			}
+		} else {
+			user = s.WebAuth.Login(body.Username, body.Password)
+		}
+
+		if user == nil {
+			s.Log.Warn("Failed login to the web UI from %s", r.RemoteAddr)
+			writeApiError(w, http.StatusUnauthorized, "wrong username, password or token")
+			return
+		}
+
+		s.Log.Info("%s logged in to the web UI as %s", user.Username, user.Role)
+		http.SetCookie(w, s.WebAuth.Cookie(user, r))
+		writeJSON(w, http.StatusOK, user)
+	case http.MethodDelete:
+		http.SetCookie(w, s.WebAuth.ExpiredCookie(r))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleOidcLogin sends the user to log in with the OIDC provider
+func (s *Session) handleOidcLogin(w http.ResponseWriter, r *http.Request) {
+	if s.Config.WebAuth.Oidc.Issuer == "" {
+		writeApiError(w, http.StatusNotFound, "web_auth oidc isn't configured")
+		return
+	}
+
+	loginUrl, state, err := s.WebAuth.OidcLoginUrl(r)
+	if err != nil {
+		s.Log.Error("Failed to reach OIDC provider %s: %s", s.Config.WebAuth.Oidc.Issuer, err)
+		writeApiError(w, http.StatusBadGateway, "failed to reach the OIDC provider")
+		return
+	}
+
+	http.SetCookie(w, state)
+	http.Redirect(w, r, loginUrl, http.StatusFound)
+}
+
+// handleOidcCallback logs in the user the OIDC provider sent back, then
+// returns them to the web UI
+func (s *Session) handleOidcCallback(w http.ResponseWriter, r *http.Request) {
+	if s.Config.WebAuth.Oidc.Issuer == "" {
+		writeApiError(w, http.StatusNotFound, "web_auth oidc isn't configured")
+		return
+	}
+
+	user, err := s.WebAuth.OidcCallback(r)
+	if err != nil {
+		s.Log.Warn("Failed OIDC login to the web UI from %s: %s", r.RemoteAddr, err)
+		http.Error(w, "Failed to log in: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	s.Log.Info("%s logged in to the web UI as %s", user.Username, user.Role)
+	http.SetCookie(w, s.WebAuth.Cookie(user, r))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
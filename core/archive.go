@@ -0,0 +1,236 @@
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ArchivePathSeparator = "!/"
+
+	maximumArchiveDepth = 2
+)
+
+var archiveExtensions = []string{".zip", ".jar", ".war", ".ear", ".whl", ".tar", ".tar.gz", ".tgz", ".gem"}
+
+func IsArchive(filePath string) bool {
+	filePath = strings.ToLower(filePath)
+
+	for _, extension := range archiveExtensions {
+		if strings.HasSuffix(filePath, extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetMaximumFileSize returns the largest file at path that should be read,
+// in bytes. Archives have their own, larger limit with --scan-archives, as
+// do archives nested in one already being expanded (i.e. a package), and
+// documents with --extract-documents
+func GetMaximumFileSize(filePath string) int64 {
+	if IsArchive(filePath) && (*session.Options.ScanArchives || strings.Contains(filePath, ArchivePathSeparator)) {
+		return int64(*session.Options.MaximumArchiveSize * 1024)
+	}
+
+	if IsDocument(filePath) && *session.Options.ExtractDocuments {
+		return int64(*session.Options.MaximumArchiveSize * 1024)
+	}
+
+	return int64(*session.Options.MaximumFileSize * 1024)
+}
+
+// ExpandArchives returns files followed by the entries of any archives among
+// them, extracted in to memory. Entries are named after the archive, i.e.
+// /lib/app.jar!/config.properties, and nested archives are expanded too.
+// No more than --maximum-archive-size is extracted from each archive
+func ExpandArchives(files []MatchFile) []MatchFile {
+	return expandArchives(files, 0)
+}
+
+func expandArchives(files []MatchFile, depth int) []MatchFile {
+	if depth >= maximumArchiveDepth {
+		return files
+	}
+
+	expanded := files
+	for _, file := range files {
+		if !IsArchive(file.Path) {
+			continue
+		}
+
+		entries, err := readArchive(file)
+		if err != nil {
+			session.Log.Debug("Failed to read archive %s: %s", file.Path, err)
+		}
+
+		expanded = append(expanded, expandArchives(entries, depth+1)...)
+	}
+
+	return expanded
+}
+
+func readArchive(file MatchFile) ([]MatchFile, error) {
+	budget := int64(*session.Options.MaximumArchiveSize * 1024)
+	entries := make([]MatchFile, 0)
+
+	add := func(name string, size int64, open func() (io.ReadCloser, error)) error {
+		entryPath := file.Path + ArchivePathSeparator + strings.TrimPrefix(name, "/")
+		if size > GetMaximumFileSize(entryPath) || size > budget || IsSkippableFile(entryPath) {
+			return nil
+		}
+
+		reader, err := open()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		contents, err := ioutil.ReadAll(io.LimitReader(reader, size))
+		if err != nil {
+			return err
+		}
+
+		budget -= int64(len(contents))
+		entries = append(entries, MatchFile{
+			Path:      entryPath,
+			Filename:  path.Base(name),
+			Extension: path.Ext(name),
+			Contents:  contents,
+			Commit:    file.Commit,
+		})
+
+		return nil
+	}
+
+	lowerPath := strings.ToLower(file.Path)
+	isTar := strings.HasSuffix(lowerPath, ".tar") || strings.HasSuffix(lowerPath, ".gem")
+	if isTar || strings.HasSuffix(lowerPath, ".tar.gz") || strings.HasSuffix(lowerPath, ".tgz") {
+		var reader io.Reader = bytes.NewReader(file.Contents)
+
+		if !isTar {
+			gzipReader, err := gzip.NewReader(reader)
+			if err != nil {
+				return nil, err
+			}
+			defer gzipReader.Close()
+			reader = gzipReader
+		}
+
+		tarReader := tar.NewReader(reader)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				return entries, nil
+			} else if err != nil {
+				return entries, err
+			}
+
+			if header.Typeflag != tar.TypeReg {
+				continue
+			}
+
+			err = add(header.Name, header.Size, func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(tarReader), nil
+			})
+			if err != nil {
+				return entries, err
+			}
+		}
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(file.Contents), int64(len(file.Contents)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range zipReader.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		if err := add(entry.Name, int64(entry.UncompressedSize64), entry.Open); err != nil {
+			return entries, err
+		}
+	}
+
+	return entries, nil
+}
+
+// GetArchiveFiles downloads an archive in to memory and extracts it, along
+// with any archives inside it. Credentials for the host under
+// clone.credentials are sent, and archives larger than
+// --maximum-repository-size are skipped. Release assets are instead limited
+// to github_releases.max_asset_size, and those that aren't archives, such as
+// binaries, are returned as they are
+func GetArchiveFiles(session *Session, archiveUrl string, source GitResourceType, dir string) ([]MatchFile, error) {
+	req, err := http.NewRequest(http.MethodGet, archiveUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if username, token := session.Cloner.credentials(archiveUrl, source); token != "" {
+		req.SetBasicAuth(username, token)
+	}
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", archiveUrl, resp.Status)
+	}
+
+	maxSize := int64(*session.Options.MaximumRepositorySize) * 1024
+	if source == RELEASE_SOURCE {
+		maxSize = int64(session.Config.GitHubReleases.MaxAssetSize) * 1024
+	}
+
+	contents, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(contents)) > maxSize {
+		return nil, fmt.Errorf("archive exceeds the maximum size of %d KB", maxSize/1024)
+	}
+
+	filename := path.Base(resp.Request.URL.Path)
+	file := MatchFile{
+		Path:      filepath.ToSlash(filepath.Join(dir, filename)),
+		Filename:  filename,
+		Extension: path.Ext(filename),
+		Contents:  contents,
+	}
+
+	if source == RELEASE_SOURCE && !IsArchive(filename) {
+		return []MatchFile{file}, nil
+	}
+
+	return expandArchiveFile(file)
+}
+
+// expandArchiveFile extracts an archive and any archives inside it
+func expandArchiveFile(archive MatchFile) ([]MatchFile, error) {
+	entries, err := readArchive(archive)
+
+	// the archives themselves are expanded here, so leave them out
+	files := make([]MatchFile, 0, len(entries))
+	for _, file := range expandArchives(entries, 1) {
+		if !IsArchive(file.Path) {
+			files = append(files, file)
+		}
+	}
+
+	return files, err
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Attribution is the commit that added a finding, and who wrote and
+// committed it, so they can be asked about it
+type Attribution struct {
+	Commit         string    `json:"commit"`
+	AuthorName     string    `json:"author_name"`
+	AuthorEmail    string    `json:"author_email"`
+	CommitterName  string    `json:"committer_name"`
+	CommitterEmail string    `json:"committer_email"`
+	Time           time.Time `json:"time"`
+}
+
+// Attributor finds the commits findings in a repository came from, caching
+// commits and blames across the files of a scan. It isn't safe for
+// concurrent use
+type Attributor struct {
+	repository *git.Repository
+	commits    map[plumbing.Hash]*Attribution
+	blames     map[string]*git.BlameResult
+}
+
+func NewAttributor(repository *git.Repository) *Attributor {
+	if repository == nil {
+		return nil
+	}
+
+	return &Attributor{
+		repository: repository,
+		commits:    make(map[plumbing.Hash]*Attribution),
+		blames:     make(map[string]*git.BlameResult),
+	}
+}
+
+// Attribute returns the attribution of the finding on each of lines of a
+// file, or nil if none are known. With --blame each line is blamed, in the
+// commit a file from the history was added in or otherwise HEAD, and lines
+// changed since HEAD are left out. Without it, findings in the history are
+// attributed to the commit that added the file
+func (a *Attributor) Attribute(file MatchFile, repositoryPath string, lines []int) []*Attribution {
+	if a == nil || len(lines) == 0 {
+		return nil
+	}
+
+	var attributions []*Attribution
+	set := func(i int, attribution *Attribution) {
+		if attribution == nil {
+			return
+		}
+		if attributions == nil {
+			attributions = make([]*Attribution, len(lines))
+		}
+		attributions[i] = attribution
+	}
+
+	// entries of archives and notebooks aren't files in the repository
+	path := strings.TrimPrefix(repositoryPath, "/")
+	inRepository := !strings.Contains(path, ArchivePathSeparator)
+
+	if *session.Options.Blame && inRepository {
+		if blame := a.blame(file.Commit, path); blame != nil {
+			for i, line := range lines {
+				if line < 1 || line > len(blame.Lines) || !sameLine(blame.Lines[line-1].Text, file, line) {
+					continue
+				}
+
+				set(i, a.commit(blame.Lines[line-1].Hash))
+			}
+
+			return attributions
+		}
+	}
+
+	if file.Commit != "" {
+		attribution := a.commit(plumbing.NewHash(file.Commit))
+		for i := range lines {
+			set(i, attribution)
+		}
+	}
+
+	return attributions
+}
+
+// commit returns the attribution of a commit, or nil if it can't be read
+func (a *Attributor) commit(hash plumbing.Hash) *Attribution {
+	if attribution, ok := a.commits[hash]; ok {
+		return attribution
+	}
+
+	var attribution *Attribution
+	if commit, err := a.repository.CommitObject(hash); err == nil {
+		attribution = &Attribution{
+			Commit:         commit.Hash.String(),
+			AuthorName:     commit.Author.Name,
+			AuthorEmail:    commit.Author.Email,
+			CommitterName:  commit.Committer.Name,
+			CommitterEmail: commit.Committer.Email,
+			Time:           commit.Committer.When.UTC(),
+		}
+	}
+
+	a.commits[hash] = attribution
+	return attribution
+}
+
+// blame returns who last changed each line of a file as of a commit, or
+// HEAD for files of the working tree. Blames that fail, as they do at the
+// edge of a shallow clone, are nil
+func (a *Attributor) blame(commit string, path string) *git.BlameResult {
+	key := commit + ":" + path
+	if blame, ok := a.blames[key]; ok {
+		return blame
+	}
+
+	var blame *git.BlameResult
+	hash := plumbing.NewHash(commit)
+	if commit == "" {
+		if head, err := a.repository.Head(); err == nil {
+			hash = head.Hash()
+		}
+	}
+
+	if object, err := a.repository.CommitObject(hash); err == nil {
+		if result, err := git.Blame(object, path); err == nil {
+			blame = result
+		} else {
+			session.Log.Debug("Failed to blame %s in %s: %s", path, hash, err)
+		}
+	}
+
+	a.blames[key] = blame
+	return blame
+}
+
+// sameLine reports whether a blamed line is still the line of the file or
+// window being checked, rather than one edited since it was committed
+func sameLine(blamed string, file MatchFile, line int) bool {
+	contents := file.Contents
+	for i := file.Lines + 1; i < line; i++ {
+		newline := bytes.IndexByte(contents, '\n')
+		if newline < 0 {
+			return false
+		}
+		contents = contents[newline+1:]
+	}
+
+	if newline := bytes.IndexByte(contents, '\n'); newline >= 0 {
+		contents = contents[:newline]
+	}
+
+	// the first line of a window may start part way through
+	return strings.HasSuffix(strings.TrimRight(blamed, "\r\n"), strings.TrimRight(string(contents), "\r"))
+}
+
+// String describes who committed a finding, i.e. Jane Doe
+// <jane@example.com> in 1a2b3c4 on 2020-01-01T00:00:00Z
+func (attribution *Attribution) String() string {
+	commit := attribution.Commit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+
+	return fmt.Sprintf("%s <%s> in %s on %s", attribution.AuthorName, attribution.AuthorEmail, commit, attribution.Time.Format(time.RFC3339))
+}
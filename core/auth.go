@@ -0,0 +1,463 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+const (
+	WebRoleViewer  = "viewer"
+	WebRoleTriager = "triager"
+	WebRoleAdmin   = "admin"
+
+	sessionCookie          = "shhgit_session"
+	oidcStateCookie        = "shhgit_oidc_state"
+	defaultSessionHours    = 12
+	defaultOidcGroupsClaim = "groups"
+	oidcStateTtl           = 10 * time.Minute
+)
+
+// WebRoles are the roles users of the web UI and API can have, each allowed
+// everything the ones before it are
+var WebRoles = []string{WebRoleViewer, WebRoleTriager, WebRoleAdmin}
+
+// dummyPasswordHash is compared against when a user doesn't exist, so
+// logging in takes as long whether they do or not
+var dummyPasswordHash = []byte("$2a$10$tK1eWMpfnl0ohOMSOiAmte94wkCPIfV5Ly9Osap/bzXy1v6lzgIOy")
+
+// WebAuthConfig is how users log in to the web UI and API. Viewers can see
+// findings and the live feed, triagers can also triage findings and admins
+// can also queue scans
+type WebAuthConfig struct {
+	// key signing session cookies. Leave empty to generate one each start,
+	// logging everyone out on restart
+	SessionKey   string          `yaml:"session_key,omitempty"`
+	SessionHours int             `yaml:"session_hours"`
+	Users        []WebUserConfig `yaml:"users"`
+	Oidc         OidcConfig      `yaml:"oidc"`
+}
+
+// WebUserConfig is a local user, with a bcrypt hash of their password
+type WebUserConfig struct {
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"password_hash"`
+	Role         string `yaml:"role"`
+}
+
+// OidcConfig logs users in with an OpenID Connect provider, giving them the
+// highest role any of their email addresses or groups are listed under
+type OidcConfig struct {
+	Issuer       string `yaml:"issuer,omitempty"`
+	ClientId     string `yaml:"client_id,omitempty"`
+	ClientSecret string `yaml:"client_secret,omitempty"`
+	// the /api/v1/session/oidc/callback URL of the web UI, as registered
+	// with the provider
+	RedirectUrl string   `yaml:"redirect_url,omitempty"`
+	Scopes      []string `yaml:"scopes"`
+	// claim of the ID token listing the user's groups
+	GroupsClaim string              `yaml:"groups_claim,omitempty"`
+	Roles       map[string][]string `yaml:"roles"`
+	// role of users not listed under roles. Leave empty to turn them away
+	DefaultRole string `yaml:"default_role,omitempty"`
+}
+
+// WebUser is who made a request to the API, and what they may do
+type WebUser struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// how they logged in: password, oidc or token
+	Method string `json:"method"`
+	// unix time their session expires
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// WebAuth signs sessions and logs users in
+type WebAuth struct {
+	config WebAuthConfig
+	key    []byte
+
+	sync.Mutex
+	oidc *oauth2.Config
+}
+
+func IsWebRole(role string) bool {
+	return containsString(WebRoles, role)
+}
+
+func webRoleRank(role string) int {
+	for i, r := range WebRoles {
+		if r == role {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Allows reports whether the user's role includes role
+func (user *WebUser) Allows(role string) bool {
+	return user != nil && webRoleRank(user.Role) >= webRoleRank(role)
+}
+
+// IsEnabled reports whether users log in to the web UI
+func (config *WebAuthConfig) IsEnabled() bool {
+	return len(config.Users) > 0 || config.Oidc.Issuer != ""
+}
+
+// validate checks the users and OIDC settings, expanding their secrets
+func (config *WebAuthConfig) validate(secrets *secretExpander) error {
+	config.SessionKey = secrets.expand(config.SessionKey)
+	if config.SessionHours <= 0 {
+		config.SessionHours = defaultSessionHours
+	}
+
+	usernames := make([]string, 0, len(config.Users))
+	for i := range config.Users {
+		// not expanded, as bcrypt hashes are full of $
+		user := &config.Users[i]
+		if user.Username == "" || user.PasswordHash == "" {
+			return errors.New("web_auth users need a username and password_hash")
+		} else if containsString(usernames, user.Username) {
+			return errors.New("web_auth user " + user.Username + " is configured more than once")
+		} else if !IsWebRole(user.Role) {
+			return errors.New("web_auth user " + user.Username + " role must be one of " + strings.Join(WebRoles, ", "))
+		} else if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return errors.New("web_auth user " + user.Username + " password_hash isn't a bcrypt hash: " + err.Error())
+		}
+
+		usernames = append(usernames, user.Username)
+	}
+
+	oidc := &config.Oidc
+	if oidc.Issuer == "" {
+		return nil
+	}
+
+	oidc.ClientId = secrets.expand(oidc.ClientId)
+	oidc.ClientSecret = secrets.expand(oidc.ClientSecret)
+	oidc.Issuer = strings.TrimSuffix(oidc.Issuer, "/")
+
+	if oidc.ClientId == "" || oidc.RedirectUrl == "" {
+		return errors.New("web_auth oidc needs a client_id and redirect_url")
+	}
+
+	if oidc.GroupsClaim == "" {
+		oidc.GroupsClaim = defaultOidcGroupsClaim
+	}
+
+	for role := range oidc.Roles {
+		if !IsWebRole(role) {
+			return errors.New("web_auth oidc roles must be one of " + strings.Join(WebRoles, ", "))
+		}
+	}
+
+	if oidc.DefaultRole != "" && !IsWebRole(oidc.DefaultRole) {
+		return errors.New("web_auth oidc default_role must be one of " + strings.Join(WebRoles, ", "))
+	}
+
+	return nil
+}
+
+func (s *Session) InitWebAuth() {
+	config := s.Config.WebAuth
+	s.WebAuth = &WebAuth{config: config, key: []byte(config.SessionKey)}
+
+	if !s.IsAuthRequired() {
+		return
+	}
+
+	if len(s.WebAuth.key) == 0 {
+		s.WebAuth.key = make([]byte, 32)
+		if _, err := rand.Read(s.WebAuth.key); err != nil {
+			s.Log.Fatal("Failed to generate a session key: %s", err)
+		}
+
+		s.Log.Warn("No web_auth session_key configured. Everyone will be logged out when shhgit restarts.")
+	}
+}
+
+// Methods returns how users can log in, for the web UI to offer
+func (auth *WebAuth) Methods() []string {
+	methods := make([]string, 0)
+	if len(auth.config.Users) > 0 {
+		methods = append(methods, "password")
+	}
+	if auth.config.Oidc.Issuer != "" {
+		methods = append(methods, "oidc")
+	}
+
+	return methods
+}
+
+// Login checks a local user's password, returning nil if it's wrong
+func (auth *WebAuth) Login(username string, password string) *WebUser {
+	hash := dummyPasswordHash
+	role := ""
+	for _, user := range auth.config.Users {
+		if subtle.ConstantTimeCompare([]byte(user.Username), []byte(username)) == 1 {
+			hash, role = []byte(user.PasswordHash), user.Role
+		}
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || role == "" {
+		return nil
+	}
+
+	return auth.newUser(username, role, "password")
+}
+
+func (auth *WebAuth) newUser(username string, role string, method string) *WebUser {
+	expires := time.Now().Add(time.Duration(auth.config.SessionHours) * time.Hour)
+	return &WebUser{Username: username, Role: role, Method: method, Expires: expires.Unix()}
+}
+
+// Cookie signs a user's session into a cookie
+func (auth *WebAuth) Cookie(user *WebUser, r *http.Request) *http.Cookie {
+	payload, _ := json.Marshal(user)
+	value := base64.RawURLEncoding.EncodeToString(payload)
+
+	return auth.cookie(sessionCookie, value+"."+auth.sign(value), time.Unix(user.Expires, 0), r)
+}
+
+// ExpiredCookie clears the session cookie, logging the user out
+func (auth *WebAuth) ExpiredCookie(r *http.Request) *http.Cookie {
+	return auth.cookie(sessionCookie, "", time.Unix(0, 0), r)
+}
+
+func (auth *WebAuth) cookie(name string, value string, expires time.Time, r *http.Request) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		// sent on the redirect back from the OIDC provider, but not with
+		// requests made by other sites
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (auth *WebAuth) sign(value string) string {
+	mac := hmac.New(sha256.New, auth.key)
+	mac.Write([]byte(value))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// User returns the user whose session a request carries, or nil if it has
+// none, or it was tampered with or has expired. Local users get the role
+// they're configured with now, and are logged out if they're removed
+func (auth *WebAuth) User(r *http.Request) *WebUser {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || len(auth.key) == 0 {
+		return nil
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(auth.sign(parts[0]))) {
+		return nil
+	}
+
+	user := &WebUser{}
+	if !decodeJwtPart(parts[0], user) || time.Now().Unix() > user.Expires {
+		return nil
+	}
+
+	if user.Method == "password" {
+		user.Role = ""
+		for _, configured := range auth.config.Users {
+			if configured.Username == user.Username {
+				user.Role = configured.Role
+			}
+		}
+	}
+
+	if !IsWebRole(user.Role) {
+		return nil
+	}
+
+	return user
+}
+
+// oidcProvider discovers the endpoints of the OIDC provider, once it's first
+// logged in with
+func (auth *WebAuth) oidcProvider() (*oauth2.Config, error) {
+	auth.Lock()
+	defer auth.Unlock()
+
+	if auth.oidc != nil {
+		return auth.oidc, nil
+	}
+
+	config := auth.config.Oidc
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Get(config.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", config.Issuer, resp.Status)
+	}
+
+	discovery := struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, err
+	}
+
+	auth.oidc = &oauth2.Config{
+		ClientID:     config.ClientId,
+		ClientSecret: config.ClientSecret,
+		RedirectURL:  config.RedirectUrl,
+		Scopes:       append([]string{"openid", "email", "profile"}, config.Scopes...),
+		Endpoint:     oauth2.Endpoint{AuthURL: discovery.AuthorizationEndpoint, TokenURL: discovery.TokenEndpoint},
+	}
+
+	return auth.oidc, nil
+}
+
+// OidcLoginUrl returns where to send the user to log in with the OIDC
+// provider, and a cookie holding the state it must send them back with
+func (auth *WebAuth) OidcLoginUrl(r *http.Request) (string, *http.Cookie, error) {
+	provider, err := auth.oidcProvider()
+	if err != nil {
+		return "", nil, err
+	}
+
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		return "", nil, err
+	}
+
+	cookie := auth.cookie(oidcStateCookie, hex.EncodeToString(state), time.Now().Add(oidcStateTtl), r)
+	return provider.AuthCodeURL(cookie.Value), cookie, nil
+}
+
+// OidcCallback exchanges the code the provider sent the user back with for
+// their ID token. As it's received straight from the provider over TLS, its
+// claims are trusted without checking its signature
+func (auth *WebAuth) OidcCallback(r *http.Request) (*WebUser, error) {
+	state, err := r.Cookie(oidcStateCookie)
+	if err != nil || state.Value == "" || subtle.ConstantTimeCompare([]byte(state.Value), []byte(r.URL.Query().Get("state"))) != 1 {
+		return nil, errors.New("the login expired or didn't start here, try again")
+	}
+
+	if message := r.URL.Query().Get("error"); message != "" {
+		return nil, errors.New(message + ": " + r.URL.Query().Get("error_description"))
+	}
+
+	provider, err := auth.oidcProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	token, err := provider.Exchange(ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		return nil, err
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	parts := strings.Split(idToken, ".")
+	claims := make(map[string]interface{})
+	if len(parts) != 3 || !decodeJwtPart(parts[1], &claims) {
+		return nil, errors.New("the provider didn't return an ID token")
+	}
+
+	if err := auth.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	// users can often set their own email address and username, so only a
+	// verified address is trusted to say who they are
+	username := ""
+	if verified, _ := claims["email_verified"].(bool); verified {
+		username = claimString(claims, "email")
+	}
+	if username == "" {
+		username = claimString(claims, "sub")
+	}
+	if username == "" {
+		return nil, errors.New("the ID token has no subject")
+	}
+
+	role := auth.oidcRole(username, claimStrings(claims, auth.config.Oidc.GroupsClaim))
+	if role == "" {
+		return nil, errors.New(username + " isn't allowed to use shhgit")
+	}
+
+	return auth.newUser(username, role, "oidc"), nil
+}
+
+func (auth *WebAuth) checkClaims(claims map[string]interface{}) error {
+	if strings.TrimSuffix(claimString(claims, "iss"), "/") != auth.config.Oidc.Issuer {
+		return errors.New("the ID token is from another issuer")
+	}
+
+	if !containsString(claimStrings(claims, "aud"), auth.config.Oidc.ClientId) {
+		return errors.New("the ID token is for another client")
+	}
+
+	if expires, ok := claims["exp"].(float64); !ok || time.Now().Unix() > int64(expires) {
+		return errors.New("the ID token has expired")
+	}
+
+	return nil
+}
+
+// oidcRole returns the highest role the user's verified email address,
+// subject or groups are listed under, or the default role
+func (auth *WebAuth) oidcRole(username string, groups []string) string {
+	role := auth.config.Oidc.DefaultRole
+	for r, members := range auth.config.Oidc.Roles {
+		if webRoleRank(r) > webRoleRank(role) && (containsAnyFold(members, []string{username}) || containsAnyFold(members, groups)) {
+			role = r
+		}
+	}
+
+	return role
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// claimStrings returns a claim that's a string or a list of them
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
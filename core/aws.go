@@ -0,0 +1,258 @@
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	awsDateFormat = "20060102T150405Z"
+
+	awsEcsCredentialsHost = "http://169.254.170.2"
+	awsImdsUrl            = "http://169.254.169.254/latest"
+	// refresh temporary credentials this long before they expire
+	awsCredentialsExpiryWindow = 5 * time.Minute
+)
+
+var awsMetadataClient = &http.Client{Timeout: 2 * time.Second}
+
+// AWSCredentials are an access key, with a session token if temporary
+type AWSCredentials struct {
+	AccessKeyId     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// SignAWSRequest signs req in place using AWS Signature Version 4. The body
+// must be the exact payload that will be sent with the request.
+func SignAWSRequest(req *http.Request, body []byte, credentials AWSCredentials, region string, service string) {
+	now := time.Now().UTC()
+	amzDate := now.Format(awsDateFormat)
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+	req.Header.Set("Host", req.URL.Host)
+
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headerNames := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		headerNames = append(headerNames, strings.ToLower(name))
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		// AWS expects spaces escaped as %20, not +
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", credentials.AccessKeyId, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// AWSCredentialsProvider finds credentials the way the AWS SDKs do: the
+// configured access key, then AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+// then the ECS task role and finally the EC2 instance role. Temporary
+// credentials are cached until shortly before they expire
+type AWSCredentialsProvider struct {
+	sync.Mutex
+
+	static AWSCredentials
+	cached AWSCredentials
+}
+
+func NewAWSCredentialsProvider(accessKeyId string, secretAccessKey string, sessionToken string) *AWSCredentialsProvider {
+	return &AWSCredentialsProvider{static: AWSCredentials{AccessKeyId: accessKeyId, SecretAccessKey: secretAccessKey, SessionToken: sessionToken}}
+}
+
+func (p *AWSCredentialsProvider) Get() (AWSCredentials, error) {
+	if p.static.AccessKeyId != "" {
+		return p.static, nil
+	}
+
+	if accessKeyId := os.Getenv("AWS_ACCESS_KEY_ID"); accessKeyId != "" {
+		return AWSCredentials{AccessKeyId: accessKeyId, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.cached.AccessKeyId != "" && time.Until(p.cached.Expiration) > awsCredentialsExpiryWindow {
+		return p.cached, nil
+	}
+
+	credentials, err := getAWSRoleCredentials()
+	if err != nil {
+		return credentials, fmt.Errorf("no AWS credentials configured, and none from an ECS task or EC2 instance role: %s", err)
+	}
+
+	p.cached = credentials
+	return credentials, nil
+}
+
+func getAWSRoleCredentials() (AWSCredentials, error) {
+	credentials := AWSCredentials{}
+
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return credentials, getAWSMetadata(http.MethodGet, awsEcsCredentialsHost+uri, nil, &credentials)
+	}
+
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); uri != "" {
+		headers := map[string]string{"Authorization": os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")}
+		return credentials, getAWSMetadata(http.MethodGet, uri, headers, &credentials)
+	}
+
+	// IMDSv2, which needs a session token first
+	var token string
+	if err := getAWSMetadata(http.MethodPut, awsImdsUrl+"/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "21600"}, &token); err != nil {
+		return credentials, err
+	}
+
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+	var role string
+	if err := getAWSMetadata(http.MethodGet, awsImdsUrl+"/meta-data/iam/security-credentials/", headers, &role); err != nil {
+		return credentials, err
+	}
+
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	if role == "" {
+		return credentials, errors.New("the EC2 instance has no role")
+	}
+
+	return credentials, getAWSMetadata(http.MethodGet, awsImdsUrl+"/meta-data/iam/security-credentials/"+role, headers, &credentials)
+}
+
+// getAWSMetadata requests a metadata endpoint, decoding JSON in to dst or
+// returning the body as is if dst is a string
+func getAWSMetadata(method string, url string, headers map[string]string, dst interface{}) error {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := awsMetadataClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if s, ok := dst.(*string); ok {
+		*s = string(body)
+		return nil
+	}
+
+	return json.Unmarshal(body, dst)
+}
+
+// awsQueryAttribute is a message attribute of an SQS message or SNS
+// notification, for subscription filter policies and routing
+type awsQueryAttribute struct {
+	Name  string
+	Value string
+}
+
+// postAWSAction calls an action of a query API such as SQS or SNS,
+// retrying throttling and server errors
+func postAWSAction(client *http.Client, credentials *AWSCredentialsProvider, endpoint string, region string, service string, params url.Values) error {
+	body := []byte(params.Encode())
+
+	return PostWithRetry(client, defaultWebhookRetries, func() (*http.Request, error) {
+		creds, err := credentials.Get()
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		req.Header.Set("User-Agent", fmt.Sprintf("%s v%s", Name, Version))
+		SignAWSRequest(req, body, creds, region, service)
+
+		return req, nil
+	})
+}
+
+// findingAttributes are the attributes findings are sent with, so
+// subscribers can filter on them without parsing the body
+func findingAttributes(event *MatchEvent) []awsQueryAttribute {
+	return []awsQueryAttribute{
+		{"signature", event.Signature},
+		{"severity", event.Severity},
+		{"source", SourceNames[event.Source]},
+	}
+}
+
+// findingDeduplicationId identifies a finding to FIFO queues and topics,
+// which drop messages sent again with the same id within five minutes
+func findingDeduplicationId(event *MatchEvent) string {
+	return sha256Hex([]byte(strings.Join(append([]string{event.Url, event.File}, event.Fingerprints...), ",")))
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	VerifierAws = "aws"
+
+	defaultAwsLoneSeverity   = SeverityMedium
+	defaultAwsLoneConfidence = ConfidenceLow
+
+	// secret access keys are base64 of 30 random bytes, far above the
+	// entropy of words and paths of the same length
+	awsSecretKeyMinimumEntropy = 4.0
+	// most secret access keys tried against each access key ID
+	maxAwsSecretKeys = 5
+)
+
+// a 40 character base64 word, not part of a longer one
+var awsSecretKeyCandidateRegex = regexp.MustCompile(`(?:^|[^A-Za-z0-9/+=])([A-Za-z0-9/+]{40})(?:[^A-Za-z0-9/+=]|$)`)
+
+// AwsPairsConfig correlates AWS access key IDs with the secret access keys
+// they're found with, as a key ID on its own can't be used
+type AwsPairsConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	LoneSeverity   string `yaml:"lone_severity,omitempty"`
+	LoneConfidence string `yaml:"lone_confidence,omitempty"`
+}
+
+// AwsSecretKeys are the plausible secret access keys in the files of each
+// commit, to pair with key IDs in other files of the same commit
+type AwsSecretKeys map[string][]string
+
+// IndexAwsSecretKeys finds the secret access keys in files from the history,
+// by the commit that added them. Files read a window at a time aren't
+// indexed, nor are files of the working tree, which have no commit
+func IndexAwsSecretKeys(files []MatchFile) AwsSecretKeys {
+	index := make(AwsSecretKeys)
+
+	for _, file := range files {
+		if file.Commit == "" || file.Lazy {
+			continue
+		}
+
+		for _, secret := range FindAwsSecretKeys(file.Contents) {
+			if !containsString(index[file.Commit], secret) {
+				index[file.Commit] = append(index[file.Commit], secret)
+			}
+		}
+	}
+
+	return index
+}
+
+// FindAwsSecretKeys returns the secret access keys in contents: values
+// assigned to a name containing secret, then other 40 character base64 words
+// random enough to be one
+func FindAwsSecretKeys(contents []byte) []string {
+	secrets := make([]string, 0)
+	add := func(secret string) {
+		if !containsString(secrets, secret) && !IsBlacklistedMatch(secret) {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	for _, match := range awsSecretKeyRegex.FindAllSubmatch(contents, -1) {
+		add(string(match[1]))
+	}
+
+	for _, match := range awsSecretKeyCandidateRegex.FindAllSubmatch(contents, -1) {
+		if candidate := string(match[1]); isPlausibleAwsSecretKey(candidate) {
+			add(candidate)
+		}
+	}
+
+	return secrets
+}
+
+// isPlausibleAwsSecretKey reports whether a 40 character word could be a
+// secret access key rather than a path, a hex digest or the like
+func isPlausibleAwsSecretKey(candidate string) bool {
+	if hexTokenRegex.MatchString(candidate) || strings.Contains(candidate, "//") {
+		return false
+	}
+
+	var upper, lower, digit bool
+	for _, c := range candidate {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+	}
+
+	return upper && lower && digit && GetEntropy(candidate) >= awsSecretKeyMinimumEntropy
+}
+
+// PairAwsCredentials rates a finding of AWS access key IDs by whether a
+// secret access key is in the same file or commit. Paired key IDs are at
+// least high severity with high confidence, and their secrets are returned
+// to verify them with. Lone key IDs are aws_pairs.lone_severity and
+// lone_confidence
+func (s *Session) PairAwsCredentials(file MatchFile, index AwsSecretKeys, severity string, confidence string) (string, string, []string) {
+	secrets := FindAwsSecretKeys(file.Contents)
+	if file.Commit != "" {
+		for _, secret := range index[file.Commit] {
+			if !containsString(secrets, secret) {
+				secrets = append(secrets, secret)
+			}
+		}
+	}
+
+	if !s.Config.AwsPairs.Enabled {
+		return severity, confidence, secrets
+	}
+
+	if len(secrets) == 0 {
+		severity, confidence = s.Config.AwsPairs.LoneSeverity, s.Config.AwsPairs.LoneConfidence
+		if severity == "" {
+			severity = defaultAwsLoneSeverity
+		}
+		if confidence == "" {
+			confidence = defaultAwsLoneConfidence
+		}
+
+		return severity, confidence, nil
+	}
+
+	if !AtLeastSeverity(severity, SeverityHigh) {
+		severity = SeverityHigh
+	}
+
+	return severity, ConfidenceHigh, secrets
+}
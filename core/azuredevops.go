@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const azureDevOpsApiVersion = "7.0"
+
+type AzureDevOpsRepository struct {
+	Id         string `json:"id"`
+	RemoteUrl  string `json:"remoteUrl"`
+	IsDisabled bool   `json:"isDisabled"`
+	Size       int64  `json:"size"` // in bytes
+	Project    struct {
+		Name string `json:"name"`
+	} `json:"project"`
+}
+
+type AzureDevOpsPush struct {
+	PushId     int64 `json:"pushId"`
+	RefUpdates []struct {
+		Name        string `json:"name"`
+		NewObjectId string `json:"newObjectId"`
+	} `json:"refUpdates"`
+}
+
+func azureDevOpsRequest(session *Session, path string, query url.Values) (*http.Request, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("api-version", azureDevOpsApiVersion)
+
+	endpoint := fmt.Sprintf("%s/%s%s?%s", strings.TrimRight(session.Config.AzureDevOps.Url, "/"), url.PathEscape(session.Config.AzureDevOps.Organization), path, query.Encode())
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// PATs are sent as the password with any username
+	req.SetBasicAuth("", session.Config.AzureDevOps.AccessToken)
+
+	return req, nil
+}
+
+// azureDevOpsCloneUrl drops the organisation name Azure DevOps puts in the
+// userinfo of clone URLs, as the PAT is given to the clone separately
+func azureDevOpsCloneUrl(remoteUrl string) string {
+	u, err := url.Parse(remoteUrl)
+	if err != nil {
+		return remoteUrl
+	}
+
+	u.User = nil
+	return u.String()
+}
+
+// GetAzureDevOpsRepositories enumerates the repositories in the organisation
+// (or just the configured projects), scanning each once when first seen,
+// then polls their pushes and queues every branch pushed to since
+func GetAzureDevOpsRepositories(session *Session) {
+	config := session.Config.AzureDevOps
+	if config.Organization == "" || config.AccessToken == "" {
+		session.Log.Warn("Azure DevOps requires an organization and access token. Skipping Azure DevOps.")
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	projects := map[string]bool{}
+	for _, project := range config.Projects {
+		projects[strings.ToLower(project)] = true
+	}
+
+	// the newest push seen for each repository
+	lastPushes := map[string]int64{}
+	limiter := session.RateLimiters[AZURE_DEVOPS_SOURCE]
+
+	for poller := session.NewPoller(PollingAzureDevOps); ; {
+		result := struct {
+			Value []AzureDevOpsRepository `json:"value"`
+		}{}
+		req, err := azureDevOpsRequest(session, "/_apis/git/repositories", nil)
+		if err == nil {
+			err = GetJSON(limiter, req, &result)
+		}
+
+		if err != nil {
+			session.Log.Warn("Error getting Azure DevOps repositories: %s... trying again", err)
+		}
+
+		for _, repository := range result.Value {
+			if repository.IsDisabled || (len(projects) > 0 && !projects[strings.ToLower(repository.Project.Name)]) {
+				continue
+			}
+
+			cloneUrl := azureDevOpsCloneUrl(repository.RemoteUrl)
+			pushes := struct {
+				Value []AzureDevOpsPush `json:"value"`
+			}{}
+			path := fmt.Sprintf("/%s/_apis/git/repositories/%s/pushes", url.PathEscape(repository.Project.Name), repository.Id)
+			req, err := azureDevOpsRequest(session, path, url.Values{"$top": {strconv.Itoa(poller.PageSize())}, "searchCriteria.includeRefUpdates": {"true"}})
+			if err == nil {
+				err = GetJSON(limiter, req, &pushes)
+			}
+
+			if err != nil {
+				session.Log.Debug("Failed to retrieve Azure DevOps pushes for %s: %s", cloneUrl, err)
+				continue
+			}
+
+			lastPush, seen := lastPushes[repository.Id]
+			lastPushes[repository.Id] = lastPush
+
+			for _, push := range pushes.Value {
+				if push.PushId > lastPushes[repository.Id] {
+					lastPushes[repository.Id] = push.PushId
+				}
+
+				if !seen || push.PushId <= lastPush {
+					continue
+				}
+
+				for _, update := range push.RefUpdates {
+					// branch deletions
+					if strings.Trim(update.NewObjectId, "0") == "" {
+						continue
+					}
+
+					session.Repositories <- GitResource{
+						Id:   push.PushId,
+						Type: AZURE_DEVOPS_SOURCE,
+						Url:  cloneUrl,
+						Ref:  update.Name,
+						Size: repository.Size / 1024,
+					}
+				}
+			}
+
+			if !seen {
+				session.Repositories <- GitResource{
+					Type: AZURE_DEVOPS_SOURCE,
+					Url:  cloneUrl,
+					Size: repository.Size / 1024,
+				}
+			}
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"bytes"
+)
+
+const (
+	// a NUL byte this near the start marks a file as binary, as git decides
+	binarySniffSize = 8000
+	// shorter runs of printable characters in binary files are mostly noise
+	minimumStringLength = 6
+)
+
+// IsBinary returns whether a file, or a window of one, looks to be binary,
+// such as an object file, .pyc or sqlite database, rather than text
+func (match MatchFile) IsBinary() bool {
+	sniff := match.Contents
+	if len(sniff) > binarySniffSize {
+		sniff = sniff[:binarySniffSize]
+	}
+
+	return bytes.IndexByte(sniff, 0) >= 0
+}
+
+// ExtractStrings returns the contents of a binary file with everything but
+// runs of printable ASCII at least minimumStringLength long replaced by
+// newlines, like strings(1). Each string is then a line of its own and
+// offsets are unchanged, so findings point in to the binary. A run at the
+// end of a window is left for the next window to decide on
+func (match MatchFile) ExtractStrings() []byte {
+	contents := match.Contents
+	extracted := make([]byte, len(contents))
+
+	start := 0
+	for i := 0; i <= len(contents); i++ {
+		if i < len(contents) && isPrintable(contents[i]) {
+			continue
+		}
+
+		if i-start >= minimumStringLength || (i == len(contents) && match.Limit > 0) {
+			copy(extracted[start:i], contents[start:i])
+		} else {
+			for j := start; j < i; j++ {
+				extracted[j] = '\n'
+			}
+		}
+
+		if i < len(contents) {
+			extracted[i] = '\n'
+		}
+		start = i + 1
+	}
+
+	return extracted
+}
+
+func isPrintable(b byte) bool {
+	return b == '\t' || (b >= ' ' && b <= '~')
+}
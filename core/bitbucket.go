@@ -0,0 +1,243 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const bitbucketApiUrl = "https://api.bitbucket.org/2.0"
+
+type BitbucketLink struct {
+	Name string `json:"name"`
+	Href string `json:"href"`
+}
+
+type BitbucketRepository struct {
+	FullName  string `json:"full_name"`
+	IsPrivate bool   `json:"is_private"`
+	Size      int64  `json:"size"`
+	UpdatedOn string `json:"updated_on"`
+	Links     struct {
+		Clone []BitbucketLink `json:"clone"`
+	} `json:"links"`
+}
+
+type BitbucketSnippet struct {
+	IsPrivate bool   `json:"is_private"`
+	UpdatedOn string `json:"updated_on"`
+	Links     struct {
+		Clone []BitbucketLink `json:"clone"`
+	} `json:"links"`
+}
+
+type BitbucketCodeSearchResult struct {
+	File struct {
+		Path  string `json:"path"`
+		Links struct {
+			Self BitbucketLink `json:"self"`
+		} `json:"links"`
+	} `json:"file"`
+}
+
+var bitbucketRepositoryRegex = regexp.MustCompile(`/repositories/([^/]+/[^/]+)/src/`)
+
+func bitbucketRequest(session *Session, path string, query url.Values) (*http.Request, error) {
+	endpoint := bitbucketApiUrl + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Config.Bitbucket.Username != "" {
+		req.SetBasicAuth(session.Config.Bitbucket.Username, session.Config.Bitbucket.AppPassword)
+	}
+
+	return req, nil
+}
+
+func bitbucketCloneUrl(links []BitbucketLink) string {
+	for _, link := range links {
+		if link.Name == "https" {
+			return link.Href
+		}
+	}
+
+	return ""
+}
+
+// GetBitbucketRepositories polls the public repositories list for anything
+// updated since the previous poll
+func GetBitbucketRepositories(session *Session) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[BITBUCKET_SOURCE]
+	after := time.Now().Add(-sleep)
+	if cursor, err := time.Parse(time.RFC3339, session.Checkpoint.Cursor("bitbucket")); err == nil {
+		after = cursor
+	}
+
+	for poller := session.NewPoller(PollingBitbucket); ; {
+		response := struct {
+			Values []BitbucketRepository `json:"values"`
+		}{}
+
+		req, err := bitbucketRequest(session, "/repositories", url.Values{"pagelen": {strconv.Itoa(poller.PageSize())}, "after": {after.UTC().Format(time.RFC3339)}})
+		if err == nil {
+			err = GetJSON(limiter, req, &response)
+		}
+
+		if err != nil {
+			session.Log.Warn("Error getting Bitbucket repositories: %s... trying again", err)
+		} else {
+			after = time.Now()
+		}
+
+		for _, repository := range response.Values {
+			cloneUrl := bitbucketCloneUrl(repository.Links.Clone)
+			key := repository.FullName + "-" + repository.UpdatedOn
+			if observedKeys[key] || repository.IsPrivate || cloneUrl == "" {
+				continue
+			}
+
+			observedKeys[key] = true
+
+			if uint(repository.Size/1024) >= *session.Options.MaximumRepositorySize {
+				continue
+			}
+
+			session.Repositories <- GitResource{
+				Type: BITBUCKET_SOURCE,
+				Url:  cloneUrl,
+				Size: repository.Size / 1024,
+			}
+		}
+
+		session.Checkpoint.SetCursor("bitbucket", after.UTC().Format(time.RFC3339))
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// GetBitbucketSnippets polls public snippets, the Bitbucket equivalent of
+// Gists. Snippets are git repositories so they are cloned and scanned as such
+func GetBitbucketSnippets(session *Session) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[BITBUCKET_SOURCE]
+
+	for poller := session.NewPoller(PollingBitbucket); ; {
+		response := struct {
+			Values []BitbucketSnippet `json:"values"`
+		}{}
+
+		req, err := bitbucketRequest(session, "/snippets", url.Values{"pagelen": {strconv.Itoa(poller.PageSize())}})
+		if err == nil {
+			err = GetJSON(limiter, req, &response)
+		}
+
+		if err != nil {
+			session.Log.Warn("Error getting Bitbucket snippets: %s... trying again", err)
+		}
+
+		for _, snippet := range response.Values {
+			cloneUrl := bitbucketCloneUrl(snippet.Links.Clone)
+			key := cloneUrl + "-" + snippet.UpdatedOn
+			if observedKeys[key] || snippet.IsPrivate || cloneUrl == "" {
+				continue
+			}
+
+			observedKeys[key] = true
+			session.Repositories <- GitResource{
+				Type: BITBUCKET_SOURCE,
+				Url:  cloneUrl,
+			}
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// SearchBitbucket periodically runs the configured code search queries in
+// each configured workspace (Bitbucket has no global code search) and queues
+// every repository with a matching file. Searching requires credentials
+func SearchBitbucket(session *Session) {
+	if len(session.Config.Bitbucket.SearchQueries) == 0 || len(session.Config.Bitbucket.SearchWorkspaces) == 0 {
+		return
+	}
+
+	if session.Config.Bitbucket.Username == "" {
+		session.Log.Warn("Bitbucket search queries configured but no Bitbucket credentials given. Skipping search.")
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[BITBUCKET_SOURCE]
+
+	for poller := session.NewPoller(PollingBitbucket); ; {
+		for _, workspace := range session.Config.Bitbucket.SearchWorkspaces {
+			for _, searchQuery := range session.Config.Bitbucket.SearchQueries {
+				response := struct {
+					Values []BitbucketCodeSearchResult `json:"values"`
+				}{}
+
+				req, err := bitbucketRequest(session, "/workspaces/"+url.PathEscape(workspace)+"/search/code", url.Values{"search_query": {searchQuery}, "pagelen": {strconv.Itoa(poller.PageSize())}})
+				if err == nil {
+					err = GetJSON(limiter, req, &response)
+				}
+
+				if err != nil {
+					session.Log.Warn("Error searching Bitbucket workspace %s for '%s': %s... trying again", workspace, searchQuery, err)
+					continue
+				}
+
+				for _, result := range response.Values {
+					match := bitbucketRepositoryRegex.FindStringSubmatch(result.File.Links.Self.Href)
+					if match == nil || observedKeys[match[1]] {
+						continue
+					}
+
+					observedKeys[match[1]] = true
+					session.Repositories <- GitResource{
+						Type: BITBUCKET_SOURCE,
+						Url:  "https://bitbucket.org/" + match[1] + ".git",
+					}
+				}
+			}
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BucketSchemeS3    = "s3"
+	BucketSchemeGcs   = "gs"
+	BucketSchemeAzure = "azure"
+
+	defaultBucketsInterval = 60
+	defaultAwsRegion       = "us-east-1"
+
+	azureApiVersion = "2019-12-12"
+)
+
+var (
+	bucketNamePattern = `([a-z0-9][a-z0-9._-]{1,61}[a-z0-9])`
+
+	// buckets referenced in scanned files, for buckets.discover
+	bucketRegexes = []struct {
+		scheme string
+		regex  *regexp.Regexp
+	}{
+		{BucketSchemeS3, regexp.MustCompile(`(?i)s3://` + bucketNamePattern)},
+		{BucketSchemeS3, regexp.MustCompile(`(?i)` + bucketNamePattern + `\.s3(?:[.-][a-z0-9-]+)?\.amazonaws\.com`)},
+		{BucketSchemeS3, regexp.MustCompile(`(?i)//s3(?:[.-][a-z0-9-]+)?\.amazonaws\.com/` + bucketNamePattern)},
+		{BucketSchemeGcs, regexp.MustCompile(`(?i)gs://` + bucketNamePattern)},
+		{BucketSchemeGcs, regexp.MustCompile(`(?i)storage\.googleapis\.com/` + bucketNamePattern)},
+		{BucketSchemeAzure, regexp.MustCompile(`(?i)([a-z0-9]{3,24})\.blob\.core\.windows\.net/([a-z0-9][a-z0-9-]{1,61}[a-z0-9])`)},
+	}
+
+	observedBuckets = struct {
+		sync.Mutex
+		objects    map[string]bool
+		discovered map[string]bool
+	}{objects: map[string]bool{}, discovered: map[string]bool{}}
+)
+
+// bucketObject is an object listed in a bucket
+type bucketObject struct {
+	Key  string
+	Size int64
+	ETag string
+}
+
+// bucket lists and downloads the objects of an S3 bucket, GCS bucket or
+// Azure Blob container, under an optional prefix
+type bucket struct {
+	scheme    string
+	account   string
+	name      string
+	prefix    string
+	anonymous bool
+	region    string
+	limiter   *RateLimiter
+	config    BucketsConfig
+}
+
+func IsBucketUrl(bucketUrl string) bool {
+	u, err := url.Parse(bucketUrl)
+	return err == nil && (u.Scheme == BucketSchemeS3 || u.Scheme == BucketSchemeGcs || u.Scheme == BucketSchemeAzure)
+}
+
+func parseBucketUrl(session *Session, bucketUrl string) (*bucket, error) {
+	u, err := url.Parse(bucketUrl)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid bucket URL %s", bucketUrl)
+	}
+
+	b := &bucket{
+		scheme: u.Scheme,
+		name:   u.Host,
+		prefix: strings.TrimPrefix(u.Path, "/"),
+		// credentials are only sent to the configured buckets
+		anonymous: !containsString(session.Config.Buckets.Urls, bucketUrl),
+		region:    session.Config.Buckets.AwsRegion,
+		limiter:   session.RateLimiters[BUCKET_SOURCE],
+		config:    session.Config.Buckets,
+	}
+
+	switch u.Scheme {
+	case BucketSchemeS3, BucketSchemeGcs:
+	case BucketSchemeAzure:
+		parts := strings.SplitN(b.prefix, "/", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("bucket URL %s has no container, i.e. azure://account/container", bucketUrl)
+		}
+
+		b.account, b.name, b.prefix = u.Host, parts[0], ""
+		if len(parts) > 1 {
+			b.prefix = parts[1]
+		}
+	default:
+		return nil, fmt.Errorf("unsupported bucket URL %s. Use s3://, gs:// or azure://", bucketUrl)
+	}
+
+	return b, nil
+}
+
+// GetBuckets queues the configured buckets to be scanned every interval
+// minutes. Only objects added or changed since the last scan are downloaded
+func GetBuckets(session *Session) {
+	if len(session.Config.Buckets.Urls) == 0 {
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	interval := time.Duration(session.Config.Buckets.Interval) * time.Minute
+
+	for c := time.Tick(interval); ; {
+		for _, bucketUrl := range session.Config.Buckets.Urls {
+			session.Repositories <- GitResource{Type: BUCKET_SOURCE, Url: bucketUrl}
+		}
+
+		select {
+		case <-c:
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// QueueDiscoveredBuckets queues the buckets referenced in contents to be
+// scanned anonymously, once each. They are dropped if the queue is full
+// rather than blocking the scan worker
+func QueueDiscoveredBuckets(session *Session, contents []byte) {
+	if !session.Config.Buckets.Discover || !*session.Options.ProcessBuckets || !session.Options.IsPublicMode() {
+		return
+	}
+
+	for _, r := range bucketRegexes {
+		for _, match := range r.regex.FindAllSubmatch(contents, -1) {
+			bucketUrl := r.scheme + "://" + strings.ToLower(strings.Join(byteSlicesToStrings(match[1:]), "/"))
+
+			observedBuckets.Lock()
+			if observedBuckets.discovered[bucketUrl] {
+				observedBuckets.Unlock()
+				continue
+			}
+
+			select {
+			case session.Repositories <- GitResource{Type: BUCKET_SOURCE, Url: bucketUrl}:
+				observedBuckets.discovered[bucketUrl] = true
+				session.Log.Debug("Queued discovered bucket %s", bucketUrl)
+			default:
+			}
+			observedBuckets.Unlock()
+		}
+	}
+}
+
+func byteSlicesToStrings(b [][]byte) []string {
+	s := make([]string, len(b))
+	for i := range b {
+		s[i] = string(b[i])
+	}
+
+	return s
+}
+
+// GetBucketFiles lists a bucket and downloads the objects added or changed
+// since it was last scanned in to memory, as if they were in dir. Objects
+// with blacklisted extensions or paths, or larger than --maximum-file-size,
+// are skipped. No more than --maximum-repository-size is downloaded per
+// scan; the rest are downloaded the next time the bucket is scanned
+func GetBucketFiles(session *Session, bucketUrl string, dir string) ([]MatchFile, error) {
+	b, err := parseBucketUrl(session, bucketUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	budget := int64(*session.Options.MaximumRepositorySize) * 1024
+	files := make([]MatchFile, 0)
+	marker := ""
+
+	for {
+		objects, next, err := b.list(marker)
+		if err != nil {
+			return files, err
+		}
+
+		for _, object := range objects {
+			key := bucketUrl + "/" + object.Key + "@" + object.ETag
+			filePath := dir + "/" + object.Key
+
+			observedBuckets.Lock()
+			observed := observedBuckets.objects[key]
+			observedBuckets.Unlock()
+
+			if observed || strings.HasSuffix(object.Key, "/") || IsSkippableFile(filePath) || object.Size > GetMaximumFileSize(filePath) {
+				continue
+			}
+
+			if object.Size > budget {
+				session.Log.Debug("[%s] Reached the maximum size of %d KB. Remaining objects will be downloaded next time", bucketUrl, *session.Options.MaximumRepositorySize)
+				return files, nil
+			}
+
+			contents, err := b.get(object)
+			if err != nil {
+				session.Log.Debug("[%s] Failed to download %s: %s", bucketUrl, object.Key, err)
+				continue
+			}
+
+			budget -= int64(len(contents))
+			observedBuckets.Lock()
+			observedBuckets.objects[key] = true
+			observedBuckets.Unlock()
+
+			files = append(files, MatchFile{
+				Path:      filePath,
+				Filename:  path.Base(object.Key),
+				Extension: path.Ext(object.Key),
+				Contents:  contents,
+			})
+		}
+
+		if next == "" {
+			return files, nil
+		}
+		marker = next
+	}
+}
+
+// list returns a page of objects and the marker of the next page, if any
+func (b *bucket) list(marker string) ([]bucketObject, string, error) {
+	switch b.scheme {
+	case BucketSchemeS3:
+		return b.listS3(marker)
+	case BucketSchemeGcs:
+		return b.listGcs(marker)
+	default:
+		return b.listAzure(marker)
+	}
+}
+
+func (b *bucket) get(object bucketObject) ([]byte, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+
+	switch b.scheme {
+	case BucketSchemeS3:
+		resp, err = b.doS3("/"+object.Key, url.Values{})
+	case BucketSchemeGcs:
+		resp, err = b.do(fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", b.gcsEndpoint(), url.PathEscape(b.name), url.PathEscape(object.Key)))
+	default:
+		resp, err = b.do(b.azureUrl("/"+escapeObjectKey(object.Key), url.Values{}))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(io.LimitReader(resp.Body, object.Size))
+}
+
+// do performs a rate limited GET, sending the GCS access token if any
+func (b *bucket) do(requestUrl string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.scheme == BucketSchemeGcs && b.config.GcsAccessToken != "" && !b.anonymous {
+		req.Header.Set("Authorization", "Bearer "+b.config.GcsAccessToken)
+	} else if b.scheme == BucketSchemeAzure {
+		req.Header.Set("x-ms-version", azureApiVersion)
+	}
+
+	return b.send(req)
+}
+
+func (b *bucket) send(req *http.Request) (*http.Response, error) {
+	b.limiter.Wait()
+	req.Header.Set("User-Agent", fmt.Sprintf("%s v%s", Name, Version))
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return resp, fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (b *bucket) listS3(marker string) ([]bucketObject, string, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {b.prefix}}
+	if marker != "" {
+		query.Set("continuation-token", marker)
+	}
+
+	resp, err := b.doS3("", query)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Contents []struct {
+			Key  string `xml:"Key"`
+			Size int64  `xml:"Size"`
+			ETag string `xml:"ETag"`
+		} `xml:"Contents"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}{}
+
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+
+	objects := make([]bucketObject, 0, len(result.Contents))
+	for _, content := range result.Contents {
+		objects = append(objects, bucketObject{Key: content.Key, Size: content.Size, ETag: content.ETag})
+	}
+
+	return objects, result.NextContinuationToken, nil
+}
+
+// doS3 requests a path-style URL in the bucket, following it to the
+// bucket's region if it isn't in the configured one
+func (b *bucket) doS3(key string, query url.Values) (*http.Response, error) {
+	endpoint := b.config.S3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", b.region)
+	}
+
+	requestUrl := strings.TrimRight(endpoint, "/") + "/" + escapeObjectKey(b.name+key)
+	if len(query) > 0 {
+		requestUrl += "?" + strings.Replace(query.Encode(), "+", "%20", -1)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !b.anonymous && b.config.AwsAccessKeyId != "" {
+		credentials := AWSCredentials{AccessKeyId: b.config.AwsAccessKeyId, SecretAccessKey: b.config.AwsSecretAccessKey, SessionToken: b.config.AwsSessionToken}
+		SignAWSRequest(req, nil, credentials, b.region, "s3")
+	}
+
+	resp, err := b.send(req)
+	if resp != nil && err != nil {
+		if region := resp.Header.Get("x-amz-bucket-region"); region != "" && region != b.region && b.config.S3Endpoint == "" {
+			b.region = region
+			return b.doS3(key, query)
+		}
+	}
+
+	return resp, err
+}
+
+// escapeObjectKey escapes each segment of an object key as AWS and Azure
+// expect, leaving the slashes between them
+func escapeObjectKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = strings.Replace(url.QueryEscape(segments[i]), "+", "%20", -1)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func (b *bucket) gcsEndpoint() string {
+	if b.config.GcsEndpoint != "" {
+		return strings.TrimRight(b.config.GcsEndpoint, "/")
+	}
+
+	return "https://storage.googleapis.com"
+}
+
+func (b *bucket) listGcs(marker string) ([]bucketObject, string, error) {
+	query := url.Values{"prefix": {b.prefix}, "fields": {"items(name,size,etag),nextPageToken"}}
+	if marker != "" {
+		query.Set("pageToken", marker)
+	}
+
+	resp, err := b.do(fmt.Sprintf("%s/storage/v1/b/%s/o?%s", b.gcsEndpoint(), url.PathEscape(b.name), query.Encode()))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Items []struct {
+			Name string `json:"name"`
+			Size string `json:"size"`
+			ETag string `json:"etag"`
+		} `json:"items"`
+		NextPageToken string `json:"nextPageToken"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+
+	objects := make([]bucketObject, 0, len(result.Items))
+	for _, item := range result.Items {
+		size, _ := strconv.ParseInt(item.Size, 10, 64)
+		objects = append(objects, bucketObject{Key: item.Name, Size: size, ETag: item.ETag})
+	}
+
+	return objects, result.NextPageToken, nil
+}
+
+// azureUrl returns the URL of a path in the container, with the SAS token
+// appended to the query
+func (b *bucket) azureUrl(key string, query url.Values) string {
+	endpoint := fmt.Sprintf("https://%s.blob.core.windows.net", b.account)
+	if b.config.AzureEndpoint != "" {
+		endpoint = strings.TrimRight(b.config.AzureEndpoint, "/") + "/" + b.account
+	}
+
+	rawQuery := query.Encode()
+	if b.config.AzureSasToken != "" && !b.anonymous {
+		if rawQuery != "" {
+			rawQuery += "&"
+		}
+		rawQuery += strings.TrimPrefix(b.config.AzureSasToken, "?")
+	}
+
+	return endpoint + "/" + url.PathEscape(b.name) + key + "?" + rawQuery
+}
+
+func (b *bucket) listAzure(marker string) ([]bucketObject, string, error) {
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {b.prefix}}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+
+	resp, err := b.do(b.azureUrl("", query))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Blobs []struct {
+			Name       string `xml:"Name"`
+			Properties struct {
+				ContentLength int64  `xml:"Content-Length"`
+				ETag          string `xml:"Etag"`
+			} `xml:"Properties"`
+		} `xml:"Blobs>Blob"`
+		NextMarker string `xml:"NextMarker"`
+	}{}
+
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+
+	objects := make([]bucketObject, 0, len(result.Blobs))
+	for _, blob := range result.Blobs {
+		objects = append(objects, bucketObject{Key: blob.Name, Size: blob.Properties.ContentLength, ETag: blob.Properties.ETag})
+	}
+
+	return objects, result.NextMarker, nil
+}
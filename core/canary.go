@@ -0,0 +1,201 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base32"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// findings are reported as usual, tagged likely_canary and rated low
+	// confidence
+	CanaryActionTag = "tag"
+	// findings are left out
+	CanaryActionSuppress = "suppress"
+	// findings are tagged and raised to critical, to alert on whoever
+	// planted the bait, or to page when one of our own canaries leaks
+	CanaryActionAlert = "alert"
+)
+
+var CanaryActions = []string{CanaryActionTag, CanaryActionSuppress, CanaryActionAlert}
+
+var (
+	// AWS accounts that issue the access keys of canarytokens.org and
+	// Thinkst Canary, as the key IDs encode their account
+	canaryAwsAccountIds = []string{
+		"052310077262",
+		"171436882533",
+		"266735846894",
+		"534261010715",
+		"595918472158",
+		"717712589309",
+		"730335385048",
+		"819147034852",
+		"992382622183",
+	}
+
+	// hosts canary tokens call back to, found in their URLs, DNS names,
+	// kubeconfigs, WireGuard and VPN configs
+	canaryDomains = []string{"canarytokens.com", "canarytokens.org", "canarytokens.net", "canary.tools"}
+
+	canaryAwsKeyIdRegex = regexp.MustCompile(`\b(AKIA|ASIA)[A-Z2-7]{16}\b`)
+	// bait tends to say what it is, in the secret itself or where it's kept
+	canaryWordRegex = regexp.MustCompile(`(?i)(canary[-_]?tokens?|honey[-_]?(token|pot|cred|key)s?|decoy)`)
+)
+
+// CanaryConfig is how matches that are likely canary tokens or honeypot
+// bait are recognised and reported, on top of the curated lists
+type CanaryConfig struct {
+	// tag, suppress or alert
+	Action string `yaml:"action"`
+	// more AWS accounts whose access keys are canaries
+	AwsAccountIds []string `yaml:"aws_account_ids"`
+	// more hosts canary tokens call back to, i.e. those of a self-hosted
+	// canarytokens server
+	Domains []string `yaml:"domains"`
+	// regular expressions of matches that are canaries
+	Patterns []string `yaml:"patterns"`
+}
+
+// validate fills in the defaults of canaries
+func (c *CanaryConfig) validate() error {
+	if c.Action == "" {
+		c.Action = CanaryActionTag
+	} else if !containsString(CanaryActions, c.Action) {
+		return fmt.Errorf("canaries.action must be one of %s, not %s", strings.Join(CanaryActions, ", "), c.Action)
+	}
+
+	for _, id := range c.AwsAccountIds {
+		if len(id) != 12 || strings.Trim(id, "0123456789") != "" {
+			return fmt.Errorf("canaries.aws_account_ids must be 12 digit account IDs, not %s", id)
+		}
+	}
+
+	for _, pattern := range c.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("canaries.patterns has an invalid pattern %s: %s", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// CanaryDetector recognises matches that are likely canary tokens: bait
+// planted to catch whoever uses it, which responders needn't rotate
+type CanaryDetector struct {
+	action        string
+	awsAccountIds map[string]bool
+	domains       [][]byte
+	patterns      []*regexp.Regexp
+}
+
+func NewCanaryDetector(config CanaryConfig) *CanaryDetector {
+	detector := &CanaryDetector{action: config.Action, awsAccountIds: make(map[string]bool)}
+
+	for _, id := range append(canaryAwsAccountIds, config.AwsAccountIds...) {
+		detector.awsAccountIds[id] = true
+	}
+
+	for _, domain := range append(canaryDomains, config.Domains...) {
+		detector.domains = append(detector.domains, []byte(strings.ToLower(domain)))
+	}
+
+	for _, pattern := range config.Patterns {
+		detector.patterns = append(detector.patterns, regexp.MustCompile(pattern))
+	}
+
+	return detector
+}
+
+func (s *Session) InitCanaries() {
+	s.Canaries = NewCanaryDetector(s.Config.Canaries)
+}
+
+// awsAccountId decodes the account an access key ID was issued to. Only key
+// IDs issued since 2019 encode it, older ones decode to nonsense
+func awsAccountId(keyId string) string {
+	decoded, err := base32.StdEncoding.DecodeString(keyId[4:])
+	if err != nil || len(decoded) < 6 {
+		return ""
+	}
+
+	var id uint64
+	for _, b := range decoded[:6] {
+		id = id<<8 | uint64(b)
+	}
+
+	return fmt.Sprintf("%012d", (id&0x7fffffffff80)>>7)
+}
+
+// Check returns why matches found in a file are likely canary tokens, or ""
+// if they don't look like any. filePath is relative to the repository root
+func (d *CanaryDetector) Check(file MatchFile, filePath string, matches []string) string {
+	if d == nil {
+		return ""
+	}
+
+	for _, match := range matches {
+		for _, keyId := range canaryAwsKeyIdRegex.FindAllString(match, -1) {
+			if id := awsAccountId(keyId); d.awsAccountIds[id] {
+				return "AWS key of canary account " + id
+			}
+		}
+
+		lower := []byte(strings.ToLower(match))
+		for _, domain := range d.domains {
+			if bytes.Contains(lower, domain) {
+				return "calls back to " + string(domain)
+			}
+		}
+
+		for _, pattern := range d.patterns {
+			if pattern.MatchString(match) {
+				return "matches canaries.patterns " + pattern.String()
+			}
+		}
+
+		if word := canaryWordRegex.FindString(match); word != "" {
+			return "match mentions " + strings.ToLower(word)
+		}
+	}
+
+	// canary kubeconfigs, VPN configs and documents carry their callback
+	// beside the credentials
+	contents := bytes.ToLower(file.Contents)
+	for _, domain := range d.domains {
+		if bytes.Contains(contents, domain) {
+			return "file calls back to " + string(domain)
+		}
+	}
+
+	if word := canaryWordRegex.FindString(filePath); word != "" {
+		return "path mentions " + strings.ToLower(word)
+	}
+
+	return ""
+}
+
+// TagCanary marks a finding that Check found likely to be a canary token,
+// going by canaries.action, and reports whether it's still to be published
+func (s *Session) TagCanary(event *MatchEvent, reason string) bool {
+	if reason == "" {
+		return true
+	}
+
+	s.Metrics.Inc(MetricLikelyCanaries, "action", s.Canaries.action)
+	event.LikelyCanary, event.CanaryReason = true, reason
+
+	switch s.Canaries.action {
+	case CanaryActionSuppress:
+		s.Log.Debug("[%s] Skipping %s in %s, likely a canary: %s", event.Url, event.Signature, event.File, reason)
+		return false
+	case CanaryActionAlert:
+		event.Severity = SeverityCritical
+	default:
+		event.Confidence = ConfidenceLow
+	}
+
+	return true
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// how often the checkpoint is saved, so less is lost if shhgit is killed
+const checkpointInterval = time.Minute
+
+// Checkpoint is how far each poller got through its feed, and the work that
+// was queued but not started, saved to --checkpoint-path so shhgit carries
+// on from there after a restart. A nil Checkpoint saves nothing
+type Checkpoint struct {
+	sync.Mutex
+
+	path     string
+	Cursors  map[string]string `json:"cursors"`
+	Pending  []DistributedJob  `json:"pending,omitempty"`
+	Comments []string          `json:"comments,omitempty"`
+	Pastes   []Paste           `json:"pastes,omitempty"`
+	SavedAt  time.Time         `json:"saved_at"`
+}
+
+// LoadCheckpoint reads the checkpoint at path, or starts a new one if there
+// isn't one yet
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(data, checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if checkpoint.Cursors == nil {
+		checkpoint.Cursors = make(map[string]string)
+	}
+
+	return checkpoint, nil
+}
+
+// Cursor returns where the poller of a feed got to, or "" to start from now
+func (c *Checkpoint) Cursor(key string) string {
+	if c == nil {
+		return ""
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.Cursors[key]
+}
+
+// SetCursor records where the poller of a feed got to. Only set it once
+// what came before has been queued
+func (c *Checkpoint) SetCursor(key string, value string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.Cursors[key] = value
+}
+
+// TakePending returns the work saved as pending, which is then no longer
+// kept in the checkpoint
+func (c *Checkpoint) TakePending() ([]DistributedJob, []string, []Paste) {
+	if c == nil {
+		return nil, nil, nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	jobs, comments, pastes := c.Pending, c.Comments, c.Pastes
+	c.Pending, c.Comments, c.Pastes = nil, nil, nil
+
+	return jobs, comments, pastes
+}
+
+// AddPending keeps work that wasn't started for after the restart
+func (c *Checkpoint) AddPending(jobs []DistributedJob, comments []string, pastes []Paste) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.Pending = append(c.Pending, jobs...)
+	c.Comments = append(c.Comments, comments...)
+	c.Pastes = append(c.Pastes, pastes...)
+}
+
+// Save writes the checkpoint, replacing the previous one only once it has
+// been written in full
+func (c *Checkpoint) Save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.Lock()
+	c.SavedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(c, "", "  ")
+	c.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), c.path)
+}
+
+// saveCheckpoints saves the checkpoint every checkpointInterval
+func saveCheckpoints(s *Session) {
+	for range time.Tick(checkpointInterval) {
+		if err := s.Checkpoint.Save(); err != nil {
+			s.Log.Warn("Failed to save checkpoint to %s: %s", *s.Options.CheckpointPath, err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+const (
+	CloneProtocolHttps = "https"
+	CloneProtocolSsh   = "ssh"
+
+	defaultSshUser = "git"
+)
+
+// CloneConfig sets how repositories are cloned
+type CloneConfig struct {
+	Protocol         string            `yaml:"protocol"`
+	Proxy            string            `yaml:"proxy,omitempty"`
+	SshUser          string            `yaml:"ssh_user,omitempty"`
+	SshKey           string            `yaml:"ssh_key,omitempty"`
+	SshKeyPassphrase string            `yaml:"ssh_key_passphrase,omitempty"`
+	SshKnownHosts    string            `yaml:"ssh_known_hosts,omitempty"`
+	Credentials      []CloneCredential `yaml:"credentials"`
+}
+
+// CloneCredential is a token for https clones from a host, for private and
+// internal repositories
+type CloneCredential struct {
+	Host     string `yaml:"host"`
+	Username string `yaml:"username,omitempty"`
+	Token    string `yaml:"token"`
+}
+
+// Cloner turns repository URLs in to what to clone and how to authenticate,
+// for both go-git and the git binary
+type Cloner struct {
+	config  CloneConfig
+	sshAuth *gitssh.PublicKeys
+}
+
+func NewCloner(config CloneConfig) (*Cloner, error) {
+	cloner := &Cloner{config: config}
+
+	switch config.Protocol {
+	case CloneProtocolHttps:
+	case CloneProtocolSsh:
+		if config.SshKey == "" {
+			break
+		}
+
+		auth, err := gitssh.NewPublicKeysFromFile(config.SshUser, config.SshKey, config.SshKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ssh key %s: %s", config.SshKey, err)
+		}
+
+		if config.SshKnownHosts != "" {
+			if auth.HostKeyCallback, err = gitssh.NewKnownHostsCallback(config.SshKnownHosts); err != nil {
+				return nil, fmt.Errorf("failed to load ssh known hosts %s: %s", config.SshKnownHosts, err)
+			}
+		}
+
+		cloner.sshAuth = auth
+	default:
+		return nil, fmt.Errorf("unknown clone protocol %s. Available protocols: %s, %s", config.Protocol, CloneProtocolHttps, CloneProtocolSsh)
+	}
+
+	if config.Proxy != "" {
+		if _, err := url.Parse(config.Proxy); err != nil {
+			return nil, fmt.Errorf("invalid clone proxy %s: %s", config.Proxy, err)
+		}
+	}
+
+	return cloner, nil
+}
+
+// URL returns the URL to clone a repository from, over ssh if configured.
+// Azure DevOps uses its own ssh URLs so is always cloned over https
+func (c *Cloner) URL(repositoryUrl string, source GitResourceType) string {
+	if c == nil || c.config.Protocol != CloneProtocolSsh || source == AZURE_DEVOPS_SOURCE {
+		return repositoryUrl
+	}
+
+	u, err := url.Parse(repositoryUrl)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return repositoryUrl
+	}
+
+	return fmt.Sprintf("%s@%s:%s", c.config.SshUser, u.Hostname(), strings.TrimPrefix(u.Path, "/"))
+}
+
+// Auth returns the go-git credentials to clone cloneUrl with, or nil to
+// clone anonymously or with an ssh agent
+func (c *Cloner) Auth(cloneUrl string, source GitResourceType) transport.AuthMethod {
+	if c == nil {
+		return nil
+	}
+
+	if isSshUrl(cloneUrl) {
+		if c.sshAuth == nil {
+			return nil
+		}
+
+		return c.sshAuth
+	}
+
+	if username, token := c.credentials(cloneUrl, source); token != "" {
+		return &githttp.BasicAuth{Username: username, Password: token}
+	}
+
+	return nil
+}
+
+// CloneDirectory creates a directory to clone or export repositoryUrl in to
+// with a binary, unique as the same repository can be queued again before
+// it has been scanned
+func CloneDirectory(session *Session, repositoryUrl string) (string, error) {
+	if err := session.DiskQuota.Wait(session.Context); err != nil {
+		return "", err
+	}
+
+	parent := filepath.Join(*session.Options.TempDirectory, ".clones")
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	return ioutil.TempDir(parent, GetHash(repositoryUrl))
+}
+
+// GitEnv returns the environment to run the git binary in to clone
+// cloneUrl. Config is passed with GIT_CONFIG_COUNT (git 2.31 or later)
+// rather than -c so tokens don't show up in the process list
+func (c *Cloner) GitEnv(cloneUrl string, source GitResourceType) []string {
+	// fail rather than wait for credentials on private repositories
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	if c == nil {
+		return env
+	}
+
+	if isSshUrl(cloneUrl) {
+		command := "ssh -o BatchMode=yes"
+		if c.config.SshKey != "" {
+			command += " -o IdentitiesOnly=yes -i " + shellQuote(c.config.SshKey)
+		}
+
+		if c.config.SshKnownHosts != "" {
+			command += " -o UserKnownHostsFile=" + shellQuote(c.config.SshKnownHosts)
+		}
+
+		return append(env, "GIT_SSH_COMMAND="+command)
+	}
+
+	config := make([]string, 0)
+	if c.config.Proxy != "" {
+		config = append(config, "http.proxy", c.config.Proxy)
+	}
+
+	if username, token := c.credentials(cloneUrl, source); token != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + token))
+		config = append(config, "http.extraHeader", "Authorization: Basic "+credentials)
+	}
+
+	for i := 0; i < len(config); i += 2 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i/2, config[i]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i/2, config[i+1]))
+	}
+
+	return append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(config)/2))
+}
+
+// SvnArgs returns the options to run the svn binary with to export
+// repositoryUrl, the environment to run it in and the password to give it
+// on stdin, if any
+func (c *Cloner) SvnArgs(repositoryUrl string) ([]string, []string, string) {
+	args := []string{"--non-interactive", "--no-auth-cache"}
+	env := os.Environ()
+
+	if c == nil {
+		return args, env, ""
+	}
+
+	if c.config.SshKey != "" {
+		env = append(env, "SVN_SSH=ssh -o BatchMode=yes -o IdentitiesOnly=yes -i "+shellQuote(c.config.SshKey))
+	}
+
+	if proxy, err := url.Parse(c.config.Proxy); err == nil && c.config.Proxy != "" {
+		args = append(args, "--config-option", "servers:global:http-proxy-host="+proxy.Hostname())
+		if proxy.Port() != "" {
+			args = append(args, "--config-option", "servers:global:http-proxy-port="+proxy.Port())
+		}
+	}
+
+	if username, token := c.credentials(repositoryUrl, SVN_SOURCE); token != "" {
+		return append(args, "--username", username, "--password-from-stdin"), env, token
+	}
+
+	return args, env, ""
+}
+
+func (c *Cloner) credentials(cloneUrl string, source GitResourceType) (string, string) {
+	if source == AZURE_DEVOPS_SOURCE && session.Config.AzureDevOps.AccessToken != "" {
+		return Name, session.Config.AzureDevOps.AccessToken
+	}
+
+	u, err := url.Parse(cloneUrl)
+	if err != nil {
+		return "", ""
+	}
+
+	for _, credential := range c.config.Credentials {
+		if strings.EqualFold(credential.Host, u.Hostname()) || strings.EqualFold(credential.Host, u.Host) {
+			return credential.Username, credential.Token
+		}
+	}
+
+	return "", ""
+}
+
+// shellQuote single quotes a path for GIT_SSH_COMMAND or SVN_SSH, which are
+// split like a shell would
+func shellQuote(path string) string {
+	return "'" + strings.Replace(path, "'", `'\''`, -1) + "'"
+}
+
+// isSshUrl reports whether cloneUrl is an ssh:// or scp-like git@host:path URL
+func isSshUrl(cloneUrl string) bool {
+	if strings.HasPrefix(cloneUrl, "ssh://") {
+		return true
+	}
+
+	return !strings.Contains(cloneUrl, "://") && strings.Contains(cloneUrl, ":")
+}
+
+// InitCloner loads the clone config, routing go-git's https clones through
+// the configured proxy. HTTP_PROXY and HTTPS_PROXY are honoured otherwise
+func (s *Session) InitCloner() {
+	cloner, err := NewCloner(s.Config.Clone)
+	if err != nil {
+		s.Log.Fatal("%s", err)
+	}
+
+	if s.Config.Clone.Proxy != "" {
+		proxy, _ := url.Parse(s.Config.Clone.Proxy)
+		proxied := githttp.NewClient(&http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}})
+		client.InstallProtocol("https", proxied)
+		client.InstallProtocol("http", proxied)
+	}
+
+	s.Cloner = cloner
+}
@@ -6,28 +6,357 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	GitHubAccessTokens           []string          `yaml:"github_access_tokens"`
-	Webhook                      string            `yaml:"webhook,omitempty"`
-	WebhookPayload               string            `yaml:"webhook_payload,omitempty"`
-	BlacklistedStrings           []string          `yaml:"blacklisted_strings"`
-	BlacklistedExtensions        []string          `yaml:"blacklisted_extensions"`
-	BlacklistedPaths             []string          `yaml:"blacklisted_paths"`
-	BlacklistedEntropyExtensions []string          `yaml:"blacklisted_entropy_extensions"`
-	Signatures                   []ConfigSignature `yaml:"signatures"`
+	GitHubAccessTokens           []string                 `yaml:"github_access_tokens"`
+	GitHubEnterprise             []GitHubEnterpriseConfig `yaml:"github_enterprise"`
+	GitHubWatch                  GitHubWatchConfig        `yaml:"github_watch"`
+	GitHubSearch                 GitHubSearchConfig       `yaml:"github_search"`
+	GitHubRemediation            GitHubRemediationConfig  `yaml:"github_remediation"`
+	Revocation                   RevocationConfig         `yaml:"revocation"`
+	Vault                        VaultConfig              `yaml:"vault"`
+	Webhook                      string                   `yaml:"webhook,omitempty"`
+	WebhookPayload               string                   `yaml:"webhook_payload,omitempty"`
+	SinksConfig                  `yaml:",inline"`
+	CsvFields                    []string                 `yaml:"csv_fields"`
+	YaraRulesDirectory           string                   `yaml:"yara_rules_dir,omitempty"`
+	OutputFormat                 string                   `yaml:"output_format,omitempty"`
+	OutputPath                   string                   `yaml:"output_path,omitempty"`
+	DedupPath                    string                   `yaml:"dedup_path,omitempty"`
+	ScanCachePath                string                   `yaml:"scan_cache_path,omitempty"`
+	CheckpointPath               string                   `yaml:"checkpoint_path,omitempty"`
+	DatabaseUrl                  string                   `yaml:"database_url,omitempty"`
+	ApiTokens                    []string                 `yaml:"api_tokens"`
+	WebAuth                      WebAuthConfig            `yaml:"web_auth"`
+	Grpc                         GrpcConfig               `yaml:"grpc"`
+	Distributed                  DistributedConfig        `yaml:"distributed"`
+	EventFilter                  EventFilterConfig        `yaml:"event_filter"`
+	GitHubReleases               GitHubReleasesConfig     `yaml:"github_releases"`
+	Polling                      map[string]PollingConfig `yaml:"polling"`
+	RedactSecrets                bool                     `yaml:"redact_secrets"`
+	MinimumSeverity              string                   `yaml:"minimum_severity,omitempty"`
+	ExitPolicy                   ExitPolicyConfig         `yaml:"exit_policy"`
+	Logging                      LoggingConfig            `yaml:"logging"`
+	SuppressedContexts           []string                 `yaml:"suppressed_contexts"`
+	Canaries                     CanaryConfig             `yaml:"canaries"`
+	BlacklistedStrings           []string                 `yaml:"blacklisted_strings"`
+	BlacklistedExtensions        []string                 `yaml:"blacklisted_extensions"`
+	BlacklistedPaths             []string                 `yaml:"blacklisted_paths"`
+	BlacklistedEntropyExtensions []string                 `yaml:"blacklisted_entropy_extensions"`
+	Entropy                      EntropyConfig            `yaml:"entropy"`
+	Generic                      GenericConfig            `yaml:"generic"`
+	Infrastructure               InfrastructureConfig     `yaml:"infrastructure"`
+	Jwt                          JwtConfig                `yaml:"jwt"`
+	AwsPairs                     AwsPairsConfig           `yaml:"aws_pairs"`
+	WatchTerms                   []WatchTerm              `yaml:"watch_terms"`
+	Tenants                      []TenantConfig           `yaml:"tenants"`
+	Workers                      WorkersConfig            `yaml:"workers"`
+	Schedules                    []ScheduleConfig         `yaml:"schedules"`
+	Clone                        CloneConfig              `yaml:"clone"`
+	GitLab                       GitLabConfig             `yaml:"gitlab"`
+	Bitbucket                    BitbucketConfig          `yaml:"bitbucket"`
+	Gitea                        GiteaConfig              `yaml:"gitea"`
+	AzureDevOps                  AzureDevOpsConfig        `yaml:"azure_devops"`
+	Pastebin                     PastebinConfig           `yaml:"pastebin"`
+	Packages                     PackagesConfig           `yaml:"packages"`
+	Docker                       DockerConfig             `yaml:"docker"`
+	Buckets                      BucketsConfig            `yaml:"buckets"`
+	Signatures                   []ConfigSignature        `yaml:"signatures"`
+}
+
+// SinksConfig are the outputs and sinks findings are sent to, by the whole
+// deployment or by a tenant
+type SinksConfig struct {
+	Outputs       []OutputConfig        `yaml:"outputs"`
+	Webhooks      []WebhookConfig       `yaml:"webhooks"`
+	Slack         []SlackConfig         `yaml:"slack"`
+	Discord       []DiscordConfig       `yaml:"discord"`
+	Teams         []TeamsConfig         `yaml:"teams"`
+	Mattermost    []MattermostConfig    `yaml:"mattermost"`
+	Kafka         []KafkaConfig         `yaml:"kafka"`
+	Elasticsearch []ElasticsearchConfig `yaml:"elasticsearch"`
+	Sqs           []SQSConfig           `yaml:"sqs"`
+	Sns           []SNSConfig           `yaml:"sns"`
+	PagerDuty     []PagerDutyConfig     `yaml:"pagerduty"`
+	Opsgenie      []OpsgenieConfig      `yaml:"opsgenie"`
+	Email         []EmailConfig         `yaml:"email"`
+	Syslog        []SyslogConfig        `yaml:"syslog"`
+	Splunk        []SplunkConfig        `yaml:"splunk"`
+}
+
+type GitHubEnterpriseConfig struct {
+	Name         string   `yaml:"name"`
+	ApiUrl       string   `yaml:"api_url"`
+	UploadsUrl   string   `yaml:"uploads_url,omitempty"`
+	AccessTokens []string `yaml:"access_tokens"`
+}
+
+type WorkersConfig struct {
+	Clone         int `yaml:"clone"`
+	Scan          int `yaml:"scan"`
+	Output        int `yaml:"output"`
+	QueueSize     int `yaml:"queue_size"`
+	ScanQueueSize int `yaml:"scan_queue_size"`
+	// the order repositories are cloned in, highest priority first
+	Priorities []string `yaml:"priorities"`
+}
+
+type GrpcConfig struct {
+	Listen      string `yaml:"listen"`
+	CertFile    string `yaml:"cert_file"`
+	KeyFile     string `yaml:"key_file"`
+	BufferSize  int    `yaml:"buffer_size"`
+	SendTimeout int    `yaml:"send_timeout"`
+}
+
+// ExitPolicyConfig decides when local scans and hooks fail, overridden by
+// --exit-code, --fail-on-severity and --max-findings
+type ExitPolicyConfig struct {
+	ExitCode       *int   `yaml:"exit_code"`
+	FailOnSeverity string `yaml:"fail_on_severity"`
+	MaxFindings    int    `yaml:"max_findings"`
+}
+
+// LoggingConfig is how shhgit logs, overridden by the --log-* flags
+type LoggingConfig struct {
+	Format     string            `yaml:"format"`
+	Level      string            `yaml:"level"`
+	File       string            `yaml:"file"`
+	MaxSize    int               `yaml:"max_size"`
+	MaxBackups *int              `yaml:"max_backups"`
+	Modules    map[string]string `yaml:"modules"`
+}
+
+// OutputConfig is a file findings are written to in a format, as with
+// --format and --output-path. github-actions prints to stdout instead
+type OutputConfig struct {
+	SinkFilter `yaml:",inline"`
+	Format     string   `yaml:"format"`
+	Path       string   `yaml:"path"`
+	CsvFields  []string `yaml:"csv_fields"`
+}
+
+type WebhookConfig struct {
+	Url        string `yaml:"url"`
+	Secret     string `yaml:"secret,omitempty"`
+	MaxRetries int    `yaml:"max_retries,omitempty"`
+}
+
+type SlackConfig struct {
+	SinkFilter `yaml:",inline"`
+	WebhookUrl string `yaml:"webhook_url,omitempty"`
+	Token      string `yaml:"token,omitempty"`
+	Channel    string `yaml:"channel,omitempty"`
+}
+
+type DiscordConfig struct {
+	SinkFilter `yaml:",inline"`
+	WebhookUrl string `yaml:"webhook_url"`
+}
+
+type TeamsConfig struct {
+	SinkFilter `yaml:",inline"`
+	WebhookUrl string `yaml:"webhook_url"`
+}
+
+type MattermostConfig struct {
+	SinkFilter `yaml:",inline"`
+	WebhookUrl string `yaml:"webhook_url"`
+	Channel    string `yaml:"channel,omitempty"`
+}
+
+type KafkaConfig struct {
+	SinkFilter    `yaml:",inline"`
+	Brokers       []string `yaml:"brokers"`
+	Topic         string   `yaml:"topic"`
+	TLS           bool     `yaml:"tls"`
+	TLSSkipVerify bool     `yaml:"tls_skip_verify"`
+	Username      string   `yaml:"username,omitempty"`
+	Password      string   `yaml:"password,omitempty"`
+}
+
+type ElasticsearchConfig struct {
+	SinkFilter    `yaml:",inline"`
+	Url           string `yaml:"url"`
+	Index         string `yaml:"index"`
+	Username      string `yaml:"username,omitempty"`
+	Password      string `yaml:"password,omitempty"`
+	ApiKey        string `yaml:"api_key,omitempty"`
+	BatchSize     int    `yaml:"batch_size"`
+	FlushInterval int    `yaml:"flush_interval"`
+	SkipTemplate  bool   `yaml:"skip_template"`
+}
+
+type SQSConfig struct {
+	SinkFilter      `yaml:",inline"`
+	QueueUrl        string `yaml:"queue_url"`
+	Region          string `yaml:"region,omitempty"`
+	AccessKeyId     string `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string `yaml:"secret_access_key,omitempty"`
+	SessionToken    string `yaml:"session_token,omitempty"`
+}
+
+type SNSConfig struct {
+	SinkFilter      `yaml:",inline"`
+	TopicArn        string `yaml:"topic_arn"`
+	Region          string `yaml:"region,omitempty"`
+	Endpoint        string `yaml:"endpoint,omitempty"`
+	AccessKeyId     string `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string `yaml:"secret_access_key,omitempty"`
+	SessionToken    string `yaml:"session_token,omitempty"`
+}
+
+type PagerDutyConfig struct {
+	IncidentFilter `yaml:",inline"`
+	RoutingKey     string `yaml:"routing_key"`
+	Url            string `yaml:"url,omitempty"`
+}
+
+type OpsgenieConfig struct {
+	IncidentFilter `yaml:",inline"`
+	ApiKey         string   `yaml:"api_key"`
+	Url            string   `yaml:"url,omitempty"`
+	Responders     []string `yaml:"responders"`
+	Tags           []string `yaml:"tags"`
+}
+
+type EmailConfig struct {
+	SinkFilter    `yaml:",inline"`
+	Host          string   `yaml:"host"`
+	Port          int      `yaml:"port"`
+	Username      string   `yaml:"username,omitempty"`
+	Password      string   `yaml:"password,omitempty"`
+	TLSSkipVerify bool     `yaml:"tls_skip_verify"`
+	From          string   `yaml:"from"`
+	To            []string `yaml:"to"`
+	Digest        string   `yaml:"digest,omitempty"`
+}
+
+type SyslogConfig struct {
+	SinkFilter    `yaml:",inline"`
+	Address       string `yaml:"address"`
+	Protocol      string `yaml:"protocol"`
+	Format        string `yaml:"format"`
+	TLSSkipVerify bool   `yaml:"tls_skip_verify"`
+}
+
+type SplunkConfig struct {
+	SinkFilter    `yaml:",inline"`
+	Url           string `yaml:"url"`
+	Token         string `yaml:"token"`
+	Index         string `yaml:"index,omitempty"`
+	Sourcetype    string `yaml:"sourcetype,omitempty"`
+	TLSSkipVerify bool   `yaml:"tls_skip_verify"`
+	BatchSize     int    `yaml:"batch_size"`
+	FlushInterval int    `yaml:"flush_interval"`
+}
+
+type GitLabConfig struct {
+	Url               string   `yaml:"url"`
+	AccessToken       string   `yaml:"access_token,omitempty"`
+	SearchQueries     []string `yaml:"search_queries"`
+	RequestsPerMinute int      `yaml:"requests_per_minute"`
+}
+
+type BitbucketConfig struct {
+	Username          string   `yaml:"username,omitempty"`
+	AppPassword       string   `yaml:"app_password,omitempty"`
+	SearchWorkspaces  []string `yaml:"search_workspaces"`
+	SearchQueries     []string `yaml:"search_queries"`
+	RequestsPerMinute int      `yaml:"requests_per_minute"`
+}
+
+type GiteaConfig struct {
+	Url               string   `yaml:"url"`
+	AccessToken       string   `yaml:"access_token,omitempty"`
+	Users             []string `yaml:"users"`
+	Organizations     []string `yaml:"organizations"`
+	RequestsPerMinute int      `yaml:"requests_per_minute"`
+}
+
+type AzureDevOpsConfig struct {
+	Url               string   `yaml:"url"`
+	Organization      string   `yaml:"organization"`
+	AccessToken       string   `yaml:"access_token,omitempty"`
+	Projects          []string `yaml:"projects"`
+	RequestsPerMinute int      `yaml:"requests_per_minute"`
+}
+
+type PastebinConfig struct {
+	ScrapeUrl         string `yaml:"scrape_url"`
+	RequestsPerMinute int    `yaml:"requests_per_minute"`
+}
+
+type PackagesConfig struct {
+	Registries        []string `yaml:"registries"`
+	NpmUrl            string   `yaml:"npm_url"`
+	NpmChangesUrl     string   `yaml:"npm_changes_url"`
+	PypiUrl           string   `yaml:"pypi_url"`
+	RubygemsUrl       string   `yaml:"rubygems_url"`
+	RequestsPerMinute int      `yaml:"requests_per_minute"`
+}
+
+type DockerConfig struct {
+	Namespaces        []string `yaml:"namespaces"`
+	Registry          string   `yaml:"registry,omitempty"`
+	Repositories      []string `yaml:"repositories"`
+	Username          string   `yaml:"username,omitempty"`
+	Password          string   `yaml:"password,omitempty"`
+	RequestsPerMinute int      `yaml:"requests_per_minute"`
+}
+
+type BucketsConfig struct {
+	Urls               []string `yaml:"urls"`
+	Discover           bool     `yaml:"discover"`
+	Interval           int      `yaml:"interval"`
+	AwsAccessKeyId     string   `yaml:"aws_access_key_id,omitempty"`
+	AwsSecretAccessKey string   `yaml:"aws_secret_access_key,omitempty"`
+	AwsSessionToken    string   `yaml:"aws_session_token,omitempty"`
+	AwsRegion          string   `yaml:"aws_region"`
+	S3Endpoint         string   `yaml:"s3_endpoint,omitempty"`
+	GcsEndpoint        string   `yaml:"gcs_endpoint,omitempty"`
+	GcsAccessToken     string   `yaml:"gcs_access_token,omitempty"`
+	AzureEndpoint      string   `yaml:"azure_endpoint,omitempty"`
+	AzureSasToken      string   `yaml:"azure_sas_token,omitempty"`
+	RequestsPerMinute  int      `yaml:"requests_per_minute"`
 }
 
 type ConfigSignature struct {
-	Name     string `yaml:"name"`
-	Part     string `yaml:"part"`
-	Match    string `yaml:"match,omitempty"`
-	Regex    string `yaml:"regex,omitempty"`
-	Verifier string `yaml:"verifier,omitempty"`
+	Name       string `yaml:"name"`
+	Part       string `yaml:"part"`
+	Match      string `yaml:"match,omitempty"`
+	Regex      string `yaml:"regex,omitempty"`
+	Verifier   string `yaml:"verifier,omitempty"`
+	Severity   string `yaml:"severity,omitempty"`
+	Confidence string `yaml:"confidence,omitempty"`
+	Near       string `yaml:"near,omitempty"`
+	Within     *int   `yaml:"within,omitempty"`
+	// fixtures run by test-signatures
+	Tests *SignatureTests `yaml:"tests,omitempty"`
+}
+
+// ConfigFile returns the path of config.yaml: in --config-path if given,
+// otherwise next to the executable or else in the working directory
+func ConfigFile(options *Options) string {
+	if len(*options.ConfigPath) > 0 {
+		return path.Join(*options.ConfigPath, "config.yaml")
+	}
+
+	// Trying to first find the configuration next to executable
+	// Helps e.g. with Drone where workdir is different than shhgit dir
+	if ex, err := os.Executable(); err == nil {
+		if file := path.Join(filepath.Dir(ex), "config.yaml"); PathExists(file) {
+			return file
+		}
+	}
+
+	dir, _ := os.Getwd()
+	return path.Join(dir, "config.yaml")
 }
 
 func ParseConfig(options *Options) (*Config, error) {
@@ -37,24 +366,16 @@ func ParseConfig(options *Options) (*Config, error) {
 		err  error
 	)
 
-	if len(*options.ConfigPath) > 0 {
-		data, err = ioutil.ReadFile(path.Join(*options.ConfigPath, "config.yaml"))
-		if err != nil {
+	file := ConfigFile(options)
+	data, err = ioutil.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+
+	if IsSopsEncrypted(data) {
+		if data, err = DecryptSops(file); err != nil {
 			return config, err
 		}
-	} else {
-		// Trying to first find the configuration next to executable
-		// Helps e.g. with Drone where workdir is different than shhgit dir
-		ex, err := os.Executable()
-		dir := filepath.Dir(ex)
-		data, err = ioutil.ReadFile(path.Join(dir, "config.yaml"))
-		if err != nil {
-			dir, _ = os.Getwd()
-			data, err = ioutil.ReadFile(path.Join(dir, "config.yaml"))
-			if err != nil {
-				return config, err
-			}
-		}
 	}
 
 	err = yaml.Unmarshal(data, config)
@@ -62,19 +383,487 @@ func ParseConfig(options *Options) (*Config, error) {
 		return config, err
 	}
 
-	if len(*options.Local) <= 0 && (len(config.GitHubAccessTokens) < 1 || strings.TrimSpace(strings.Join(config.GitHubAccessTokens, "")) == "") {
+	secrets := &secretExpander{}
+
+	if options.IsPublicMode() && len(config.GitHubEnterprise) == 0 && (len(config.GitHubAccessTokens) < 1 || strings.TrimSpace(strings.Join(config.GitHubAccessTokens, "")) == "") {
 		return config, errors.New("You need to provide at least one GitHub Access Token. See https://help.github.com/en/articles/creating-a-personal-access-token-for-the-command-line")
 	}
 
 	for i := 0; i < len(config.GitHubAccessTokens); i++ {
-		config.GitHubAccessTokens[i] = os.ExpandEnv(config.GitHubAccessTokens[i])
+		config.GitHubAccessTokens[i] = secrets.expand(config.GitHubAccessTokens[i])
+	}
+
+	for i := range config.GitHubEnterprise {
+		enterprise := &config.GitHubEnterprise[i]
+		enterprise.ApiUrl = secrets.expand(enterprise.ApiUrl)
+		enterprise.UploadsUrl = secrets.expand(enterprise.UploadsUrl)
+
+		if enterprise.Name == "" {
+			enterprise.Name = enterprise.ApiUrl
+		}
+
+		if enterprise.UploadsUrl == "" {
+			enterprise.UploadsUrl = enterprise.ApiUrl
+		}
+
+		for j := range enterprise.AccessTokens {
+			enterprise.AccessTokens[j] = secrets.expand(enterprise.AccessTokens[j])
+		}
+	}
+
+	if config.Clone.Protocol == "" {
+		config.Clone.Protocol = CloneProtocolHttps
+	}
+
+	if config.Clone.SshUser == "" {
+		config.Clone.SshUser = defaultSshUser
+	}
+
+	config.Clone.Proxy = secrets.expand(config.Clone.Proxy)
+	config.Clone.SshKey = secrets.expand(config.Clone.SshKey)
+	config.Clone.SshKeyPassphrase = secrets.expand(config.Clone.SshKeyPassphrase)
+
+	for i := range config.Clone.Credentials {
+		credential := &config.Clone.Credentials[i]
+		credential.Token = secrets.expand(credential.Token)
+
+		if credential.Username == "" {
+			credential.Username = Name
+		}
+	}
+
+	if config.GitHubSearch.Interval <= 0 {
+		config.GitHubSearch.Interval = defaultGitHubSearchInterval
+	}
+
+	if config.GitHubSearch.MaxPages <= 0 {
+		config.GitHubSearch.MaxPages = defaultGitHubSearchMaxPages
+	}
+
+	if config.GitHubReleases.MaxAssetSize <= 0 {
+		config.GitHubReleases.MaxAssetSize = defaultGitHubReleasesMaxAssetSize
+	}
+
+	// every context is suppressed unless suppressed_contexts is set, and
+	// none if it's empty
+	if len(*options.SuppressedContexts) <= 0 {
+		if config.SuppressedContexts == nil {
+			*options.SuppressedContexts = strings.Join(Contexts, ",")
+		} else if len(config.SuppressedContexts) == 0 {
+			*options.SuppressedContexts = ContextNone
+		} else {
+			*options.SuppressedContexts = strings.Join(config.SuppressedContexts, ",")
+		}
+	}
+
+	if len(*options.YaraRulesDirectory) <= 0 {
+		*options.YaraRulesDirectory = config.YaraRulesDirectory
+	}
+
+	if len(*options.CsvFields) <= 0 {
+		*options.CsvFields = strings.Join(config.CsvFields, ",")
+	}
+
+	if len(*options.Format) <= 0 {
+		*options.Format = config.OutputFormat
+	}
+
+	if len(*options.OutputPath) <= 0 {
+		*options.OutputPath = config.OutputPath
+	}
+
+	if len(*options.DedupPath) <= 0 {
+		*options.DedupPath = config.DedupPath
+	}
+
+	if len(*options.ScanCachePath) <= 0 {
+		*options.ScanCachePath = config.ScanCachePath
+	}
+
+	if len(*options.CheckpointPath) <= 0 {
+		*options.CheckpointPath = config.CheckpointPath
+	}
+
+	for i := range config.ApiTokens {
+		config.ApiTokens[i] = secrets.expand(config.ApiTokens[i])
+	}
+
+	if err := config.WebAuth.validate(secrets); err != nil {
+		return config, err
+	}
+
+	config.Grpc.Listen = secrets.expand(config.Grpc.Listen)
+
+	if config.Grpc.BufferSize <= 0 {
+		config.Grpc.BufferSize = defaultGrpcBufferSize
+	}
+
+	if config.Grpc.SendTimeout <= 0 {
+		config.Grpc.SendTimeout = defaultGrpcSendTimeout
+	}
+
+	if err := config.validatePolling(); err != nil {
+		return config, err
+	}
+
+	if err := config.Workers.validatePriorities(); err != nil {
+		return config, err
+	}
+
+	for i := range config.Schedules {
+		if err := config.Schedules[i].validate(); err != nil {
+			return config, err
+		}
+	}
+
+	config.Distributed.RedisUrl = secrets.expand(config.Distributed.RedisUrl)
+
+	if *options.Role != RoleStandalone && config.Distributed.RedisUrl == "" {
+		return config, errors.New("distributed.redis_url must be set to run as a " + *options.Role)
+	}
+
+	if config.Distributed.Queue == "" {
+		config.Distributed.Queue = defaultDistributedQueue
+	}
+
+	if config.Distributed.MaxQueuedJobs <= 0 {
+		config.Distributed.MaxQueuedJobs = defaultDistributedMaxQueuedJobs
+	}
+
+	if config.Distributed.SeenTtl <= 0 {
+		config.Distributed.SeenTtl = defaultDistributedSeenTtl
+	}
+
+	if config.Distributed.FindingTtl <= 0 {
+		config.Distributed.FindingTtl = defaultDistributedFindingTtl
+	}
+
+	if err := config.Canaries.validate(); err != nil {
+		return config, err
+	}
+
+	if err := config.EventFilter.validate(); err != nil {
+		return config, err
+	} else if config.EventFilter.Redis && config.Distributed.RedisUrl == "" {
+		return config, errors.New("distributed.redis_url must be set to share event_filter on Redis")
+	}
+
+	if len(*options.DatabaseUrl) <= 0 {
+		*options.DatabaseUrl = secrets.expand(config.DatabaseUrl)
+	}
+
+	if config.Entropy.MinimumLength <= 0 {
+		config.Entropy.MinimumLength = defaultEntropyMinimumLength
+	}
+
+	if config.Entropy.Base64Threshold <= 0 {
+		config.Entropy.Base64Threshold = *options.EntropyThreshold
+	}
+
+	if config.Entropy.HexThreshold <= 0 {
+		config.Entropy.HexThreshold = defaultEntropyHexThreshold
+	}
+
+	if config.Generic.Keywords == nil {
+		config.Generic.Keywords = defaultGenericKeywords
+	}
+
+	if config.Generic.MinimumLength <= 0 {
+		config.Generic.MinimumLength = defaultGenericMinimumLength
+	}
+
+	if config.Generic.MaximumLength <= 0 {
+		config.Generic.MaximumLength = defaultGenericMaximumLength
+	}
+
+	if config.Generic.Threshold <= 0 {
+		config.Generic.Threshold = defaultGenericThreshold
+	}
+
+	if len(*options.MinimumSeverity) <= 0 {
+		*options.MinimumSeverity = config.MinimumSeverity
+	}
+
+	if *options.MinimumSeverity != "" && !IsSeverity(*options.MinimumSeverity) {
+		return config, errors.New("minimum severity must be one of " + strings.Join(Severities, ", "))
+	}
+
+	if len(*options.FailOn) <= 0 {
+		*options.FailOn = config.ExitPolicy.FailOnSeverity
+	}
+
+	if *options.FailOn != "" && !IsSeverity(*options.FailOn) {
+		return config, errors.New("fail on severity must be one of " + strings.Join(Severities, ", "))
+	}
+
+	if !options.IsSet("exit-code") && config.ExitPolicy.ExitCode != nil {
+		*options.ExitCode = *config.ExitPolicy.ExitCode
+	}
+
+	if !options.IsSet("max-findings") {
+		*options.MaxFindings = config.ExitPolicy.MaxFindings
+	}
+
+	if *options.MaxFindings < 0 {
+		return config, errors.New("max findings can't be negative")
+	}
+
+	if config.RedactSecrets {
+		*options.RedactSecrets = true
+	}
+
+	if len(*options.LogFormat) <= 0 {
+		*options.LogFormat = config.Logging.Format
+	}
+
+	if *options.LogFormat == "" {
+		*options.LogFormat = LogFormatText
+	}
+
+	if !containsString(LogFormats, *options.LogFormat) {
+		return config, errors.New("log format must be one of " + strings.Join(LogFormats, ", "))
+	}
+
+	if len(*options.LogLevel) <= 0 {
+		*options.LogLevel = config.Logging.Level
+	}
+
+	if _, ok := LogLevels[*options.LogLevel]; *options.LogLevel != "" && !ok {
+		return config, errors.New("log level must be one of " + strings.Join(logLevelNames(), ", "))
+	}
+
+	if len(*options.LogFile) <= 0 {
+		*options.LogFile = config.Logging.File
+	}
+
+	if !options.IsSet("log-max-size") && config.Logging.MaxSize > 0 {
+		*options.LogMaxSize = config.Logging.MaxSize
+	}
+
+	if !options.IsSet("log-max-backups") && config.Logging.MaxBackups != nil {
+		*options.LogMaxBackups = *config.Logging.MaxBackups
+	}
+
+	if len(*options.LogModules) <= 0 {
+		var modules []string
+		for module, level := range config.Logging.Modules {
+			modules = append(modules, module+"="+level)
+		}
+
+		sort.Strings(modules)
+		*options.LogModules = strings.Join(modules, ",")
+	}
+
+	if _, err := ParseLogModules(*options.LogModules); err != nil {
+		return config, err
 	}
 
 	if len(config.Webhook) > 0 {
-		config.Webhook = os.ExpandEnv(config.Webhook)
+		config.Webhook = secrets.expand(config.Webhook)
+	}
+
+	if err := config.SinksConfig.expand(secrets); err != nil {
+		return config, err
+	}
+
+	config.GitHubRemediation.Token = secrets.expand(config.GitHubRemediation.Token)
+	if action := config.GitHubRemediation.Action; action != "" && !containsString(RemediationActions, action) {
+		return config, errors.New("github_remediation.action must be one of " + strings.Join(RemediationActions, ", "))
+	}
+
+	if expired := config.Jwt.Expired; expired != "" && expired != JwtExpiredDowngrade && expired != JwtExpiredDrop {
+		return config, errors.New("jwt.expired must be " + JwtExpiredDowngrade + " or " + JwtExpiredDrop)
+	} else if config.Jwt.ExpiredDays < 0 {
+		return config, errors.New("jwt.expired_days can't be negative")
 	}
 
-	return config, nil
+	if severity := config.AwsPairs.LoneSeverity; severity != "" && !IsSeverity(severity) {
+		return config, errors.New("aws_pairs.lone_severity must be one of " + strings.Join(Severities, ", "))
+	} else if confidence := config.AwsPairs.LoneConfidence; confidence != "" && !IsConfidence(confidence) {
+		return config, errors.New("aws_pairs.lone_confidence must be one of " + strings.Join(Confidences, ", "))
+	}
+
+	for i := range config.WatchTerms {
+		if err := config.WatchTerms[i].compile(); err != nil {
+			return config, err
+		}
+	}
+
+	tenants := make(map[string]bool)
+	for i := range config.Tenants {
+		if err := config.Tenants[i].validate(secrets); err != nil {
+			return config, err
+		}
+
+		if tenants[config.Tenants[i].Name] {
+			return config, errors.New("tenant " + config.Tenants[i].Name + " is configured twice")
+		}
+		tenants[config.Tenants[i].Name] = true
+	}
+
+	for i := range config.Revocation.Aws {
+		config.Revocation.Aws[i].AccessKeyId = secrets.expand(config.Revocation.Aws[i].AccessKeyId)
+		config.Revocation.Aws[i].SecretAccessKey = secrets.expand(config.Revocation.Aws[i].SecretAccessKey)
+		config.Revocation.Aws[i].SessionToken = secrets.expand(config.Revocation.Aws[i].SessionToken)
+	}
+
+	for i := range config.Revocation.Webhooks {
+		config.Revocation.Webhooks[i].Url = secrets.expand(config.Revocation.Webhooks[i].Url)
+		config.Revocation.Webhooks[i].Secret = secrets.expand(config.Revocation.Webhooks[i].Secret)
+	}
+
+	config.Vault.Address = secrets.expand(config.Vault.Address)
+	config.Vault.Token = secrets.expand(config.Vault.Token)
+	if version := config.Vault.KvVersion; version != 0 && version != 1 && version != 2 {
+		return config, errors.New("vault.kv_version must be 1 or 2")
+	}
+
+	if len(config.GitLab.Url) <= 0 {
+		config.GitLab.Url = "https://gitlab.com"
+	}
+
+	config.GitLab.AccessToken = secrets.expand(config.GitLab.AccessToken)
+
+	if len(config.Gitea.Url) <= 0 {
+		config.Gitea.Url = "https://gitea.com"
+	}
+
+	config.Gitea.Url = secrets.expand(config.Gitea.Url)
+	config.Gitea.AccessToken = secrets.expand(config.Gitea.AccessToken)
+
+	if len(config.AzureDevOps.Url) <= 0 {
+		config.AzureDevOps.Url = "https://dev.azure.com"
+	}
+
+	config.AzureDevOps.AccessToken = secrets.expand(config.AzureDevOps.AccessToken)
+
+	if len(config.Pastebin.ScrapeUrl) <= 0 {
+		config.Pastebin.ScrapeUrl = "https://scrape.pastebin.com"
+	}
+
+	if len(config.Packages.Registries) <= 0 {
+		config.Packages.Registries = []string{NpmRegistry, PypiRegistry, RubygemsRegistry}
+	}
+
+	if len(config.Packages.NpmUrl) <= 0 {
+		config.Packages.NpmUrl = "https://registry.npmjs.org"
+	}
+
+	if len(config.Packages.NpmChangesUrl) <= 0 {
+		config.Packages.NpmChangesUrl = "https://replicate.npmjs.com/_changes"
+	}
+
+	if len(config.Packages.PypiUrl) <= 0 {
+		config.Packages.PypiUrl = "https://pypi.org"
+	}
+
+	if len(config.Packages.RubygemsUrl) <= 0 {
+		config.Packages.RubygemsUrl = "https://rubygems.org"
+	}
+
+	if config.Buckets.Interval <= 0 {
+		config.Buckets.Interval = defaultBucketsInterval
+	}
+
+	if len(config.Buckets.AwsRegion) <= 0 {
+		config.Buckets.AwsRegion = defaultAwsRegion
+	}
+
+	config.Buckets.AwsAccessKeyId = secrets.expand(config.Buckets.AwsAccessKeyId)
+	config.Buckets.AwsSecretAccessKey = secrets.expand(config.Buckets.AwsSecretAccessKey)
+	config.Buckets.AwsSessionToken = secrets.expand(config.Buckets.AwsSessionToken)
+	config.Buckets.GcsAccessToken = secrets.expand(config.Buckets.GcsAccessToken)
+	config.Buckets.AzureSasToken = secrets.expand(config.Buckets.AzureSasToken)
+	config.Docker.Password = secrets.expand(config.Docker.Password)
+	config.Bitbucket.AppPassword = secrets.expand(config.Bitbucket.AppPassword)
+
+	return config, secrets.err
+}
+
+// expand expands the secrets of the sinks and checks their settings
+func (sinks *SinksConfig) expand(secrets *secretExpander) error {
+	for i := range sinks.Webhooks {
+		sinks.Webhooks[i].Url = secrets.expand(sinks.Webhooks[i].Url)
+		sinks.Webhooks[i].Secret = secrets.expand(sinks.Webhooks[i].Secret)
+	}
+
+	for i := range sinks.Slack {
+		sinks.Slack[i].WebhookUrl = secrets.expand(sinks.Slack[i].WebhookUrl)
+		sinks.Slack[i].Token = secrets.expand(sinks.Slack[i].Token)
+	}
+
+	for i := range sinks.Kafka {
+		sinks.Kafka[i].Password = secrets.expand(sinks.Kafka[i].Password)
+	}
+
+	for i := range sinks.Elasticsearch {
+		sinks.Elasticsearch[i].Url = secrets.expand(sinks.Elasticsearch[i].Url)
+		sinks.Elasticsearch[i].Password = secrets.expand(sinks.Elasticsearch[i].Password)
+		sinks.Elasticsearch[i].ApiKey = secrets.expand(sinks.Elasticsearch[i].ApiKey)
+	}
+
+	for i := range sinks.Sqs {
+		sinks.Sqs[i].QueueUrl = secrets.expand(sinks.Sqs[i].QueueUrl)
+		sinks.Sqs[i].AccessKeyId = secrets.expand(sinks.Sqs[i].AccessKeyId)
+		sinks.Sqs[i].SecretAccessKey = secrets.expand(sinks.Sqs[i].SecretAccessKey)
+		sinks.Sqs[i].SessionToken = secrets.expand(sinks.Sqs[i].SessionToken)
+	}
+
+	for i := range sinks.Sns {
+		sinks.Sns[i].TopicArn = secrets.expand(sinks.Sns[i].TopicArn)
+		sinks.Sns[i].AccessKeyId = secrets.expand(sinks.Sns[i].AccessKeyId)
+		sinks.Sns[i].SecretAccessKey = secrets.expand(sinks.Sns[i].SecretAccessKey)
+		sinks.Sns[i].SessionToken = secrets.expand(sinks.Sns[i].SessionToken)
+	}
+
+	for i := range sinks.PagerDuty {
+		sinks.PagerDuty[i].RoutingKey = secrets.expand(sinks.PagerDuty[i].RoutingKey)
+	}
+
+	for i := range sinks.Opsgenie {
+		sinks.Opsgenie[i].ApiKey = secrets.expand(sinks.Opsgenie[i].ApiKey)
+	}
+
+	for i := range sinks.Email {
+		sinks.Email[i].Password = secrets.expand(sinks.Email[i].Password)
+
+		if _, ok := EmailDigests[sinks.Email[i].Digest]; !ok && sinks.Email[i].Digest != EmailDigestNone {
+			return errors.New("email digest must be " + EmailDigestHourly + " or " + EmailDigestDaily)
+		}
+	}
+
+	for i := range sinks.Splunk {
+		sinks.Splunk[i].Url = secrets.expand(sinks.Splunk[i].Url)
+		sinks.Splunk[i].Token = secrets.expand(sinks.Splunk[i].Token)
+	}
+
+	for i := range sinks.Outputs {
+		sinks.Outputs[i].Path = secrets.expand(sinks.Outputs[i].Path)
+
+		if !containsString(OutputFormats, sinks.Outputs[i].Format) {
+			return errors.New("outputs format must be one of " + strings.Join(OutputFormats, ", "))
+		}
+	}
+
+	for _, syslog := range sinks.Syslog {
+		if syslog.Format != "" && syslog.Format != SyslogFormatCef && syslog.Format != SyslogFormatLeef {
+			return errors.New("syslog format must be " + SyslogFormatCef + " or " + SyslogFormatLeef)
+		}
+	}
+
+	for i := range sinks.Discord {
+		sinks.Discord[i].WebhookUrl = secrets.expand(sinks.Discord[i].WebhookUrl)
+	}
+
+	for i := range sinks.Teams {
+		sinks.Teams[i].WebhookUrl = secrets.expand(sinks.Teams[i].WebhookUrl)
+	}
+
+	for i := range sinks.Mattermost {
+		sinks.Mattermost[i].WebhookUrl = secrets.expand(sinks.Mattermost[i].WebhookUrl)
+	}
+
+	return nil
 }
 
 func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
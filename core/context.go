@@ -0,0 +1,196 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	ContextTests        = "tests"
+	ContextExamples     = "examples"
+	ContextPlaceholders = "placeholders"
+	ContextLockfiles    = "lockfiles"
+	ContextMinified     = "minified"
+	ContextDocs         = "docs"
+	ContextNone         = "none"
+
+	// average line length above which a .js or .css file counts as minified
+	minifiedLineLength = 300
+)
+
+// Contexts are where matches are usually false positives, and are all
+// suppressed unless suppressed_contexts says otherwise
+var Contexts = []string{ContextTests, ContextExamples, ContextPlaceholders, ContextLockfiles, ContextMinified, ContextDocs}
+
+var (
+	testDirectories    = []string{"test", "tests", "__tests__", "spec", "specs", "fixtures", "__fixtures__", "testdata", "mocks", "__mocks__"}
+	testFileRegex      = regexp.MustCompile(`(?i)(_test\.[a-z]+|\.(test|spec)\.[a-z]+|^test_.+\.py)$`)
+	exampleDirectories = []string{"example", "examples", "sample", "samples", "demo", "demos"}
+	exampleExtensions  = []string{".example", ".sample", ".dist", ".template", ".tmpl"}
+	lockfiles          = []string{"package-lock.json", "npm-shrinkwrap.json", "yarn.lock", "pnpm-lock.yaml", "composer.lock", "gemfile.lock", "poetry.lock", "pipfile.lock", "cargo.lock", "go.sum", "podfile.lock", "mix.lock"}
+	placeholderRegex   = regexp.MustCompile(`(?i)(example|sample|dummy|placeholder|changeme|your[_-]?[a-z_-]*[_-]here|x{6,}|\*{6,}|<[a-z_-]+>)`)
+	markdownExtensions = []string{".md", ".markdown", ".mdx"}
+)
+
+// ContextFilter drops matches in the suppressed contexts. A nil filter
+// suppresses nothing
+type ContextFilter struct {
+	suppressed map[string]bool
+}
+
+// NewContextFilter suppresses the given contexts
+func NewContextFilter(contexts []string) (*ContextFilter, error) {
+	filter := &ContextFilter{suppressed: make(map[string]bool)}
+
+	for _, context := range contexts {
+		context = strings.ToLower(strings.TrimSpace(context))
+		if context == ContextNone || context == "" {
+			continue
+		}
+
+		known := false
+		for _, c := range Contexts {
+			known = known || c == context
+		}
+
+		if !known {
+			return nil, fmt.Errorf("unknown context %s. Available contexts: %s", context, strings.Join(Contexts, ", "))
+		}
+
+		filter.suppressed[context] = true
+	}
+
+	return filter, nil
+}
+
+// FileContext returns the suppressed context every match in a file is in,
+// or "". filePath is relative to the repository root
+func (c *ContextFilter) FileContext(file MatchFile, filePath string) string {
+	if c == nil {
+		return ""
+	}
+
+	filePath = strings.ToLower(strings.TrimPrefix(filePath, "/"))
+	filename := strings.ToLower(file.Filename)
+	extension := strings.ToLower(file.Extension)
+
+	if c.suppressed[ContextTests] && (inDirectory(filePath, testDirectories) || testFileRegex.MatchString(filename)) {
+		return ContextTests
+	}
+
+	if c.suppressed[ContextExamples] && (inDirectory(filePath, exampleDirectories) || containsString(exampleExtensions, extension)) {
+		return ContextExamples
+	}
+
+	if c.suppressed[ContextLockfiles] && containsString(lockfiles, filename) {
+		return ContextLockfiles
+	}
+
+	if c.suppressed[ContextMinified] && isMinified(filename, extension, file.Contents) {
+		return ContextMinified
+	}
+
+	return ""
+}
+
+// MatchContext returns the suppressed context a match in a file is in, or ""
+func (c *ContextFilter) MatchContext(file MatchFile, match ContentsMatch) string {
+	if c == nil {
+		return ""
+	}
+
+	if c.suppressed[ContextPlaceholders] && placeholderRegex.MatchString(match.Value) {
+		return ContextPlaceholders
+	}
+
+	if c.suppressed[ContextDocs] && containsString(markdownExtensions, strings.ToLower(file.Extension)) && inCodeFence(file.Contents, match.Offset) {
+		return ContextDocs
+	}
+
+	return ""
+}
+
+// Filter returns the matches not in a suppressed context
+func (c *ContextFilter) Filter(file MatchFile, matches []ContentsMatch) []ContentsMatch {
+	if c == nil {
+		return matches
+	}
+
+	filtered := make([]ContentsMatch, 0, len(matches))
+	for _, match := range matches {
+		if c.MatchContext(file, match) == "" {
+			filtered = append(filtered, match)
+		}
+	}
+
+	return filtered
+}
+
+func (s *Session) InitContextFilter() {
+	filter, err := NewContextFilter(strings.Split(*s.Options.SuppressedContexts, ","))
+	if err != nil {
+		s.Log.Fatal("%s", err)
+	}
+
+	s.Contexts = filter
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inDirectory reports whether any directory of filePath is in directories
+func inDirectory(filePath string, directories []string) bool {
+	for dir := path.Dir(filePath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if containsString(directories, path.Base(dir)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isMinified(filename string, extension string, contents []byte) bool {
+	if strings.Contains(filename, ".min.") || strings.Contains(filename, ".bundle.") {
+		return true
+	}
+
+	if extension != ".js" && extension != ".css" {
+		return false
+	}
+
+	return len(contents)/(bytes.Count(contents, []byte("\n"))+1) > minifiedLineLength
+}
+
+// inCodeFence reports whether offset is inside a ``` or ~~~ fenced block
+func inCodeFence(contents []byte, offset int) bool {
+	fenced := false
+	for start := 0; start < len(contents) && start <= offset; {
+		end := bytes.IndexByte(contents[start:], '\n')
+		if end < 0 {
+			end = len(contents)
+		} else {
+			end += start
+		}
+
+		line := bytes.TrimSpace(contents[start:end])
+		if bytes.HasPrefix(line, []byte("```")) || bytes.HasPrefix(line, []byte("~~~")) {
+			fenced = !fenced
+		} else if offset < end && fenced {
+			return true
+		}
+
+		start = end + 1
+	}
+
+	return false
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const FormatCsv = "csv"
+
+// CsvFields are the columns --csv-fields can select from, in their default
+// order
+var CsvFields = []string{
+	"repository",
+	"source",
+	"branch",
+	"commit",
+	"file",
+	"line",
+	"column",
+	"offset",
+	"signature",
+	"signature_id",
+	"severity",
+	"confidence",
+	"entropy",
+	"verified",
+	"match",
+	"fingerprint",
+}
+
+// ParseCsvFields splits a comma separated list of CsvFields, returning all
+// of them if it's empty
+func ParseCsvFields(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return CsvFields, nil
+	}
+
+	fields := []string{}
+	for _, field := range strings.Split(list, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+
+		known := false
+		for _, csvField := range CsvFields {
+			known = known || csvField == field
+		}
+
+		if !known {
+			return nil, fmt.Errorf("unknown CSV field %s. Available fields: %s", field, strings.Join(CsvFields, ", "))
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// CsvWriter appends a row per match, or per file for findings matched on
+// their path, with the selected fields as columns
+type CsvWriter struct {
+	sync.Mutex
+
+	file   *os.File
+	writer *csv.Writer
+	fields []string
+}
+
+func NewCsvWriter(path string, fields []string) (*CsvWriter, error) {
+	header := strings.Join(fields, ",")
+
+	if existing, err := os.Open(path); err == nil {
+		line, _ := bufio.NewReader(existing).ReadString('\n')
+		existing.Close()
+
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && line != header {
+			session.Log.Warn("%s was written with different columns (%s). New rows will have %s", path, line, header)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &CsvWriter{file: file, writer: csv.NewWriter(file), fields: fields}
+
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		w.writer.Write(fields)
+		w.writer.Flush()
+	}
+
+	return w, w.writer.Error()
+}
+
+func (w *CsvWriter) Write(event *MatchEvent) error {
+	signatureId := GetSlug(event.Signature)
+
+	w.Lock()
+	defer w.Unlock()
+
+	for i := 0; i == 0 || i < len(event.Matches); i++ {
+		row := make([]string, len(w.fields))
+
+		for j, field := range w.fields {
+			switch field {
+			case "repository":
+				row[j] = event.Url
+			case "source":
+				row[j] = SourceNames[event.Source]
+			case "branch":
+				row[j] = event.Branch
+			case "commit":
+				row[j] = event.Commit
+			case "file":
+				row[j] = event.File
+			case "line":
+				if i < len(event.Lines) {
+					row[j] = strconv.Itoa(event.Lines[i])
+				}
+			case "column":
+				if i < len(event.Columns) {
+					row[j] = strconv.Itoa(event.Columns[i])
+				}
+			case "offset":
+				if i < len(event.Offsets) {
+					row[j] = strconv.Itoa(event.Offsets[i])
+				}
+			case "signature":
+				row[j] = event.Signature
+			case "signature_id":
+				row[j] = signatureId
+			case "severity":
+				row[j] = event.Severity
+			case "confidence":
+				row[j] = event.Confidence
+			case "entropy":
+				if event.Entropy > 0 {
+					row[j] = strconv.FormatFloat(event.Entropy, 'f', 2, 64)
+				}
+			case "verified":
+				row[j] = strconv.FormatBool(event.Verified)
+			case "match":
+				if i < len(event.Matches) {
+					row[j] = event.Matches[i]
+				}
+			case "fingerprint":
+				if i < len(event.Fingerprints) {
+					row[j] = event.Fingerprints[i]
+				}
+			}
+		}
+
+		w.writer.Write(row)
+	}
+
+	w.writer.Flush()
+	return w.writer.Error()
+}
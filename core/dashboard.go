@@ -0,0 +1,181 @@
+package core
+
+import (
+	"time"
+)
+
+const (
+	DashboardHour = "hour"
+	DashboardDay  = "day"
+
+	defaultDashboardDays = 30
+	maximumDashboardDays = 365
+	// hourly buckets are only kept to a chartable number
+	maximumDashboardHourlyDays = 14
+	dashboardTopSize           = 10
+)
+
+// DashboardStats are the aggregates charted by the web UI, over the
+// findings of the last Days
+type DashboardStats struct {
+	Days     int
+	Interval string
+	Findings int
+	Verified int
+	// share of findings whose secret was confirmed to be live
+	VerificationRate float64
+	Triaged          int
+	// mean seconds from a finding being reported to it last being triaged,
+	// or 0 if none were
+	MeanTimeToTriage float64
+	BySeverity       map[string]int
+	Timeline         []DashboardBucket
+	TopSignatures    []DashboardCount
+	// owners of the repositories with the most findings, i.e.
+	// github.com/acme
+	TopOrganizations []DashboardCount
+}
+
+// DashboardBucket is the findings reported in an hour or day
+type DashboardBucket struct {
+	Time     time.Time
+	Findings int
+	Verified int
+}
+
+type DashboardCount struct {
+	Name     string
+	Findings int
+}
+
+// dashboardOrganization is the host and first path segment of a repository
+// URL, without its scheme or credentials
+const dashboardOrganization = `(split_part(regexp_replace(repository_url, '^[a-z+]+://([^@/]*@)?', ''), '/', 1) || '/' ||
+	split_part(regexp_replace(repository_url, '^[a-z+]+://([^@/]*@)?', ''), '/', 2))`
+
+// GetDashboardStats aggregates the findings of the last days into hourly or
+// daily buckets
+func (d *Database) GetDashboardStats(days int, interval string) (*DashboardStats, error) {
+	stats := &DashboardStats{Days: days, Interval: interval, BySeverity: map[string]int{}}
+
+	err := d.db.QueryRow(`SELECT count(*),
+			count(*) FILTER (WHERE verified),
+			count(*) FILTER (WHERE status <> 'new' AND status_updated_at IS NOT NULL),
+			COALESCE(avg(extract(epoch FROM status_updated_at - created_at)) FILTER (WHERE status <> 'new' AND status_updated_at IS NOT NULL), 0)
+		FROM findings WHERE created_at > now() - make_interval(days => $1)`, days).Scan(&stats.Findings, &stats.Verified, &stats.Triaged, &stats.MeanTimeToTriage)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.Findings > 0 {
+		stats.VerificationRate = float64(stats.Verified) / float64(stats.Findings)
+	}
+
+	if stats.BySeverity, err = d.getDashboardSeverities(days); err != nil {
+		return nil, err
+	}
+
+	if stats.Timeline, err = d.getDashboardTimeline(days, interval); err != nil {
+		return nil, err
+	}
+
+	if stats.TopSignatures, err = d.getDashboardTop("signature", days); err != nil {
+		return nil, err
+	}
+
+	if stats.TopOrganizations, err = d.getDashboardTop(dashboardOrganization, days); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// getDashboardSeverities counts the findings of each severity
+func (d *Database) getDashboardSeverities(days int) (map[string]int, error) {
+	rows, err := d.db.Query(`SELECT severity, count(*) FROM findings
+		WHERE created_at > now() - make_interval(days => $1) GROUP BY severity`, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	severities := map[string]int{}
+	for rows.Next() {
+		var severity string
+		var count int
+		if err := rows.Scan(&severity, &count); err != nil {
+			return nil, err
+		}
+		severities[severity] = count
+	}
+
+	return severities, rows.Err()
+}
+
+// getDashboardTimeline counts the findings of each hour or day, including
+// those without any
+func (d *Database) getDashboardTimeline(days int, interval string) ([]DashboardBucket, error) {
+	rows, err := d.db.Query(`SELECT to_char(date_trunc($2, created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), count(*), count(*) FILTER (WHERE verified)
+		FROM findings WHERE created_at > now() - make_interval(days => $1) GROUP BY 1`, days, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	counts := make(map[time.Time]DashboardBucket)
+	for rows.Next() {
+		bucket := DashboardBucket{}
+		var bucketTime string
+		if err := rows.Scan(&bucketTime, &bucket.Findings, &bucket.Verified); err != nil {
+			return nil, err
+		}
+
+		bucket.Time, _ = time.Parse(time.RFC3339, bucketTime)
+		counts[bucket.Time] = bucket
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	step := 24 * time.Hour
+	if interval == DashboardHour {
+		step = time.Hour
+	}
+
+	now := time.Now().UTC()
+	timeline := make([]DashboardBucket, 0)
+	for t := now.AddDate(0, 0, -days).Truncate(step); !t.After(now); t = t.Add(step) {
+		bucket, ok := counts[t]
+		if !ok {
+			bucket = DashboardBucket{Time: t}
+		}
+		timeline = append(timeline, bucket)
+	}
+
+	return timeline, nil
+}
+
+// getDashboardTop returns the values of column with the most findings
+func (d *Database) getDashboardTop(column string, days int) ([]DashboardCount, error) {
+	rows, err := d.db.Query(`SELECT `+column+`, count(*) FROM findings
+		WHERE created_at > now() - make_interval(days => $1)
+		GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT $2`, days, dashboardTopSize)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	top := make([]DashboardCount, 0, dashboardTopSize)
+	for rows.Next() {
+		count := DashboardCount{}
+		if err := rows.Scan(&count.Name, &count.Findings); err != nil {
+			return nil, err
+		}
+		top = append(top, count)
+	}
+
+	return top, rows.Err()
+}
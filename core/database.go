@@ -0,0 +1,385 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ScanStatusScanned = "scanned"
+	ScanStatusMatched = "matched"
+	ScanStatusFailed  = "failed"
+
+	FindingStatusNew           = "new"
+	FindingStatusConfirmed     = "confirmed"
+	FindingStatusFalsePositive = "false_positive"
+	FindingStatusRevoked       = "revoked"
+
+	// arbitrary key for pg_advisory_lock so instances migrate one at a time
+	databaseMigrationLock = 7041991
+)
+
+// databaseMigrations are applied in order, each once. Append, never edit
+var databaseMigrations = []string{
+	`CREATE TABLE repositories (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL UNIQUE,
+		source INTEGER NOT NULL,
+		stars INTEGER NOT NULL DEFAULT -1,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		scans INTEGER NOT NULL DEFAULT 1,
+		first_seen TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_scanned TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE TABLE findings (
+		id BIGSERIAL PRIMARY KEY,
+		repository_url TEXT NOT NULL,
+		source INTEGER NOT NULL,
+		stars INTEGER NOT NULL DEFAULT -1,
+		signature TEXT NOT NULL,
+		file TEXT NOT NULL,
+		commit_sha TEXT NOT NULL DEFAULT '',
+		matches JSONB NOT NULL,
+		lines JSONB NOT NULL,
+		verified BOOLEAN NOT NULL DEFAULT false,
+		entropy DOUBLE PRECISION NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+
+	CREATE INDEX findings_repository_url ON findings (repository_url);
+	CREATE INDEX findings_created_at ON findings (created_at);
+
+	CREATE TABLE dedup_keys (
+		key TEXT PRIMARY KEY,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`,
+
+	`ALTER TABLE findings
+		ADD COLUMN status TEXT NOT NULL DEFAULT 'new',
+		ADD COLUMN status_updated_at TIMESTAMPTZ;
+
+	CREATE INDEX findings_status ON findings (status);`,
+
+	`ALTER TABLE findings
+		ADD COLUMN severity TEXT NOT NULL DEFAULT '',
+		ADD COLUMN confidence TEXT NOT NULL DEFAULT '';`,
+
+	`CREATE INDEX findings_signature ON findings (signature);
+	CREATE INDEX findings_severity ON findings (severity);`,
+}
+
+var FindingStatuses = []string{FindingStatusNew, FindingStatusConfirmed, FindingStatusFalsePositive, FindingStatusRevoked}
+
+func IsFindingStatus(status string) bool {
+	for _, s := range FindingStatuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StoredFinding is a finding as persisted, with its triage status
+type StoredFinding struct {
+	MatchEvent
+	Id              int64
+	SourceName      string
+	Status          string
+	CreatedAt       time.Time
+	StatusUpdatedAt *time.Time
+}
+
+// Database stores findings and the scan status of every repository in
+// PostgreSQL, through a pool of connections which are re-established if
+// they break
+type Database struct {
+	db *sql.DB
+}
+
+func NewDatabase(url string) (*Database, error) {
+	db, err := openPostgres(url)
+	if err != nil {
+		return nil, err
+	}
+
+	database := &Database{db: db}
+	if err := database.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return database, nil
+}
+
+// migrate applies the migrations not yet applied, each in a transaction. An
+// advisory lock, held by the connection that takes it, keeps other instances
+// from migrating at the same time
+func (d *Database) migrate() error {
+	ctx := context.Background()
+
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())"); err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", databaseMigrationLock); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", databaseMigrationLock)
+
+	current := 0
+	if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+
+	for version := current + 1; version <= len(databaseMigrations); version++ {
+		if err := migrateTo(ctx, conn, version); err != nil {
+			return fmt.Errorf("migration %d failed: %s", version, err)
+		}
+	}
+
+	return nil
+}
+
+func migrateTo(ctx context.Context, conn *sql.Conn, version int) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// without arguments the migration is sent as a simple query, which may
+	// hold several statements
+	if _, err := tx.ExecContext(ctx, databaseMigrations[version-1]); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RecordScan upserts the repository with the outcome of its latest scan
+func (d *Database) RecordScan(url string, source GitResourceType, stars int, status string, scanErr error) {
+	if d == nil {
+		return
+	}
+
+	message := ""
+	if scanErr != nil {
+		message = scanErr.Error()
+	}
+
+	_, err := d.db.Exec(`INSERT INTO repositories (url, source, stars, status, error) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (url) DO UPDATE SET stars = EXCLUDED.stars, status = EXCLUDED.status, error = EXCLUDED.error,
+		scans = repositories.scans + 1, last_scanned = now()`, url, int(source), stars, status, message)
+
+	LogIfError("Failed to record scan in database", err)
+}
+
+// IsNew is the database equivalent of DedupStore.IsNew, shared by every
+// instance using the same database
+func (d *Database) IsNew(url string, network string, path string, match string) bool {
+	keys := findingKeys(url, network, path, match)
+
+	placeholders := make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		placeholders[i], args[i] = fmt.Sprintf("($%d)", i+1), key
+	}
+
+	inserted := 0
+	err := d.db.QueryRow(`WITH inserted AS (INSERT INTO dedup_keys (key) VALUES `+strings.Join(placeholders, ", ")+` ON CONFLICT DO NOTHING RETURNING key)
+		SELECT count(*) FROM inserted`, args...).Scan(&inserted)
+
+	if err != nil {
+		// better to alert twice than not at all
+		session.Log.Warn("Failed to check database for duplicate findings: %s", err)
+		return true
+	}
+
+	return inserted == len(keys)
+}
+
+// FindingsQuery filters stored findings and pages through them. Fields left
+// empty match every finding
+type FindingsQuery struct {
+	Status    string
+	Signature string
+	Severity  string
+	Source    *GitResourceType
+	// part of the repository URL, whatever its case
+	Repository string
+	// part of the repository URL, file, signature or matches
+	Search string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+// where returns the WHERE clause of the query and its arguments
+func (query FindingsQuery) where() (string, []interface{}) {
+	conditions := []string{"true"}
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", fmt.Sprintf("$%d", len(args)), -1))
+	}
+
+	if query.Status != "" {
+		add("status = ?", query.Status)
+	}
+	if query.Signature != "" {
+		add("signature = ?", query.Signature)
+	}
+	if query.Severity != "" {
+		add("severity = ?", query.Severity)
+	}
+	if query.Source != nil {
+		add("source = ?", int(*query.Source))
+	}
+	if query.Repository != "" {
+		add("repository_url ILIKE ?", likePattern(query.Repository))
+	}
+	if query.Search != "" {
+		add("(repository_url ILIKE ? OR file ILIKE ? OR signature ILIKE ? OR matches::text ILIKE ?)", likePattern(query.Search))
+	}
+	if !query.Since.IsZero() {
+		add("created_at >= ?", query.Since.UTC().Format(time.RFC3339))
+	}
+	if !query.Until.IsZero() {
+		add("created_at < ?", query.Until.UTC().Format(time.RFC3339))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// likePattern matches text containing value, escaping the wildcards of LIKE
+func likePattern(value string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value) + "%"
+}
+
+// GetFindings returns the findings matching a query, newest first
+func (d *Database) GetFindings(query FindingsQuery) ([]StoredFinding, error) {
+	where, args := query.where()
+	args = append(args, query.Limit, query.Offset)
+
+	rows, err := d.db.Query(fmt.Sprintf(`SELECT id, repository_url, source, stars, signature, file, commit_sha, matches, lines, verified, entropy, status, severity, confidence, created_at, status_updated_at
+		FROM findings WHERE %s ORDER BY id DESC LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args)), args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	findings := []StoredFinding{}
+	for rows.Next() {
+		finding := StoredFinding{}
+		var matches, lines []byte
+
+		err := rows.Scan(&finding.Id, &finding.Url, &finding.Source, &finding.Stars, &finding.Signature, &finding.File, &finding.Commit,
+			&matches, &lines, &finding.Verified, &finding.Entropy, &finding.Status, &finding.Severity, &finding.Confidence,
+			&finding.CreatedAt, &finding.StatusUpdatedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		json.Unmarshal(matches, &finding.Matches)
+		json.Unmarshal(lines, &finding.Lines)
+		finding.SourceName = SourceNames[finding.Source]
+		finding.CreatedAt = finding.CreatedAt.UTC()
+
+		if finding.StatusUpdatedAt != nil {
+			updated := finding.StatusUpdatedAt.UTC()
+			finding.StatusUpdatedAt = &updated
+		}
+
+		findings = append(findings, finding)
+	}
+
+	return findings, rows.Err()
+}
+
+// CountMatchingFindings returns the number of findings matching a query,
+// regardless of its limit and offset
+func (d *Database) CountMatchingFindings(query FindingsQuery) (int, error) {
+	where, args := query.where()
+
+	count := 0
+	err := d.db.QueryRow("SELECT count(*) FROM findings WHERE "+where, args...).Scan(&count)
+
+	return count, err
+}
+
+// CountFindings returns the number of findings with each triage status
+func (d *Database) CountFindings() (map[string]int, error) {
+	rows, err := d.db.Query("SELECT status, count(*) FROM findings GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// SetFindingStatus triages a finding, reporting false if it doesn't exist
+func (d *Database) SetFindingStatus(id int64, status string) (bool, error) {
+	result, err := d.db.Exec("UPDATE findings SET status = $1, status_updated_at = now() WHERE id = $2", status, id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// DatabaseSink stores every finding
+type DatabaseSink struct {
+	database *Database
+}
+
+func NewDatabaseSink(database *Database) *DatabaseSink {
+	return &DatabaseSink{database: database}
+}
+
+func (d *DatabaseSink) Name() string {
+	return "PostgreSQL"
+}
+
+func (d *DatabaseSink) Send(event *MatchEvent) error {
+	matches, _ := json.Marshal(event.Matches)
+	lines, _ := json.Marshal(event.Lines)
+
+	_, err := d.database.db.Exec(`INSERT INTO findings (repository_url, source, stars, signature, file, commit_sha, matches, lines, verified, entropy, severity, confidence)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8::jsonb, $9, $10, $11, $12)`,
+		event.Url, int(event.Source), event.Stars, event.Signature, event.File, event.Commit, string(matches), string(lines), event.Verified, event.Entropy, event.Severity, event.Confidence)
+
+	return err
+}
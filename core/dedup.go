@@ -0,0 +1,160 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var dedupBucket = []byte("findings")
+
+// DedupStore remembers reported findings across restarts in a BoltDB file,
+// keyed on the SHA-1 of where they were found and what matched
+type DedupStore struct {
+	db *bolt.DB
+}
+
+func NewDedupStore(path string) (*DedupStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dedupBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DedupStore{db: db}, nil
+}
+
+func dedupKey(parts ...string) string {
+	hash := sha1.Sum([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+// findingKeys are the keys a match is deduplicated on: where it was found,
+// and, unless network is empty, where in the network of forks and mirrors
+// the repository belongs to
+func findingKeys(url string, network string, path string, match string) []string {
+	keys := []string{dedupKey(url, path, match)}
+	if network != "" {
+		keys = append(keys, dedupKey("network", normalizeRepositoryUrl(network), path, match))
+	}
+
+	return keys
+}
+
+// IsNew records a match and reports whether it was new. A match is a
+// duplicate if it was seen before in the same repository at the same path,
+// or in the same network of forks when network, the repository they were
+// copied from, is given
+func (d *DedupStore) IsNew(url string, network string, path string, match string) bool {
+	isNew := true
+
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dedupBucket)
+		keys := findingKeys(url, network, path, match)
+
+		for _, key := range keys {
+			if bucket.Get([]byte(key)) != nil {
+				isNew = false
+				return nil
+			}
+		}
+
+		for _, key := range keys {
+			if err := bucket.Put([]byte(key), []byte{}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		// better to alert twice than not at all
+		session.Log.Warn("Failed to record finding in dedup store: %s", err)
+	}
+
+	return isNew
+}
+
+// findingNetwork is the repository whose forks and mirrors share findings
+// with the one at url: the one they were all copied from, or url itself
+func (s *Session) findingNetwork(url string) string {
+	if root := s.Forks.Root(url); root != "" {
+		return root
+	}
+
+	return url
+}
+
+// IsNewFinding reports whether a match should be alerted on: it isn't
+// allowlisted, a baseline isn't being created and, when --dedup-path or
+// --database-url is set, it hasn't been reported before. With
+// distributed.redis_url it mustn't have been reported by another instance.
+// Matches already reported in the repository a fork or mirror was copied
+// from, or in another of its forks, are left out
+func (s *Session) IsNewFinding(url string, path string, match string) bool {
+	return s.isNewFinding(url, s.findingNetwork(url), path, match)
+}
+
+// IsNewPathFinding is IsNewFinding for a file matched on its path alone.
+// Every repository can have its own .env or id_rsa, so these are only ever
+// duplicates of findings in the same repository, not in its forks
+func (s *Session) IsNewPathFinding(url string, path string, signature string) bool {
+	return s.isNewFinding(url, "", path, signature)
+}
+
+// isNewFinding deduplicates a match within its repository and, unless
+// network is empty, the network of forks it belongs to
+func (s *Session) isNewFinding(url string, network string, path string, match string) bool {
+	if s.Allowlist.IsAllowed(path, match) {
+		return false
+	}
+
+	if s.Baseline != nil {
+		s.Baseline.Add(path, match)
+		return false
+	}
+
+	if s.Forks != nil && network != "" {
+		if isNew, reported := s.Forks.IsNew(url, path, match); !isNew {
+			s.Log.Debug("[%s] Skipping finding in %s, already reported in %s", url, path, reported)
+			s.Metrics.Inc(MetricForkDuplicates)
+			return false
+		}
+	}
+
+	if s.Dedup != nil && !s.Dedup.IsNew(url, network, path, match) {
+		return false
+	}
+
+	if s.Shared != nil && !s.Shared.IsNewFinding(url, network, path, match) {
+		return false
+	}
+
+	return s.Database == nil || s.Database.IsNew(url, network, path, match)
+}
+
+// FilterNewFindings returns the matches not reported before, or nil if
+// there are none
+func (s *Session) FilterNewFindings(url string, path string, matches []ContentsMatch) []ContentsMatch {
+	var fresh []ContentsMatch
+
+	for _, match := range matches {
+		if s.IsNewFinding(url, path, match.Value) {
+			fresh = append(fresh, match)
+		}
+	}
+
+	return fresh
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// the compare API lists at most this many files, and omits patches of
+// large diffs
+const maximumCompareFiles = 300
+
+var (
+	ErrDiffUnavailable = errors.New("diff not available from the compare API")
+
+	zeroCommitRegex = regexp.MustCompile(`^0+$`)
+	hunkHeaderRegex = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+)
+
+// GetPushDiffFiles returns the lines added between before and head as
+// files to scan, with the rest of each file blank so line numbers match.
+// Only the commits the scan cache hasn't seen are compared, and they're
+// returned alongside the files. It returns ErrDiffUnavailable when the
+// repository should be cloned instead, i.e. for new branches or diffs too
+// large for the compare API
+func GetPushDiffFiles(session *Session, instance *GitHubInstance, repo *github.Repository, before string, head string, dir string) ([]MatchFile, []string, error) {
+	if before == "" || head == "" || zeroCommitRegex.MatchString(before) {
+		return nil, nil, ErrDiffUnavailable
+	}
+
+	comparison, err := compareCommits(session, instance, repo, before, head)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// commits are listed oldest first. Those up to the newest already
+	// scanned, such as when a branch is pushed again after a rebase or
+	// merged in to another, are left out of the diff
+	var commits []string
+	for i := len(comparison.Commits) - 1; i >= 0; i-- {
+		sha := comparison.Commits[i].GetSHA()
+		if !session.ScanCache.Has(repo.GetCloneURL(), sha) {
+			commits = append(commits, sha)
+			continue
+		}
+
+		if len(commits) == 0 {
+			return nil, nil, nil
+		}
+
+		if comparison, err = compareCommits(session, instance, repo, sha, head); err != nil {
+			return nil, nil, err
+		}
+		break
+	}
+
+	if len(comparison.Files) >= maximumCompareFiles {
+		return nil, nil, ErrDiffUnavailable
+	}
+
+	files := make([]MatchFile, 0, len(comparison.Files))
+	for _, f := range comparison.Files {
+		if f.GetStatus() == "removed" || f.GetAdditions() == 0 {
+			continue
+		}
+
+		// binary files and large diffs have no patch
+		if f.Patch == nil {
+			return nil, nil, ErrDiffUnavailable
+		}
+
+		path := filepath.ToSlash(filepath.Join(dir, f.GetFilename()))
+		files = append(files, MatchFile{
+			Path:      path,
+			Filename:  filepath.Base(path),
+			Extension: filepath.Ext(path),
+			Contents:  GetAddedLines(f.GetPatch()),
+			Commit:    head,
+		})
+	}
+
+	return files, commits, nil
+}
+
+func compareCommits(session *Session, instance *GitHubInstance, repo *github.Repository, base string, head string) (*github.CommitsComparison, error) {
+	client := instance.GetClient()
+	defer instance.FreeClient(client)
+
+	comparison, resp, err := client.Repositories.CompareCommits(session.Context, repo.GetOwner().GetLogin(), repo.GetName(), base, head)
+	instance.UpdateRate(client, resp)
+
+	return comparison, err
+}
+
+// GetAddedLines rebuilds the new version of a file from a unified diff,
+// keeping only the added lines at their line numbers. Everything else is
+// left blank so unchanged secrets aren't reported again
+func GetAddedLines(patch string) []byte {
+	var (
+		contents bytes.Buffer
+		line     = 1
+	)
+
+	for _, l := range strings.Split(patch, "\n") {
+		if header := hunkHeaderRegex.FindStringSubmatch(l); header != nil {
+			start, _ := strconv.Atoi(header[1])
+			for ; line < start; line++ {
+				contents.WriteByte('\n')
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(l, "+"):
+			contents.WriteString(l[1:])
+		case strings.HasPrefix(l, " "):
+		default:
+			// removed lines and "\ No newline at end of file" aren't in the
+			// new version
+			continue
+		}
+
+		contents.WriteByte('\n')
+		line++
+	}
+
+	return contents.Bytes()
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how often a clone waiting for the temp directory to get back under its
+	// quota checks again
+	diskQuotaInterval = 5 * time.Second
+	// how often saved matching files are evicted when nothing is waiting
+	diskQuotaEvictInterval = time.Minute
+)
+
+// DiskQuota keeps the temp directory under --temp-directory-quota. When it
+// is over, the matching files saved for review longest ago are removed, and
+// new clones and exports wait for those being scanned to be removed. A nil
+// DiskQuota is unlimited
+type DiskQuota struct {
+	sync.Mutex
+
+	dir   string
+	limit int64
+}
+
+func NewDiskQuota(dir string, limit int64) *DiskQuota {
+	return &DiskQuota{dir: dir, limit: limit}
+}
+
+// Wait returns once the temp directory is under its quota, or an error if
+// it can't be: the saved matching files are all gone and there are no clones
+// being scanned left to be removed, or ctx is done
+func (q *DiskQuota) Wait(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+
+	for {
+		used, clones := q.Evict()
+		if used < q.limit {
+			return nil
+		}
+
+		if clones == 0 {
+			return fmt.Errorf("temp directory %s is over its quota of %d MB", q.dir, q.limit/1024/1024)
+		}
+
+		select {
+		case <-time.After(diskQuotaInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Evict removes the directories of matching files saved for review, least
+// recently written first, until the temp directory is under its quota. It
+// returns the bytes then used and the number of clones in progress
+func (q *DiskQuota) Evict() (int64, int) {
+	if q == nil {
+		return 0, 0
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	used := GetDirectorySize(q.dir)
+	clones, _ := ioutil.ReadDir(filepath.Join(q.dir, ".clones"))
+
+	if used >= q.limit {
+		for _, dir := range q.reviewDirectories() {
+			size := GetDirectorySize(dir)
+			if err := os.RemoveAll(dir); err != nil {
+				session.Log.Warn("Failed to remove %s to stay under the temp directory quota: %s", dir, err)
+				continue
+			}
+
+			session.Log.Debug("Removed %s to stay under the temp directory quota", dir)
+			if used -= size; used < q.limit {
+				break
+			}
+		}
+	}
+
+	session.Metrics.Set(MetricTempDirectoryBytes, float64(used))
+	return used, len(clones)
+}
+
+// reviewDirectories lists the directories matching files were saved to,
+// least recently written first. Clones and other dot files are left alone
+func (q *DiskQuota) reviewDirectories() []string {
+	entries, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+
+	dirs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			dirs = append(dirs, filepath.Join(q.dir, entry.Name()))
+		}
+	}
+
+	return dirs
+}
+
+// EnforceDiskQuota evicts saved matching files every diskQuotaEvictInterval,
+// as clones held in memory never wait on the quota
+func EnforceDiskQuota(s *Session) {
+	if s.DiskQuota == nil {
+		return
+	}
+
+	for range time.Tick(diskQuotaEvictInterval) {
+		s.DiskQuota.Evict()
+	}
+}
+
+func (s *Session) InitDiskQuota() {
+	if *s.Options.TempDirectoryQuota == 0 {
+		return
+	}
+
+	s.DiskQuota = NewDiskQuota(*s.Options.TempDirectory, int64(*s.Options.TempDirectoryQuota)*1024*1024)
+}
+
+// CleanTempDirectory removes the clones and exports left behind by a run
+// that crashed or was killed, then gets the temp directory under its quota
+func (s *Session) CleanTempDirectory() {
+	clones := filepath.Join(*s.Options.TempDirectory, ".clones")
+	if entries, err := ioutil.ReadDir(clones); err == nil && len(entries) > 0 {
+		s.Log.Info("[*] Removing %d clones left behind in %s", len(entries), clones)
+		os.RemoveAll(clones)
+	}
+
+	s.DiskQuota.Evict()
+}
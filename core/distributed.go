@@ -0,0 +1,264 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	RoleStandalone  = ""
+	RoleCoordinator = "coordinator"
+	RoleWorker      = "worker"
+
+	defaultDistributedQueue         = Name
+	defaultDistributedMaxQueuedJobs = 10000
+
+	// how long a BLPOP waits before it is sent again
+	redisPopTimeout = 5 * time.Second
+)
+
+// Roles are the parts shhgit can play in distributed mode, besides running
+// on its own
+var Roles = []string{RoleCoordinator, RoleWorker}
+
+// DistributedConfig is the Redis server the coordinator and workers share
+// their queues on, and any instance shares state on
+type DistributedConfig struct {
+	RedisUrl      string `yaml:"redis_url"`
+	Queue         string `yaml:"queue"`
+	MaxQueuedJobs int    `yaml:"max_queued_jobs"`
+	SeenTtl       int    `yaml:"seen_ttl"`
+	FindingTtl    int    `yaml:"finding_ttl"`
+}
+
+// DistributedJob is a repository, gist, image, package or archive queued
+// by the coordinator for a worker to clone and scan
+type DistributedJob struct {
+	Id      int64           `json:"id,omitempty"`
+	Type    GitResourceType `json:"type"`
+	Url     string          `json:"url"`
+	Ref     string          `json:"ref,omitempty"`
+	Before  string          `json:"before,omitempty"`
+	Head    string          `json:"head,omitempty"`
+	Watched bool            `json:"watched,omitempty"`
+	Size    int64           `json:"size,omitempty"`
+	// the queue it's taken from once received
+	Priority string `json:"priority,omitempty"`
+	// the name of the GitHub instance to look the repository up on
+	GitHub string `json:"github,omitempty"`
+}
+
+func NewDistributedJob(repository GitResource) DistributedJob {
+	job := DistributedJob{
+		Id:       repository.Id,
+		Type:     repository.Type,
+		Url:      repository.Url,
+		Ref:      repository.Ref,
+		Before:   repository.Before,
+		Head:     repository.Head,
+		Watched:  repository.Watched,
+		Size:     repository.Size,
+		Priority: repository.Priority,
+	}
+
+	if repository.GitHub != nil {
+		job.GitHub = repository.GitHub.Name
+	}
+
+	return job
+}
+
+// jobResource is the repository a job is for, looking up its GitHub
+// instance by name. ok is false if the instance isn't configured
+func (s *Session) jobResource(job DistributedJob) (GitResource, bool) {
+	repository := GitResource{
+		Id:       job.Id,
+		Type:     job.Type,
+		Url:      job.Url,
+		Ref:      job.Ref,
+		Before:   job.Before,
+		Head:     job.Head,
+		Watched:  job.Watched,
+		Size:     job.Size,
+		Priority: job.Priority,
+	}
+
+	if job.GitHub == "" {
+		return repository, true
+	}
+
+	for _, instance := range s.GitHub {
+		if instance.Name == job.GitHub {
+			repository.GitHub = instance
+		}
+	}
+
+	return repository, repository.GitHub != nil
+}
+
+// RedisQueue is a list in Redis that values are pushed to and popped from
+// as JSON, over a connection of its own as popping blocks
+type RedisQueue struct {
+	client *redisClient
+	key    string
+}
+
+func NewRedisQueue(url string, key string) (*RedisQueue, error) {
+	queue := &RedisQueue{client: &redisClient{url: url}, key: key}
+
+	if _, err := queue.client.Do(0, "PING"); err != nil {
+		return nil, err
+	}
+
+	return queue, nil
+}
+
+// Push appends v to the end of the queue
+func (q *RedisQueue) Push(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.client.Do(0, "RPUSH", q.key, string(data))
+	return err
+}
+
+// Pop waits for a value at the front of the queue and decodes it in to dst
+func (q *RedisQueue) Pop(dst interface{}) error {
+	for {
+		reply, err := q.client.Do(redisPopTimeout, "BLPOP", q.key, fmt.Sprint(int(redisPopTimeout.Seconds())))
+		if err == errRedisNil {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		// BLPOP replies with the key and the value
+		if items, ok := reply.([]interface{}); ok && len(items) == 2 {
+			if value, ok := items[1].(string); ok {
+				return json.Unmarshal([]byte(value), dst)
+			}
+		}
+
+		return fmt.Errorf("unexpected BLPOP reply from %s", q.key)
+	}
+}
+
+// Len returns the number of values waiting in the queue
+func (q *RedisQueue) Len() (int64, error) {
+	reply, err := q.client.Do(0, "LLEN", q.key)
+	if err != nil {
+		return 0, err
+	}
+
+	length, _ := reply.(int64)
+	return length, nil
+}
+
+func (s *Session) InitDistributed() {
+	if *s.Options.Role == RoleStandalone || !s.Options.IsPublicMode() {
+		return
+	}
+
+	var err error
+	config := s.Config.Distributed
+
+	if s.Jobs, err = NewRedisQueue(config.RedisUrl, config.Queue+":jobs"); err == nil {
+		s.Results, err = NewRedisQueue(config.RedisUrl, config.Queue+":findings")
+	}
+
+	if err != nil {
+		s.Log.Fatal("Failed to connect to Redis: %s", err)
+	}
+}
+
+// DispatchJobs pushes the repositories and gists found by the pollers to
+// the jobs queue for the workers, instead of cloning them here, those of
+// the highest priority first. It waits
+// while more than max_queued_jobs are waiting for a worker
+func DispatchJobs(session *Session) {
+	for {
+		job := DistributedJob{}
+
+		if repository, ok := session.pollRepository(); ok {
+			job = NewDistributedJob(repository)
+		} else {
+			select {
+			case repository := <-session.queue(PriorityOnDemand):
+				job = NewDistributedJob(repository)
+			case repository := <-session.queue(PriorityWatched):
+				job = NewDistributedJob(repository)
+			case repository := <-session.queue(PriorityScheduled):
+				job = NewDistributedJob(repository)
+			case repository := <-session.Repositories:
+				job = NewDistributedJob(repository)
+			case gistUrl := <-session.Gists:
+				job = DistributedJob{Type: GIST_SOURCE, Url: gistUrl}
+			}
+		}
+
+		if !session.IsNewResource(GitResource{Type: job.Type, Url: job.Url, Ref: job.Ref, Head: job.Head}) {
+			continue
+		}
+
+		for {
+			queued, err := session.Jobs.Len()
+			if err == nil && queued < int64(session.Config.Distributed.MaxQueuedJobs) {
+				if err = session.Jobs.Push(job); err == nil {
+					break
+				}
+			}
+
+			if err != nil {
+				session.Log.Warn("Failed to queue %s for the workers: %s... trying again", job.Url, err)
+			}
+
+			time.Sleep(sleep)
+		}
+	}
+}
+
+// ReceiveJobs pops jobs queued by the coordinator and queues them to be
+// cloned and scanned here, waiting while the clone workers are busy. Once
+// shutting down it stops, returning a job it was still waiting for
+func ReceiveJobs(session *Session) {
+	for session.PollContext.Err() == nil {
+		job := DistributedJob{}
+		if err := session.Jobs.Pop(&job); err != nil {
+			session.Log.Warn("Failed to receive a job from the coordinator: %s... trying again", err)
+			time.Sleep(sleep)
+			continue
+		}
+
+		if session.PollContext.Err() != nil {
+			if err := session.Jobs.Push(job); err != nil {
+				session.Log.Warn("Failed to return %s to the jobs queue: %s", job.Url, err)
+			}
+			return
+		}
+
+		repository, ok := session.jobResource(job)
+		if !ok {
+			session.Log.Warn("Skipping %s from GitHub instance %s, which isn't configured here", job.Url, job.GitHub)
+			continue
+		}
+
+		session.QueueRepository(repository)
+	}
+}
+
+// ReportFinding sends a finding made by a worker to the coordinator, which
+// writes it to the outputs and sinks
+func (s *Session) ReportFinding(event *MatchEvent) {
+	if err := s.Results.Push(event); err != nil {
+		s.Log.Warn("Failed to report finding in %s to the coordinator: %s", event.Url, err)
+	}
+}
+
+// ReceiveFinding waits for a finding reported by a worker
+func (s *Session) ReceiveFinding() (*MatchEvent, error) {
+	event := &MatchEvent{}
+	return event, s.Results.Pop(event)
+}
@@ -0,0 +1,471 @@
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dockerHubUrl      = "https://hub.docker.com"
+	dockerRegistryUrl = "https://registry-1.docker.io"
+
+	dockerManifestV2     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestList   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociManifest          = "application/vnd.oci.image.manifest.v1+json"
+	ociIndex             = "application/vnd.oci.image.index.v1+json"
+	dockerImageEnvFile   = "image-config.env"
+	dockerWhiteoutPrefix = ".wh."
+)
+
+var dockerAuthParamRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+type DockerHubRepository struct {
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	IsPrivate   bool   `json:"is_private"`
+	LastUpdated string `json:"last_updated"`
+}
+
+type DockerHubTag struct {
+	Name        string `json:"name"`
+	LastUpdated string `json:"last_updated"`
+}
+
+type DockerDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Platform  struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform"`
+}
+
+type DockerManifest struct {
+	MediaType string             `json:"mediaType"`
+	Config    DockerDescriptor   `json:"config"`
+	Layers    []DockerDescriptor `json:"layers"`
+	Manifests []DockerDescriptor `json:"manifests"`
+}
+
+type DockerImageConfig struct {
+	Config struct {
+		Env    []string          `json:"Env"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// DockerImage is a reference to an image in a registry, i.e.
+// https://registry-1.docker.io/library/redis:latest
+type DockerImage struct {
+	Registry   string
+	Repository string
+	Tag        string
+}
+
+func (i DockerImage) String() string {
+	return strings.TrimRight(i.Registry, "/") + "/" + i.Repository + ":" + i.Tag
+}
+
+// ParseDockerImage parses a reference produced by DockerImage.String
+func ParseDockerImage(reference string) (DockerImage, error) {
+	scheme := strings.Index(reference, "://") + len("://")
+	slash := strings.Index(reference[scheme:], "/") + scheme
+	colon := strings.LastIndex(reference, ":")
+	if scheme < len("://") || slash <= scheme || colon <= slash {
+		return DockerImage{}, fmt.Errorf("invalid image reference %s", reference)
+	}
+
+	return DockerImage{
+		Registry:   reference[:slash],
+		Repository: reference[slash+1 : colon],
+		Tag:        reference[colon+1:],
+	}, nil
+}
+
+// dockerRegistryClient talks to a registry's v2 API, fetching bearer tokens
+// as the registry asks for them
+type dockerRegistryClient struct {
+	session *Session
+	limiter *RateLimiter
+	token   string
+}
+
+func (c *dockerRegistryClient) do(image DockerImage, path string, accept ...string) (*http.Response, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		c.limiter.Wait()
+
+		req, err := http.NewRequest("GET", strings.TrimRight(image.Registry, "/")+"/v2/"+image.Repository+path, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("User-Agent", fmt.Sprintf("%s v%s", Name, Version))
+		for _, mediaType := range accept {
+			req.Header.Add("Accept", mediaType)
+		}
+
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		} else if c.session.Config.Docker.Username != "" {
+			req.SetBasicAuth(c.session.Config.Docker.Username, c.session.Config.Docker.Password)
+		}
+
+		resp, err := dockerClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+
+			if c.token, err = c.authenticate(challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+		}
+
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("unauthorized to pull %s", image)
+}
+
+// authenticate requests a pull token from the realm in a Bearer challenge
+func (c *dockerRegistryClient) authenticate(challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry authentication: %s", challenge)
+	}
+
+	params := map[string]string{}
+	for _, match := range dockerAuthParamRegex.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+
+	query := url.Values{"service": {params["service"]}, "scope": {params["scope"]}}
+	req, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if c.session.Config.Docker.Username != "" {
+		req.SetBasicAuth(c.session.Config.Docker.Username, c.session.Config.Docker.Password)
+	}
+
+	response := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+
+	if err := GetJSON(c.limiter, req, &response); err != nil {
+		return "", err
+	}
+
+	if response.Token == "" {
+		return response.AccessToken, nil
+	}
+
+	return response.Token, nil
+}
+
+func (c *dockerRegistryClient) getManifest(image DockerImage, reference string) (*DockerManifest, error) {
+	resp, err := c.do(image, "/manifests/"+reference, dockerManifestV2, dockerManifestList, ociManifest, ociIndex)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	manifest := &DockerManifest{}
+	if err := json.NewDecoder(resp.Body).Decode(manifest); err != nil {
+		return nil, err
+	}
+
+	// multi-platform images point to a manifest per platform
+	if len(manifest.Manifests) > 0 {
+		for _, platform := range manifest.Manifests {
+			if platform.Platform.OS == "linux" && platform.Platform.Architecture == "amd64" {
+				return c.getManifest(image, platform.Digest)
+			}
+		}
+
+		return c.getManifest(image, manifest.Manifests[0].Digest)
+	}
+
+	return manifest, nil
+}
+
+var dockerClient = &http.Client{Timeout: 5 * time.Minute}
+
+// GetImageFiles pulls an image in to memory and returns the files in each of
+// its layers, plus its ENV variables as a file named image-config.env, as if
+// they were checked out in to dir. Images larger than
+// --maximum-repository-size are skipped
+func GetImageFiles(session *Session, image DockerImage, dir string) ([]MatchFile, error) {
+	client := &dockerRegistryClient{session: session, limiter: session.RateLimiters[DOCKER_SOURCE]}
+
+	manifest, err := client.getManifest(image, image.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+
+	if maxSize := int64(*session.Options.MaximumRepositorySize) * 1024; size > maxSize {
+		return nil, fmt.Errorf("image exceeds the maximum size of %d KB", maxSize/1024)
+	}
+
+	fileList := make([]MatchFile, 0)
+
+	if manifest.Config.Digest != "" {
+		resp, err := client.do(image, "/blobs/"+manifest.Config.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		config := DockerImageConfig{}
+		err = json.NewDecoder(resp.Body).Decode(&config)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		contents := strings.Join(config.Config.Env, "\n")
+		for label, value := range config.Config.Labels {
+			contents += fmt.Sprintf("\nLABEL %s=%s", label, value)
+		}
+
+		fileList = append(fileList, MatchFile{
+			Path:      filepath.ToSlash(filepath.Join(dir, dockerImageEnvFile)),
+			Filename:  dockerImageEnvFile,
+			Extension: filepath.Ext(dockerImageEnvFile),
+			Contents:  []byte(contents),
+		})
+	}
+
+	for _, layer := range manifest.Layers {
+		resp, err := client.do(image, "/blobs/"+layer.Digest)
+		if err != nil {
+			return fileList, err
+		}
+
+		files, err := readImageLayer(resp.Body, dir)
+		resp.Body.Close()
+		if err != nil {
+			session.Log.Debug("[%s] Failed to read layer %s: %s", image, layer.Digest, err)
+		}
+
+		fileList = append(fileList, files...)
+	}
+
+	return fileList, nil
+}
+
+func readImageLayer(reader io.Reader, dir string) ([]MatchFile, error) {
+	fileList := make([]MatchFile, 0)
+
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return fileList, nil
+		} else if err != nil {
+			return fileList, err
+		}
+
+		name := path.Clean("/" + header.Name)
+		filePath := filepath.ToSlash(dir) + name
+
+		if header.Typeflag != tar.TypeReg || strings.HasPrefix(path.Base(name), dockerWhiteoutPrefix) ||
+			header.Size > GetMaximumFileSize(filePath) || IsSkippableFile(filePath) {
+			continue
+		}
+
+		contents, err := ioutil.ReadAll(io.LimitReader(tarReader, header.Size))
+		if err != nil {
+			return fileList, err
+		}
+
+		fileList = append(fileList, MatchFile{
+			Path:      filePath,
+			Filename:  path.Base(name),
+			Extension: path.Ext(name),
+			Contents:  contents,
+		})
+	}
+}
+
+// GetDockerHubImages polls the configured Docker Hub namespaces for
+// repositories pushed to since the previous poll and queues their most
+// recently pushed tag
+func GetDockerHubImages(session *Session) {
+	if len(session.Config.Docker.Namespaces) == 0 {
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[DOCKER_SOURCE]
+
+	for poller := session.NewPoller(PollingDocker); ; {
+		for _, namespace := range session.Config.Docker.Namespaces {
+			response := struct {
+				Results []DockerHubRepository `json:"results"`
+			}{}
+
+			req, err := http.NewRequest("GET", dockerHubUrl+"/v2/repositories/"+url.PathEscape(namespace)+"/?"+url.Values{"ordering": {"last_updated"}, "page_size": {strconv.Itoa(poller.PageSize())}}.Encode(), nil)
+			if err == nil {
+				err = GetJSON(limiter, req, &response)
+			}
+
+			if err != nil {
+				session.Log.Warn("Error getting Docker Hub repositories for %s: %s... trying again", namespace, err)
+				continue
+			}
+
+			for _, repository := range response.Results {
+				key := repository.Namespace + "/" + repository.Name + "-" + repository.LastUpdated
+				if observedKeys[key] || repository.IsPrivate {
+					continue
+				}
+
+				observedKeys[key] = true
+				queueDockerHubTag(session, limiter, repository.Namespace+"/"+repository.Name)
+			}
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+func queueDockerHubTag(session *Session, limiter *RateLimiter, repository string) {
+	response := struct {
+		Results []DockerHubTag `json:"results"`
+	}{}
+
+	req, err := http.NewRequest("GET", dockerHubUrl+"/v2/repositories/"+repository+"/tags/?"+url.Values{"ordering": {"last_updated"}, "page_size": {"1"}}.Encode(), nil)
+	if err == nil {
+		err = GetJSON(limiter, req, &response)
+	}
+
+	if err != nil || len(response.Results) == 0 {
+		session.Log.Debug("Failed to get Docker Hub tags for %s: %v", repository, err)
+		return
+	}
+
+	session.Repositories <- GitResource{
+		Type: DOCKER_SOURCE,
+		Url:  DockerImage{Registry: dockerRegistryUrl, Repository: repository, Tag: response.Results[0].Name}.String(),
+	}
+}
+
+// GetRegistryImages polls the catalog of a private registry and queues every
+// tag that wasn't seen on the previous poll. Registries don't expose push
+// times so the first poll queues everything
+func GetRegistryImages(session *Session) {
+	if session.Config.Docker.Registry == "" {
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[DOCKER_SOURCE]
+	client := &dockerRegistryClient{session: session, limiter: limiter}
+	catalog := DockerImage{Registry: session.Config.Docker.Registry}
+
+	for poller := session.NewPoller(PollingDocker); ; {
+		repositories := session.Config.Docker.Repositories
+		if len(repositories) == 0 {
+			response := struct {
+				Repositories []string `json:"repositories"`
+			}{}
+
+			// the catalog lives at /v2/_catalog rather than under a repository
+			catalog.Repository = "_catalog"
+			resp, err := client.do(catalog, "")
+			if err == nil {
+				err = json.NewDecoder(resp.Body).Decode(&response)
+				resp.Body.Close()
+			}
+
+			if err != nil {
+				session.Log.Warn("Error getting catalog for registry %s: %s... trying again", session.Config.Docker.Registry, err)
+			}
+
+			repositories = response.Repositories
+		}
+
+		for _, repository := range repositories {
+			response := struct {
+				Tags []string `json:"tags"`
+			}{}
+
+			image := DockerImage{Registry: session.Config.Docker.Registry, Repository: repository}
+			resp, err := client.do(image, "/tags/list")
+			if err == nil {
+				err = json.NewDecoder(resp.Body).Decode(&response)
+				resp.Body.Close()
+			}
+
+			if err != nil {
+				session.Log.Debug("Failed to get tags for %s: %s", repository, err)
+				continue
+			}
+
+			for _, tag := range response.Tags {
+				image.Tag = tag
+				if observedKeys[image.String()] {
+					continue
+				}
+
+				observedKeys[image.String()] = true
+				session.Repositories <- GitResource{Type: DOCKER_SOURCE, Url: image.String()}
+			}
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
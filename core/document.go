@@ -0,0 +1,463 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var documentExtensions = []string{".pdf", ".docx", ".xlsx"}
+
+var (
+	// the parts of a Word document with text: the body, headers, footers,
+	// footnotes, endnotes and comments
+	docxPartRegex          = regexp.MustCompile(`^word/(document|header\d*|footer\d*|footnotes|endnotes|comments)\.xml$`)
+	xlsxSheetRegex         = regexp.MustCompile(`^xl/worksheets/sheet\d+\.xml$`)
+	xlsxSharedStringsRegex = regexp.MustCompile(`^xl/sharedStrings\.xml$`)
+)
+
+func IsDocument(filePath string) bool {
+	extension := strings.ToLower(path.Ext(filePath))
+
+	for _, documentExtension := range documentExtensions {
+		if extension == documentExtension {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExtractText returns the text of a PDF, Word or Excel document, so
+// signatures run over what it says rather than its compressed parts. Lines,
+// columns and offsets of findings are then of the text. No more than
+// --maximum-archive-size is decompressed from each document
+func (match MatchFile) ExtractText() ([]byte, error) {
+	budget := int64(*session.Options.MaximumArchiveSize * 1024)
+
+	switch strings.ToLower(match.Extension) {
+	case ".pdf":
+		return extractPdfText(match.Contents, budget), nil
+	case ".docx":
+		return extractDocxText(match.Contents, budget)
+	case ".xlsx":
+		return extractXlsxText(match.Contents, budget)
+	}
+
+	return nil, fmt.Errorf("%s isn't a document", match.Path)
+}
+
+// readDocumentParts reads the parts of an Office document whose names match
+// regex, in order of name, taking their sizes from budget
+func readDocumentParts(contents []byte, regex *regexp.Regexp, budget *int64) (map[string][]byte, []string, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parts := make(map[string][]byte)
+	names := make([]string, 0)
+	for _, entry := range zipReader.File {
+		if !regex.MatchString(entry.Name) || *budget <= 0 {
+			continue
+		}
+
+		reader, err := entry.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		part, err := ioutil.ReadAll(io.LimitReader(reader, *budget))
+		reader.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		*budget -= int64(len(part))
+		parts[entry.Name] = part
+		names = append(names, entry.Name)
+	}
+
+	sort.Strings(names)
+	return parts, names, nil
+}
+
+// extractDocxText returns each paragraph of a Word document on a line,
+// joining the runs spell checking and formatting split them in to
+func extractDocxText(contents []byte, budget int64) ([]byte, error) {
+	parts, names, err := readDocumentParts(contents, docxPartRegex, &budget)
+	if err != nil {
+		return nil, err
+	}
+
+	var text bytes.Buffer
+	for _, name := range names {
+		decoder := xml.NewDecoder(bytes.NewReader(parts[name]))
+		inText := false
+
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return text.Bytes(), err
+			}
+
+			switch token := token.(type) {
+			case xml.StartElement:
+				switch token.Name.Local {
+				case "t":
+					inText = true
+				case "tab":
+					text.WriteByte('\t')
+				case "br", "cr":
+					text.WriteByte('\n')
+				}
+			case xml.EndElement:
+				switch token.Name.Local {
+				case "t":
+					inText = false
+				case "p":
+					text.WriteByte('\n')
+				}
+			case xml.CharData:
+				if inText {
+					text.Write(token)
+				}
+			}
+		}
+	}
+
+	return text.Bytes(), nil
+}
+
+// extractXlsxText returns each row of an Excel workbook's sheets on a line,
+// with its cells separated by tabs and shared strings looked up
+func extractXlsxText(contents []byte, budget int64) ([]byte, error) {
+	shared, _, err := readDocumentParts(contents, xlsxSharedStringsRegex, &budget)
+	if err != nil {
+		return nil, err
+	}
+
+	var sharedStrings []string
+	if part, ok := shared["xl/sharedStrings.xml"]; ok {
+		if sharedStrings, err = readSharedStrings(part); err != nil {
+			return nil, err
+		}
+	}
+
+	sheets, names, err := readDocumentParts(contents, xlsxSheetRegex, &budget)
+	if err != nil {
+		return nil, err
+	}
+
+	var text bytes.Buffer
+	for _, name := range names {
+		decoder := xml.NewDecoder(bytes.NewReader(sheets[name]))
+		var cellType string
+		var value bytes.Buffer
+		inValue, cells := false, 0
+
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return text.Bytes(), err
+			}
+
+			switch token := token.(type) {
+			case xml.StartElement:
+				switch token.Name.Local {
+				case "c":
+					cellType = ""
+					value.Reset()
+					for _, attr := range token.Attr {
+						if attr.Name.Local == "t" {
+							cellType = attr.Value
+						}
+					}
+				case "v", "t":
+					inValue = true
+				}
+			case xml.EndElement:
+				switch token.Name.Local {
+				case "v", "t":
+					inValue = false
+				case "c":
+					cell := value.String()
+					if cellType == "s" {
+						if i, err := strconv.Atoi(cell); err == nil && i >= 0 && i < len(sharedStrings) {
+							cell = sharedStrings[i]
+						}
+					}
+
+					if cells > 0 {
+						text.WriteByte('\t')
+					}
+					text.WriteString(cell)
+					cells++
+				case "row":
+					text.WriteByte('\n')
+					cells = 0
+				}
+			case xml.CharData:
+				if inValue {
+					value.Write(token)
+				}
+			}
+		}
+	}
+
+	return text.Bytes(), nil
+}
+
+// readSharedStrings reads the strings cells of a workbook refer to by index
+func readSharedStrings(part []byte) ([]string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(part))
+	sharedStrings := make([]string, 0)
+	var value bytes.Buffer
+	inText := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return sharedStrings, nil
+		} else if err != nil {
+			return sharedStrings, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "si":
+				value.Reset()
+			case "t":
+				inText = true
+			}
+		case xml.EndElement:
+			switch token.Name.Local {
+			case "si":
+				sharedStrings = append(sharedStrings, value.String())
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				value.Write(token)
+			}
+		}
+	}
+}
+
+// extractPdfText returns the text shown by the content streams of a PDF.
+// Streams are inflated when they're Flate encoded, or read as they are when
+// they aren't encoded at all; images and fonts are left alone
+func extractPdfText(contents []byte, budget int64) []byte {
+	var text bytes.Buffer
+
+	for offset := 0; budget > 0; {
+		start := bytes.Index(contents[offset:], []byte("stream"))
+		if start < 0 {
+			break
+		}
+		start += offset
+
+		// the stream's dictionary comes between the object and the keyword
+		dictStart := bytes.LastIndex(contents[offset:start], []byte("obj"))
+		if dictStart < 0 {
+			dictStart = 0
+		}
+		dict := contents[offset+dictStart : start]
+
+		dataStart := start + len("stream")
+		if bytes.HasPrefix(contents[dataStart:], []byte("\r\n")) {
+			dataStart += 2
+		} else if bytes.HasPrefix(contents[dataStart:], []byte("\n")) {
+			dataStart++
+		} else {
+			// endstream, or stream in some other word
+			offset = dataStart
+			continue
+		}
+
+		end := bytes.Index(contents[dataStart:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		data := contents[dataStart : dataStart+end]
+		offset = dataStart + end + len("endstream")
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			reader, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				continue
+			}
+
+			// streams are often cut short of their checksum, so keep what inflates
+			data, _ = ioutil.ReadAll(io.LimitReader(reader, budget))
+			reader.Close()
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+
+		budget -= int64(len(data))
+		extractPdfContentText(data, &text)
+	}
+
+	return text.Bytes()
+}
+
+// extractPdfContentText writes the strings shown between BT and ET in a
+// content stream to text, a line for each line of text on the page
+func extractPdfContentText(content []byte, text *bytes.Buffer) {
+	inText := false
+	operands := make([]string, 0)
+
+	for i := 0; i < len(content); {
+		switch c := content[i]; {
+		case c == '(':
+			literal, n := readPdfLiteral(content[i:])
+			if inText {
+				text.Write(literal)
+			}
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+
+			// hex strings in fonts with their own encoding are glyph ids
+			if decoded, err := hex.DecodeString(string(bytes.Join(bytes.Fields(content[i+1:i+end]), nil))); err == nil && inText && isPrintableText(decoded) {
+				text.Write(decoded)
+			}
+			i += end + 1
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isPdfRegular(c):
+			j := i
+			for j < len(content) && isPdfRegular(content[j]) {
+				j++
+			}
+
+			token := string(content[i:j])
+			switch token {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				endPdfLine(text)
+			case "T*", "'", "\"", "Tm":
+				if inText {
+					endPdfLine(text)
+				}
+			case "Td", "TD":
+				// moving along the line separates words, moving down starts a line
+				if inText && len(operands) >= 2 && operands[len(operands)-1] != "0" {
+					endPdfLine(text)
+				} else if inText {
+					text.WriteByte(' ')
+				}
+			}
+
+			if _, err := strconv.ParseFloat(token, 64); err == nil {
+				operands = append(operands, token)
+			} else {
+				operands = operands[:0]
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+}
+
+// readPdfLiteral reads a (string) at the start of content, returning it
+// unescaped along with the bytes it took up
+func readPdfLiteral(content []byte) ([]byte, int) {
+	var literal bytes.Buffer
+	depth := 0
+
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; c {
+		case '(':
+			if depth > 0 {
+				literal.WriteByte(c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return literal.Bytes(), i + 1
+			}
+			literal.WriteByte(c)
+		case '\\':
+			if i+1 >= len(content) {
+				return literal.Bytes(), len(content)
+			}
+
+			i++
+			switch escaped := content[i]; escaped {
+			case 'n':
+				literal.WriteByte('\n')
+			case 'r':
+				literal.WriteByte('\r')
+			case 't':
+				literal.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// a line continuation
+			default:
+				if escaped >= '0' && escaped <= '7' {
+					j := i
+					for j < len(content) && j < i+3 && content[j] >= '0' && content[j] <= '7' {
+						j++
+					}
+					octal, _ := strconv.ParseUint(string(content[i:j]), 8, 8)
+					literal.WriteByte(byte(octal))
+					i = j - 1
+				} else {
+					literal.WriteByte(escaped)
+				}
+			}
+		default:
+			literal.WriteByte(c)
+		}
+	}
+
+	return literal.Bytes(), len(content)
+}
+
+// endPdfLine starts a new line of text, unless one was just started
+func endPdfLine(text *bytes.Buffer) {
+	if text.Len() > 0 && text.Bytes()[text.Len()-1] != '\n' {
+		text.WriteByte('\n')
+	}
+}
+
+func isPdfRegular(c byte) bool {
+	return c > ' ' && c <= '~' && !strings.ContainsRune("()<>[]{}/%", rune(c))
+}
+
+func isPrintableText(text []byte) bool {
+	for _, b := range text {
+		if !isPrintable(b) && b != '\n' && b != '\r' {
+			return false
+		}
+	}
+
+	return true
+}
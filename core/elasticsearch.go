@@ -0,0 +1,233 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultElasticsearchIndex         = "shhgit-%{+yyyy.MM.dd}"
+	defaultElasticsearchBatchSize     = 100
+	defaultElasticsearchFlushInterval = 5
+	elasticsearchTemplateName         = "shhgit"
+)
+
+// ElasticsearchTemplate is installed as a composable index template so that
+// findings are mapped for Kibana (keywords for aggregations, a date field)
+// regardless of which day's index they land in
+const ElasticsearchTemplate = `{
+  "index_patterns": ["%s"],
+  "template": {
+    "settings": {
+      "number_of_shards": 1
+    },
+    "mappings": {
+      "properties": {
+        "@timestamp": { "type": "date" },
+        "Url": { "type": "keyword" },
+        "Matches": { "type": "keyword" },
+        "Lines": { "type": "integer" },
+        "Signature": { "type": "keyword" },
+        "File": { "type": "keyword" },
+        "Stars": { "type": "integer" },
+        "Metadata": {
+          "properties": {
+            "created_at": { "type": "date" },
+            "owner_type": { "type": "keyword" },
+            "language": { "type": "keyword" },
+            "new": { "type": "boolean" }
+          }
+        },
+        "Source": { "type": "integer" },
+        "Verified": { "type": "boolean" },
+        "LikelyCanary": { "type": "boolean" },
+        "Commit": { "type": "keyword" },
+        "Entropy": { "type": "float" },
+        "Severity": { "type": "keyword" },
+        "Confidence": { "type": "keyword" }
+      }
+    }
+  }
+}`
+
+var (
+	elasticsearchDateRegex = regexp.MustCompile(`%\{\+([^}]+)\}`)
+
+	// Joda style date tokens, as used by Logstash and Beats, to Go layouts
+	elasticsearchDateReplacer = strings.NewReplacer("yyyy", "2006", "yy", "06", "MM", "01", "dd", "02", "HH", "15")
+)
+
+// FormatIndexName expands %{+yyyy.MM.dd} style dates in an index pattern
+func FormatIndexName(pattern string, t time.Time) string {
+	return elasticsearchDateRegex.ReplaceAllStringFunc(pattern, func(match string) string {
+		layout := elasticsearchDateRegex.FindStringSubmatch(match)[1]
+		return t.UTC().Format(elasticsearchDateReplacer.Replace(layout))
+	})
+}
+
+// ElasticsearchSink buffers findings and bulk indexes them every
+// batch_size findings or flush_interval seconds, whichever comes first.
+// Batches that fail are kept and retried with the next flush
+type ElasticsearchSink struct {
+	sync.Mutex
+
+	config            ElasticsearchConfig
+	client            *http.Client
+	buffer            []*MatchEvent
+	templateInstalled bool
+}
+
+func NewElasticsearchSink(config ElasticsearchConfig) *ElasticsearchSink {
+	if config.Index == "" {
+		config.Index = defaultElasticsearchIndex
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultElasticsearchBatchSize
+	}
+
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultElasticsearchFlushInterval
+	}
+
+	sink := &ElasticsearchSink{config: config, client: &http.Client{Timeout: 30 * time.Second}}
+
+	go func() {
+		for range time.Tick(time.Duration(config.FlushInterval) * time.Second) {
+			if err := sink.Flush(); err != nil {
+				session.Log.Warn("Failed to send findings to %s: %s", sink.Name(), err)
+			}
+		}
+	}()
+
+	return sink
+}
+
+func (e *ElasticsearchSink) Name() string {
+	return "Elasticsearch " + e.config.Url
+}
+
+func (e *ElasticsearchSink) Send(event *MatchEvent) error {
+	if !e.config.Accepts(event) {
+		return nil
+	}
+
+	e.Lock()
+	e.buffer = append(e.buffer, event)
+	full := len(e.buffer) >= e.config.BatchSize
+	e.Unlock()
+
+	if full {
+		return e.Flush()
+	}
+
+	return nil
+}
+
+func (e *ElasticsearchSink) request(method string, path string, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(e.config.Url, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", fmt.Sprintf("%s v%s", Name, Version))
+
+	if e.config.ApiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+e.config.ApiKey)
+	} else if e.config.Username != "" {
+		req.SetBasicAuth(e.config.Username, e.config.Password)
+	}
+
+	return e.client.Do(req)
+}
+
+func (e *ElasticsearchSink) installTemplate() error {
+	pattern := elasticsearchDateRegex.ReplaceAllString(e.config.Index, "*")
+	template := fmt.Sprintf(ElasticsearchTemplate, pattern)
+
+	resp, err := e.request("PUT", "/_index_template/"+elasticsearchTemplateName, "application/json", []byte(template))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("installing index template returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Flush bulk indexes everything buffered so far
+func (e *ElasticsearchSink) Flush() error {
+	e.Lock()
+	defer e.Unlock()
+
+	if len(e.buffer) == 0 {
+		return nil
+	}
+
+	if !e.templateInstalled && !e.config.SkipTemplate {
+		if err := e.installTemplate(); err != nil {
+			return err
+		}
+		e.templateInstalled = true
+	}
+
+	now := time.Now()
+	body := &bytes.Buffer{}
+	encoder := json.NewEncoder(body)
+
+	for _, event := range e.buffer {
+		document := map[string]interface{}{}
+		raw, _ := json.Marshal(event)
+		json.Unmarshal(raw, &document)
+		document["@timestamp"] = now.UTC().Format(time.RFC3339)
+
+		encoder.Encode(map[string]interface{}{"index": map[string]string{"_index": FormatIndexName(e.config.Index, now)}})
+		encoder.Encode(document)
+	}
+
+	resp, err := e.request("POST", "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("bulk request returned %s", resp.Status)
+	}
+
+	result := struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	e.buffer = nil
+
+	if result.Errors {
+		for _, item := range result.Items {
+			for _, action := range item {
+				if action.Status >= 300 {
+					return fmt.Errorf("indexing failed: %s", action.Error)
+				}
+			}
+		}
+	}
+
+	return nil
+}
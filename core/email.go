@@ -0,0 +1,228 @@
+package core
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EmailDigestNone   = ""
+	EmailDigestHourly = "hourly"
+	EmailDigestDaily  = "daily"
+
+	defaultSmtpPort = 587
+	// the port for SMTP over TLS rather than STARTTLS
+	smtpsPort   = 465
+	smtpTimeout = 30 * time.Second
+)
+
+// EmailDigests are the intervals findings can be summarised over
+var EmailDigests = map[string]time.Duration{
+	EmailDigestHourly: time.Hour,
+	EmailDigestDaily:  24 * time.Hour,
+}
+
+// EmailSink emails each finding as it is found or, in digest mode, a summary
+// of the findings since the last digest grouped by signature and repository
+type EmailSink struct {
+	sync.Mutex
+
+	config  EmailConfig
+	pending []*MatchEvent
+}
+
+func NewEmailSink(config EmailConfig) *EmailSink {
+	if config.Port == 0 {
+		config.Port = defaultSmtpPort
+	}
+
+	sink := &EmailSink{config: config}
+
+	if interval, ok := EmailDigests[config.Digest]; ok {
+		go func() {
+			for range time.Tick(interval) {
+				if err := sink.Flush(); err != nil {
+					session.Log.Warn("Failed to send findings to %s: %s", sink.Name(), err)
+				}
+			}
+		}()
+	}
+
+	return sink
+}
+
+func (e *EmailSink) Name() string {
+	return "email " + strings.Join(e.config.To, ", ")
+}
+
+func (e *EmailSink) Send(event *MatchEvent) error {
+	if !e.config.Accepts(event) {
+		return nil
+	}
+
+	if e.config.Digest != EmailDigestNone {
+		e.Lock()
+		e.pending = append(e.pending, event)
+		e.Unlock()
+
+		return nil
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "%s found in %s\n\n", event.Signature, event.Url)
+	writeEmailFinding(&body, event)
+
+	return e.deliver(fmt.Sprintf("[%s] %s found in %s", Name, event.Signature, event.Url), body.String())
+}
+
+// Flush sends the digest of the findings since the last one, if any
+func (e *EmailSink) Flush() error {
+	e.Lock()
+	events := e.pending
+	e.pending = nil
+	e.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	// group by signature, then by repository
+	groups := make(map[string]map[string][]*MatchEvent)
+	for _, event := range events {
+		if groups[event.Signature] == nil {
+			groups[event.Signature] = make(map[string][]*MatchEvent)
+		}
+		groups[event.Signature][event.Url] = append(groups[event.Signature][event.Url], event)
+	}
+
+	signatures := make([]string, 0, len(groups))
+	for signature := range groups {
+		signatures = append(signatures, signature)
+	}
+	sort.Strings(signatures)
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "%d new findings since the last %s digest.\n", len(events), e.config.Digest)
+
+	for _, signature := range signatures {
+		fmt.Fprintf(&body, "\n== %s ==\n", signature)
+
+		urls := make([]string, 0, len(groups[signature]))
+		for url := range groups[signature] {
+			urls = append(urls, url)
+		}
+		sort.Strings(urls)
+
+		for _, url := range urls {
+			fmt.Fprintf(&body, "\n%s\n", url)
+			for _, event := range groups[signature][url] {
+				writeEmailFinding(&body, event)
+			}
+		}
+	}
+
+	err := e.deliver(fmt.Sprintf("[%s] %s digest: %d new findings", Name, strings.Title(e.config.Digest), len(events)), body.String())
+	if err != nil {
+		// keep them for the next digest
+		e.Lock()
+		e.pending = append(events, e.pending...)
+		e.Unlock()
+	}
+
+	return err
+}
+
+func writeEmailFinding(body *bytes.Buffer, event *MatchEvent) {
+	fmt.Fprintf(body, "  File: %s\n", event.File)
+	fmt.Fprintf(body, "  Severity: %s (%s confidence)\n", event.Severity, event.Confidence)
+
+	if event.Metadata != nil {
+		fmt.Fprintf(body, "  Repository: %s\n", event.Metadata)
+	}
+
+	if event.LikelyCanary {
+		fmt.Fprintf(body, "  Likely canary: %s\n", event.CanaryReason)
+	}
+
+	if snippet := notificationSnippet(event); snippet != "" {
+		fmt.Fprintf(body, "  Match: %s\n", snippet)
+	}
+}
+
+// deliver sends a plain text email, over TLS on port 465 or upgrading with
+// STARTTLS when the server offers it
+func (e *EmailSink) deliver(subject string, body string) error {
+	host := e.config.Host
+	addr := net.JoinHostPort(host, strconv.Itoa(e.config.Port))
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: e.config.TLSSkipVerify}
+
+	var conn net.Conn
+	var err error
+	if e.config.Port == smtpsPort {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, smtpTimeout)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && e.config.Port != smtpsPort {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if e.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.config.Username, e.config.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(e.config.From); err != nil {
+		return err
+	}
+
+	for _, to := range e.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "From: %s\r\n", e.config.From)
+	fmt.Fprintf(w, "To: %s\r\n", strings.Join(e.config.To, ", "))
+	fmt.Fprintf(w, "Subject: %s\r\n", subject)
+	fmt.Fprintf(w, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(w, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(w, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	w.Write([]byte(strings.Replace(body, "\n", "\r\n", -1)))
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
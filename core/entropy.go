@@ -0,0 +1,64 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	CharsetBase64 = "base64"
+	CharsetHex    = "hex"
+
+	defaultEntropyMinimumLength = 20
+	defaultEntropyHexThreshold  = 3.0
+)
+
+var (
+	base64TokenRegex = regexp.MustCompile(`[A-Za-z0-9+/_\-]+={0,2}`)
+	hexTokenRegex    = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	// IDs, timestamps and phone numbers rather than secrets
+	decimalTokenRegex = regexp.MustCompile(`^[0-9]+$`)
+)
+
+type EntropyConfig struct {
+	MinimumLength   int     `yaml:"minimum_length"`
+	Base64Threshold float64 `yaml:"base64_threshold"`
+	HexThreshold    float64 `yaml:"hex_threshold"`
+}
+
+// EntropyFinding is a token that looks random for its character set
+type EntropyFinding struct {
+	Token   string
+	Charset string
+	Entropy float64
+}
+
+// FindHighEntropyTokens splits a line in to base64 and hex words and returns
+// those with an entropy at or above the threshold for their character set.
+// Words that are entirely hex are held to the hex threshold, as their
+// smaller alphabet caps their entropy at 4 bits per character. Words that
+// are entirely digits are skipped
+func FindHighEntropyTokens(line string, config EntropyConfig) []EntropyFinding {
+	var findings []EntropyFinding
+
+	for _, token := range base64TokenRegex.FindAllString(line, -1) {
+		if len(token) < config.MinimumLength || decimalTokenRegex.MatchString(token) {
+			continue
+		}
+
+		charset, threshold := CharsetBase64, config.Base64Threshold
+		if hexTokenRegex.MatchString(token) {
+			charset, threshold = CharsetHex, config.HexThreshold
+		}
+
+		if threshold <= 0 {
+			continue
+		}
+
+		if entropy := GetEntropy(strings.TrimRight(token, "=")); entropy >= threshold {
+			findings = append(findings, EntropyFinding{Token: token, Charset: charset, Entropy: entropy})
+		}
+	}
+
+	return findings
+}
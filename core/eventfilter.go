@@ -0,0 +1,160 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEventFilterSize              = 1000000
+	defaultEventFilterFalsePositiveRate = 0.001
+	defaultEventFilterWindow            = 10
+)
+
+// EventFilterConfig is how the repositories and refs turned up by the event
+// feeds are remembered, so the duplicates the feeds deliver are dropped
+// before they're queued or any API call is made for them
+type EventFilterConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// repository and ref combinations remembered per window
+	Size              int     `yaml:"size"`
+	FalsePositiveRate float64 `yaml:"false_positive_rate"`
+	// minutes a combination is remembered for, at least
+	Window int `yaml:"window"`
+	// share the combinations with the instances on distributed.redis_url
+	Redis bool `yaml:"redis"`
+}
+
+// EventFilter is a pair of bloom filters of the repositories and refs
+// queued recently. New ones are added to the current filter, which takes
+// the place of the previous one once it holds Size or Window has passed, so
+// each is remembered for between one and two windows. False positives drop
+// an event that wasn't a duplicate at the configured rate
+type EventFilter struct {
+	sync.Mutex
+
+	current, previous []uint64
+	// bits set per key
+	hashes int
+	added  int
+	size   int
+	window time.Duration
+	// when current was started
+	started time.Time
+	shared  *SharedState
+}
+
+func NewEventFilter(config EventFilterConfig, shared *SharedState) *EventFilter {
+	// the optimal number of bits and hashes for size keys at the rate
+	bits := int(math.Ceil(-float64(config.Size) * math.Log(config.FalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashes := int(math.Round(float64(bits) / float64(config.Size) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+
+	words := (bits + 63) / 64
+	return &EventFilter{
+		current:  make([]uint64, words),
+		previous: make([]uint64, words),
+		hashes:   hashes,
+		size:     config.Size,
+		window:   time.Duration(config.Window) * time.Minute,
+		started:  time.Now(),
+		shared:   shared,
+	}
+}
+
+// validate fills in the defaults of the event filter
+func (c *EventFilterConfig) validate() error {
+	if c.Size <= 0 {
+		c.Size = defaultEventFilterSize
+	}
+
+	if c.FalsePositiveRate == 0 {
+		c.FalsePositiveRate = defaultEventFilterFalsePositiveRate
+	} else if c.FalsePositiveRate < 0 || c.FalsePositiveRate >= 1 {
+		return fmt.Errorf("event_filter.false_positive_rate must be between 0 and 1, not %g", c.FalsePositiveRate)
+	}
+
+	if c.Window <= 0 {
+		c.Window = defaultEventFilterWindow
+	}
+
+	return nil
+}
+
+// positions are the bits of a key, by double hashing its FNV-1a hash
+func (f *EventFilter) positions(key string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	bits := uint64(len(f.current) * 64)
+	positions := make([]uint64, f.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % bits
+	}
+
+	return positions
+}
+
+func bloomContains(filter []uint64, positions []uint64) bool {
+	for _, p := range positions {
+		if filter[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsNew records a key and reports whether it wasn't seen in the last window.
+// With redis set, keys new here are checked with the other instances too
+func (f *EventFilter) IsNew(key string) bool {
+	positions := f.positions(key)
+
+	f.Lock()
+	if f.added >= f.size || time.Since(f.started) >= f.window {
+		f.previous, f.current = f.current, f.previous
+		for i := range f.current {
+			f.current[i] = 0
+		}
+		f.added, f.started = 0, time.Now()
+	}
+
+	if bloomContains(f.current, positions) || bloomContains(f.previous, positions) {
+		f.Unlock()
+		return false
+	}
+
+	for _, p := range positions {
+		f.current[p/64] |= 1 << (p % 64)
+	}
+	f.added++
+	f.Unlock()
+
+	return f.shared == nil || f.shared.setIfAbsent("event:"+dedupKey(key), f.window)
+}
+
+// IsNewEvent reports whether a repository turned up by an event feed should
+// be queued, as the same ref of it wasn't recently at the same head. Pushes
+// have to be the same push to be dropped, so none of their commits are
+// missed. Repositories count as filtered when they're dropped
+func (s *Session) IsNewEvent(resource GitResource) bool {
+	if s.EventFilter == nil {
+		return true
+	}
+
+	key := fmt.Sprint(resource.Type) + "\x00" + scanCacheRepository(resource.Url) + "\x00" + resource.Ref + "\x00" + resource.Head
+
+	if s.EventFilter.IsNew(key) {
+		return true
+	}
+
+	s.Metrics.Inc(MetricEventsFiltered, "source", SourceNames[resource.Type])
+	return false
+}
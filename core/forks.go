@@ -0,0 +1,104 @@
+package core
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// forks of forks are followed this far back to the repository they all
+// came from, which also stops cycles of mirrors
+const maxForkDepth = 10
+
+// ForkIndex links forks and mirrors to the repository they were copied
+// from, as their provider reports it, so a secret leaked in a repository is
+// reported once rather than again in each of the forks made of it
+type ForkIndex struct {
+	sync.Mutex
+
+	// normalized URL of a fork or mirror to the URL of its parent
+	parents map[string]string
+	// key of a finding in a network of forks to the URL it was reported in
+	reported map[string]string
+}
+
+func NewForkIndex() *ForkIndex {
+	return &ForkIndex{parents: make(map[string]string), reported: make(map[string]string)}
+}
+
+func (s *Session) InitForkIndex() {
+	s.Forks = NewForkIndex()
+}
+
+// normalizeRepositoryUrl returns a URL without its scheme, credentials,
+// case or .git suffix, as providers give clone and mirror URLs of the same
+// repository in different forms
+func normalizeRepositoryUrl(repositoryUrl string) string {
+	normalized := strings.ToLower(strings.TrimSpace(repositoryUrl))
+	if parsed, err := url.Parse(normalized); err == nil && parsed.Host != "" {
+		normalized = parsed.Host + parsed.Path
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(normalized, "/"), ".git")
+}
+
+// Link records that the repository at url is a fork or mirror of parent
+func (f *ForkIndex) Link(url string, parent string) {
+	child := normalizeRepositoryUrl(url)
+	if f == nil || parent == "" || child == normalizeRepositoryUrl(parent) {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	f.parents[child] = parent
+}
+
+// Root returns the URL of the repository a fork or mirror was copied from,
+// following forks of forks, or an empty string if it isn't known to be one
+func (f *ForkIndex) Root(url string) string {
+	if f == nil {
+		return ""
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	return f.root(url)
+}
+
+func (f *ForkIndex) root(url string) string {
+	root := ""
+	for i, key := 0, normalizeRepositoryUrl(url); i < maxForkDepth; i++ {
+		parent, ok := f.parents[key]
+		if !ok {
+			break
+		}
+
+		root, key = parent, normalizeRepositoryUrl(parent)
+	}
+
+	return root
+}
+
+// IsNew records a match and reports whether it was new to the network of
+// forks the repository at url belongs to. If it isn't, the URL it was
+// reported in is returned
+func (f *ForkIndex) IsNew(url string, path string, match string) (bool, string) {
+	f.Lock()
+	defer f.Unlock()
+
+	root := f.root(url)
+	if root == "" {
+		root = url
+	}
+
+	key := dedupKey(normalizeRepositoryUrl(root), path, match)
+	if reported, ok := f.reported[key]; ok && normalizeRepositoryUrl(reported) != normalizeRepositoryUrl(url) {
+		return false, reported
+	}
+
+	f.reported[key] = url
+	return true, ""
+}
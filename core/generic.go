@@ -0,0 +1,173 @@
+package core
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+const (
+	GenericSecretName = "Generic secret"
+
+	defaultGenericMinimumLength = 12
+	defaultGenericMaximumLength = 128
+	defaultGenericThreshold     = 3.5
+)
+
+var (
+	defaultGenericKeywords = []string{"password", "passwd", "pwd", "secret", "token", "apikey", "api_key", "api-key", "access_key", "auth", "credential", "private_key"}
+
+	// values are whatever sits between quotes, whitespace, assignments and
+	// separators
+	genericValueRegex = regexp.MustCompile("[^\\s'\"`=:,;(){}<>\\[\\]]+")
+)
+
+// GenericConfig configures the keyword and entropy detector, which finds
+// secrets in formats no signature knows
+type GenericConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	Keywords      []string `yaml:"keywords"`
+	MinimumLength int      `yaml:"minimum_length"`
+	MaximumLength int      `yaml:"maximum_length"`
+	Threshold     float64  `yaml:"threshold"`
+	Structured    bool     `yaml:"structured"`
+	Severity      string   `yaml:"severity,omitempty"`
+	Confidence    string   `yaml:"confidence,omitempty"`
+}
+
+// GenericSignature matches random looking values on the same line as a
+// keyword like password or token, or in config files, the values of keys
+// naming one
+type GenericSignature struct {
+	keywords      []string
+	minimumLength int
+	maximumLength int
+	threshold     float64
+	structured    bool
+	severity      string
+	confidence    string
+}
+
+func NewGenericSignature(s *Session, config GenericConfig) GenericSignature {
+	signature := GenericSignature{
+		minimumLength: config.MinimumLength,
+		maximumLength: config.MaximumLength,
+		threshold:     config.Threshold,
+		structured:    config.Structured,
+		severity:      config.Severity,
+		confidence:    config.Confidence,
+	}
+
+	for _, keyword := range config.Keywords {
+		signature.keywords = append(signature.keywords, strings.ToLower(keyword))
+	}
+
+	if signature.severity == "" {
+		signature.severity = EntropySeverity
+	}
+
+	if signature.confidence == "" {
+		signature.confidence = EntropyConfidence
+	}
+
+	signature.severity, signature.confidence = signatureRating(s, ConfigSignature{
+		Name:       GenericSecretName,
+		Part:       PartContents,
+		Severity:   signature.severity,
+		Confidence: signature.confidence,
+	})
+
+	return signature
+}
+
+func (s GenericSignature) Match(file MatchFile) (bool, string) {
+	return len(s.GetFileMatches(file)) > 0, PartContents
+}
+
+// GetFileMatches returns the values in .env, YAML, JSON, TOML, INI and XML
+// files whose key names a keyword and that are long and random enough, with
+// their dotted key paths. Other files, those that can't be parsed and
+// windows of larger ones are matched line by line
+func (s GenericSignature) GetFileMatches(file MatchFile) []ContentsMatch {
+	if !s.structured || file.Offset > 0 || file.Limit > 0 {
+		return s.GetContentsMatches(file.Contents)
+	}
+
+	values, ok := ParseConfigFile(file)
+	if !ok {
+		return s.GetContentsMatches(file.Contents)
+	}
+
+	matches := make([]ContentsMatch, 0)
+	for _, value := range values {
+		if s.hasKeyword([]byte(strings.ToLower(ConfigKeyName(value.Key)))) && s.isSecret(value.Value) {
+			matches = append(matches, ContentsMatch{Value: value.Value, Offset: value.Offset, Key: value.Key})
+		}
+	}
+
+	return matches
+}
+
+// GetContentsMatches returns the values on lines containing a keyword that
+// are long and random enough, other than those containing a keyword
+func (s GenericSignature) GetContentsMatches(contents []byte) []ContentsMatch {
+	matches := make([]ContentsMatch, 0)
+
+	for start := 0; start < len(contents); {
+		end := bytes.IndexByte(contents[start:], '\n')
+		if end < 0 {
+			end = len(contents)
+		} else {
+			end += start
+		}
+
+		line := contents[start:end]
+		if s.hasKeyword(bytes.ToLower(line)) {
+			for _, index := range genericValueRegex.FindAllIndex(line, -1) {
+				if value := string(line[index[0]:index[1]]); s.isSecret(value) {
+					matches = append(matches, ContentsMatch{Value: value, Offset: start + index[0]})
+				}
+			}
+		}
+
+		start = end + 1
+	}
+
+	return matches
+}
+
+// isSecret reports whether a value is long and random enough to be a secret,
+// and isn't a keyword itself
+func (s GenericSignature) isSecret(value string) bool {
+	if len(value) < s.minimumLength || len(value) > s.maximumLength || s.hasKeyword([]byte(strings.ToLower(value))) {
+		return false
+	}
+
+	return GetEntropy(value) >= s.threshold && !IsBlacklistedMatch(value)
+}
+
+func (s GenericSignature) hasKeyword(line []byte) bool {
+	for _, keyword := range s.keywords {
+		if bytes.Contains(line, []byte(keyword)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s GenericSignature) Name() string {
+	return GenericSecretName
+}
+
+func (s GenericSignature) Verifier() string {
+	return ""
+}
+
+func (s GenericSignature) Severity() string {
+	return s.severity
+}
+
+func (s GenericSignature) Confidence() string {
+	return s.confidence
+}
@@ -2,11 +2,16 @@ package core
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
 	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
 )
 
 type GitResourceType int
@@ -18,25 +23,121 @@ const (
 	GIST_SOURCE
 	BITBUCKET_SOURCE
 	GITLAB_SOURCE
+	DOCKER_SOURCE
+	GITEA_SOURCE
+	AZURE_DEVOPS_SOURCE
+	PASTE_SOURCE
+	PACKAGE_SOURCE
+	SVN_SOURCE
+	ARCHIVE_SOURCE
+	BUCKET_SOURCE
+	RELEASE_SOURCE
 )
 
+// SourceNames are how sources are named in structured output
+var SourceNames = map[GitResourceType]string{
+	LOCAL_SOURCE:        "local",
+	GITHUB_SOURCE:       "github",
+	GITHUB_COMMENT:      "github_comment",
+	GIST_SOURCE:         "gist",
+	BITBUCKET_SOURCE:    "bitbucket",
+	GITLAB_SOURCE:       "gitlab",
+	DOCKER_SOURCE:       "docker",
+	GITEA_SOURCE:        "gitea",
+	AZURE_DEVOPS_SOURCE: "azure_devops",
+	PASTE_SOURCE:        "paste",
+	PACKAGE_SOURCE:      "package",
+	SVN_SOURCE:          "svn",
+	ARCHIVE_SOURCE:      "archive",
+	BUCKET_SOURCE:       "bucket",
+	RELEASE_SOURCE:      "github_release",
+}
+
 type GitResource struct {
-	Id   int64
-	Type GitResourceType
-	Url  string
-	Ref  string
+	Id     int64
+	Type   GitResourceType
+	Url    string
+	Ref    string
+	Before string
+	Head   string
+	GitHub *GitHubInstance
+	// Watched repositories are scanned whatever their star count
+	Watched bool
+	// Size in KB as reported by the provider when queued, 0 if unknown
+	Size int64
+	// Deferred repositories were put on the low priority queue for being
+	// larger than --large-repository-size, and were already checked as new
+	Deferred bool
+	// Parent is the clone URL of the repository this is a fork or mirror
+	// of, if its provider says
+	Parent string
+	// Priority is the queue the repository waits on to be cloned, blank for
+	// the firehose
+	Priority string
+}
+
+// ScanJob is a cloned repository or a comment waiting to be scanned. Dir is
+// where matching files are written out to, it does not exist beforehand
+type ScanJob struct {
+	Dir   string
+	Url   string
+	Stars int
+	// Metadata is what GitHub says about the repository, if it was looked up
+	Metadata   *RepositoryMetadata
+	Source     GitResourceType
+	Branch     string
+	Head       string
+	Repository *git.Repository
+	Files      []MatchFile
+	// CloneDir is where a partial clone is, to be removed after scanning
+	CloneDir string
+	// AwsSecretKeys are the secret access keys in each commit of the files
+	// being checked
+	AwsSecretKeys AwsSecretKeys
+	// Attributor finds the commits of findings in Repository
+	Attributor *Attributor
+	// Commits are those scanned besides Head, remembered in the scan cache
+	// once the job is done
+	Commits []string
+	// Rescan scans the commits in the scan cache again, for scheduled
+	// re-scans
+	Rescan bool
+}
+
+// cappedStorage is an in-memory object store that refuses objects once the
+// repository grows past maxSize bytes, aborting the clone
+type cappedStorage struct {
+	*memory.Storage
+
+	size    int64
+	maxSize int64
 }
 
-func CloneRepository(session *Session, url string, ref string, dir string) (*git.Repository, error) {
+func (s *cappedStorage) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	s.size += obj.Size()
+	if s.maxSize > 0 && s.size > s.maxSize {
+		return plumbing.ZeroHash, fmt.Errorf("repository exceeds the maximum size of %d KB", s.maxSize/1024)
+	}
+
+	return s.Storage.SetEncodedObject(obj)
+}
+
+// CloneRepository clones in to memory, without a worktree, so nothing touches
+// the disk until a match is found. Clones larger than --maximum-repository-size
+// are aborted
+func CloneRepository(session *Session, url string, ref string, source GitResourceType) (*git.Repository, error) {
 	timeout := time.Duration(*session.Options.CloneRepositoryTimeout) * time.Second
 	localCtx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	session.Log.Debug("[%s] Cloning %s in to %s", url, ref, strings.Replace(dir, *session.Options.TempDirectory, "", -1))
+	session.Log.Debug("[%s] Cloning %s", url, ref)
+
+	cloneUrl := session.Cloner.URL(url, source)
 	opts := &git.CloneOptions{
-		Depth:             1,
+		Depth:             cloneDepth(session),
 		RecurseSubmodules: git.NoRecurseSubmodules,
-		URL:               url,
+		URL:               cloneUrl,
+		Auth:              session.Cloner.Auth(cloneUrl, source),
 		SingleBranch:      true,
 		Tags:              git.NoTags,
 	}
@@ -45,12 +146,102 @@ func CloneRepository(session *Session, url string, ref string, dir string) (*git
 		opts.ReferenceName = plumbing.ReferenceName(ref)
 	}
 
-	repository, err := git.PlainCloneContext(localCtx, dir, false, opts)
+	storage := &cappedStorage{
+		Storage: memory.NewStorage(),
+		maxSize: int64(*session.Options.MaximumRepositorySize) * 1024,
+	}
+
+	repository, err := git.CloneContext(localCtx, storage, nil, opts)
 
 	if err != nil {
+		session.Metrics.Inc(MetricCloneFailures)
 		session.Log.Debug("[%s] Cloning failed: %s", url, err.Error())
 		return nil, err
 	}
 
+	session.Metrics.Inc(MetricRepositoriesCloned)
+
 	return repository, nil
 }
+
+// PartialCloneRepository clones with the git binary instead, as go-git
+// doesn't support partial clones, leaving out blobs larger than
+// --maximum-file-size. The clone is bare and written to dir, which the
+// caller removes once it has been scanned
+func PartialCloneRepository(session *Session, url string, ref string, source GitResourceType, dir string) (*git.Repository, error) {
+	timeout := time.Duration(*session.Options.CloneRepositoryTimeout) * time.Second
+	localCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	session.Log.Debug("[%s] Partially cloning %s", url, ref)
+
+	args := []string{"clone", "--quiet", "--bare", "--single-branch", "--no-tags", fmt.Sprintf("--filter=blob:limit=%dk", *session.Options.MaximumFileSize)}
+	if depth := cloneDepth(session); depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+
+	if ref != "" {
+		args = append(args, "--branch", plumbing.ReferenceName(ref).Short())
+	}
+
+	cloneUrl := session.Cloner.URL(url, source)
+	cmd := exec.CommandContext(localCtx, "git", append(args, "--", cloneUrl, dir)...)
+	cmd.Env = session.Cloner.GitEnv(cloneUrl, source)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		session.Metrics.Inc(MetricCloneFailures)
+		if localCtx.Err() != nil {
+			err = localCtx.Err()
+		} else if msg := strings.TrimSpace(string(out)); msg != "" {
+			err = errors.New(strings.SplitN(msg, "\n", 2)[0])
+		}
+		session.Log.Debug("[%s] Cloning failed: %s", url, err.Error())
+		return nil, err
+	}
+
+	if maxSize := int64(*session.Options.MaximumRepositorySize) * 1024; maxSize > 0 && GetDirectorySize(dir) > maxSize {
+		session.Metrics.Inc(MetricCloneFailures)
+		return nil, fmt.Errorf("repository exceeds the maximum size of %d KB", maxSize/1024)
+	}
+
+	repository, err := git.PlainOpen(dir)
+	if err != nil {
+		session.Metrics.Inc(MetricCloneFailures)
+		return nil, err
+	}
+
+	session.Metrics.Inc(MetricRepositoriesCloned)
+
+	return repository, nil
+}
+
+// cloneDepth fetches one more commit than is walked with --history-depth so
+// the oldest one can be diffed. 0 fetches the full history
+func cloneDepth(session *Session) int {
+	if historyDepth := *session.Options.HistoryDepth; historyDepth < 0 {
+		return 0
+	} else if historyDepth > 0 {
+		return historyDepth + 1
+	}
+
+	return 1
+}
+
+// GetHead returns the branch a repository is on, if any, and its commit
+func GetHead(repository *git.Repository) (string, string) {
+	head, err := repository.Head()
+	if err != nil {
+		return "", ""
+	}
+
+	branch := ""
+	if head.Name().IsBranch() {
+		branch = head.Name().Short()
+	}
+
+	return branch, head.Hash().String()
+}
+
+func OpenRepository(dir string) (*git.Repository, error) {
+	return git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+}
@@ -0,0 +1,201 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Forgejo is a fork of Gitea and serves the same API, so everything here
+// works against either
+type GiteaRepository struct {
+	Id          int64            `json:"id"`
+	CloneUrl    string           `json:"clone_url"`
+	UpdatedAt   string           `json:"updated_at"`
+	Private     bool             `json:"private"`
+	Empty       bool             `json:"empty"`
+	Size        int64            `json:"size"` // in KB
+	Parent      *GiteaRepository `json:"parent"`
+	Mirror      bool             `json:"mirror"`
+	OriginalUrl string           `json:"original_url"`
+}
+
+type GiteaActivity struct {
+	Id      int64           `json:"id"`
+	OpType  string          `json:"op_type"`
+	RefName string          `json:"ref_name"`
+	Repo    GiteaRepository `json:"repo"`
+}
+
+// ParentUrl returns the clone URL of the repository a fork was made from, or
+// the URL a mirror is pulled from, or an empty string for other repositories
+func (repository GiteaRepository) ParentUrl() string {
+	if repository.Parent != nil {
+		return repository.Parent.CloneUrl
+	}
+
+	if repository.Mirror {
+		return repository.OriginalUrl
+	}
+
+	return ""
+}
+
+func giteaRequest(session *Session, path string, query url.Values) (*http.Request, error) {
+	endpoint := strings.TrimRight(session.Config.Gitea.Url, "/") + "/api/v1" + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Config.Gitea.AccessToken != "" {
+		req.Header.Set("Authorization", "token "+session.Config.Gitea.AccessToken)
+	}
+
+	return req, nil
+}
+
+// GetGiteaRepositories polls the repository search ordered by last update.
+// Gitea has no instance wide event feed so this is the closest to one, and
+// with a token it includes the internal repositories the token can see
+func GetGiteaRepositories(session *Session) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[GITEA_SOURCE]
+
+	for poller := session.NewPoller(PollingGitea); ; {
+		query := url.Values{
+			"sort":  {"updated"},
+			"order": {"desc"},
+			"limit": {strconv.Itoa(poller.PageSize())},
+		}
+
+		result := struct {
+			Data []GiteaRepository `json:"data"`
+		}{}
+		req, err := giteaRequest(session, "/repos/search", query)
+		if err == nil {
+			err = GetJSON(limiter, req, &result)
+		}
+
+		if err != nil {
+			session.Log.Warn("Error getting Gitea repositories: %s... trying again", err)
+		}
+
+		for _, repository := range result.Data {
+			key := fmt.Sprintf("%d-%s", repository.Id, repository.UpdatedAt)
+			if repository.Empty || observedKeys[key] {
+				continue
+			}
+
+			observedKeys[key] = true
+			session.Repositories <- GitResource{
+				Id:     repository.Id,
+				Type:   GITEA_SOURCE,
+				Url:    repository.CloneUrl,
+				Size:   repository.Size,
+				Parent: repository.ParentUrl(),
+			}
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// GetGiteaActivities polls the activity feeds of the configured users and
+// organisations and queues each pushed branch, like the GitHub events poller
+func GetGiteaActivities(session *Session) {
+	if len(session.Config.Gitea.Users) == 0 && len(session.Config.Gitea.Organizations) == 0 {
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[int64]bool{}
+	limiter := session.RateLimiters[GITEA_SOURCE]
+
+	var feeds []string
+	for _, user := range session.Config.Gitea.Users {
+		feeds = append(feeds, fmt.Sprintf("/users/%s/activities/feeds", url.PathEscape(user)))
+	}
+
+	for _, organization := range session.Config.Gitea.Organizations {
+		feeds = append(feeds, fmt.Sprintf("/orgs/%s/activities/feeds", url.PathEscape(organization)))
+	}
+
+	for poller := session.NewPoller(PollingGitea); ; {
+		for _, feed := range feeds {
+			// activities up to the cursor were queued before the last restart
+			cursor := "gitea:" + feed
+			lastId, _ := strconv.ParseInt(session.Checkpoint.Cursor(cursor), 10, 64)
+
+			activities := make([]GiteaActivity, 0)
+			req, err := giteaRequest(session, feed, url.Values{"limit": {strconv.Itoa(poller.PageSize())}})
+			if err == nil {
+				err = GetJSON(limiter, req, &activities)
+			}
+
+			if err != nil {
+				session.Log.Warn("Error getting Gitea activities from %s: %s... trying again", feed, err)
+				continue
+			}
+
+			newestId := lastId
+			for _, activity := range activities {
+				if observedKeys[activity.Id] || activity.Id <= lastId {
+					continue
+				}
+
+				if activity.Id > newestId {
+					newestId = activity.Id
+				}
+
+				observedKeys[activity.Id] = true
+				if activity.OpType != "commit_repo" || activity.Repo.CloneUrl == "" {
+					continue
+				}
+
+				// older releases give the branch name rather than the full ref
+				ref := activity.RefName
+				if ref != "" && !strings.HasPrefix(ref, "refs/") {
+					ref = "refs/heads/" + ref
+				}
+
+				session.Repositories <- GitResource{
+					Id:     activity.Repo.Id,
+					Type:   GITEA_SOURCE,
+					Url:    activity.Repo.CloneUrl,
+					Ref:    ref,
+					Size:   activity.Repo.Size,
+					Parent: activity.Repo.ParentUrl(),
+				}
+			}
+
+			session.Checkpoint.SetCursor(cursor, fmt.Sprint(newestId))
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
@@ -4,45 +4,284 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/go-github/github"
 )
 
+const (
+	githubInstanceName = "GitHub"
+
+	// calls a token is assumed to have left before its first response
+	defaultGitHubRateLimit = 5000
+	// longest to sleep at once with every token exhausted, so tokens
+	// freed early (i.e. by another instance) are picked up
+	maximumGitHubPoolWait = 5 * time.Minute
+)
+
+// GitHubClientWrapper is a client for one token, shared by every caller,
+// with the token's rate limit as of its latest response
 type GitHubClientWrapper struct {
 	*github.Client
 	Token            string
 	RateLimitedUntil time.Time
+	Remaining        int
+	Limit            int
+	Reset            time.Time
+
+	// calls being made with it right now
+	inFlight int
 }
 
-const (
-	perPage = 300
-	sleep   = 30 * time.Second
-)
+// available estimates how many calls the token has left, counting those in
+// flight as made
+func (c *GitHubClientWrapper) available(now time.Time) int {
+	remaining := c.Remaining
+	if c.Limit == 0 {
+		remaining = defaultGitHubRateLimit
+	} else if now.After(c.Reset) {
+		remaining = c.Limit
+	}
+
+	return remaining - c.inFlight
+}
+
+// GitHubInstance is github.com or a GitHub Enterprise Server, each with its
+// own pool of clients as tokens are only valid for the instance they're from
+type GitHubInstance struct {
+	sync.Mutex
+
+	Name    string
+	clients []*GitHubClientWrapper
+}
+
+func NewGitHubInstance(name string) *GitHubInstance {
+	return &GitHubInstance{Name: name}
+}
+
+// AddClient pools a client for a token
+func (g *GitHubInstance) AddClient(client *github.Client, token string) *GitHubClientWrapper {
+	g.Lock()
+	defer g.Unlock()
+
+	wrapper := &GitHubClientWrapper{Client: client, Token: token}
+	g.clients = append(g.clients, wrapper)
+
+	return wrapper
+}
+
+// RemoveClient takes the client for a token out of the pool. Calls being
+// made with it are left to finish
+func (g *GitHubInstance) RemoveClient(token string) {
+	g.Lock()
+	defer g.Unlock()
+
+	for i, client := range g.clients {
+		if client.Token == token {
+			g.clients = append(g.clients[:i:i], g.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+// Tokens returns the token of every pooled client
+func (g *GitHubInstance) Tokens() []string {
+	g.Lock()
+	defer g.Unlock()
+
+	tokens := make([]string, 0, len(g.clients))
+	for _, client := range g.clients {
+		tokens = append(tokens, client.Token)
+	}
+
+	return tokens
+}
 
-func GetRepositories(session *Session) {
-	localCtx, cancel := context.WithCancel(session.Context)
+func (g *GitHubInstance) Size() int {
+	g.Lock()
+	defer g.Unlock()
+
+	return len(g.clients)
+}
+
+// GetClient returns the client whose token has the most calls left, or
+// waits for the first token to reset if every one is rate limited. Return
+// it with FreeClient once done
+func (g *GitHubInstance) GetClient() *GitHubClientWrapper {
+	for {
+		g.Lock()
+		client, reset := g.healthiest(time.Now())
+		if client != nil {
+			client.inFlight++
+		}
+		g.Unlock()
+
+		if client == nil {
+			wait := time.Until(reset)
+			if wait < time.Second {
+				wait = time.Second
+			} else if wait > maximumGitHubPoolWait {
+				wait = maximumGitHubPoolWait
+			}
+
+			session.Metrics.Inc(MetricGitHubPoolWaits, "instance", g.Name)
+			session.Log.Warn("All %s tokens exhausted/rate limited. Sleeping for %s", g.Name, wait.String())
+			time.Sleep(wait)
+			continue
+		}
+
+		// another instance may have exhausted the token
+		if session.Shared != nil {
+			if until := session.Shared.RateLimitedUntil(client.Token); until.After(time.Now()) {
+				g.Lock()
+				client.RateLimitedUntil = until
+				client.inFlight--
+				g.Unlock()
+
+				g.updateMetrics()
+				continue
+			}
+		}
+
+		session.Log.Debug("Using %s client with token: %s", g.Name, client.Token[:10])
+		g.updateMetrics()
+		return client
+	}
+}
+
+// healthiest returns the client that isn't rate limited with the most calls
+// left, or else when the first rate limited one resets
+func (g *GitHubInstance) healthiest(now time.Time) (*GitHubClientWrapper, time.Time) {
+	var best *GitHubClientWrapper
+	var reset time.Time
+
+	for _, client := range g.clients {
+		if client.RateLimitedUntil.After(now) {
+			if reset.IsZero() || client.RateLimitedUntil.Before(reset) {
+				reset = client.RateLimitedUntil
+			}
+			continue
+		}
+
+		if best == nil || client.available(now) > best.available(now) {
+			best = client
+		}
+	}
+
+	return best, reset
+}
+
+// RateBudget is how many calls the tokens of the instance have left, and
+// when the last of them resets. Tokens not yet used don't count
+func (g *GitHubInstance) RateBudget() (int, time.Time) {
+	g.Lock()
+	defer g.Unlock()
+
+	now, remaining, reset := time.Now(), 0, time.Time{}
+	for _, client := range g.clients {
+		if client.Limit == 0 || now.After(client.Reset) {
+			continue
+		}
+
+		remaining += client.Remaining
+		if client.Reset.After(reset) {
+			reset = client.Reset
+		}
+	}
+
+	return remaining, reset
+}
+
+// FreeClient returns a client to the pool once a call with it is done,
+// sharing its rate limit with other instances if it has run out
+func (g *GitHubInstance) FreeClient(client *GitHubClientWrapper) {
+	g.Lock()
+	client.inFlight--
+	until := client.RateLimitedUntil
+	g.Unlock()
+
+	if session.Shared != nil && until.After(time.Now()) {
+		session.Shared.SetRateLimited(client.Token, until)
+	}
+
+	g.updateMetrics()
+}
+
+// UpdateRate records the rate limit of a client's token from the headers of
+// a response, marking it rate limited once it has run out of calls
+func (g *GitHubInstance) UpdateRate(client *GitHubClientWrapper, resp *github.Response) {
+	// instances with rate limiting disabled don't send the headers
+	if resp == nil || resp.Rate.Limit == 0 {
+		return
+	}
+
+	g.Lock()
+	client.Remaining, client.Limit, client.Reset = resp.Rate.Remaining, resp.Rate.Limit, resp.Rate.Reset.Time
+	limited := resp.Rate.Remaining <= 1 && client.RateLimitedUntil.Before(client.Reset)
+	if limited {
+		client.RateLimitedUntil = client.Reset
+	}
+	g.Unlock()
+
+	session.Metrics.Set(MetricRateLimitRemaining, float64(resp.Rate.Remaining), "token", client.Token[:10])
+	session.Metrics.Set(MetricRateLimitReset, float64(resp.Rate.Reset.Unix()), "token", client.Token[:10])
+
+	if limited {
+		session.Log.Warn("Token %s[..] rate limited. Reset at %s", client.Token[:10], resp.Rate.Reset)
+	}
+}
+
+func (g *GitHubInstance) updateMetrics() {
+	g.Lock()
+	now, available, limited := time.Now(), 0, 0
+	for _, client := range g.clients {
+		if client.RateLimitedUntil.After(now) {
+			limited++
+		} else {
+			available++
+		}
+	}
+	g.Unlock()
+
+	session.Metrics.Set(MetricGitHubTokensAvailable, float64(available), "instance", g.Name)
+	session.Metrics.Set(MetricGitHubTokensRateLimited, float64(limited), "instance", g.Name)
+}
+
+// sleep is how long to wait before trying again
+const sleep = 30 * time.Second
+
+func GetRepositories(session *Session, instance *GitHubInstance) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
 	defer cancel()
 
 	observedKeys := map[string]bool{}
 	var client *GitHubClientWrapper
 
-	for c := time.Tick(sleep); ; {
-		opt := &github.ListOptions{PerPage: perPage}
+	// events up to the cursor were queued before the last restart
+	cursor := "github:" + instance.Name
+	lastId, _ := strconv.ParseInt(session.Checkpoint.Cursor(cursor), 10, 64)
+	polled := false
+
+	for poller := session.NewGitHubPoller(instance); ; {
+		opt := &github.ListOptions{PerPage: poller.PageSize()}
+		newestId := lastId
+		gap := eventGap{}
 
 		for {
 			if client != nil {
-				session.FreeClient(client)
+				instance.FreeClient(client)
 			}
 
-			client = session.GetClient()
+			client = instance.GetClient()
 			events, resp, err := client.Activity.ListEvents(localCtx, opt)
+			instance.UpdateRate(client, resp)
 
 			if err != nil {
 				if _, ok := err.(*github.RateLimitError); ok {
-					session.Log.Warn("Token %s[..] rate limited. Reset at %s", client.Token[:10], resp.Rate.Reset)
-					client.RateLimitedUntil = resp.Rate.Reset.Time
-					session.FreeClient(client)
+					instance.FreeClient(client)
+					client = nil
 					break
 				}
 
@@ -50,16 +289,16 @@ func GetRepositories(session *Session) {
 					session.Log.Fatal("GitHub API abused detected. Quitting...")
 				}
 
-				session.Log.Warn("Error getting GitHub events: %s... trying again", err)
+				session.Log.Warn("Error getting %s events: %s... trying again", instance.Name, err)
 			}
 
 			if opt.Page == 0 {
 				tokenMessage := fmt.Sprintf("[?] Token %s[..] has %d/%d calls remaining.", client.Token[:10], resp.Rate.Remaining, resp.Rate.Limit)
 
 				if resp.Rate.Remaining < 100 {
-					session.Log.Warn(tokenMessage)
+					session.Log.Warn("%s", tokenMessage)
 				} else {
-					session.Log.Debug(tokenMessage)
+					session.Log.Debug("%s", tokenMessage)
 				}
 			}
 
@@ -67,10 +306,16 @@ func GetRepositories(session *Session) {
 
 			// remove duplicates
 			for _, e := range events {
-				if observedKeys[*e.ID] {
+				id, _ := strconv.ParseInt(e.GetID(), 10, 64)
+				gap.observe(id, lastId)
+				if observedKeys[*e.ID] || id <= lastId {
 					continue
 				}
 
+				if id > newestId {
+					newestId = id
+				}
+
 				newEvents = append(newEvents, e)
 			}
 
@@ -80,11 +325,26 @@ func GetRepositories(session *Session) {
 
 					dst := &github.PushEvent{}
 					json.Unmarshal(e.GetRawPayload(), dst)
-					session.Repositories <- GitResource{
-						Id:   e.GetRepo().GetID(),
-						Type: GITHUB_SOURCE,
-						Url:  e.GetRepo().GetURL(),
-						Ref:  dst.GetRef(),
+					resource := GitResource{
+						Id:     e.GetRepo().GetID(),
+						Type:   GITHUB_SOURCE,
+						Url:    e.GetRepo().GetURL(),
+						Ref:    dst.GetRef(),
+						Before: dst.GetBefore(),
+						Head:   dst.GetHead(),
+						GitHub: instance,
+					}
+
+					if session.IsNewEvent(resource) {
+						session.Repositories <- resource
+					}
+				} else if *e.Type == "ReleaseEvent" && *session.Options.ProcessReleases {
+					observedKeys[*e.ID] = true
+
+					dst := &github.ReleaseEvent{}
+					json.Unmarshal(e.GetRawPayload(), dst)
+					if dst.GetAction() == "published" {
+						queueReleaseAssets(session, dst.GetRelease(), "")
 					}
 				} else if *e.Type == "IssueCommentEvent" {
 					observedKeys[*e.ID] = true
@@ -101,7 +361,9 @@ func GetRepositories(session *Session) {
 				}
 			}
 
-			if resp.NextPage == 0 {
+			// pages go back in time, and are only needed until the events of
+			// the last poll are reached
+			if resp.NextPage == 0 || gap.caughtUp {
 				break
 			}
 
@@ -109,8 +371,23 @@ func GetRepositories(session *Session) {
 			time.Sleep(5 * time.Second)
 		}
 
+		if missed := gap.missed(lastId); missed > 0 {
+			session.Metrics.Add(MetricGitHubEventsMissed, float64(missed), "instance", instance.Name)
+			if polled {
+				session.Log.Warn("Missed about %d %s events since the last poll, which was too long ago to page back to. Poll more often with polling.github, or add tokens", missed, instance.Name)
+			} else {
+				session.Log.Warn("Missed about %d %s events since the checkpoint, which was too long ago to page back to", missed, instance.Name)
+			}
+		}
+		polled = true
+
+		if newestId > lastId {
+			lastId = newestId
+			session.Checkpoint.SetCursor(cursor, fmt.Sprint(lastId))
+		}
+
 		select {
-		case <-c:
+		case <-poller.Next():
 			continue
 		case <-localCtx.Done():
 			cancel()
@@ -119,55 +396,113 @@ func GetRepositories(session *Session) {
 	}
 }
 
-func GetGists(session *Session) {
-	localCtx, cancel := context.WithCancel(session.Context)
+// eventGap tracks how far back the pages of a poll of the events feed go.
+// Event IDs are shared with private events, so the IDs between those of
+// the last poll and the oldest of this one are a gap of about as many
+// public events as the pages fetched had per ID
+type eventGap struct {
+	oldest, newest int64
+	events         int
+	// whether an event of the last poll was reached
+	caughtUp bool
+}
+
+func (g *eventGap) observe(id int64, lastId int64) {
+	if id <= lastId {
+		g.caughtUp = true
+		return
+	}
+
+	if g.oldest == 0 || id < g.oldest {
+		g.oldest = id
+	}
+	if id > g.newest {
+		g.newest = id
+	}
+	g.events++
+}
+
+// missed estimates how many public events were between the last poll and
+// the oldest fetched by this one, none if they were reached or there was
+// no last poll
+func (g *eventGap) missed(lastId int64) int {
+	if g.caughtUp || lastId == 0 || g.events < 2 || g.newest <= g.oldest {
+		return 0
+	}
+
+	perId := float64(g.events-1) / float64(g.newest-g.oldest)
+	return int(float64(g.oldest-lastId-1) * perId)
+}
+
+func GetGists(session *Session, instance *GitHubInstance) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
 	defer cancel()
 
 	observedKeys := map[string]bool{}
-	opt := &github.GistListOptions{}
+	var since time.Time
 
 	var client *GitHubClientWrapper
-	for c := time.Tick(sleep); ; {
-		if client != nil {
-			session.FreeClient(client)
-		}
+	for poller := session.NewGitHubPoller(instance); ; {
+		// the start of this poll, so gists updated while paging are picked
+		// up by the next
+		started := time.Now()
+		opt := &github.GistListOptions{Since: since, ListOptions: github.ListOptions{PerPage: poller.PageSize()}}
+		failed := false
 
-		client = session.GetClient()
-		gists, resp, err := client.Gists.ListAll(localCtx, opt)
+		for {
+			if client != nil {
+				instance.FreeClient(client)
+			}
 
-		if err != nil {
-			if _, ok := err.(*github.RateLimitError); ok {
-				session.Log.Warn("Token %s[..] rate limited. Reset at %s", client.Token[:10], resp.Rate.Reset)
-				client.RateLimitedUntil = resp.Rate.Reset.Time
-				session.FreeClient(client)
+			client = instance.GetClient()
+			gists, resp, err := client.Gists.ListAll(localCtx, opt)
+			instance.UpdateRate(client, resp)
+
+			if err != nil {
+				failed = true
+
+				if _, ok := err.(*github.RateLimitError); ok {
+					instance.FreeClient(client)
+					client = nil
+					break
+				}
+
+				if _, ok := err.(*github.AbuseRateLimitError); ok {
+					session.Log.Fatal("GitHub API abused detected. Quitting...")
+				}
+
+				session.Log.Warn("Error getting %s Gists: %s ... trying again", instance.Name, err)
 				break
 			}
 
-			if _, ok := err.(*github.AbuseRateLimitError); ok {
-				session.Log.Fatal("GitHub API abused detected. Quitting...")
-			}
+			// keyed on the update time too so new revisions of a Gist are cloned
+			for _, e := range gists {
+				key := e.GetID() + e.GetUpdatedAt().String()
+				if observedKeys[key] {
+					continue
+				}
 
-			session.Log.Warn("Error getting GitHub Gists: %s ... trying again", err)
-		}
+				observedKeys[key] = true
+				session.Gists <- e.GetGitPullURL()
+			}
 
-		newGists := make([]*github.Gist, 0, len(gists))
-		for _, e := range gists {
-			if observedKeys[*e.ID] {
-				continue
+			// the first poll only looks at the latest page, rather than every
+			// gist there is
+			if since.IsZero() || resp.NextPage == 0 {
+				break
 			}
 
-			newGists = append(newGists, e)
+			opt.Page = resp.NextPage
 		}
 
-		for _, e := range newGists {
-			observedKeys[*e.ID] = true
-			session.Gists <- *e.GitPullURL
+		// only moved on once every page was fetched, so a failed poll is
+		// made again from the same point
+		if !failed {
+			since = started
 		}
 
-		opt.Since = time.Now()
-
 		select {
-		case <-c:
+		case <-poller.Next():
 			continue
 		case <-localCtx.Done():
 			cancel()
@@ -176,20 +511,16 @@ func GetGists(session *Session) {
 	}
 }
 
-func GetRepository(session *Session, id int64) (*github.Repository, error) {
-	client := session.GetClient()
-	defer session.FreeClient(client)
+func GetRepository(session *Session, instance *GitHubInstance, id int64) (*github.Repository, error) {
+	client := instance.GetClient()
+	defer instance.FreeClient(client)
 
 	repo, resp, err := client.Repositories.GetByID(session.Context, id)
+	instance.UpdateRate(client, resp)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.Rate.Remaining <= 1 {
-		session.Log.Warn("Token %s[..] rate limited. Reset at %s", client.Token[:10], resp.Rate.Reset)
-		client.RateLimitedUntil = resp.Rate.Reset.Time
-	}
-
 	return repo, nil
 }
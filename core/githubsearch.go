@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	defaultGitHubSearchInterval = 60
+	defaultGitHubSearchMaxPages = 10
+
+	// code search allows far fewer requests than the rest of the API
+	githubCodeSearchRequestsPerMinute = 10
+	// how long to back off when no reset time or Retry-After is given
+	githubSearchBackoff = time.Minute
+)
+
+// GitHubSearchConfig are code search queries to run periodically, finding
+// secrets committed before shhgit was watching
+type GitHubSearchConfig struct {
+	Queries  []string `yaml:"queries"`
+	Interval int      `yaml:"interval"`
+	MaxPages int      `yaml:"max_pages"`
+}
+
+// SearchGitHub runs the configured code search queries every interval
+// minutes and queues every repository with a matching file, whatever its
+// star count. Each repository is only queued once
+func SearchGitHub(session *Session, instance *GitHubInstance) {
+	if len(session.Config.GitHubSearch.Queries) == 0 {
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[int64]bool{}
+	limiter := NewRateLimiter(githubCodeSearchRequestsPerMinute)
+	interval := time.Duration(session.Config.GitHubSearch.Interval) * time.Minute
+
+	for c := time.Tick(interval); ; {
+		for _, searchQuery := range session.Config.GitHubSearch.Queries {
+			queued := 0
+			opt := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 100}}
+
+			for page := 1; page <= session.Config.GitHubSearch.MaxPages; {
+				opt.Page = page
+				limiter.Wait()
+
+				client := instance.GetClient()
+				result, resp, err := client.Search.Code(localCtx, searchQuery, opt)
+				instance.FreeClient(client)
+
+				if err != nil {
+					if wait, limited := githubSearchRetryAfter(err); limited {
+						session.Log.Warn("%s code search rate limited. Retrying '%s' in %s", instance.Name, searchQuery, wait)
+						time.Sleep(wait)
+						continue
+					}
+
+					session.Log.Warn("Error searching %s for '%s': %s... trying again", instance.Name, searchQuery, err)
+					break
+				}
+
+				for _, code := range result.CodeResults {
+					repository := code.GetRepository()
+					if observedKeys[repository.GetID()] {
+						continue
+					}
+
+					observedKeys[repository.GetID()] = true
+					queued++
+					session.QueueRepository(GitResource{
+						Id:       repository.GetID(),
+						Type:     GITHUB_SOURCE,
+						Url:      repository.GetHTMLURL(),
+						GitHub:   instance,
+						Watched:  true,
+						Priority: PriorityWatched,
+					})
+				}
+
+				if resp.NextPage == 0 {
+					break
+				}
+				page = resp.NextPage
+			}
+
+			session.Log.Debug("Queued %d new repositories from %s code search '%s'", queued, instance.Name, searchQuery)
+		}
+
+		select {
+		case <-c:
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// githubSearchRetryAfter returns how long to wait before retrying a search
+// that hit the primary or secondary rate limit
+func githubSearchRetryAfter(err error) (time.Duration, bool) {
+	switch e := err.(type) {
+	case *github.RateLimitError:
+		if wait := time.Until(e.Rate.Reset.Time); wait > 0 {
+			return wait, true
+		}
+		return githubSearchBackoff, true
+	case *github.AbuseRateLimitError:
+		if e.RetryAfter != nil {
+			return *e.RetryAfter, true
+		}
+		return githubSearchBackoff, true
+	case *github.ErrorResponse:
+		// newer secondary rate limit responses aren't recognised as abuse
+		if e.Response == nil || (e.Response.StatusCode != http.StatusForbidden && e.Response.StatusCode != http.StatusTooManyRequests) ||
+			!strings.Contains(strings.ToLower(e.Message), "rate limit") {
+			return 0, false
+		}
+
+		if seconds, err := strconv.Atoi(e.Response.Header.Get("Retry-After")); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		return githubSearchBackoff, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// GitHubWatchConfig lists the organisations and users whose activity is
+// watched closely, such as your own and their typosquats
+type GitHubWatchConfig struct {
+	Organizations []string `yaml:"organizations"`
+	Users         []string `yaml:"users"`
+}
+
+func (c GitHubWatchConfig) Enabled() bool {
+	return len(c.Organizations) > 0 || len(c.Users) > 0
+}
+
+// WatchGitHub polls the event feeds of the watched organisations and users
+// and queues every push, new repository, branch and tag, and release. Their
+// repositories are scanned whatever their star count
+func WatchGitHub(session *Session, instance *GitHubInstance) {
+	if !session.Config.GitHubWatch.Enabled() {
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[string]bool{}
+	opt := &github.ListOptions{PerPage: session.Config.Polling[PollingGitHub].PageSize}
+
+	for poller := session.NewGitHubPoller(instance); ; {
+		for _, organization := range session.Config.GitHubWatch.Organizations {
+			var events []*github.Event
+			err := callGitHub(session, instance, func(client *GitHubClientWrapper) (resp *github.Response, err error) {
+				events, resp, err = client.Activity.ListEventsForOrganization(localCtx, organization, opt)
+				return resp, err
+			})
+
+			if err != nil {
+				session.Log.Warn("Error getting %s events for organization %s: %s... trying again", instance.Name, organization, err)
+			}
+
+			queueWatchedEvents(session, instance, events, observedKeys)
+		}
+
+		for _, user := range session.Config.GitHubWatch.Users {
+			var events []*github.Event
+			err := callGitHub(session, instance, func(client *GitHubClientWrapper) (resp *github.Response, err error) {
+				events, resp, err = client.Activity.ListEventsPerformedByUser(localCtx, user, false, opt)
+				return resp, err
+			})
+
+			if err != nil {
+				session.Log.Warn("Error getting %s events for user %s: %s... trying again", instance.Name, user, err)
+			}
+
+			queueWatchedEvents(session, instance, events, observedKeys)
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// WatchGitHubGists polls the Gists of the watched users. Organisations
+// can't own Gists so aren't polled
+func WatchGitHubGists(session *Session, instance *GitHubInstance) {
+	if len(session.Config.GitHubWatch.Users) == 0 {
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[string]bool{}
+	since := make(map[string]time.Time)
+	for _, user := range session.Config.GitHubWatch.Users {
+		since[user], _ = time.Parse(time.RFC3339, session.Checkpoint.Cursor("gists:"+instance.Name+":"+user))
+	}
+
+	for poller := session.NewGitHubPoller(instance); ; {
+		for _, user := range session.Config.GitHubWatch.Users {
+			var gists []*github.Gist
+			polled := time.Now()
+			err := callGitHub(session, instance, func(client *GitHubClientWrapper) (resp *github.Response, err error) {
+				gists, resp, err = client.Gists.List(localCtx, user, &github.GistListOptions{Since: since[user]})
+				return resp, err
+			})
+
+			if err != nil {
+				session.Log.Warn("Error getting %s Gists for user %s: %s... trying again", instance.Name, user, err)
+				continue
+			}
+
+			since[user] = polled
+			for _, gist := range gists {
+				// keyed on the update time too so new revisions of a Gist are cloned
+				key := gist.GetID() + gist.GetUpdatedAt().String()
+				if observedKeys[key] {
+					continue
+				}
+
+				observedKeys[key] = true
+				session.Gists <- gist.GetGitPullURL()
+			}
+
+			session.Checkpoint.SetCursor("gists:"+instance.Name+":"+user, polled.UTC().Format(time.RFC3339))
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+func queueWatchedEvents(session *Session, instance *GitHubInstance, events []*github.Event, observedKeys map[string]bool) {
+	for _, e := range events {
+		// the same event is in the feeds of both the organization and the user
+		if observedKeys[e.GetID()] {
+			continue
+		}
+		observedKeys[e.GetID()] = true
+
+		resource := GitResource{
+			Id:       e.GetRepo().GetID(),
+			Type:     GITHUB_SOURCE,
+			Url:      e.GetRepo().GetURL(),
+			GitHub:   instance,
+			Watched:  true,
+			Priority: PriorityWatched,
+		}
+
+		switch e.GetType() {
+		case "PushEvent":
+			dst := &github.PushEvent{}
+			json.Unmarshal(e.GetRawPayload(), dst)
+			resource.Ref, resource.Before, resource.Head = dst.GetRef(), dst.GetBefore(), dst.GetHead()
+
+		case "CreateEvent":
+			dst := &github.CreateEvent{}
+			json.Unmarshal(e.GetRawPayload(), dst)
+
+			switch dst.GetRefType() {
+			case "branch":
+				resource.Ref = "refs/heads/" + dst.GetRef()
+			case "tag":
+				resource.Ref = "refs/tags/" + dst.GetRef()
+			}
+
+		case "ReleaseEvent":
+			dst := &github.ReleaseEvent{}
+			json.Unmarshal(e.GetRawPayload(), dst)
+			resource.Ref = "refs/tags/" + dst.GetRelease().GetTagName()
+
+			// release assets are often build output, with config baked in
+			queueReleaseAssets(session, dst.GetRelease(), PriorityWatched)
+
+		default:
+			continue
+		}
+
+		if !session.IsNewEvent(resource) {
+			continue
+		}
+
+		session.Log.Debug("Queued %s %s for %s", e.GetType(), resource.Ref, e.GetRepo().GetName())
+		session.QueueRepository(resource)
+	}
+}
+
+// callGitHub calls the API with a client from the instance's pool, recording
+// the rate limit of its token
+func callGitHub(session *Session, instance *GitHubInstance, call func(client *GitHubClientWrapper) (*github.Response, error)) error {
+	client := instance.GetClient()
+	defer instance.FreeClient(client)
+
+	resp, err := call(client)
+	instance.UpdateRate(client, resp)
+
+	if _, ok := err.(*github.AbuseRateLimitError); ok {
+		session.Log.Fatal("GitHub API abused detected. Quitting...")
+	}
+
+	return err
+}
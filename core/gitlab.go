@@ -0,0 +1,249 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type GitLabProject struct {
+	Id             int64  `json:"id"`
+	HttpUrlToRepo  string `json:"http_url_to_repo"`
+	LastActivityAt string `json:"last_activity_at"`
+	// only set for forks
+	ForkedFromProject *GitLabProject `json:"forked_from_project"`
+}
+
+type GitLabSnippet struct {
+	Id            int64  `json:"id"`
+	HttpUrlToRepo string `json:"http_url_to_repo"`
+	RawUrl        string `json:"raw_url"`
+}
+
+type GitLabBlob struct {
+	ProjectId int64  `json:"project_id"`
+	Path      string `json:"path"`
+}
+
+func gitLabRequest(session *Session, path string, query url.Values) (*http.Request, error) {
+	endpoint := strings.TrimRight(session.Config.GitLab.Url, "/") + "/api/v4" + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Config.GitLab.AccessToken != "" {
+		req.Header.Set("PRIVATE-TOKEN", session.Config.GitLab.AccessToken)
+	}
+
+	return req, nil
+}
+
+// GetGitLabProjectSize looks up the size of a project's repository in KB,
+// which GitLab only returns to tokens with at least reporter access
+func GetGitLabProjectSize(session *Session, id int64) (int64, error) {
+	project := struct {
+		Statistics struct {
+			RepositorySize int64 `json:"repository_size"`
+		} `json:"statistics"`
+	}{}
+
+	req, err := gitLabRequest(session, fmt.Sprintf("/projects/%d", id), url.Values{"statistics": {"true"}})
+	if err == nil {
+		err = GetJSON(session.RateLimiters[GITLAB_SOURCE], req, &project)
+	}
+
+	return project.Statistics.RepositorySize / 1024, err
+}
+
+// GetGitLabProjects polls the public projects list ordered by last activity,
+// the closest GitLab has to a public event feed
+func GetGitLabProjects(session *Session) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[GITLAB_SOURCE]
+
+	// projects last active up to the cursor were queued before the last restart
+	lastActivity := session.Checkpoint.Cursor("gitlab")
+
+	for poller := session.NewPoller(PollingGitLab); ; {
+		query := url.Values{
+			"visibility": {"public"},
+			"order_by":   {"last_activity_at"},
+			"sort":       {"desc"},
+			"per_page":   {strconv.Itoa(poller.PageSize())},
+		}
+
+		projects := make([]GitLabProject, 0)
+		req, err := gitLabRequest(session, "/projects", query)
+		if err == nil {
+			err = GetJSON(limiter, req, &projects)
+		}
+
+		if err != nil {
+			session.Log.Warn("Error getting GitLab projects: %s... trying again", err)
+		}
+
+		newestActivity := lastActivity
+		for _, project := range projects {
+			key := fmt.Sprintf("%d-%s", project.Id, project.LastActivityAt)
+			if observedKeys[key] || project.LastActivityAt <= lastActivity {
+				continue
+			}
+
+			if project.LastActivityAt > newestActivity {
+				newestActivity = project.LastActivityAt
+			}
+
+			observedKeys[key] = true
+			resource := GitResource{
+				Id:   project.Id,
+				Type: GITLAB_SOURCE,
+				Url:  project.HttpUrlToRepo,
+			}
+			if project.ForkedFromProject != nil {
+				resource.Parent = project.ForkedFromProject.HttpUrlToRepo
+			}
+
+			session.Repositories <- resource
+		}
+
+		if newestActivity > lastActivity {
+			lastActivity = newestActivity
+			session.Checkpoint.SetCursor("gitlab", lastActivity)
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// GetGitLabSnippets polls public snippets, the GitLab equivalent of Gists.
+// Snippets are cloned where GitLab exposes them as repositories, otherwise
+// the raw contents are scanned like issue comments
+func GetGitLabSnippets(session *Session) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[int64]bool{}
+	limiter := session.RateLimiters[GITLAB_SOURCE]
+
+	for poller := session.NewPoller(PollingGitLab); ; {
+		snippets := make([]GitLabSnippet, 0)
+		req, err := gitLabRequest(session, "/snippets/public", url.Values{"per_page": {strconv.Itoa(poller.PageSize())}})
+		if err == nil {
+			err = GetJSON(limiter, req, &snippets)
+		}
+
+		if err != nil {
+			session.Log.Warn("Error getting GitLab snippets: %s... trying again", err)
+		}
+
+		for _, snippet := range snippets {
+			if observedKeys[snippet.Id] {
+				continue
+			}
+
+			observedKeys[snippet.Id] = true
+			if snippet.HttpUrlToRepo != "" {
+				session.Repositories <- GitResource{
+					Id:   snippet.Id,
+					Type: GITLAB_SOURCE,
+					Url:  snippet.HttpUrlToRepo,
+				}
+			} else if body, err := getRaw(limiter, snippet.RawUrl); err != nil {
+				session.Log.Debug("Failed to retrieve GitLab snippet %s: %s", snippet.RawUrl, err)
+			} else {
+				session.Comments <- body
+			}
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// SearchGitLab periodically runs the configured code search queries and
+// queues every project with a matching blob. Searching requires a token
+func SearchGitLab(session *Session) {
+	if len(session.Config.GitLab.SearchQueries) == 0 {
+		return
+	}
+
+	if session.Config.GitLab.AccessToken == "" {
+		session.Log.Warn("GitLab search queries configured but no GitLab access token given. Skipping search.")
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[int64]bool{}
+	limiter := session.RateLimiters[GITLAB_SOURCE]
+
+	for poller := session.NewPoller(PollingGitLab); ; {
+		for _, searchQuery := range session.Config.GitLab.SearchQueries {
+			blobs := make([]GitLabBlob, 0)
+			req, err := gitLabRequest(session, "/search", url.Values{"scope": {"blobs"}, "search": {searchQuery}, "per_page": {strconv.Itoa(poller.PageSize())}})
+			if err == nil {
+				err = GetJSON(limiter, req, &blobs)
+			}
+
+			if err != nil {
+				session.Log.Warn("Error searching GitLab for '%s': %s... trying again", searchQuery, err)
+				continue
+			}
+
+			for _, blob := range blobs {
+				if observedKeys[blob.ProjectId] {
+					continue
+				}
+
+				project := GitLabProject{}
+				req, err := gitLabRequest(session, fmt.Sprintf("/projects/%d", blob.ProjectId), nil)
+				if err == nil {
+					err = GetJSON(limiter, req, &project)
+				}
+
+				if err != nil {
+					session.Log.Debug("Failed to retrieve GitLab project %d: %s", blob.ProjectId, err)
+					continue
+				}
+
+				observedKeys[blob.ProjectId] = true
+				session.Repositories <- GitResource{
+					Id:   blob.ProjectId,
+					Type: GITLAB_SOURCE,
+					Url:  project.HttpUrlToRepo,
+				}
+			}
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
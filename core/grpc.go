@@ -0,0 +1,256 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/eth0izzle/shhgit/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The gRPC service described by shhgit.proto, with the code generated from
+// it in api/v1
+const (
+	defaultGrpcBufferSize  = 100
+	defaultGrpcSendTimeout = 30
+)
+
+// grpcSubscriber is a StreamFindings call, fed by GrpcSink
+type grpcSubscriber struct {
+	filter  SinkFilter
+	events  chan *MatchEvent
+	dropped chan struct{}
+	drop    sync.Once
+}
+
+// GrpcSink fans findings out to every StreamFindings call. A client that
+// falls behind holds up the output workers until its buffer has room, for at
+// most send_timeout seconds, after which its stream is ended
+type GrpcSink struct {
+	sync.Mutex
+
+	config      GrpcConfig
+	subscribers map[*grpcSubscriber]bool
+}
+
+func NewGrpcSink(config GrpcConfig) *GrpcSink {
+	return &GrpcSink{config: config, subscribers: make(map[*grpcSubscriber]bool)}
+}
+
+func (g *GrpcSink) Name() string {
+	return "gRPC"
+}
+
+func (g *GrpcSink) Send(event *MatchEvent) error {
+	g.Lock()
+	subscribers := make([]*grpcSubscriber, 0, len(g.subscribers))
+	for subscriber := range g.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	g.Unlock()
+
+	dropped := 0
+	for _, subscriber := range subscribers {
+		if !subscriber.filter.Accepts(event) {
+			continue
+		}
+
+		select {
+		case subscriber.events <- event:
+		case <-subscriber.dropped:
+		case <-time.After(time.Duration(g.config.SendTimeout) * time.Second):
+			subscriber.drop.Do(func() { close(subscriber.dropped) })
+			dropped++
+		}
+	}
+
+	if dropped > 0 {
+		return fmt.Errorf("ended %d stream(s) that stopped reading", dropped)
+	}
+
+	return nil
+}
+
+func (g *GrpcSink) subscribe(filter SinkFilter) *grpcSubscriber {
+	subscriber := &grpcSubscriber{
+		filter:  filter,
+		events:  make(chan *MatchEvent, g.config.BufferSize),
+		dropped: make(chan struct{}),
+	}
+
+	g.Lock()
+	g.subscribers[subscriber] = true
+	g.Unlock()
+
+	return subscriber
+}
+
+func (g *GrpcSink) unsubscribe(subscriber *grpcSubscriber) {
+	g.Lock()
+	delete(g.subscribers, subscriber)
+	g.Unlock()
+
+	subscriber.drop.Do(func() { close(subscriber.dropped) })
+}
+
+// InitGrpc serves the gRPC API on grpc.listen. It must run before InitSinks
+// starts the output workers
+func (s *Session) InitGrpc() {
+	config := s.Config.Grpc
+	if config.Listen == "" {
+		return
+	}
+
+	if config.CertFile == "" || config.KeyFile == "" {
+		s.Log.Fatal("gRPC needs grpc.cert_file and grpc.key_file as it is only served over TLS")
+	}
+
+	creds, err := credentials.NewServerTLSFromFile(config.CertFile, config.KeyFile)
+	if err != nil {
+		s.Log.Fatal("Failed to load the gRPC certificate: %s", err)
+	}
+
+	sink := NewGrpcSink(config)
+	s.Sinks = append(s.Sinks, sink)
+
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		s.Log.Fatal("Failed to serve gRPC on %s: %s", config.Listen, err)
+	}
+
+	server := s.newGrpcServer(sink, grpc.Creds(creds))
+
+	go func() {
+		s.Log.Debug("Serving gRPC on %s", config.Listen)
+		if err := server.Serve(listener); err != nil {
+			s.Log.Fatal("Failed to serve gRPC on %s: %s", config.Listen, err)
+		}
+	}()
+}
+
+// newGrpcServer registers the service on a server that only accepts calls
+// carrying one of the api_tokens
+func (s *Session) newGrpcServer(sink *GrpcSink, options ...grpc.ServerOption) *grpc.Server {
+	options = append(options,
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := s.authorizeGrpc(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.authorizeGrpc(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	)
+
+	server := grpc.NewServer(options...)
+	pb.RegisterShhgitServer(server, &grpcServer{session: s, sink: sink})
+
+	return server
+}
+
+func (s *Session) authorizeGrpc(ctx context.Context) error {
+	authorization := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		authorization = md.Get("authorization")[0]
+	}
+
+	if !s.IsAuthorized(authorization) {
+		return status.Error(codes.Unauthenticated, "a valid API token is required")
+	}
+
+	return nil
+}
+
+type grpcServer struct {
+	pb.UnimplementedShhgitServer
+
+	session *Session
+	sink    *GrpcSink
+}
+
+func (g *grpcServer) StreamFindings(request *pb.StreamFindingsRequest, stream pb.Shhgit_StreamFindingsServer) error {
+	filter := SinkFilter{Signatures: request.Signatures, MinimumSeverity: request.MinimumSeverity}
+	if filter.MinimumSeverity != "" && !IsSeverity(filter.MinimumSeverity) {
+		return status.Error(codes.InvalidArgument, "minimum_severity must be one of "+strings.Join(Severities, ", "))
+	}
+
+	subscriber := g.sink.subscribe(filter)
+	defer g.sink.unsubscribe(subscriber)
+
+	for {
+		select {
+		case event := <-subscriber.events:
+			if err := stream.Send(newGrpcFinding(event)); err != nil {
+				return err
+			}
+		case <-subscriber.dropped:
+			return status.Error(codes.ResourceExhausted, "stream ended as findings weren't read quickly enough")
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-g.session.Context.Done():
+			return status.Error(codes.Unavailable, "shutting down")
+		}
+	}
+}
+
+func (g *grpcServer) SubmitScanTarget(ctx context.Context, request *pb.ScanTarget) (*pb.SubmitScanTargetResponse, error) {
+	if request.Url == "" {
+		return nil, status.Error(codes.InvalidArgument, "url is required")
+	}
+
+	target := GitResource{Url: request.Url, Ref: request.Ref, Type: GitResourceType(request.Source)}
+
+	switch _, err := g.session.QueueScanTarget(target); err {
+	case nil:
+	case ErrScanNotPublic:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case ErrScanQueueFull:
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &pb.SubmitScanTargetResponse{Url: target.Url}, nil
+}
+
+func newGrpcFinding(event *MatchEvent) *pb.Finding {
+	finding := &pb.Finding{
+		Signature:  event.Signature,
+		Url:        event.Url,
+		File:       event.File,
+		Matches:    event.Matches,
+		Source:     pb.Source(event.Source),
+		Stars:      int32(event.Stars),
+		Verified:   event.Verified,
+		Commit:     event.Commit,
+		Entropy:    event.Entropy,
+		Severity:   event.Severity,
+		Confidence: event.Confidence,
+	}
+
+	for _, line := range event.Lines {
+		finding.Lines = append(finding.Lines, int32(line))
+	}
+
+	for _, column := range event.Columns {
+		finding.Columns = append(finding.Columns, int32(column))
+	}
+
+	for _, offset := range event.Offsets {
+		finding.Offsets = append(finding.Offsets, int64(offset))
+	}
+
+	return finding
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/eth0izzle/shhgit/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGrpcClient(t *testing.T, sink *GrpcSink) pb.ShhgitClient {
+	s := &Session{Config: &Config{ApiTokens: []string{"secret"}}, Context: context.Background()}
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := s.newGrpcServer(sink)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewShhgitClient(conn)
+}
+
+func TestGrpcRequiresApiToken(t *testing.T) {
+	client := newTestGrpcClient(t, NewGrpcSink(GrpcConfig{BufferSize: 1, SendTimeout: 1}))
+
+	for _, test := range []struct {
+		authorization string
+		code          codes.Code
+	}{
+		{"", codes.Unauthenticated},
+		{"Bearer wrong", codes.Unauthenticated},
+		{"Bearer secret", codes.InvalidArgument},
+	} {
+		ctx := context.Background()
+		if test.authorization != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", test.authorization)
+		}
+
+		_, err := client.SubmitScanTarget(ctx, &pb.ScanTarget{})
+		if code := status.Code(err); code != test.code {
+			t.Errorf("SubmitScanTarget with %q returned %s, want %s", test.authorization, code, test.code)
+		}
+	}
+}
+
+func TestGrpcStreamFindings(t *testing.T) {
+	sink := NewGrpcSink(GrpcConfig{BufferSize: 1, SendTimeout: 1})
+	client := newTestGrpcClient(t, sink)
+
+	ctx, cancel := context.WithTimeout(metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret"), 10*time.Second)
+	defer cancel()
+
+	invalid, err := client.StreamFindings(ctx, &pb.StreamFindingsRequest{MinimumSeverity: "severe"})
+	if err == nil {
+		_, err = invalid.Recv()
+	}
+
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("an unknown minimum_severity returned %v", err)
+	}
+
+	stream, err := client.StreamFindings(ctx, &pb.StreamFindingsRequest{MinimumSeverity: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		sink.Lock()
+		subscribed := len(sink.subscribers) > 0
+		sink.Unlock()
+
+		if subscribed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sink.Send(&MatchEvent{Url: "https://github.com/a/low", Severity: "low"})
+	sink.Send(&MatchEvent{
+		Signature: "AWS Access Key ID",
+		Url:       "https://github.com/a/b",
+		Lines:     []int{3, 300},
+		Offsets:   []int{1 << 40},
+		Source:    GitResourceType(pb.Source_GITHUB),
+		Stars:     2,
+		Entropy:   4.5,
+		Severity:  "high",
+	})
+
+	finding, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if finding.Url != "https://github.com/a/b" || finding.Source != pb.Source_GITHUB || len(finding.Lines) != 2 || finding.Lines[1] != 300 || finding.Offsets[0] != 1<<40 || finding.Entropy != 4.5 {
+		t.Errorf("finding was streamed as %v", finding)
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/utils/merkletrie"
+)
+
+// GetHistoryFiles walks up to depth commits back from HEAD (or the entire
+// history if depth is negative) and returns every blob added or modified in
+// those commits. Each blob is only returned once, for the newest commit that
+// introduced it, so secrets that were later removed are still found.
+// Commits for which scanned returns true are passed over, and the rest
+// walked are returned alongside the files
+func GetHistoryFiles(repository *git.Repository, dir string, depth int, scanned func(commit string) bool) ([]MatchFile, []string, error) {
+	fileList := make([]MatchFile, 0)
+	walkedCommits := make([]string, 0)
+	seen := make(map[plumbing.Hash]bool)
+
+	head, err := repository.Head()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commits, err := repository.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	walked := 0
+	err = commits.ForEach(func(commit *object.Commit) error {
+		if depth > 0 && walked >= depth {
+			return storer.ErrStop
+		}
+		walked++
+
+		if scanned != nil && scanned(commit.Hash.String()) {
+			return nil
+		}
+		walkedCommits = append(walkedCommits, commit.Hash.String())
+
+		tree, err := commit.Tree()
+		if err != nil {
+			return err
+		}
+
+		// the parent is missing for root commits and at the edge of a shallow
+		// clone, in which case every file in the commit is treated as added
+		var parentTree *object.Tree
+		if parent, err := commit.Parent(0); err == nil {
+			parentTree, _ = parent.Tree()
+		}
+
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			if action, err := change.Action(); err != nil || action == merkletrie.Delete {
+				continue
+			}
+
+			entry := change.To.TreeEntry
+			path := filepath.ToSlash(filepath.Join(dir, change.To.Name))
+
+			if seen[entry.Hash] || !entry.Mode.IsFile() || IsSkippableFile(path) {
+				continue
+			}
+			seen[entry.Hash] = true
+
+			blob, err := repository.BlobObject(entry.Hash)
+			if err != nil || blob.Size > GetMaximumFileSize(path) {
+				continue
+			}
+
+			reader, err := blob.Reader()
+			if err != nil {
+				continue
+			}
+
+			contents, err := ioutil.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				continue
+			}
+
+			fileList = append(fileList, MatchFile{
+				Path:      path,
+				Filename:  filepath.Base(path),
+				Extension: filepath.Ext(path),
+				Contents:  contents,
+				Commit:    commit.Hash.String(),
+			})
+		}
+
+		return nil
+	})
+
+	return fileList, walkedCommits, err
+}
+
+// GetTreeFiles returns the files in the HEAD commit of a repository cloned
+// without a worktree, as if it were checked out in to dir. Skippable paths
+// are left unread, and blobs missing from partial clones are skipped
+func GetTreeFiles(repository *git.Repository, dir string) ([]MatchFile, error) {
+	fileList := make([]MatchFile, 0)
+
+	head, err := repository.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := repository.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fileList, err
+		}
+
+		path := filepath.ToSlash(filepath.Join(dir, name))
+		if !entry.Mode.IsFile() || IsSkippableFile(path) {
+			continue
+		}
+
+		blob, err := repository.BlobObject(entry.Hash)
+		if err != nil || blob.Size > GetMaximumFileSize(path) {
+			continue
+		}
+
+		reader, err := blob.Reader()
+		if err != nil {
+			continue
+		}
+
+		contents, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			continue
+		}
+
+		fileList = append(fileList, MatchFile{
+			Path:      path,
+			Filename:  filepath.Base(path),
+			Extension: filepath.Ext(path),
+			Contents:  contents,
+		})
+	}
+
+	return fileList, nil
+}
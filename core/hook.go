@@ -0,0 +1,161 @@
+package core
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const zeroHash = "0000000000000000000000000000000000000000"
+
+func gitOutput(args ...string) ([]byte, error) {
+	return exec.Command("git", args...).Output()
+}
+
+// GetStagedFiles returns the files added, copied or modified in the index
+// of the git repository in the current directory, as they would be committed
+func GetStagedFiles(root string) ([]MatchFile, error) {
+	out, err := gitOutput("diff", "--cached", "--name-only", "-z", "--diff-filter=ACM")
+	if err != nil {
+		return nil, err
+	}
+
+	return getGitFiles(root, "", ":", out)
+}
+
+// GetPushedFiles returns the files added, copied or modified by each commit
+// pushed to a ref, as given to a pre-receive hook on stdin, so a secret
+// committed and removed again within the push is still seen. Commits
+// already reachable from another ref aren't scanned again. New refs have
+// the rest of their tree scanned too, and deleted ones nothing
+func GetPushedFiles(root string, oldRev string, newRev string) ([]MatchFile, error) {
+	if newRev == zeroHash {
+		return nil, nil
+	}
+
+	out, err := gitOutput("rev-list", "--reverse", newRev, "--not", "--all")
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		files []MatchFile
+		seen  = make(map[string]bool)
+	)
+
+	for _, commit := range strings.Fields(string(out)) {
+		changes, err := gitOutput("diff-tree", "-r", "--root", "--no-commit-id", "-z", "--diff-filter=ACM", commit)
+		if err != nil {
+			return nil, err
+		}
+
+		// :<old mode> <new mode> <old blob> <new blob> <status>\0<path>\0
+		fields := bytes.Split(changes, []byte{0})
+		for i := 0; i+1 < len(fields); i += 2 {
+			meta := strings.Fields(string(fields[i]))
+			if len(meta) < 4 {
+				continue
+			}
+
+			if files, err = appendBlob(files, seen, root, commit, meta[3], string(fields[i+1])); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if oldRev == zeroHash {
+		tree, err := gitOutput("ls-tree", "-r", "-z", newRev)
+		if err != nil {
+			return nil, err
+		}
+
+		// <mode> <type> <blob>\t<path>
+		for _, entry := range bytes.Split(tree, []byte{0}) {
+			parts := strings.SplitN(string(entry), "\t", 2)
+			if meta := strings.Fields(parts[0]); len(parts) == 2 && len(meta) == 3 && meta[1] == "blob" {
+				if files, err = appendBlob(files, seen, root, newRev, meta[2], parts[1]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// appendBlob reads a blob in to files, unless the same contents were
+// already read from an earlier commit
+func appendBlob(files []MatchFile, seen map[string]bool, root string, commit string, blob string, name string) ([]MatchFile, error) {
+	if seen[blob] {
+		return files, nil
+	}
+	seen[blob] = true
+
+	path := filepath.ToSlash(filepath.Join(root, name))
+	if IsSkippableFile(path) {
+		return files, nil
+	}
+
+	contents, err := gitOutput("cat-file", "blob", blob)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(contents)) > GetMaximumFileSize(path) {
+		return files, nil
+	}
+
+	return append(files, MatchFile{
+		Path:      path,
+		Filename:  filepath.Base(path),
+		Extension: filepath.Ext(path),
+		Contents:  contents,
+		Commit:    commit,
+	}), nil
+}
+
+func getGitFiles(root string, commit string, revPrefix string, paths []byte) ([]MatchFile, error) {
+	fileList := make([]MatchFile, 0)
+
+	for _, name := range bytes.Split(paths, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		path := filepath.ToSlash(filepath.Join(root, string(name)))
+		if IsSkippableFile(path) {
+			continue
+		}
+
+		contents, err := gitOutput("show", revPrefix+string(name))
+		if err != nil {
+			return nil, err
+		}
+
+		if int64(len(contents)) > GetMaximumFileSize(path) {
+			continue
+		}
+
+		fileList = append(fileList, MatchFile{
+			Path:      path,
+			Filename:  filepath.Base(path),
+			Extension: filepath.Ext(path),
+			Contents:  contents,
+			Commit:    commit,
+		})
+	}
+
+	return fileList, nil
+}
+
+// GetRepositoryRoot returns the top level of the working tree, or the
+// current directory for bare repositories (i.e. on the server)
+func GetRepositoryRoot() string {
+	if out, err := gitOutput("rev-parse", "--show-toplevel"); err == nil {
+		return strings.TrimSpace(string(out))
+	}
+
+	dir, _ := filepath.Abs(".")
+	return dir
+}
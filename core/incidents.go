@@ -0,0 +1,205 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	pagerDutyEventsUrl = "https://events.pagerduty.com/v2/enqueue"
+	opsgenieApiUrl     = "https://api.opsgenie.com"
+
+	// Opsgenie truncates alert messages longer than this
+	opsgenieMaxMessageLength = 130
+)
+
+var pagerDutySeverities = map[string]string{
+	SeverityCritical: "critical",
+	SeverityHigh:     "error",
+	SeverityMedium:   "warning",
+	SeverityLow:      "info",
+}
+
+var opsgeniePriorities = map[string]string{
+	SeverityCritical: "P1",
+	SeverityHigh:     "P2",
+	SeverityMedium:   "P3",
+	SeverityLow:      "P4",
+}
+
+// IncidentFilter is a SinkFilter for sinks that page someone, which only
+// accept critical findings unless minimum_severity says otherwise
+type IncidentFilter struct {
+	SinkFilter   `yaml:",inline"`
+	VerifiedOnly bool `yaml:"verified_only"`
+}
+
+func (f IncidentFilter) Accepts(event *MatchEvent) bool {
+	if f.VerifiedOnly && !event.Verified {
+		return false
+	}
+
+	return f.SinkFilter.Accepts(event)
+}
+
+// incidentDedupKey identifies the incident for a finding by its
+// fingerprints, so the same secret found again updates the open incident
+// rather than paging again
+func incidentDedupKey(event *MatchEvent) string {
+	if len(event.Fingerprints) == 1 {
+		return event.Fingerprints[0]
+	}
+
+	return dedupKey(event.Fingerprints...)
+}
+
+func incidentSummary(event *MatchEvent) string {
+	return fmt.Sprintf("%s found in %s (%s)", event.Signature, event.Url, event.File)
+}
+
+func incidentDetails(event *MatchEvent) map[string]interface{} {
+	return map[string]interface{}{
+		"repository": event.Url,
+		"file":       event.File,
+		"signature":  event.Signature,
+		"severity":   event.Severity,
+		"confidence": event.Confidence,
+		"verified":   event.Verified,
+		"source":     SourceNames[event.Source],
+		"branch":     event.Branch,
+		"commit":     event.Commit,
+		"match":      notificationSnippet(event),
+	}
+}
+
+// PagerDutySink triggers an event through the PagerDuty Events API v2 per
+// finding
+type PagerDutySink struct {
+	config PagerDutyConfig
+	client *http.Client
+}
+
+func NewPagerDutySink(config PagerDutyConfig) *PagerDutySink {
+	if config.Url == "" {
+		config.Url = pagerDutyEventsUrl
+	}
+
+	if config.MinimumSeverity == "" {
+		config.MinimumSeverity = SeverityCritical
+	}
+
+	return &PagerDutySink{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *PagerDutySink) Name() string {
+	return "PagerDuty"
+}
+
+func (p *PagerDutySink) Send(event *MatchEvent) error {
+	if !p.config.Accepts(event) {
+		return nil
+	}
+
+	payload := map[string]interface{}{
+		"routing_key":  p.config.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    incidentDedupKey(event),
+		"client":       Name,
+		"client_url":   event.Url,
+		"payload": map[string]interface{}{
+			"summary":        incidentSummary(event),
+			"source":         event.Url,
+			"severity":       pagerDutySeverities[event.Severity],
+			"component":      event.File,
+			"class":          event.Signature,
+			"custom_details": incidentDetails(event),
+		},
+	}
+
+	return postNotification(p.client, p.config.Url, "", payload, nil)
+}
+
+// OpsgenieSink creates an Opsgenie alert per finding. Alerts are aliased
+// on the finding, so detecting it again bumps the open alert's count
+type OpsgenieSink struct {
+	config OpsgenieConfig
+	client *http.Client
+}
+
+func NewOpsgenieSink(config OpsgenieConfig) *OpsgenieSink {
+	if config.Url == "" {
+		config.Url = opsgenieApiUrl
+	}
+
+	if config.MinimumSeverity == "" {
+		config.MinimumSeverity = SeverityCritical
+	}
+
+	return &OpsgenieSink{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (o *OpsgenieSink) Name() string {
+	return "Opsgenie"
+}
+
+func (o *OpsgenieSink) Send(event *MatchEvent) error {
+	if !o.config.Accepts(event) {
+		return nil
+	}
+
+	message := incidentSummary(event)
+	if len(message) > opsgenieMaxMessageLength {
+		message = message[:opsgenieMaxMessageLength-3] + "..."
+	}
+
+	payload := map[string]interface{}{
+		"message":     message,
+		"alias":       incidentDedupKey(event),
+		"description": fmt.Sprintf("%s found in %s\nFile: %s\nSeverity: %s (%s confidence)", event.Signature, event.Url, event.File, event.Severity, event.Confidence),
+		"priority":    opsgeniePriorities[event.Severity],
+		"source":      Name,
+		"entity":      event.Url,
+		"tags":        append([]string{Name, event.Severity}, o.config.Tags...),
+		"details":     opsgenieDetails(event),
+	}
+
+	if len(o.config.Responders) > 0 {
+		responders := make([]map[string]string, len(o.config.Responders))
+		for i, team := range o.config.Responders {
+			responders[i] = map[string]string{"type": "team", "name": team}
+		}
+		payload["responders"] = responders
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return PostWithRetry(o.client, defaultWebhookRetries, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", strings.TrimSuffix(o.config.Url, "/")+"/v2/alerts", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		req.Header.Set("Authorization", "GenieKey "+o.config.ApiKey)
+
+		return req, nil
+	})
+}
+
+// opsgenieDetails are the incident details as strings, the only values
+// Opsgenie accepts
+func opsgenieDetails(event *MatchEvent) map[string]string {
+	details := make(map[string]string)
+	for key, value := range incidentDetails(event) {
+		details[key] = fmt.Sprint(value)
+	}
+
+	return details
+}
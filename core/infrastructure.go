@@ -0,0 +1,437 @@
+package core
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	TerraformStateSecretName     = "Terraform state secret"
+	TerraformVariablesSecretName = "Terraform variables secret"
+	CloudFormationSecretName     = "CloudFormation secret"
+)
+
+var (
+	// attributes, properties, variables and outputs named like these hold
+	// secrets...
+	secretAttributeRegex = regexp.MustCompile(`(?i)(password|passwd|secret|private_?key|token|access_?key|api_?key|connection_?string|credential)`)
+	// ...unless they're only details of or references to one
+	secretDetailRegex = regexp.MustCompile(`(?i)(encrypted|hash|fingerprint|_?arn|_?ids?|_?names?|_?policy|_?length|_?version|_?type|_?enabled|_?required|_?rotation\w*)$`)
+
+	awsAccessKeyIdRegex = regexp.MustCompile(`^(AKIA|ASIA)[A-Z0-9]{16}$`)
+	privateKeyRegex     = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+)
+
+// InfrastructureConfig configures the Terraform, CloudFormation and
+// Kubernetes detectors, which parse state files, variables, templates and
+// manifests and report the resources secrets are embedded in
+type InfrastructureConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Severity   string `yaml:"severity,omitempty"`
+	Confidence string `yaml:"confidence,omitempty"`
+}
+
+// InfrastructureSignature finds the access keys, passwords and private keys
+// in one kind of infrastructure as code file, keyed by the address of the
+// resource or variable they belong to
+type InfrastructureSignature struct {
+	name       string
+	severity   string
+	confidence string
+	// returns the secrets in a file of this kind, or false for other files
+	parse func(file MatchFile) ([]ConfigValue, bool)
+}
+
+func NewInfrastructureSignatures(s *Session, config InfrastructureConfig) []Signature {
+	parsers := []struct {
+		name  string
+		parse func(file MatchFile) ([]ConfigValue, bool)
+	}{
+		{TerraformStateSecretName, parseTerraformState},
+		{TerraformVariablesSecretName, parseTerraformVariables},
+		{CloudFormationSecretName, parseCloudFormation},
+		{KubernetesSecretName, parseKubernetesSecrets},
+		{HelmValuesSecretName, parseHelmValues},
+		{KubeconfigSecretName, parseKubeconfig},
+	}
+
+	severity, confidence := config.Severity, config.Confidence
+	if severity == "" {
+		severity = SeverityCritical
+	}
+
+	signatures := make([]Signature, 0, len(parsers))
+	for _, parser := range parsers {
+		signature := InfrastructureSignature{name: parser.name, parse: parser.parse}
+		signature.severity, signature.confidence = signatureRating(s, ConfigSignature{
+			Name:       parser.name,
+			Part:       PartContents,
+			Severity:   severity,
+			Confidence: confidence,
+		})
+
+		signatures = append(signatures, signature)
+	}
+
+	return signatures
+}
+
+func (s InfrastructureSignature) Match(file MatchFile) (bool, string) {
+	return len(s.GetFileMatches(file)) > 0, PartContents
+}
+
+// GetFileMatches returns the secrets in a whole file of the signature's
+// kind, with the address of the resource each belongs to as its key
+func (s InfrastructureSignature) GetFileMatches(file MatchFile) []ContentsMatch {
+	if file.Offset > 0 || file.Limit > 0 {
+		return nil
+	}
+
+	values, ok := s.parse(file)
+	if !ok {
+		return nil
+	}
+
+	matches := make([]ContentsMatch, 0, len(values))
+	for _, value := range values {
+		if !IsBlacklistedMatch(value.Value) {
+			matches = append(matches, ContentsMatch{Value: value.Value, Offset: value.Offset, Key: value.Key})
+		}
+	}
+
+	return matches
+}
+
+// GetContentsMatches finds nothing, as what a file holds depends on its kind
+func (s InfrastructureSignature) GetContentsMatches(contents []byte) []ContentsMatch {
+	return nil
+}
+
+func (s InfrastructureSignature) Name() string {
+	return s.name
+}
+
+func (s InfrastructureSignature) Verifier() string {
+	return ""
+}
+
+func (s InfrastructureSignature) Severity() string {
+	return s.severity
+}
+
+func (s InfrastructureSignature) Confidence() string {
+	return s.confidence
+}
+
+// isSecretValue reports whether value, found under key, is a secret: an
+// access key ID or private key wherever it is, or anything but a
+// placeholder under a name like password
+func isSecretValue(key string, value string, named bool) bool {
+	if awsAccessKeyIdRegex.MatchString(value) || privateKeyRegex.MatchString(value) {
+		return true
+	}
+
+	if !named {
+		name := ConfigKeyName(key)
+		named = secretAttributeRegex.MatchString(name) && !secretDetailRegex.MatchString(name)
+	}
+
+	return named && len(value) >= 4 && !strings.HasPrefix(value, "${") && !strings.Contains(value, "{{resolve:")
+}
+
+// parseTerraformState returns the secrets in the attributes of the resources
+// in a terraform.tfstate file, i.e. aws_db_instance.main.password, along
+// with the sensitive outputs. Attributes Terraform marks as sensitive are
+// secrets whatever their name
+func parseTerraformState(file MatchFile) ([]ConfigValue, bool) {
+	filename := strings.ToLower(file.Filename)
+	if !strings.HasSuffix(filename, ".tfstate") && !strings.HasSuffix(filename, ".tfstate.backup") {
+		return nil, false
+	}
+
+	documents, ok := parseYamlDocuments(file.Contents)
+	if !ok || len(documents) == 0 {
+		return nil, false
+	}
+
+	lines := lineStarts(file.Contents)
+	values := make([]ConfigValue, 0)
+	add := func(key string, node *yaml.Node, named bool) {
+		if isSecretValue(key, node.Value, named) {
+			values = append(values, ConfigValue{Key: key, Value: node.Value, Offset: yamlOffset(file.Contents, lines, node)})
+		}
+	}
+
+	state := documents[0]
+	if resources := yamlMapValue(state, "resources"); resources != nil && resources.Kind == yaml.SequenceNode {
+		for _, resource := range resources.Content {
+			address := terraformAddress(resource)
+
+			instances := yamlMapValue(resource, "instances")
+			if instances == nil || instances.Kind != yaml.SequenceNode {
+				continue
+			}
+
+			for _, instance := range instances.Content {
+				instanceAddress := address
+				if indexKey := yamlMapValue(instance, "index_key"); indexKey != nil {
+					instanceAddress += "[" + terraformIndex(indexKey) + "]"
+				}
+
+				sensitive := terraformSensitiveAttributes(yamlMapValue(instance, "sensitive_attributes"))
+				if attributes := yamlMapValue(instance, "attributes"); attributes != nil {
+					walkYamlStrings(attributes, "", func(key string, node *yaml.Node) {
+						add(joinConfigKey(instanceAddress, key), node, sensitive[key])
+					})
+				}
+			}
+		}
+	}
+
+	if outputs := yamlMapValue(state, "outputs"); outputs != nil && outputs.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(outputs.Content); i += 2 {
+			output := outputs.Content[i+1]
+			sensitive := yamlMapValue(output, "sensitive")
+
+			if value := yamlMapValue(output, "value"); value != nil {
+				walkYamlStrings(value, "output."+outputs.Content[i].Value, func(key string, node *yaml.Node) {
+					add(key, node, sensitive != nil && sensitive.Value == "true")
+				})
+			}
+		}
+	}
+
+	return values, true
+}
+
+// terraformAddress returns the address of a resource in a state file, i.e.
+// module.db.aws_db_instance.main
+func terraformAddress(resource *yaml.Node) string {
+	field := func(key string) string {
+		if value := yamlMapValue(resource, key); value != nil {
+			return value.Value
+		}
+		return ""
+	}
+
+	address := field("type") + "." + field("name")
+	if field("mode") == "data" {
+		address = "data." + address
+	}
+
+	return joinConfigKey(field("module"), address)
+}
+
+// terraformSensitiveAttributes returns the dotted paths of the attributes
+// Terraform marks as sensitive, from the get_attr and index steps to them
+func terraformSensitiveAttributes(node *yaml.Node) map[string]bool {
+	sensitive := make(map[string]bool)
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return sensitive
+	}
+
+	for _, steps := range node.Content {
+		if steps.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		key := ""
+		for _, step := range steps.Content {
+			value := yamlMapValue(step, "value")
+			if value == nil {
+				continue
+			}
+
+			// indexes are {"value": 0, "type": "number"}
+			if index := yamlMapValue(value, "value"); index != nil {
+				value = index
+			}
+			key = joinConfigKey(key, value.Value)
+		}
+
+		sensitive[key] = true
+	}
+
+	return sensitive
+}
+
+// parseTerraformVariables returns the secrets assigned to variables in a
+// .tfvars file, i.e. var.db_password. .tfvars.json files are JSON
+func parseTerraformVariables(file MatchFile) ([]ConfigValue, bool) {
+	filename := strings.ToLower(file.Filename)
+
+	var values []ConfigValue
+	if strings.HasSuffix(filename, ".tfvars.json") {
+		parsed, ok := parseYamlValues(file.Contents)
+		if !ok {
+			return nil, false
+		}
+		values = parsed
+	} else if path.Ext(filename) == ".tfvars" {
+		values = parseHclAssignments(file.Contents)
+	} else {
+		return nil, false
+	}
+
+	secrets := make([]ConfigValue, 0)
+	for _, value := range values {
+		if isSecretValue(value.Key, value.Value, false) {
+			value.Key = "var." + value.Key
+			secrets = append(secrets, value)
+		}
+	}
+
+	return secrets, true
+}
+
+// parseHclAssignments reads the string values assigned in HCL, keyed by the
+// objects they're in, i.e. credentials.password. Heredocs are read whole
+func parseHclAssignments(contents []byte) []ConfigValue {
+	values := make([]ConfigValue, 0)
+	keys := make([]string, 0)
+
+	heredoc, heredocKey, heredocStart := "", "", 0
+	for start := 0; start < len(contents); {
+		end := bytes.IndexByte(contents[start:], '\n')
+		if end < 0 {
+			end = len(contents)
+		} else {
+			end += start
+		}
+
+		lineStart := start
+		line := strings.TrimSpace(string(contents[start:end]))
+		start = end + 1
+
+		if heredoc != "" {
+			if line == heredoc {
+				values = append(values, ConfigValue{Key: heredocKey, Value: string(contents[heredocStart:lineStart]), Offset: heredocStart})
+				heredoc = ""
+			}
+			continue
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "}") || strings.HasPrefix(line, "]") {
+			if len(keys) > 0 {
+				keys = keys[:len(keys)-1]
+			}
+			continue
+		}
+
+		separator := strings.IndexAny(line, "=:")
+		if separator < 0 {
+			continue
+		}
+
+		key := joinConfigKey(strings.Join(keys, "."), strings.Trim(strings.TrimSpace(line[:separator]), `"`))
+		value := strings.TrimSpace(line[separator+1:])
+
+		switch {
+		case value == "{" || value == "[":
+			keys = append(keys, strings.Trim(strings.TrimSpace(line[:separator]), `"`))
+		case strings.HasPrefix(value, "<<"):
+			heredoc, heredocKey, heredocStart = strings.TrimLeft(value, "<-"), key, start
+		case len(value) >= 2 && value[0] == '"':
+			if closing := strings.IndexByte(value[1:], '"'); closing > 0 {
+				value = value[1 : closing+1]
+				offset := lineStart + bytes.Index(contents[lineStart:end], []byte(value))
+				values = append(values, ConfigValue{Key: key, Value: value, Offset: offset})
+			}
+		}
+	}
+
+	return values
+}
+
+// parseCloudFormation returns the secrets written in to the resource
+// properties, parameter defaults and outputs of a CloudFormation template,
+// i.e. Resources.Database.Properties.MasterUserPassword, and in the outputs
+// file of a CDK deployment. References, intrinsic functions and dynamic
+// references to secrets are left out
+func parseCloudFormation(file MatchFile) ([]ConfigValue, bool) {
+	filename := strings.ToLower(file.Filename)
+	isOutputs := filename == "cdk-outputs.json"
+
+	switch path.Ext(filename) {
+	case ".yaml", ".yml", ".json", ".template":
+	default:
+		return nil, false
+	}
+
+	if !isOutputs && !bytes.Contains(file.Contents, []byte("AWSTemplateFormatVersion")) && !(bytes.Contains(file.Contents, []byte("Resources")) && bytes.Contains(file.Contents, []byte("AWS::"))) {
+		return nil, false
+	}
+
+	documents, ok := parseYamlDocuments(file.Contents)
+	if !ok || len(documents) == 0 {
+		return nil, false
+	}
+
+	lines := lineStarts(file.Contents)
+	values := make([]ConfigValue, 0)
+	add := func(key string, node *yaml.Node, named bool) {
+		if isSecretValue(key, node.Value, named) {
+			values = append(values, ConfigValue{Key: key, Value: node.Value, Offset: yamlOffset(file.Contents, lines, node)})
+		}
+	}
+
+	template := documents[0]
+	if isOutputs {
+		walkYamlStrings(template, "", func(key string, node *yaml.Node) { add(key, node, false) })
+		return values, true
+	}
+
+	if resources := yamlMapValue(template, "Resources"); resources != nil && resources.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(resources.Content); i += 2 {
+			if properties := yamlMapValue(resources.Content[i+1], "Properties"); properties != nil {
+				walkYamlStrings(properties, "Resources."+resources.Content[i].Value+".Properties", func(key string, node *yaml.Node) {
+					add(key, node, false)
+				})
+			}
+		}
+	}
+
+	// parameters are secrets when they're hidden from the console
+	if parameters := yamlMapValue(template, "Parameters"); parameters != nil && parameters.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(parameters.Content); i += 2 {
+			parameter := parameters.Content[i+1]
+			noEcho := yamlMapValue(parameter, "NoEcho")
+			name := parameters.Content[i].Value
+
+			if value := yamlMapValue(parameter, "Default"); value != nil && value.Kind == yaml.ScalarNode && value.Tag == "!!str" {
+				named := noEcho != nil && strings.EqualFold(noEcho.Value, "true")
+				add("Parameters."+name+".Default", value, named || isSecretValue(name, value.Value, false))
+			}
+		}
+	}
+
+	if outputs := yamlMapValue(template, "Outputs"); outputs != nil && outputs.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(outputs.Content); i += 2 {
+			name := outputs.Content[i].Value
+			if value := yamlMapValue(outputs.Content[i+1], "Value"); value != nil && value.Kind == yaml.ScalarNode && value.Tag == "!!str" {
+				add("Outputs."+name+".Value", value, isSecretValue(name, value.Value, false))
+			}
+		}
+	}
+
+	return values, true
+}
+
+// terraformIndex formats the index of a resource created with count or
+// for_each
+func terraformIndex(index *yaml.Node) string {
+	if index.Tag == "!!str" {
+		return strconv.Quote(index.Value)
+	}
+
+	return index.Value
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+const FormatJsonl = "jsonl"
+
+// JsonlFinding is one line of --format jsonl. Its fields are a stable
+// schema for downstream parsing, so only ever add to them
+type JsonlFinding struct {
+	Timestamp    string              `json:"timestamp"`
+	Source       string              `json:"source"`
+	Repository   string              `json:"repository"`
+	Parent       string              `json:"parent,omitempty"`
+	Metadata     *RepositoryMetadata `json:"repository_metadata,omitempty"`
+	Path         string              `json:"path"`
+	Commit       string              `json:"commit,omitempty"`
+	Line         int                 `json:"line"`
+	Column       int                 `json:"column"`
+	Offset       int                 `json:"offset"`
+	Signature    string              `json:"signature"`
+	Severity     string              `json:"severity"`
+	Confidence   string              `json:"confidence"`
+	Entropy      float64             `json:"entropy"`
+	Verified     bool                `json:"verified"`
+	LikelyCanary bool                `json:"likely_canary,omitempty"`
+	CanaryReason string              `json:"canary_reason,omitempty"`
+	Match        string              `json:"match"`
+	Key          string              `json:"key,omitempty"`
+	Fingerprint  string              `json:"fingerprint,omitempty"`
+	PrivateKey   *PrivateKey         `json:"private_key,omitempty"`
+	Jwt          *JsonWebToken       `json:"jwt,omitempty"`
+	Attribution  *Attribution        `json:"attribution,omitempty"`
+	WatchTerms   []string            `json:"watch_terms,omitempty"`
+	Tenants      []string            `json:"tenants,omitempty"`
+}
+
+// JsonlWriter appends a JSON object per match, or per file for findings
+// matched on their path, to a file
+type JsonlWriter struct {
+	sync.Mutex
+
+	file *os.File
+}
+
+func NewJsonlWriter(path string) (*JsonlWriter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JsonlWriter{file: file}, nil
+}
+
+func (w *JsonlWriter) Write(event *MatchEvent) error {
+	finding := JsonlFinding{
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		Source:       SourceNames[event.Source],
+		Repository:   event.Url,
+		Parent:       event.Parent,
+		Metadata:     event.Metadata,
+		Path:         event.File,
+		Commit:       event.Commit,
+		Signature:    event.Signature,
+		Severity:     event.Severity,
+		Confidence:   event.Confidence,
+		Entropy:      event.Entropy,
+		Verified:     event.Verified,
+		LikelyCanary: event.LikelyCanary,
+		CanaryReason: event.CanaryReason,
+		WatchTerms:   event.WatchTerms,
+		Tenants:      event.Tenants,
+	}
+
+	// matches are only redacted already with --redact-secrets, but this
+	// output always is
+	redact := !*session.Options.RedactSecrets
+
+	var lines []byte
+	for i := 0; i == 0 || i < len(event.Matches); i++ {
+		finding.Line, finding.Column, finding.Offset, finding.Match, finding.Key, finding.Fingerprint, finding.PrivateKey, finding.Jwt, finding.Attribution = 0, 0, 0, "", "", "", nil, nil, nil
+
+		if i < len(event.Matches) {
+			finding.Match = event.Matches[i]
+			if redact {
+				finding.Match = Redact(finding.Match)
+			}
+		}
+
+		if i < len(event.Lines) {
+			finding.Line = event.Lines[i]
+		}
+
+		if i < len(event.Columns) {
+			finding.Column = event.Columns[i]
+		}
+
+		if i < len(event.Offsets) {
+			finding.Offset = event.Offsets[i]
+		}
+
+		if i < len(event.Keys) {
+			finding.Key = event.Keys[i]
+		}
+
+		if i < len(event.Fingerprints) {
+			finding.Fingerprint = event.Fingerprints[i]
+		}
+
+		if i < len(event.PrivateKeys) {
+			finding.PrivateKey = event.PrivateKeys[i]
+		}
+
+		if i < len(event.Tokens) {
+			finding.Jwt = event.Tokens[i]
+		}
+
+		if i < len(event.Attributions) {
+			finding.Attribution = event.Attributions[i]
+		}
+
+		line, err := json.Marshal(finding)
+		if err != nil {
+			return err
+		}
+
+		lines = append(append(lines, line...), '\n')
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	_, err := w.file.Write(lines)
+	return err
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const FormatJunit = "junit"
+
+type JunitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []JunitTestSuite `xml:"testsuite"`
+}
+
+type JunitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []JunitTestCase `xml:"testcase"`
+}
+
+type JunitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Failure   *JunitFailure `xml:"failure"`
+}
+
+type JunitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",cdata"`
+}
+
+// JunitWriter reports each match as a failed test case, named after its
+// signature, in a test suite per repository so CI systems show findings
+// like failing tests. The whole report is rewritten on every write, and a
+// clean scan is a single passing test case
+type JunitWriter struct {
+	sync.Mutex
+
+	path      string
+	timestamp string
+	suites    []JunitTestSuite
+	index     map[string]int
+}
+
+func NewJunitWriter(path string) (*JunitWriter, error) {
+	w := &JunitWriter{path: path, timestamp: time.Now().UTC().Format("2006-01-02T15:04:05"), index: make(map[string]int)}
+
+	// so a scan without findings still leaves a report behind
+	return w, w.flush()
+}
+
+func (w *JunitWriter) Write(event *MatchEvent) error {
+	w.Lock()
+	defer w.Unlock()
+
+	i, ok := w.index[event.Url]
+	if !ok {
+		i = len(w.suites)
+		w.index[event.Url] = i
+		w.suites = append(w.suites, JunitTestSuite{Name: event.Url, Timestamp: w.timestamp})
+	}
+
+	suite := &w.suites[i]
+	path := strings.TrimPrefix(event.File, "/")
+
+	// matches are only redacted already with --redact-secrets, but CI
+	// reports are seen by anyone with access to the build
+	redact := !*session.Options.RedactSecrets
+
+	for m := 0; m == 0 || m < len(event.Matches); m++ {
+		testCase := JunitTestCase{ClassName: path, Name: event.Signature, File: path}
+		location := path
+
+		if m < len(event.Lines) && event.Lines[m] > 0 {
+			testCase.Line = event.Lines[m]
+			location = fmt.Sprintf("%s:%d", path, testCase.Line)
+		}
+
+		details := []string{
+			"Signature: " + event.Signature,
+			"Severity: " + event.Severity,
+			"Confidence: " + event.Confidence,
+			"Repository: " + event.Url,
+			"File: " + location,
+		}
+
+		if event.Commit != "" {
+			details = append(details, "Commit: "+event.Commit)
+		}
+
+		if m < len(event.Matches) {
+			match := event.Matches[m]
+			if redact {
+				match = Redact(match)
+			}
+			details = append(details, "Match: "+match)
+		}
+
+		if event.Verified {
+			details = append(details, "Verified: the secret is live")
+		}
+
+		testCase.Failure = &JunitFailure{Message: location, Type: event.Severity, Text: strings.Join(details, "\n")}
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+		suite.Failures++
+	}
+
+	return w.flush()
+}
+
+func (w *JunitWriter) flush() error {
+	report := JunitTestSuites{Name: Name, Suites: w.suites}
+
+	for _, suite := range w.suites {
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+	}
+
+	if len(report.Suites) == 0 {
+		report.Tests = 1
+		report.Suites = []JunitTestSuite{{Name: Name, Tests: 1, Timestamp: w.timestamp, Cases: []JunitTestCase{{ClassName: Name, Name: "No secrets found"}}}}
+	}
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(w.path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
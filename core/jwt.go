@@ -0,0 +1,191 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	JwtExpiredDowngrade = "downgrade"
+	JwtExpiredDrop      = "drop"
+)
+
+var jwtRegex = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+// JwtConfig decides what happens to JSON Web Tokens that expired long ago,
+// as they can no longer be used
+type JwtConfig struct {
+	// days since a token expired before it's downgraded or dropped. 0 to
+	// report expired tokens as usual
+	ExpiredDays int `yaml:"expired_days"`
+	// downgrade to low severity and confidence, or drop
+	Expired string `yaml:"expired"`
+}
+
+// JsonWebToken is what a matched JWT says about itself. Its signature isn't
+// verified, so the claims are only as trustworthy as whoever committed it
+type JsonWebToken struct {
+	Algorithm string     `json:"algorithm,omitempty"`
+	Issuer    string     `json:"issuer,omitempty"`
+	Subject   string     `json:"subject,omitempty"`
+	Audience  []string   `json:"audience,omitempty"`
+	IssuedAt  *time.Time `json:"issued_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ParseJsonWebTokens returns the decoded JWTs among matches, in the same
+// order, or nil if none of them are
+func ParseJsonWebTokens(matches []ContentsMatch) []*JsonWebToken {
+	var tokens []*JsonWebToken
+
+	for i, match := range matches {
+		if token := ParseJsonWebToken(match.Value); token != nil {
+			if tokens == nil {
+				tokens = make([]*JsonWebToken, len(matches))
+			}
+			tokens[i] = token
+		}
+	}
+
+	return tokens
+}
+
+// ParseJsonWebToken decodes the header and claims of the first JWT in value,
+// i.e. a bearer token or an assignment of one, returning nil if there isn't
+// one
+func ParseJsonWebToken(value string) *JsonWebToken {
+	parts := strings.Split(jwtRegex.FindString(value), ".")
+	if len(parts) != 3 {
+		return nil
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	var claims struct {
+		Issuer    string          `json:"iss"`
+		Subject   string          `json:"sub"`
+		Audience  json.RawMessage `json:"aud"`
+		IssuedAt  *float64        `json:"iat"`
+		ExpiresAt *float64        `json:"exp"`
+	}
+
+	if !decodeJwtPart(parts[0], &header) || !decodeJwtPart(parts[1], &claims) {
+		return nil
+	}
+
+	token := &JsonWebToken{
+		Algorithm: header.Algorithm,
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+		IssuedAt:  jwtTime(claims.IssuedAt),
+		ExpiresAt: jwtTime(claims.ExpiresAt),
+	}
+
+	// the audience is a string or a list of them
+	var audience string
+	if json.Unmarshal(claims.Audience, &audience) == nil && audience != "" {
+		token.Audience = []string{audience}
+	} else {
+		json.Unmarshal(claims.Audience, &token.Audience)
+	}
+
+	return token
+}
+
+func decodeJwtPart(part string, v interface{}) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(decoded, v) == nil
+}
+
+func jwtTime(seconds *float64) *time.Time {
+	if seconds == nil {
+		return nil
+	}
+
+	t := time.Unix(int64(*seconds), 0).UTC()
+	return &t
+}
+
+// ExpiredFor reports whether the token expired more than d ago
+func (token *JsonWebToken) ExpiredFor(d time.Duration) bool {
+	return token != nil && token.ExpiresAt != nil && time.Since(*token.ExpiresAt) > d
+}
+
+// String describes the token, i.e. HS256, issued by https://auth.example.com,
+// for api and web, expired 2020-01-01T00:00:00Z
+func (token *JsonWebToken) String() string {
+	details := make([]string, 0, 4)
+
+	if token.Algorithm != "" {
+		details = append(details, token.Algorithm)
+	}
+
+	if token.Issuer != "" {
+		details = append(details, "issued by "+token.Issuer)
+	}
+
+	if len(token.Audience) > 0 {
+		details = append(details, "for "+strings.Join(token.Audience, " and "))
+	}
+
+	if token.ExpiresAt == nil {
+		details = append(details, "never expires")
+	} else if token.ExpiredFor(0) {
+		details = append(details, fmt.Sprintf("expired %s", token.ExpiresAt.Format(time.RFC3339)))
+	} else {
+		details = append(details, fmt.Sprintf("expires %s", token.ExpiresAt.Format(time.RFC3339)))
+	}
+
+	return strings.Join(details, ", ")
+}
+
+// jwtExpiredAge is how long ago tokens must have expired to be downgraded or
+// dropped, or 0 if they never are
+func (s *Session) jwtExpiredAge() time.Duration {
+	return time.Duration(s.Config.Jwt.ExpiredDays) * 24 * time.Hour
+}
+
+// FilterExpiredTokens leaves out JWTs that expired more than jwt.expired_days
+// ago when they're to be dropped, or when the low severity they'd be
+// downgraded to isn't reported
+func (s *Session) FilterExpiredTokens(matches []ContentsMatch) []ContentsMatch {
+	age := s.jwtExpiredAge()
+	if age <= 0 || (s.Config.Jwt.Expired != JwtExpiredDrop && s.IsReportable(SeverityLow)) {
+		return matches
+	}
+
+	kept := make([]ContentsMatch, 0, len(matches))
+	for _, match := range matches {
+		if !ParseJsonWebToken(match.Value).ExpiredFor(age) {
+			kept = append(kept, match)
+		}
+	}
+
+	return kept
+}
+
+// RateExpiredTokens returns the severity and confidence of a finding, low
+// when every match is a JWT that expired more than jwt.expired_days ago
+func (s *Session) RateExpiredTokens(tokens []*JsonWebToken, count int, severity string, confidence string) (string, string) {
+	age := s.jwtExpiredAge()
+	if age <= 0 || len(tokens) < count {
+		return severity, confidence
+	}
+
+	for _, token := range tokens {
+		if !token.ExpiredFor(age) {
+			return severity, confidence
+		}
+	}
+
+	return SeverityLow, ConfidenceLow
+}
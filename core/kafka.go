@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const (
+	kafkaTimeout     = 10 * time.Second
+	kafkaMaxAttempts = 2
+)
+
+// KafkaSink publishes findings to a topic with segmentio/kafka-go
+type KafkaSink struct {
+	config KafkaConfig
+	writer *kafka.Writer
+}
+
+func NewKafkaSink(config KafkaConfig) *KafkaSink {
+	return &KafkaSink{config: config, writer: kafka.NewWriter(kafkaWriterConfig(config))}
+}
+
+// kafkaWriterConfig writes each finding as soon as it's sent, waiting for
+// the partition leader to acknowledge it. Failures are retried once, as
+// the writer refreshes its metadata in case the leader moved
+func kafkaWriterConfig(config KafkaConfig) kafka.WriterConfig {
+	dialer := &kafka.Dialer{Timeout: kafkaTimeout, ClientID: Name}
+	if config.TLS {
+		dialer.TLS = &tls.Config{InsecureSkipVerify: config.TLSSkipVerify}
+	}
+
+	if config.Username != "" {
+		dialer.SASLMechanism = plain.Mechanism{Username: config.Username, Password: config.Password}
+	}
+
+	return kafka.WriterConfig{
+		Brokers:      config.Brokers,
+		Topic:        config.Topic,
+		Dialer:       dialer,
+		Balancer:     &kafka.Hash{},
+		MaxAttempts:  kafkaMaxAttempts,
+		BatchSize:    1,
+		ReadTimeout:  kafkaTimeout,
+		WriteTimeout: kafkaTimeout,
+		RequiredAcks: 1,
+	}
+}
+
+func (k *KafkaSink) Name() string {
+	return "Kafka " + k.config.Topic
+}
+
+// Send produces the finding as JSON, keyed on the repository URL so the
+// findings for a repository stay in order on one partition
+func (k *KafkaSink) Send(event *MatchEvent) error {
+	if !k.config.Accepts(event) {
+		return nil
+	}
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaMaxAttempts*2*kafkaTimeout)
+	defer cancel()
+
+	return k.writer.WriteMessages(ctx, kafka.Message{Key: []byte(event.Url), Value: value})
+}
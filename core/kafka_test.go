@@ -0,0 +1,52 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+func TestKafkaWriterConfig(t *testing.T) {
+	config := kafkaWriterConfig(KafkaConfig{
+		Brokers:       []string{"kafka-1:9093", "kafka-2:9093"},
+		Topic:         "findings",
+		TLS:           true,
+		TLSSkipVerify: true,
+		Username:      "shhgit",
+		Password:      "secret",
+	})
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Topic != "findings" || len(config.Brokers) != 2 || config.RequiredAcks != 1 || config.MaxAttempts != kafkaMaxAttempts || config.BatchSize != 1 {
+		t.Errorf("writer is configured with %+v", config)
+	}
+
+	if config.Dialer.TLS == nil || !config.Dialer.TLS.InsecureSkipVerify {
+		t.Errorf("dialer TLS is %+v", config.Dialer.TLS)
+	}
+
+	if mechanism, ok := config.Dialer.SASLMechanism.(plain.Mechanism); !ok || mechanism.Username != "shhgit" || mechanism.Password != "secret" {
+		t.Errorf("SASL mechanism is %#v", config.Dialer.SASLMechanism)
+	}
+
+	plaintext := kafkaWriterConfig(KafkaConfig{Brokers: []string{"kafka:9092"}, Topic: "findings"})
+	if plaintext.Dialer.TLS != nil || plaintext.Dialer.SASLMechanism != nil {
+		t.Errorf("dialer without tls or a username is %+v", plaintext.Dialer)
+	}
+}
+
+func TestKafkaWriterConfigKeepsRepositoriesOnOnePartition(t *testing.T) {
+	balancer := kafkaWriterConfig(KafkaConfig{}).Balancer
+	partitions := []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+	first := balancer.Balance(kafka.Message{Key: []byte("https://github.com/a/b")}, partitions...)
+	for i := 0; i < 10; i++ {
+		if partition := balancer.Balance(kafka.Message{Key: []byte("https://github.com/a/b")}, partitions...); partition != first {
+			t.Fatalf("findings of a repository went to partitions %d and %d", first, partition)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	KubernetesSecretName = "Kubernetes secret"
+	HelmValuesSecretName = "Helm values secret"
+	KubeconfigSecretName = "Kubeconfig secret"
+)
+
+const (
+	kubernetesSecretKind  = "Secret"
+	kubernetesListKind    = "List"
+	kubeconfigKind        = "Config"
+	kubeconfigKeyDataName = "client-key-data"
+)
+
+var (
+	helmValuesRegex = regexp.MustCompile(`(?i)^values([.-][\w.-]+)?\.ya?ml$`)
+	// values naming the Secret a chart should read a password from rather
+	// than the password itself, i.e. existingSecret and passwordKey
+	helmSecretReferenceRegex = regexp.MustCompile(`(?i)(^existing|(secret|password|token)(name|ref|keyref)$|passwordkey$)`)
+
+	// the credentials of a kubeconfig user, and of the auth provider it logs
+	// in with
+	kubeconfigCredentials = map[string]bool{
+		"token":               true,
+		"password":            true,
+		kubeconfigKeyDataName: true,
+		"client-secret":       true,
+		"id-token":            true,
+		"refresh-token":       true,
+		"access-token":        true,
+	}
+)
+
+// parseKubernetesSecrets returns the values of the Secrets in a Kubernetes
+// manifest, keyed by namespace and name, i.e.
+// production/db-credentials.data.password. Values under data are base64
+// decoded, as regular expressions can't see through the encoding
+func parseKubernetesSecrets(file MatchFile) ([]ConfigValue, bool) {
+	if !isYamlFile(file) || !bytes.Contains(file.Contents, []byte(kubernetesSecretKind)) {
+		return nil, false
+	}
+
+	documents, ok := parseYamlDocuments(file.Contents)
+	if !ok {
+		return nil, false
+	}
+
+	lines := lineStarts(file.Contents)
+	values := make([]ConfigValue, 0)
+
+	var addSecret func(object *yaml.Node)
+	addSecret = func(object *yaml.Node) {
+		switch kubernetesField(object, "kind") {
+		case kubernetesListKind:
+			if items := yamlMapValue(object, "items"); items != nil && items.Kind == yaml.SequenceNode {
+				for _, item := range items.Content {
+					addSecret(item)
+				}
+			}
+			return
+		case kubernetesSecretKind:
+		default:
+			return
+		}
+
+		metadata := yamlMapValue(object, "metadata")
+		name := joinKubernetesName(kubernetesField(metadata, "namespace"), kubernetesField(metadata, "name"))
+
+		for _, field := range []string{"data", "stringData"} {
+			walkYamlStrings(yamlMapValue(object, field), joinConfigKey(name, field), func(key string, node *yaml.Node) {
+				value := node.Value
+				if field == "data" {
+					value = decodeKubernetesData(value)
+				}
+
+				if !isTemplated(value) && isSecretValue(key, value, true) {
+					values = append(values, ConfigValue{Key: key, Value: value, Offset: yamlOffset(file.Contents, lines, node)})
+				}
+			})
+		}
+	}
+
+	for _, document := range documents {
+		addSecret(document)
+	}
+
+	return values, true
+}
+
+// parseHelmValues returns the secrets in a chart's values.yaml, or in the
+// values-production.yaml and the like passed to helm install, keyed by their
+// path through the values, i.e. postgresql.auth.password
+func parseHelmValues(file MatchFile) ([]ConfigValue, bool) {
+	if !helmValuesRegex.MatchString(file.Filename) {
+		return nil, false
+	}
+
+	values, ok := parseYamlValues(file.Contents)
+	if !ok {
+		return nil, false
+	}
+
+	secrets := make([]ConfigValue, 0)
+	for _, value := range values {
+		if helmSecretReferenceRegex.MatchString(ConfigKeyName(value.Key)) || isTemplated(value.Value) {
+			continue
+		}
+
+		if isSecretValue(value.Key, value.Value, false) {
+			secrets = append(secrets, value)
+		}
+	}
+
+	return secrets, true
+}
+
+// parseKubeconfig returns the tokens, passwords and client keys of the users
+// in a kubeconfig, keyed by user, i.e. users.admin.client-key-data. Client
+// keys are base64 decoded
+func parseKubeconfig(file MatchFile) ([]ConfigValue, bool) {
+	isKubeconfig := strings.Contains(strings.ToLower(file.Filename), "kubeconfig") ||
+		(file.Filename == "config" && strings.HasSuffix(path.Dir(file.Path), ".kube"))
+	if !isKubeconfig && !(isYamlFile(file) && bytes.Contains(file.Contents, []byte("clusters")) && bytes.Contains(file.Contents, []byte("users"))) {
+		return nil, false
+	}
+
+	documents, ok := parseYamlDocuments(file.Contents)
+	if !ok || len(documents) == 0 || kubernetesField(documents[0], "kind") != kubeconfigKind {
+		return nil, false
+	}
+
+	lines := lineStarts(file.Contents)
+	values := make([]ConfigValue, 0)
+
+	users := yamlMapValue(documents[0], "users")
+	if users == nil || users.Kind != yaml.SequenceNode {
+		return values, true
+	}
+
+	for _, user := range users.Content {
+		name := joinConfigKey("users", kubernetesField(user, "name"))
+
+		walkYamlStrings(yamlMapValue(user, "user"), name, func(key string, node *yaml.Node) {
+			credential := ConfigKeyName(key)
+			if !kubeconfigCredentials[credential] {
+				return
+			}
+
+			value := node.Value
+			if credential == kubeconfigKeyDataName {
+				value = decodeKubernetesData(value)
+			}
+
+			if isSecretValue(key, value, true) {
+				values = append(values, ConfigValue{Key: key, Value: value, Offset: yamlOffset(file.Contents, lines, node)})
+			}
+		})
+	}
+
+	return values, true
+}
+
+// decodeKubernetesData base64 decodes a value under a Secret's data.
+// Values that don't decode, or decode to something other than text, i.e. a
+// keystore, are returned as they are
+func decodeKubernetesData(value string) string {
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+	if err != nil || len(decoded) == 0 || !isPrintableText(decoded) {
+		return value
+	}
+
+	return string(decoded)
+}
+
+// kubernetesField returns a string field of an object, or an empty string
+func kubernetesField(object *yaml.Node, field string) string {
+	if value := yamlMapValue(object, field); value != nil && value.Kind == yaml.ScalarNode {
+		return value.Value
+	}
+
+	return ""
+}
+
+func joinKubernetesName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + "/" + name
+}
+
+// isTemplated reports whether a value is filled in by Helm or another
+// templating tool when deployed
+func isTemplated(value string) bool {
+	return strings.Contains(value, "{{") && strings.Contains(value, "}}")
+}
+
+func isYamlFile(file MatchFile) bool {
+	switch strings.ToLower(path.Ext(file.Filename)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+
+	return false
+}
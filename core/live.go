@@ -0,0 +1,394 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The live feed is a WebSocket at /api/v1/live speaking the shhgit.live.v1
+// subprotocol, documented in the README. Clients subscribe with a filter and
+// are only sent the findings it accepts. Changes that would break clients
+// get a new version of the subprotocol, served alongside the old one
+const (
+	LiveProtocol        = "shhgit.live.v1"
+	LiveProtocolVersion = 1
+	liveBacklogSize     = 100
+	liveBufferSize      = 256
+	livePingInterval    = 30 * time.Second
+	apiLivePath         = apiPrefix + "/live"
+
+	LiveMessageHello       = "hello"
+	LiveMessageSubscribe   = "subscribe"
+	LiveMessageSubscribed  = "subscribed"
+	LiveMessageUnsubscribe = "unsubscribe"
+	LiveMessageFinding     = "finding"
+	LiveMessageError       = "error"
+)
+
+// LiveFilter picks the findings a client of the live feed is sent. Empty
+// fields accept everything
+type LiveFilter struct {
+	Signatures      []string `json:"signatures,omitempty"`
+	MinimumSeverity string   `json:"minimum_severity,omitempty"`
+	// names of providers, i.e. github or gitlab
+	Sources    []string `json:"sources,omitempty"`
+	WatchTerms []string `json:"watch_terms,omitempty"`
+}
+
+// LiveMessage is a message of the live feed, sent either way. Only the
+// fields of its type are set
+type LiveMessage struct {
+	Type string `json:"type"`
+	// hello
+	Version     int      `json:"version,omitempty"`
+	Server      string   `json:"server,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	Sources     []string `json:"sources,omitempty"`
+	BacklogSize int      `json:"backlog_size,omitempty"`
+	// subscribe and subscribed
+	Filter *LiveFilter `json:"filter,omitempty"`
+	// subscribe: replay up to this many of the recent findings, or those
+	// after the id of the last one received before reconnecting
+	Backlog int    `json:"backlog,omitempty"`
+	Since   uint64 `json:"since,omitempty"`
+	// finding
+	Id      uint64       `json:"id,omitempty"`
+	Time    string       `json:"time,omitempty"`
+	Finding *LiveFinding `json:"finding,omitempty"`
+	// error
+	Error string `json:"error,omitempty"`
+}
+
+// LiveFinding is a finding as the live feed sends it. Only ever add to its
+// fields within a version of the protocol
+type LiveFinding struct {
+	Source       string              `json:"source"`
+	Repository   string              `json:"repository"`
+	Parent       string              `json:"parent,omitempty"`
+	Path         string              `json:"path"`
+	Branch       string              `json:"branch,omitempty"`
+	Commit       string              `json:"commit,omitempty"`
+	Stars        int                 `json:"stars"`
+	Metadata     *RepositoryMetadata `json:"repository_metadata,omitempty"`
+	Signature    string              `json:"signature"`
+	Severity     string              `json:"severity"`
+	Confidence   string              `json:"confidence"`
+	Entropy      float64             `json:"entropy,omitempty"`
+	Verified     bool                `json:"verified"`
+	LikelyCanary bool                `json:"likely_canary,omitempty"`
+	CanaryReason string              `json:"canary_reason,omitempty"`
+	Matches      []string            `json:"matches"`
+	Lines        []int               `json:"lines,omitempty"`
+	WatchTerms   []string            `json:"watch_terms,omitempty"`
+	Tenants      []string            `json:"tenants,omitempty"`
+}
+
+type liveEntry struct {
+	event   *MatchEvent
+	message *LiveMessage
+}
+
+// liveSubscriber is a client of the live feed, sent nothing until it
+// subscribes
+type liveSubscriber struct {
+	filter   *LiveFilter
+	messages chan *LiveMessage
+	dropped  chan struct{}
+	drop     sync.Once
+}
+
+// LiveSink fans findings out to the clients of the live feed, keeping the
+// most recent ones for those that just connected. A client that falls
+// behind is disconnected rather than holding up the output workers
+type LiveSink struct {
+	sync.Mutex
+
+	lastId      uint64
+	backlog     []liveEntry
+	subscribers map[*liveSubscriber]bool
+}
+
+func NewLiveSink() *LiveSink {
+	return &LiveSink{subscribers: make(map[*liveSubscriber]bool)}
+}
+
+// InitLive serves the live feed on --listen. It must run before InitSinks
+// starts the output workers
+func (s *Session) InitLive() {
+	if *s.Options.Listen == "" {
+		return
+	}
+
+	s.Live = NewLiveSink()
+	s.Sinks = append(s.Sinks, s.Live)
+	s.Server.Handle(apiLivePath, s.authenticate(WebRoleViewer, s.handleLive))
+}
+
+// Accepts reports whether the filter lets a finding through
+func (f *LiveFilter) Accepts(event *MatchEvent) bool {
+	filter := SinkFilter{Signatures: f.Signatures, MinimumSeverity: f.MinimumSeverity, WatchTerms: f.WatchTerms}
+	if !filter.Accepts(event) {
+		return false
+	}
+
+	return len(f.Sources) == 0 || containsAnyFold(f.Sources, []string{SourceNames[event.Source]})
+}
+
+func (f *LiveFilter) validate() error {
+	if f.MinimumSeverity != "" && !IsSeverity(f.MinimumSeverity) {
+		return fmt.Errorf("minimum_severity must be one of %s", strings.Join(Severities, ", "))
+	}
+
+	for _, source := range f.Sources {
+		if !containsAnyFold(liveSourceNames(), []string{source}) {
+			return fmt.Errorf("sources must be among %s", strings.Join(liveSourceNames(), ", "))
+		}
+	}
+
+	return nil
+}
+
+func liveSourceNames() []string {
+	names := make([]string, 0, len(SourceNames))
+	for _, name := range SourceNames {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func newLiveFinding(event *MatchEvent) *LiveFinding {
+	matches := event.Matches
+	if matches == nil {
+		matches = []string{}
+	}
+
+	return &LiveFinding{
+		Source:       SourceNames[event.Source],
+		Repository:   event.Url,
+		Parent:       event.Parent,
+		Path:         event.File,
+		Branch:       event.Branch,
+		Commit:       event.Commit,
+		Stars:        event.Stars,
+		Metadata:     event.Metadata,
+		Signature:    event.Signature,
+		Severity:     event.Severity,
+		Confidence:   event.Confidence,
+		Entropy:      event.Entropy,
+		Verified:     event.Verified,
+		LikelyCanary: event.LikelyCanary,
+		CanaryReason: event.CanaryReason,
+		Matches:      matches,
+		Lines:        event.Lines,
+		WatchTerms:   event.WatchTerms,
+		Tenants:      event.Tenants,
+	}
+}
+
+func (l *LiveSink) Name() string {
+	return "live feed"
+}
+
+func (l *LiveSink) Send(event *MatchEvent) error {
+	l.Lock()
+	defer l.Unlock()
+
+	l.lastId++
+	entry := liveEntry{event: event, message: &LiveMessage{
+		Type:    LiveMessageFinding,
+		Id:      l.lastId,
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Finding: newLiveFinding(event),
+	}}
+
+	l.backlog = append(l.backlog, entry)
+	if len(l.backlog) > liveBacklogSize {
+		l.backlog = l.backlog[len(l.backlog)-liveBacklogSize:]
+	}
+
+	dropped := 0
+	for subscriber := range l.subscribers {
+		if subscriber.filter != nil && subscriber.filter.Accepts(event) && !l.deliver(subscriber, entry.message) {
+			dropped++
+		}
+	}
+
+	if dropped > 0 {
+		return fmt.Errorf("disconnected %d %s that stopped reading", dropped, Pluralize(dropped, "client", "clients"))
+	}
+
+	return nil
+}
+
+// deliver queues a message for a subscriber without waiting, dropping the
+// subscriber if it's too far behind to take it
+func (l *LiveSink) deliver(subscriber *liveSubscriber, message *LiveMessage) bool {
+	select {
+	case subscriber.messages <- message:
+		return true
+	default:
+		subscriber.drop.Do(func() { close(subscriber.dropped) })
+		return false
+	}
+}
+
+func (l *LiveSink) connect() *liveSubscriber {
+	subscriber := &liveSubscriber{messages: make(chan *LiveMessage, liveBufferSize), dropped: make(chan struct{})}
+
+	l.Lock()
+	l.subscribers[subscriber] = true
+	l.Unlock()
+
+	return subscriber
+}
+
+func (l *LiveSink) disconnect(subscriber *liveSubscriber) {
+	l.Lock()
+	delete(l.subscribers, subscriber)
+	l.Unlock()
+
+	subscriber.drop.Do(func() { close(subscriber.dropped) })
+}
+
+// subscribe sets a subscriber's filter and queues the recent findings it
+// asked for, or unsubscribes it with a nil filter. Both happen under the
+// lock Send delivers with, so nothing is skipped or sent twice
+func (l *LiveSink) subscribe(subscriber *liveSubscriber, request *LiveMessage) {
+	l.Lock()
+	defer l.Unlock()
+
+	subscriber.filter = request.Filter
+	if request.Filter == nil {
+		return
+	}
+
+	if !l.deliver(subscriber, &LiveMessage{Type: LiveMessageSubscribed, Filter: request.Filter}) {
+		return
+	}
+
+	var replay []*LiveMessage
+	for _, entry := range l.backlog {
+		if (request.Since > 0 && entry.message.Id > request.Since) || (request.Since == 0 && request.Backlog > 0) {
+			if request.Filter.Accepts(entry.event) {
+				replay = append(replay, entry.message)
+			}
+		}
+	}
+
+	if request.Since == 0 && len(replay) > request.Backlog {
+		replay = replay[len(replay)-request.Backlog:]
+	}
+
+	for _, message := range replay {
+		if !l.deliver(subscriber, message) {
+			return
+		}
+	}
+}
+
+// handleLive upgrades a request to the live feed. The handshake must offer
+// a supported version of the subprotocol, or none for the latest
+func (s *Session) handleLive(w http.ResponseWriter, r *http.Request) {
+	if protocols := websocket.Subprotocols(r); len(protocols) > 0 && !containsString(protocols, LiveProtocol) {
+		writeApiError(w, http.StatusBadRequest, "Sec-WebSocket-Protocol must offer "+LiveProtocol)
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+
+	subscriber := s.Live.connect()
+	defer s.Live.disconnect(subscriber)
+
+	user := s.RequestUser(r)
+	s.Log.Debug("%s connected to the live feed from %s", user.Username, r.RemoteAddr)
+
+	ws.WriteJSON(&LiveMessage{
+		Type:        LiveMessageHello,
+		Version:     LiveProtocolVersion,
+		Server:      fmt.Sprintf("%s v%s", Name, Version),
+		Role:        user.Role,
+		Sources:     liveSourceNames(),
+		BacklogSize: liveBacklogSize,
+	})
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		s.readLive(ws, subscriber)
+	}()
+
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case message := <-subscriber.messages:
+			if err := ws.WriteJSON(message); err != nil {
+				ws.Close(wsCloseUnexpected, "")
+				return
+			}
+		case <-ping.C:
+			if err := ws.Ping(); err != nil {
+				ws.Close(wsCloseUnexpected, "")
+				return
+			}
+		case <-subscriber.dropped:
+			ws.Close(wsClosePolicy, "findings weren't read quickly enough")
+			return
+		case <-closed:
+			return
+		case <-s.Context.Done():
+			ws.Close(wsCloseGoingAway, "shutting down")
+			return
+		}
+	}
+}
+
+// readLive handles the messages a client sends until it disconnects. Clients
+// that send nothing are still answering pings, so they have two intervals
+// to send anything before they're taken to be gone
+func (s *Session) readLive(ws *webSocket, subscriber *liveSubscriber) {
+	for {
+		data, err := ws.ReadMessage(2 * livePingInterval)
+		if err != nil {
+			ws.Close(wsCloseNormal, "")
+			return
+		}
+
+		request := &LiveMessage{}
+		if err := json.Unmarshal(data, request); err != nil {
+			ws.WriteJSON(&LiveMessage{Type: LiveMessageError, Error: "messages must be JSON objects"})
+			continue
+		}
+
+		switch request.Type {
+		case LiveMessageSubscribe:
+			if request.Filter == nil {
+				request.Filter = &LiveFilter{}
+			}
+
+			if err := request.Filter.validate(); err != nil {
+				ws.WriteJSON(&LiveMessage{Type: LiveMessageError, Error: err.Error()})
+				continue
+			}
+
+			s.Live.subscribe(subscriber, request)
+		case LiveMessageUnsubscribe:
+			s.Live.subscribe(subscriber, &LiveMessage{})
+		default:
+			ws.WriteJSON(&LiveMessage{Type: LiveMessageError, Error: "unknown message type " + request.Type})
+		}
+	}
+}
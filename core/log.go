@@ -1,12 +1,18 @@
 package core
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fatih/color"
 )
@@ -20,6 +26,17 @@ const (
 	DEBUG     = 0
 )
 
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+var (
+	LogFormats = []string{LogFormatText, LogFormatJson}
+	LogLevels  = map[string]int{"debug": DEBUG, "info": INFO, "warn": WARN, "important": IMPORTANT, "error": ERROR, "fatal": FATAL}
+	ansiRegex  = regexp.MustCompile("[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))")
+)
+
 var LogColors = map[int]*color.Color{
 	FATAL:     color.New(color.FgRed).Add(color.Bold),
 	ERROR:     color.New(color.FgRed),
@@ -28,11 +45,23 @@ var LogColors = map[int]*color.Color{
 	DEBUG:     color.New(color.Faint),
 }
 
+// Logger writes the colored lines shhgit has always printed to stdout or,
+// for log pipelines, lines of JSON or plain text to stdout or a rotated file.
+// Each source file of shhgit is a module whose level can be set on its own
 type Logger struct {
 	sync.Mutex
 
-	debug  bool
-	silent bool
+	debug   bool
+	silent  bool
+	level   int
+	modules map[string]int
+	format  string
+	out     io.Writer
+	file    *rotatingFile
+}
+
+func NewLogger() *Logger {
+	return &Logger{level: INFO, format: LogFormatText, out: os.Stdout}
 }
 
 func (l *Logger) SetDebug(d bool) {
@@ -43,22 +72,62 @@ func (l *Logger) SetSilent(d bool) {
 	l.silent = d
 }
 
+// SetLevel only logs messages of at least level, one of LogLevels
+func (l *Logger) SetLevel(level string) error {
+	value, ok := LogLevels[level]
+	if !ok {
+		return fmt.Errorf("unknown log level %s", level)
+	}
+
+	l.level = value
+	return nil
+}
+
+// SetModules sets the level of modules, as parsed by ParseLogModules
+func (l *Logger) SetModules(modules map[string]int) {
+	l.modules = modules
+}
+
+func (l *Logger) SetFormat(format string) {
+	l.format = format
+}
+
+// SetFile logs to the file at path instead of stdout, rotating it once it
+// grows past maxSize MB and keeping maxBackups of the old ones
+func (l *Logger) SetFile(path string, maxSize int, maxBackups int) error {
+	file, err := openRotatingFile(path, int64(maxSize)*1024*1024, maxBackups)
+	if err != nil {
+		return err
+	}
+
+	l.file, l.out = file, file
+	return nil
+}
+
 func (l *Logger) Log(level int, format string, args ...interface{}) {
 	l.Lock()
 	defer l.Unlock()
 
-	if level == DEBUG && !l.debug {
-		return
+	module := ""
+	if l.format == LogFormatJson || l.file != nil || len(l.modules) > 0 {
+		module = callerModule()
 	}
 
-	if l.silent && level < IMPORTANT {
+	if level < FATAL && level < l.threshold(module) {
 		return
 	}
 
-	if c, ok := LogColors[level]; ok {
-		c.Printf("\r"+format+"\n", args...)
-	} else {
-		fmt.Printf("\r"+format+"\n", args...)
+	switch {
+	case l.format == LogFormatJson:
+		l.writeJson(level, module, fmt.Sprintf(format, args...))
+	case l.file != nil:
+		fmt.Fprintf(l.out, "%s %s [%s] %s\n", time.Now().UTC().Format(time.RFC3339), strings.ToUpper(logLevelName(level)), module, strings.TrimSpace(colorStrip(fmt.Sprintf(format, args...))))
+	default:
+		if c, ok := LogColors[level]; ok {
+			c.Printf("\r"+format+"\n", args...)
+		} else {
+			fmt.Printf("\r"+format+"\n", args...)
+		}
 	}
 
 	if level > WARN && session.Config.Webhook != "" {
@@ -72,6 +141,38 @@ func (l *Logger) Log(level int, format string, args ...interface{}) {
 	}
 }
 
+// threshold is the lowest level logged for the module. --debug and --silent
+// win over the levels set
+func (l *Logger) threshold(module string) int {
+	threshold := l.level
+	if level, ok := l.modules[module]; ok {
+		threshold = level
+	}
+
+	if l.debug {
+		threshold = DEBUG
+	}
+
+	if l.silent && threshold < IMPORTANT {
+		threshold = IMPORTANT
+	}
+
+	return threshold
+}
+
+func (l *Logger) writeJson(level int, module string, message string) {
+	line, err := json.Marshal(struct {
+		Time    string `json:"time"`
+		Level   string `json:"level"`
+		Module  string `json:"module"`
+		Message string `json:"message"`
+	}{time.Now().UTC().Format(time.RFC3339Nano), logLevelName(level), module, strings.TrimSpace(colorStrip(message))})
+
+	if err == nil {
+		l.out.Write(append(line, '\n'))
+	}
+}
+
 func (l *Logger) Fatal(format string, args ...interface{}) {
 	l.Log(FATAL, format, args...)
 }
@@ -96,8 +197,134 @@ func (l *Logger) Debug(format string, args ...interface{}) {
 	l.Log(DEBUG, format, args...)
 }
 
+// ParseLogModules parses comma separated module=level pairs, i.e.
+// github=debug,gitlab=warn
+func ParseLogModules(value string) (map[string]int, error) {
+	modules := make(map[string]int)
+
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("log module %s must be given as module=level", pair)
+		}
+
+		level, ok := LogLevels[strings.TrimSpace(parts[1])]
+		if !ok {
+			return nil, fmt.Errorf("unknown log level %s for module %s", parts[1], parts[0])
+		}
+
+		modules[strings.TrimSpace(parts[0])] = level
+	}
+
+	return modules, nil
+}
+
+func logLevelName(level int) string {
+	for name, value := range LogLevels {
+		if value == level {
+			return name
+		}
+	}
+
+	return "info"
+}
+
+// logLevelNames lists LogLevels from the lowest
+func logLevelNames() []string {
+	var names []string
+	for name := range LogLevels {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool { return LogLevels[names[i]] < LogLevels[names[j]] })
+	return names
+}
+
+// callerModule is the name of the source file that logged, without .go
+func callerModule() string {
+	pcs := make([]uintptr, 8)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+
+	for {
+		frame, more := frames.Next()
+		if file := filepath.Base(frame.File); file != "log.go" {
+			return strings.TrimSuffix(file, ".go")
+		}
+
+		if !more {
+			return ""
+		}
+	}
+}
+
 func colorStrip(str string) string {
-	ansi := "[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))"
-	re := regexp.MustCompile(ansi)
-	return re.ReplaceAllString(str, "")
+	return ansiRegex.ReplaceAllString(str, "")
+}
+
+// rotatingFile is a log file that's renamed to path.1 once it grows past
+// maxSize bytes, shifting older ones along up to path.<maxBackups>
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	return f, f.open()
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+
+	if f.maxBackups <= 0 {
+		os.Remove(f.path)
+	}
+
+	for i := f.maxBackups; i > 0; i-- {
+		source := f.path
+		if i > 1 {
+			source = fmt.Sprintf("%s.%d", f.path, i-1)
+		}
+
+		if PathExists(source) {
+			os.Rename(source, fmt.Sprintf("%s.%d", f.path, i))
+		}
+	}
+
+	return f.open()
 }
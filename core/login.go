@@ -0,0 +1,186 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	githubDeviceCodeUrl  = "https://github.com/login/device/code"
+	githubAccessTokenUrl = "https://github.com/login/oauth/access_token"
+	githubDeviceGrant    = "urn:ietf:params:oauth:grant-type:device_code"
+	githubTokensKey      = "github_access_tokens"
+)
+
+var (
+	githubTokensBlockRegex = regexp.MustCompile(`^` + githubTokensKey + `:\s*(#.*)?$`)
+	githubTokensFlowRegex  = regexp.MustCompile(`^` + githubTokensKey + `:\s*\[(.*)\]\s*(#.*)?$`)
+)
+
+// GitHubDeviceCode is what the user is asked to enter to authorize shhgit
+type GitHubDeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationUri string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// GitHubDeviceLogin gets a token for the OAuth app through the device flow.
+// prompt is called with the code to show the user, then GitHub is polled
+// until they have entered it
+func GitHubDeviceLogin(clientId string, scope string, prompt func(code *GitHubDeviceCode)) (string, error) {
+	code := &GitHubDeviceCode{}
+	if err := postGitHubLogin(githubDeviceCodeUrl, url.Values{"client_id": {clientId}, "scope": {scope}}, code); err != nil {
+		return "", err
+	}
+
+	if code.DeviceCode == "" {
+		return "", errors.New("GitHub didn't return a device code. Is device flow enabled for the OAuth app?")
+	}
+
+	prompt(code)
+
+	interval := time.Duration(code.Interval) * time.Second
+	expires := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+
+	for time.Now().Before(expires) {
+		time.Sleep(interval)
+
+		token := struct {
+			AccessToken      string `json:"access_token"`
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+			Interval         int    `json:"interval"`
+		}{}
+
+		params := url.Values{"client_id": {clientId}, "device_code": {code.DeviceCode}, "grant_type": {githubDeviceGrant}}
+		if err := postGitHubLogin(githubAccessTokenUrl, params, &token); err != nil {
+			return "", err
+		}
+
+		switch token.Error {
+		case "":
+			return token.AccessToken, nil
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval = time.Duration(token.Interval) * time.Second
+		default:
+			return "", fmt.Errorf("%s: %s", token.Error, token.ErrorDescription)
+		}
+	}
+
+	return "", errors.New("the code expired before it was entered")
+}
+
+func postGitHubLogin(endpoint string, params url.Values, dst interface{}) error {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", fmt.Sprintf("%s v%s", Name, Version))
+
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// AddGitHubToken adds a token to github_access_tokens in the config file,
+// editing only that list so the rest of the file is left as it is. Empty
+// placeholder tokens are replaced
+func AddGitHubToken(path string, token string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// editing it would break the MAC, and the token would be left in clear
+	if IsSopsEncrypted(data) {
+		return fmt.Errorf("%s is encrypted with sops. Add the token with sops instead", path)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	entry := "'" + token + "'"
+	added := false
+
+	for i := 0; i < len(lines) && !added; i++ {
+		if match := githubTokensFlowRegex.FindStringSubmatch(lines[i]); match != nil {
+			var tokens []string
+			for _, existing := range strings.Split(match[1], ",") {
+				if existing = strings.TrimSpace(existing); existing != "" && existing != "''" && existing != `""` {
+					tokens = append(tokens, existing)
+				}
+			}
+
+			lines[i] = fmt.Sprintf("%s: [%s]", githubTokensKey, strings.Join(append(tokens, entry), ", "))
+			if match[2] != "" {
+				lines[i] += " " + match[2]
+			}
+			added = true
+		} else if githubTokensBlockRegex.MatchString(lines[i]) {
+			indent := "  "
+			last := i
+
+			// the list runs until the next line that isn't an item or comment
+			for j := i + 1; j < len(lines); j++ {
+				trimmed := strings.TrimLeft(lines[j], " ")
+				if !strings.HasPrefix(trimmed, "-") && !strings.HasPrefix(trimmed, "#") {
+					break
+				}
+
+				if strings.HasPrefix(trimmed, "-") {
+					indent = lines[j][:len(lines[j])-len(trimmed)]
+					if value := strings.TrimSpace(strings.TrimPrefix(trimmed, "-")); value == "''" || value == `""` {
+						lines = append(lines[:j], lines[j+1:]...)
+						j--
+						continue
+					}
+
+					last = j
+				}
+			}
+
+			lines = append(lines[:last+1], append([]string{indent + "- " + entry}, lines[last+1:]...)...)
+			added = true
+		}
+	}
+
+	if !added {
+		lines = append([]string{githubTokensKey + ":", "  - " + entry}, lines...)
+	}
+
+	updated := strings.Join(lines, "\n")
+
+	// make sure the edit left valid config with the token in it
+	config := &Config{}
+	if err := yaml.Unmarshal([]byte(updated), config); err != nil {
+		return fmt.Errorf("couldn't add the token to %s: %s", path, err)
+	}
+
+	if !containsString(config.GitHubAccessTokens, token) {
+		return fmt.Errorf("couldn't add the token to %s", path)
+	}
+
+	return ioutil.WriteFile(path, []byte(updated), 0600)
+}
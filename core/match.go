@@ -12,6 +12,18 @@ type MatchFile struct {
 	Filename  string
 	Extension string
 	Contents  []byte
+	Commit    string
+	// Lazy files are read from Path a window at a time when scanned, rather
+	// than held in Contents
+	Lazy bool
+	// a window of a larger file starts Offset bytes and Lines lines in to
+	// it, and Column bytes in to its first line when the last window ended
+	// part way through a line. Matches starting from Limit on are left to
+	// the next window
+	Offset int
+	Lines  int
+	Column int
+	Limit  int
 }
 
 func NewMatchFile(path string) MatchFile {
@@ -28,16 +40,32 @@ func NewMatchFile(path string) MatchFile {
 	}
 }
 
+// SaveMatchFile writes a file from an in-memory clone out to its path so
+// findings can be reviewed after the clone is gone
+func SaveMatchFile(file MatchFile) error {
+	if PathExists(file.Path) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file.Path), os.ModePerm); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file.Path, file.Contents, 0644)
+}
+
 func IsSkippableFile(path string) bool {
 	extension := strings.ToLower(filepath.Ext(path))
 
-	for _, skippableExt := range session.Config.BlacklistedExtensions {
-		if extension == skippableExt {
+	blacklists := session.CurrentBlacklists()
+
+	for _, skippableExt := range blacklists.Extensions {
+		if extension == skippableExt && !(*session.Options.ScanArchives && IsArchive(path)) {
 			return true
 		}
 	}
 
-	for _, skippablePathIndicator := range session.Config.BlacklistedPaths {
+	for _, skippablePathIndicator := range blacklists.Paths {
 		skippablePathIndicator = strings.Replace(skippablePathIndicator, "{sep}", string(os.PathSeparator), -1)
 		if strings.Contains(path, skippablePathIndicator) {
 			return true
@@ -52,7 +80,7 @@ func (match MatchFile) CanCheckEntropy() bool {
 		return false
 	}
 
-	for _, skippableExt := range session.Config.BlacklistedEntropyExtensions {
+	for _, skippableExt := range session.CurrentBlacklists().EntropyExtensions {
 		if match.Extension == skippableExt {
 			return false
 		}
@@ -61,12 +89,43 @@ func (match MatchFile) CanCheckEntropy() bool {
 	return true
 }
 
+// InWindow drops the matches a window of a file leaves to the next window
+func (match MatchFile) InWindow(matches []ContentsMatch) []ContentsMatch {
+	if match.Limit <= 0 {
+		return matches
+	}
+
+	kept := make([]ContentsMatch, 0, len(matches))
+	for _, contentsMatch := range matches {
+		if contentsMatch.Offset < match.Limit {
+			kept = append(kept, contentsMatch)
+		}
+	}
+
+	return kept
+}
+
+// GetPositions returns the lines and columns of offsets in the contents of
+// a file, or of a window of it, along with the offsets in to the whole file
+func (match MatchFile) GetPositions(offsets []int) ([]int, []int, []int) {
+	lines, columns := GetPositions(match.Contents, offsets)
+
+	fileOffsets := make([]int, len(offsets))
+	for i := range offsets {
+		if lines[i] == 1 {
+			columns[i] += match.Column
+		}
+		lines[i] += match.Lines
+		fileOffsets[i] = offsets[i] + match.Offset
+	}
+
+	return lines, columns, fileOffsets
+}
+
 func GetMatchingFiles(dir string) []MatchFile {
 	fileList := make([]MatchFile, 0)
-	maxFileSize := *session.Options.MaximumFileSize * 1024
-
 	filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
-		if err != nil || f.IsDir() || uint(f.Size()) > maxFileSize || IsSkippableFile(path) {
+		if err != nil || f.IsDir() || f.Size() > GetMaximumFileSize(path) || IsSkippableFile(path) {
 			return nil
 		}
 		fileList = append(fileList, NewMatchFile(path))
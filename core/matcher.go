@@ -0,0 +1,228 @@
+package core
+
+import (
+	"bytes"
+	"regexp"
+	"runtime"
+	"sync"
+)
+
+const (
+	MatchBackendRegexp    = "regexp"
+	MatchBackendPrefilter = "prefilter"
+)
+
+// MatchBackends are the ways contents signatures can be matched: running
+// every regex over each file, or first finding the literals they need in a
+// single pass and only running the regexes that could match
+var MatchBackends = []string{MatchBackendRegexp, MatchBackendPrefilter}
+
+const (
+	// files larger than this are split in to chunks matched concurrently
+	matchChunkSize = 1024 * 1024
+	// each chunk runs on in to the next by this much, so matches spanning
+	// the boundary, such as private keys, are still found whole
+	matchChunkOverlap = 64 * 1024
+)
+
+// matchPool bounds the goroutines matching signatures and chunks across
+// every scan worker. When it's full the work runs on the caller instead
+var matchPool = make(chan struct{}, runtime.NumCPU())
+
+// SignatureMatch is a signature that matched a file, with the values it
+// matched for contents signatures
+type SignatureMatch struct {
+	Signature Signature
+	Part      string
+	Matches   []ContentsMatch
+}
+
+// Matcher is the signatures grouped by what they match, built once each
+// time they're loaded. Exact paths, filenames and extensions are looked up
+// in maps and regexes on them are cheap, so only the contents signatures
+// are matched concurrently
+type Matcher struct {
+	signatures []Signature
+	// indexes of the simple signatures by part, then by the value matched
+	exact     map[string]map[string][]int
+	patterns  []int
+	contents  []int
+	prefilter *prefilter
+}
+
+func NewMatcher(signatures []Signature, backend string) *Matcher {
+	m := &Matcher{signatures: signatures, exact: make(map[string]map[string][]int)}
+
+	for i, signature := range signatures {
+		switch signature := signature.(type) {
+		case SimpleSignature:
+			if m.exact[signature.part] == nil {
+				m.exact[signature.part] = make(map[string][]int)
+			}
+			m.exact[signature.part][signature.match] = append(m.exact[signature.part][signature.match], i)
+		case PatternSignature:
+			if signature.part == PartContents {
+				m.contents = append(m.contents, i)
+			} else {
+				m.patterns = append(m.patterns, i)
+			}
+		default:
+			m.contents = append(m.contents, i)
+		}
+	}
+
+	if backend == MatchBackendPrefilter {
+		m.prefilter = newPrefilter(signatures, m.contents)
+	}
+
+	return m
+}
+
+// Match returns the signatures that match file, in the order they were
+// loaded. Only the contents signatures matchContents accepts are matched,
+// and only with at least one value that isn't blacklisted
+func (m *Matcher) Match(file MatchFile, matchContents func(Signature) bool) []SignatureMatch {
+	matched := make([]SignatureMatch, len(m.signatures))
+
+	for part, values := range m.exact {
+		var haystack string
+		switch part {
+		case PartPath:
+			haystack = file.Path
+		case PartFilename:
+			haystack = file.Filename
+		case PartExtension:
+			haystack = file.Extension
+		default:
+			continue
+		}
+
+		for _, i := range values[haystack] {
+			matched[i] = SignatureMatch{Signature: m.signatures[i], Part: PartPath}
+		}
+	}
+
+	for _, i := range m.patterns {
+		if ok, part := m.signatures[i].Match(file); ok {
+			matched[i] = SignatureMatch{Signature: m.signatures[i], Part: part}
+		}
+	}
+
+	var candidates []bool
+	if m.prefilter != nil {
+		candidates = m.prefilter.candidates(file.Contents, len(m.signatures))
+	}
+
+	var wg sync.WaitGroup
+	for _, i := range m.contents {
+		signature := m.signatures[i]
+		if (candidates != nil && !candidates[i]) || !matchContents(signature) {
+			continue
+		}
+
+		i := i
+		runPooled(&wg, func() {
+			var found []ContentsMatch
+			if fileSignature, ok := signature.(FileSignature); ok {
+				found = file.InWindow(fileSignature.GetFileMatches(file))
+			} else {
+				found = file.InWindow(signature.GetContentsMatches(file.Contents))
+			}
+
+			if len(found) > 0 {
+				matched[i] = SignatureMatch{Signature: signature, Part: PartContents, Matches: found}
+			}
+		})
+	}
+	wg.Wait()
+
+	results := make([]SignatureMatch, 0)
+	for _, match := range matched {
+		if match.Signature != nil {
+			results = append(results, match)
+		}
+	}
+
+	return results
+}
+
+// runPooled runs f on a goroutine of the match pool, or on the caller when
+// the pool is busy, so nested calls never wait on each other
+func runPooled(wg *sync.WaitGroup, f func()) {
+	select {
+	case matchPool <- struct{}{}:
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-matchPool
+				wg.Done()
+			}()
+			f()
+		}()
+	default:
+		f()
+	}
+}
+
+// findChunkedMatches matches a regex over a large file in chunks split on
+// lines, concurrently. Matches that start in the overlap are left to the
+// next chunk, and those overlapping an earlier match are dropped, as a
+// single pass over the whole file would
+func findChunkedMatches(regex *regexp.Regexp, contents []byte) []ContentsMatch {
+	var starts []int
+	for start := 0; start < len(contents); {
+		starts = append(starts, start)
+
+		next := start + matchChunkSize
+		if next >= len(contents) {
+			break
+		}
+
+		newline := bytes.IndexByte(contents[next:], '\n')
+		if newline < 0 {
+			break
+		}
+		start = next + newline + 1
+	}
+
+	chunks := make([][]ContentsMatch, len(starts))
+	var wg sync.WaitGroup
+	for i, start := range starts {
+		end := len(contents)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+
+		i, start := i, start
+		runPooled(&wg, func() {
+			window := end + matchChunkOverlap
+			if window > len(contents) {
+				window = len(contents)
+			}
+
+			for _, index := range regex.FindAllIndex(contents[start:window], -1) {
+				if start+index[0] >= end && end < len(contents) {
+					break
+				}
+
+				chunks[i] = append(chunks[i], ContentsMatch{Value: string(contents[start+index[0] : start+index[1]]), Offset: start + index[0]})
+			}
+		})
+	}
+	wg.Wait()
+
+	matches := make([]ContentsMatch, 0)
+	matchedUntil := 0
+	for _, chunk := range chunks {
+		for _, match := range chunk {
+			if match.Offset < matchedUntil {
+				continue
+			}
+
+			matches = append(matches, match)
+			matchedUntil = match.Offset + len(match.Value)
+		}
+	}
+
+	return matches
+}
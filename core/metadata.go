@@ -0,0 +1,68 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// repositories created this recently before they were scanned are new.
+// Throwaway repositories pushed with a secret in them are far more likely
+// to be real leaks, or honeypots
+const newRepositoryAge = 30 * time.Minute
+
+// RepositoryMetadata is what GitHub says about the repository a finding is
+// in, as of when it was scanned
+type RepositoryMetadata struct {
+	Stars     int       `json:"stars"`
+	CreatedAt time.Time `json:"created_at"`
+	// user or organization
+	OwnerType string `json:"owner_type"`
+	Language  string `json:"language,omitempty"`
+	// created within minutes of being scanned
+	New bool `json:"new"`
+}
+
+// NewRepositoryMetadata collects the details of a repository looked up
+// through the GitHub API
+func NewRepositoryMetadata(repo *github.Repository) *RepositoryMetadata {
+	if repo == nil {
+		return nil
+	}
+
+	createdAt := repo.GetCreatedAt().Time
+	return &RepositoryMetadata{
+		Stars:     repo.GetStargazersCount(),
+		CreatedAt: createdAt,
+		OwnerType: strings.ToLower(repo.GetOwner().GetType()),
+		Language:  repo.GetLanguage(),
+		New:       !createdAt.IsZero() && time.Since(createdAt) < newRepositoryAge,
+	}
+}
+
+// String summarises the metadata for logs and notifications, i.e.
+// "organization, Go, 12 stars, created 2020-01-02"
+func (m *RepositoryMetadata) String() string {
+	if m == nil {
+		return ""
+	}
+
+	details := []string{}
+	if m.OwnerType != "" {
+		details = append(details, m.OwnerType)
+	}
+	if m.Language != "" {
+		details = append(details, m.Language)
+	}
+	details = append(details, fmt.Sprintf("%d %s", m.Stars, Pluralize(m.Stars, "star", "stars")))
+
+	if m.New {
+		details = append(details, fmt.Sprintf("created %d minutes ago", int(time.Since(m.CreatedAt).Minutes())))
+	} else if !m.CreatedAt.IsZero() {
+		details = append(details, "created "+m.CreatedAt.Format("2006-01-02"))
+	}
+
+	return strings.Join(details, ", ")
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	MetricRepositoriesCloned      = "shhgit_repositories_cloned_total"
+	MetricCloneFailures           = "shhgit_clone_failures_total"
+	MetricFilesScanned            = "shhgit_files_scanned_total"
+	MetricBytesProcessed          = "shhgit_bytes_processed_total"
+	MetricMatches                 = "shhgit_matches_total"
+	MetricRateLimitRemaining      = "shhgit_github_rate_limit_remaining"
+	MetricRateLimitReset          = "shhgit_github_rate_limit_reset_timestamp_seconds"
+	MetricGitHubTokensAvailable   = "shhgit_github_tokens_available"
+	MetricGitHubTokensRateLimited = "shhgit_github_tokens_rate_limited"
+	MetricGitHubPoolWaits         = "shhgit_github_token_pool_waits_total"
+	MetricConfigReloads           = "shhgit_config_reloads_total"
+	MetricTempDirectoryBytes      = "shhgit_temp_directory_bytes"
+	MetricForkDuplicates          = "shhgit_fork_duplicates_total"
+	MetricEventsFiltered          = "shhgit_events_filtered_total"
+	MetricGitHubEventsMissed      = "shhgit_github_events_missed_total"
+	MetricLikelyCanaries          = "shhgit_likely_canaries_total"
+	metricTypeCounter             = "counter"
+	metricTypeGauge               = "gauge"
+	metricLabelSeparator          = "\xff"
+	metricsContentType            = "text/plain; version=0.0.4"
+)
+
+type metric struct {
+	help   string
+	kind   string
+	values map[string]float64
+}
+
+// Metrics is a minimal registry of counters and gauges, rendered in the
+// Prometheus text exposition format on /metrics
+type Metrics struct {
+	sync.Mutex
+
+	metrics map[string]*metric
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{metrics: make(map[string]*metric)}
+
+	m.register(MetricRepositoriesCloned, metricTypeCounter, "Number of repositories and gists successfully cloned")
+	m.register(MetricCloneFailures, metricTypeCounter, "Number of repositories and gists that failed to clone")
+	m.register(MetricFilesScanned, metricTypeCounter, "Number of files checked against signatures")
+	m.register(MetricBytesProcessed, metricTypeCounter, "Number of bytes of file contents checked against signatures")
+	m.register(MetricMatches, metricTypeCounter, "Number of findings per signature")
+	m.register(MetricRateLimitRemaining, metricTypeGauge, "GitHub API calls remaining for each token")
+	m.register(MetricRateLimitReset, metricTypeGauge, "When the GitHub API calls of each token reset, as a Unix timestamp")
+	m.register(MetricGitHubTokensAvailable, metricTypeGauge, "GitHub tokens of each instance with calls left")
+	m.register(MetricGitHubTokensRateLimited, metricTypeGauge, "GitHub tokens of each instance waiting for their rate limit to reset")
+	m.register(MetricGitHubPoolWaits, metricTypeCounter, "Number of times every GitHub token of an instance was rate limited and a call had to wait")
+	m.register(MetricConfigReloads, metricTypeCounter, "Number of times config.yaml, signature packs or YARA rules were reloaded, by result")
+	m.register(MetricTempDirectoryBytes, metricTypeGauge, "Bytes used by clones and saved matching files in the temp directory, as of the last quota check")
+	m.register(MetricForkDuplicates, metricTypeCounter, "Number of findings in forks and mirrors left out for having been reported in the repository they were copied from")
+	m.register(MetricGitHubEventsMissed, metricTypeCounter, "Estimated number of public events of each GitHub instance missed for polling too rarely")
+	m.register(MetricEventsFiltered, metricTypeCounter, "Number of repositories turned up by the event feeds dropped for having been queued recently, by source")
+	m.register(MetricLikelyCanaries, metricTypeCounter, "Number of findings that are likely canary tokens, by the canaries.action taken")
+
+	// unlabelled counters are exported from the start so rate() works
+	for _, name := range []string{MetricRepositoriesCloned, MetricCloneFailures, MetricFilesScanned, MetricBytesProcessed, MetricForkDuplicates} {
+		m.Add(name, 0)
+	}
+
+	return m
+}
+
+func (m *Metrics) register(name string, kind string, help string) {
+	m.metrics[name] = &metric{help: help, kind: kind, values: make(map[string]float64)}
+}
+
+// Add increases a counter by value. labels are name, value pairs
+func (m *Metrics) Add(name string, value float64, labels ...string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if metric, ok := m.metrics[name]; ok {
+		metric.values[strings.Join(labels, metricLabelSeparator)] += value
+	}
+}
+
+func (m *Metrics) Inc(name string, labels ...string) {
+	m.Add(name, 1, labels...)
+}
+
+// Set sets a gauge to value. labels are name, value pairs
+func (m *Metrics) Set(name string, value float64, labels ...string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if metric, ok := m.metrics[name]; ok {
+		metric.values[strings.Join(labels, metricLabelSeparator)] = value
+	}
+}
+
+// Total sums a metric across all of its labels
+func (m *Metrics) Total(name string) float64 {
+	m.Lock()
+	defer m.Unlock()
+
+	total := 0.0
+	if metric, ok := m.metrics[name]; ok {
+		for _, value := range metric.values {
+			total += value
+		}
+	}
+
+	return total
+}
+
+// ByLabel sums a metric by the value of one of its labels
+func (m *Metrics) ByLabel(name string, label string) map[string]float64 {
+	m.Lock()
+	defer m.Unlock()
+
+	values := map[string]float64{}
+	if metric, ok := m.metrics[name]; ok {
+		for key, value := range metric.values {
+			labels := strings.Split(key, metricLabelSeparator)
+			for i := 0; i+1 < len(labels); i += 2 {
+				if labels[i] == label {
+					values[labels[i+1]] += value
+				}
+			}
+		}
+	}
+
+	return values
+}
+
+func (m *Metrics) Render(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+
+	names := make([]string, 0, len(m.metrics))
+	for name := range m.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		metric := m.metrics[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, metric.help, name, metric.kind)
+
+		keys := make([]string, 0, len(metric.values))
+		for key := range metric.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", name, formatMetricLabels(key), metric.values[key])
+		}
+	}
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	m.Render(w)
+}
+
+func formatMetricLabels(key string) string {
+	if key == "" {
+		return ""
+	}
+
+	labels := strings.Split(key, metricLabelSeparator)
+	pairs := make([]string, 0, len(labels)/2)
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], escaper.Replace(labels[i+1])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const notebookExtension = ".ipynb"
+
+type notebook struct {
+	Cells []notebookCell `json:"cells"`
+}
+
+type notebookCell struct {
+	Source  notebookText     `json:"source"`
+	Outputs []notebookOutput `json:"outputs"`
+}
+
+type notebookOutput struct {
+	Text      notebookText            `json:"text"`
+	Data      map[string]notebookText `json:"data"`
+	Evalue    string                  `json:"evalue"`
+	Traceback []string                `json:"traceback"`
+}
+
+// notebookText is text saved either whole or as a list of lines
+type notebookText string
+
+func (t *notebookText) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*t = notebookText(text)
+		return nil
+	}
+
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err != nil {
+		// other outputs, i.e. application/json, aren't text
+		return nil
+	}
+
+	*t = notebookText(strings.Join(lines, ""))
+	return nil
+}
+
+func IsNotebook(filePath string) bool {
+	return strings.ToLower(path.Ext(filePath)) == notebookExtension
+}
+
+// ExpandNotebooks returns files with the cells of any Jupyter notebooks among
+// them as entries of their own, i.e. /analysis.ipynb!/cells/3/source and
+// /analysis.ipynb!/cells/3/outputs, so contents signatures and the entropy
+// check run over the code and what it printed rather than the notebook's
+// JSON. Notebooks are kept, without their contents, for path signatures.
+// Those that can't be parsed are scanned as they are
+func ExpandNotebooks(files []MatchFile) []MatchFile {
+	expanded := make([]MatchFile, 0, len(files))
+
+	for _, file := range files {
+		if !IsNotebook(file.Path) || file.Lazy {
+			expanded = append(expanded, file)
+			continue
+		}
+
+		var nb notebook
+		if err := json.Unmarshal(file.Contents, &nb); err != nil || nb.Cells == nil {
+			expanded = append(expanded, file)
+			continue
+		}
+
+		cells := file
+		cells.Contents = nil
+		expanded = append(expanded, cells)
+
+		for i, cell := range nb.Cells {
+			add := func(name string, text string) {
+				if strings.TrimSpace(text) == "" {
+					return
+				}
+
+				expanded = append(expanded, MatchFile{
+					Path:     fmt.Sprintf("%s%scells/%d/%s", file.Path, ArchivePathSeparator, i+1, name),
+					Filename: name,
+					Contents: []byte(text),
+					Commit:   file.Commit,
+				})
+			}
+
+			add("source", string(cell.Source))
+			add("outputs", cell.outputText())
+		}
+	}
+
+	return expanded
+}
+
+// outputText joins the text a cell printed, returned and raised, leaving out
+// images and other binary outputs
+func (cell notebookCell) outputText() string {
+	var text strings.Builder
+	writeLine := func(line string) {
+		text.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			text.WriteString("\n")
+		}
+	}
+
+	for _, output := range cell.Outputs {
+		if output.Text != "" {
+			writeLine(string(output.Text))
+		}
+
+		mimeTypes := make([]string, 0, len(output.Data))
+		for mimeType := range output.Data {
+			if strings.HasPrefix(mimeType, "text/") {
+				mimeTypes = append(mimeTypes, mimeType)
+			}
+		}
+		sort.Strings(mimeTypes)
+
+		for _, mimeType := range mimeTypes {
+			writeLine(string(output.Data[mimeType]))
+		}
+
+		if output.Evalue != "" {
+			writeLine(output.Evalue)
+		}
+
+		for _, line := range output.Traceback {
+			writeLine(line)
+		}
+	}
+
+	return text.String()
+}
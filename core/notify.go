@@ -0,0 +1,336 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const slackPostMessageUrl = "https://slack.com/api/chat.postMessage"
+
+var discordSeverityColors = map[string]int{
+	SeverityCritical: 0xe74c3c,
+	SeverityHigh:     0xe67e22,
+	SeverityMedium:   0xf1c40f,
+	SeverityLow:      0x95a5a6,
+}
+
+// Adaptive Card text colors
+var teamsSeverityColors = map[string]string{
+	SeverityCritical: "Attention",
+	SeverityHigh:     "Warning",
+	SeverityMedium:   "Accent",
+	SeverityLow:      "Default",
+}
+
+// SinkFilter routes findings to a sink. An empty filter accepts everything
+type SinkFilter struct {
+	Signatures      []string `yaml:"signatures"`
+	MinimumSeverity string   `yaml:"minimum_severity,omitempty"`
+	// only findings in files mentioning one of these watch_terms
+	WatchTerms []string `yaml:"watch_terms"`
+}
+
+func (f SinkFilter) Accepts(event *MatchEvent) bool {
+	if !AtLeastSeverity(event.Severity, f.MinimumSeverity) {
+		return false
+	}
+
+	if len(f.WatchTerms) > 0 && !containsAnyFold(f.WatchTerms, event.WatchTerms) {
+		return false
+	}
+
+	if len(f.Signatures) == 0 {
+		return true
+	}
+
+	for _, signature := range f.Signatures {
+		if strings.EqualFold(signature, event.Signature) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SlackSink posts a message per finding to an incoming webhook, or to a
+// channel with a bot token
+type SlackSink struct {
+	config SlackConfig
+	client *http.Client
+}
+
+func NewSlackSink(config SlackConfig) *SlackSink {
+	return &SlackSink{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *SlackSink) Name() string {
+	if s.config.Channel != "" {
+		return "Slack " + s.config.Channel
+	}
+
+	return "Slack"
+}
+
+func (s *SlackSink) Send(event *MatchEvent) error {
+	if !s.config.Accepts(event) {
+		return nil
+	}
+
+	text := fmt.Sprintf(":rotating_light: *%s* found in %s\n*File:* `%s`\n*Severity:* %s (%s confidence)", event.Signature, event.Url, event.File, event.Severity, event.Confidence)
+	if event.Metadata != nil {
+		text += fmt.Sprintf("\n*Repository:* %s", event.Metadata)
+	}
+
+	if event.LikelyCanary {
+		text += fmt.Sprintf("\n*Likely canary:* %s", event.CanaryReason)
+	}
+	if snippet := notificationSnippet(event); snippet != "" {
+		text += fmt.Sprintf("\n*Match:* `%s`", snippet)
+	}
+
+	payload := map[string]string{"text": text}
+	endpoint := s.config.WebhookUrl
+	var check func(*http.Response) error
+
+	if s.config.Token != "" {
+		payload["channel"] = s.config.Channel
+		endpoint = slackPostMessageUrl
+		check = checkSlackResponse
+	}
+
+	return postNotification(s.client, endpoint, s.config.Token, payload, check)
+}
+
+// DiscordSink posts an embed per finding to a Discord webhook
+type DiscordSink struct {
+	config DiscordConfig
+	client *http.Client
+}
+
+func NewDiscordSink(config DiscordConfig) *DiscordSink {
+	return &DiscordSink{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (d *DiscordSink) Name() string {
+	return "Discord"
+}
+
+func (d *DiscordSink) Send(event *MatchEvent) error {
+	if !d.config.Accepts(event) {
+		return nil
+	}
+
+	type field struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	fields := []field{
+		{Name: "Repository", Value: event.Url},
+		{Name: "File", Value: "`" + event.File + "`"},
+		{Name: "Severity", Value: fmt.Sprintf("%s (%s confidence)", event.Severity, event.Confidence)},
+	}
+
+	if event.Metadata != nil {
+		fields = append(fields, field{Name: "Repository details", Value: event.Metadata.String()})
+	}
+
+	if event.LikelyCanary {
+		fields = append(fields, field{Name: "Likely canary", Value: event.CanaryReason})
+	}
+
+	if snippet := notificationSnippet(event); snippet != "" {
+		fields = append(fields, field{Name: "Match", Value: "`" + snippet + "`"})
+	}
+
+	payload := map[string]interface{}{
+		"username": Name,
+		"embeds": []map[string]interface{}{{
+			"title":  event.Signature,
+			"color":  discordSeverityColors[event.Severity],
+			"fields": fields,
+		}},
+	}
+
+	return postNotification(d.client, d.config.WebhookUrl, "", payload, nil)
+}
+
+// TeamsSink posts an Adaptive Card per finding to a Microsoft Teams
+// incoming webhook
+type TeamsSink struct {
+	config TeamsConfig
+	client *http.Client
+}
+
+func NewTeamsSink(config TeamsConfig) *TeamsSink {
+	return &TeamsSink{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (t *TeamsSink) Name() string {
+	return "Teams"
+}
+
+func (t *TeamsSink) Send(event *MatchEvent) error {
+	if !t.config.Accepts(event) {
+		return nil
+	}
+
+	facts := []map[string]string{
+		{"title": "Repository", "value": event.Url},
+		{"title": "File", "value": event.File},
+		{"title": "Severity", "value": fmt.Sprintf("%s (%s confidence)", event.Severity, event.Confidence)},
+	}
+
+	if event.Metadata != nil {
+		facts = append(facts, map[string]string{"title": "Repository details", "value": event.Metadata.String()})
+	}
+
+	if event.LikelyCanary {
+		facts = append(facts, map[string]string{"title": "Likely canary", "value": event.CanaryReason})
+	}
+
+	if snippet := notificationSnippet(event); snippet != "" {
+		facts = append(facts, map[string]string{"title": "Match", "value": snippet})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []map[string]interface{}{
+			{"type": "TextBlock", "text": event.Signature, "weight": "Bolder", "size": "Medium", "color": teamsSeverityColors[event.Severity], "wrap": true},
+			{"type": "FactSet", "facts": facts},
+		},
+		"actions": []map[string]string{
+			{"type": "Action.OpenUrl", "title": "Open repository", "url": event.Url},
+		},
+	}
+
+	payload := map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	}
+
+	return postNotification(t.client, t.config.WebhookUrl, "", payload, nil)
+}
+
+// MattermostSink posts an attachment per finding to a Mattermost incoming
+// webhook
+type MattermostSink struct {
+	config MattermostConfig
+	client *http.Client
+}
+
+func NewMattermostSink(config MattermostConfig) *MattermostSink {
+	return &MattermostSink{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (m *MattermostSink) Name() string {
+	if m.config.Channel != "" {
+		return "Mattermost " + m.config.Channel
+	}
+
+	return "Mattermost"
+}
+
+func (m *MattermostSink) Send(event *MatchEvent) error {
+	if !m.config.Accepts(event) {
+		return nil
+	}
+
+	type field struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+
+	fields := []field{
+		{Title: "Repository", Value: event.Url},
+		{Title: "File", Value: "`" + event.File + "`", Short: true},
+		{Title: "Severity", Value: fmt.Sprintf("%s (%s confidence)", event.Severity, event.Confidence), Short: true},
+	}
+
+	if event.Metadata != nil {
+		fields = append(fields, field{Title: "Repository details", Value: event.Metadata.String()})
+	}
+
+	if event.LikelyCanary {
+		fields = append(fields, field{Title: "Likely canary", Value: event.CanaryReason})
+	}
+
+	if snippet := notificationSnippet(event); snippet != "" {
+		fields = append(fields, field{Title: "Match", Value: "`" + snippet + "`"})
+	}
+
+	payload := map[string]interface{}{
+		"username": Name,
+		"attachments": []map[string]interface{}{{
+			"fallback": fmt.Sprintf("%s found in %s", event.Signature, event.Url),
+			"title":    event.Signature,
+			"color":    fmt.Sprintf("#%06x", discordSeverityColors[event.Severity]),
+			"fields":   fields,
+		}},
+	}
+
+	if m.config.Channel != "" {
+		payload["channel"] = m.config.Channel
+	}
+
+	return postNotification(m.client, m.config.WebhookUrl, "", payload, nil)
+}
+
+// notificationSnippet is the redacted first match of a finding, so chat
+// notifications don't become a leak of their own
+func notificationSnippet(event *MatchEvent) string {
+	if len(event.Matches) == 0 {
+		return ""
+	}
+
+	return Redact(event.Matches[0])
+}
+
+// checkSlackResponse fails calls the Slack Web API rejected, i.e. with a bad
+// token or channel, as it responds 200 and reports failures in the body
+func checkSlackResponse(resp *http.Response) error {
+	result := struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	if !result.Ok {
+		return fmt.Errorf("slack returned %s", result.Error)
+	}
+
+	return nil
+}
+
+func postNotification(client *http.Client, endpoint string, token string, payload interface{}, check func(*http.Response) error) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return PostWithRetryChecked(client, defaultWebhookRetries, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		return req, nil
+	}, check)
+}
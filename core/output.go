@@ -0,0 +1,226 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// MatchEvent is a single finding, as published to the live feed and
+// written to the configured output format
+type MatchEvent struct {
+	Url          string
+	Parent       string // repository Url is a fork or mirror of, if known
+	Matches      []string
+	Lines        []int
+	Columns      []int
+	Offsets      []int
+	Keys         []string        // dotted key paths of matches in config files, if any
+	PrivateKeys  []*PrivateKey   // details of matches that are private keys, if any
+	Tokens       []*JsonWebToken // claims of matches that are JWTs, if any
+	Attributions []*Attribution  // commits matches were added in, if known
+	WatchTerms   []string        // names of the watch_terms mentioned in the file
+	Tenants      []string        // names of the tenants the finding belongs to
+	Fingerprints []string
+	Signature    string
+	File         string
+	Stars        int
+	Metadata     *RepositoryMetadata // GitHub details of the repository, if it was looked up
+	Source       GitResourceType
+	Verified     bool
+	Revoked      bool
+	LikelyCanary bool
+	CanaryReason string // why the finding is likely a canary token
+	Branch       string
+	Commit       string
+	Entropy      float64
+	Severity     string
+	Confidence   string
+}
+
+// Sink is an output that receives every finding, i.e. a webhook. Send may
+// block while retrying; it is called from the output workers
+type Sink interface {
+	Name() string
+	Send(event *MatchEvent) error
+}
+
+// RedactMatches masks matched secrets before they are logged or sent
+// anywhere when redact_secrets is enabled
+func (s *Session) RedactMatches(matches []string) []string {
+	if !*s.Options.RedactSecrets {
+		return matches
+	}
+
+	redacted := make([]string, len(matches))
+	for i, match := range matches {
+		redacted[i] = Redact(match)
+	}
+
+	return redacted
+}
+
+// IsReportable reports whether findings of this severity meet
+// --minimum-severity
+func (s *Session) IsReportable(severity string) bool {
+	return AtLeastSeverity(severity, *s.Options.MinimumSeverity)
+}
+
+// RecordFailure counts the finding towards failing a local scan or hook if
+// it is of at least --fail-on-severity
+func (s *Session) RecordFailure(event *MatchEvent) {
+	if AtLeastSeverity(event.Severity, *s.Options.FailOn) {
+		atomic.AddInt64(&s.failures, 1)
+	}
+}
+
+// Failures is how many findings count towards failing a local scan or hook
+func (s *Session) Failures() int {
+	return int(atomic.LoadInt64(&s.failures))
+}
+
+// Failed reports whether a local scan or hook should exit with --exit-code,
+// having more than --max-findings of at least --fail-on-severity
+func (s *Session) Failed() bool {
+	return s.Failures() > *s.Options.MaxFindings
+}
+
+// Publish queues the finding for the output workers, blocking when they
+// have fallen behind by more than workers.queue_size findings
+func (s *Session) Publish(event *MatchEvent) {
+	s.ScanRequests.Record(event)
+
+	if len(s.Sinks) == 0 && len(event.Tenants) == 0 {
+		return
+	}
+
+	s.publishing.Add(1)
+	s.findings <- event
+}
+
+func (s *Session) processFindings() {
+	for event := range s.findings {
+		for _, sink := range s.Sinks {
+			if err := sink.Send(event); err != nil {
+				s.Log.Warn("Failed to send finding to %s: %s", sink.Name(), err)
+			}
+		}
+		s.sendToTenants(event)
+
+		s.publishing.Done()
+	}
+}
+
+// OutputWriter writes findings to --output-path in a --format
+type OutputWriter interface {
+	Write(event *MatchEvent) error
+}
+
+// FlushingWriter is an OutputWriter that keeps findings in memory until
+// flushed
+type FlushingWriter interface {
+	OutputWriter
+	Flush() error
+}
+
+// OutputFormats are the formats findings can be written to a file in
+var OutputFormats = []string{FormatSarif, FormatJsonl, FormatJunit, FormatCsv, FormatGitHubActions}
+
+// NewOutputWriter opens a writer of findings in a format. Every format but
+// github-actions, which prints to stdout, writes to path
+func NewOutputWriter(format string, path string, csvFields []string, signatures []Signature) (OutputWriter, error) {
+	if format == FormatGitHubActions {
+		return NewGitHubActionsWriter(), nil
+	}
+
+	if path == "" {
+		return nil, fmt.Errorf("a path is required for the %s format", format)
+	}
+
+	switch format {
+	case FormatSarif:
+		return NewSarifWriter(path, signatures)
+	case FormatJsonl:
+		return NewJsonlWriter(path)
+	case FormatJunit:
+		return NewJunitWriter(path)
+	case FormatCsv:
+		return NewCsvWriter(path, csvFields)
+	}
+
+	return nil, fmt.Errorf("unknown format %s. Available formats: %s", format, strings.Join(OutputFormats, ", "))
+}
+
+// OutputSink writes the findings it accepts to a file, so several files can
+// be written at once, each in its own format and with its own filter
+type OutputSink struct {
+	config OutputConfig
+	writer OutputWriter
+}
+
+func NewOutputSink(config OutputConfig, signatures []Signature) (*OutputSink, error) {
+	fields, err := ParseCsvFields(strings.Join(config.CsvFields, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := NewOutputWriter(config.Format, config.Path, fields, signatures)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutputSink{config: config, writer: writer}, nil
+}
+
+func (o *OutputSink) Name() string {
+	if o.config.Path == "" {
+		return o.config.Format + " output"
+	}
+
+	return fmt.Sprintf("%s output %s", o.config.Format, o.config.Path)
+}
+
+func (o *OutputSink) Send(event *MatchEvent) error {
+	if !o.config.Accepts(event) {
+		return nil
+	}
+
+	return o.writer.Write(event)
+}
+
+func (o *OutputSink) Flush() error {
+	if flushing, ok := o.writer.(FlushingWriter); ok {
+		return flushing.Flush()
+	}
+
+	return nil
+}
+
+// FlushingSink is a Sink that buffers findings and sends them in batches
+type FlushingSink interface {
+	Sink
+	Flush() error
+}
+
+// WaitForSinks blocks until every finding published so far has been sent,
+// so nothing is lost when exiting after a local scan
+func (s *Session) WaitForSinks() {
+	s.publishing.Wait()
+
+	sinks := s.Sinks
+	for _, tenant := range s.Tenants {
+		sinks = append(sinks, tenant.sinks...)
+	}
+
+	for _, sink := range sinks {
+		if flushing, ok := sink.(FlushingSink); ok {
+			if err := flushing.Flush(); err != nil {
+				s.Log.Warn("Failed to send findings to %s: %s", sink.Name(), err)
+			}
+		}
+	}
+
+	if flushing, ok := s.OutputWriter.(FlushingWriter); ok {
+		LogIfError("Could not write to "+*s.Options.OutputPath, flushing.Flush())
+	}
+}
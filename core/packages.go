@@ -0,0 +1,240 @@
+package core
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	NpmRegistry      = "npm"
+	PypiRegistry     = "pypi"
+	RubygemsRegistry = "rubygems"
+)
+
+type NpmChanges struct {
+	Results []struct {
+		Id      string `json:"id"`
+		Deleted bool   `json:"deleted"`
+	} `json:"results"`
+	LastSeq interface{} `json:"last_seq"`
+}
+
+type NpmVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Dist    struct {
+		Tarball string `json:"tarball"`
+	} `json:"dist"`
+}
+
+type PypiRelease struct {
+	Urls []struct {
+		Url         string `json:"url"`
+		PackageType string `json:"packagetype"`
+	} `json:"urls"`
+}
+
+type RubygemsVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	GemUri  string `json:"gem_uri"`
+}
+
+func (p PackagesConfig) Watches(registry string) bool {
+	for _, r := range p.Registries {
+		if strings.EqualFold(r, registry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func queuePackage(session *Session, observedKeys map[string]bool, key string, artifactUrl string) {
+	if observedKeys[key] || artifactUrl == "" {
+		return
+	}
+
+	observedKeys[key] = true
+	session.Repositories <- GitResource{
+		Type: PACKAGE_SOURCE,
+		Url:  artifactUrl,
+	}
+}
+
+// GetNpmPackages follows the npm registry's CouchDB changes feed from when
+// shhgit started and queues the tarball of the latest version of each
+// package published or updated
+func GetNpmPackages(session *Session) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	config := session.Config.Packages
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[PACKAGE_SOURCE]
+	since := "now"
+	if cursor := session.Checkpoint.Cursor("npm"); cursor != "" {
+		since = cursor
+	}
+
+	for poller := session.NewPoller(PollingPackages); ; {
+		changes := NpmChanges{}
+		req, err := http.NewRequest("GET", config.NpmChangesUrl+"?"+url.Values{"since": {since}, "limit": {strconv.Itoa(poller.PageSize())}}.Encode(), nil)
+		if err == nil {
+			err = GetJSON(limiter, req, &changes)
+		}
+
+		if err != nil {
+			session.Log.Warn("Error getting npm changes: %s... trying again", err)
+		} else if changes.LastSeq != nil {
+			since = fmt.Sprint(changes.LastSeq)
+		}
+
+		for _, change := range changes.Results {
+			if change.Deleted || strings.HasPrefix(change.Id, "_design/") {
+				continue
+			}
+
+			version := NpmVersion{}
+			req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s/latest", strings.TrimRight(config.NpmUrl, "/"), url.PathEscape(change.Id)), nil)
+			if err == nil {
+				err = GetJSON(limiter, req, &version)
+			}
+
+			if err != nil {
+				session.Log.Debug("Failed to retrieve npm package %s: %s", change.Id, err)
+				continue
+			}
+
+			queuePackage(session, observedKeys, NpmRegistry+version.Name+"@"+version.Version, version.Dist.Tarball)
+		}
+
+		if since != "now" {
+			session.Checkpoint.SetCursor("npm", since)
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// GetPypiPackages polls the PyPI updates RSS feed and queues the source
+// distribution of each new release, or its first wheel if it has none
+func GetPypiPackages(session *Session) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	config := session.Config.Packages
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[PACKAGE_SOURCE]
+
+	for poller := session.NewPoller(PollingPackages); ; {
+		feed := struct {
+			Items []struct {
+				Title string `xml:"title"`
+			} `xml:"channel>item"`
+		}{}
+
+		body, err := getRaw(limiter, strings.TrimRight(config.PypiUrl, "/")+"/rss/updates.xml")
+		if err == nil {
+			err = xml.Unmarshal([]byte(body), &feed)
+		}
+
+		if err != nil {
+			session.Log.Warn("Error getting PyPI updates: %s... trying again", err)
+		}
+
+		for _, item := range feed.Items {
+			// titles are "<name> <version>"
+			parts := strings.Fields(item.Title)
+			if len(parts) != 2 || observedKeys[PypiRegistry+item.Title] {
+				continue
+			}
+
+			release := PypiRelease{}
+			req, err := http.NewRequest("GET", fmt.Sprintf("%s/pypi/%s/%s/json", strings.TrimRight(config.PypiUrl, "/"), url.PathEscape(parts[0]), url.PathEscape(parts[1])), nil)
+			if err == nil {
+				err = GetJSON(limiter, req, &release)
+			}
+
+			if err != nil {
+				session.Log.Debug("Failed to retrieve PyPI release %s: %s", item.Title, err)
+				continue
+			}
+
+			artifactUrl := ""
+			for _, artifact := range release.Urls {
+				if artifact.PackageType == "sdist" {
+					artifactUrl = artifact.Url
+					break
+				} else if artifactUrl == "" && artifact.PackageType == "bdist_wheel" {
+					artifactUrl = artifact.Url
+				}
+			}
+
+			queuePackage(session, observedKeys, PypiRegistry+item.Title, artifactUrl)
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// GetRubygemsPackages polls the RubyGems just updated activity feed and
+// queues each new gem version
+func GetRubygemsPackages(session *Session) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	config := session.Config.Packages
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[PACKAGE_SOURCE]
+
+	for poller := session.NewPoller(PollingPackages); ; {
+		versions := make([]RubygemsVersion, 0)
+		req, err := http.NewRequest("GET", strings.TrimRight(config.RubygemsUrl, "/")+"/api/v1/activity/just_updated.json", nil)
+		if err == nil {
+			err = GetJSON(limiter, req, &versions)
+		}
+
+		if err != nil {
+			session.Log.Warn("Error getting RubyGems updates: %s... trying again", err)
+		}
+
+		for _, version := range versions {
+			queuePackage(session, observedKeys, RubygemsRegistry+version.Name+"@"+version.Version, version.GemUri)
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
+
+// GetPackageFiles downloads a package in to memory and extracts it, along
+// with any archives inside it such as a gem's data.tar.gz. Packages larger
+// than --maximum-repository-size are skipped
+func GetPackageFiles(session *Session, artifactUrl string, dir string) ([]MatchFile, error) {
+	session.RateLimiters[PACKAGE_SOURCE].Wait()
+
+	return GetArchiveFiles(session, artifactUrl, PACKAGE_SOURCE, dir)
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Paste is the contents of a paste site post, scanned like an issue comment
+type Paste struct {
+	Site     string
+	Url      string
+	Contents string
+}
+
+type PastebinPaste struct {
+	Key       string `json:"key"`
+	FullUrl   string `json:"full_url"`
+	ScrapeUrl string `json:"scrape_url"`
+	Size      string `json:"size"`
+}
+
+// GetPastebinPastes polls the Pastebin scraping API for the newest public
+// pastes. The scraping API needs a PRO account with a whitelisted IP
+func GetPastebinPastes(session *Session) {
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	observedKeys := map[string]bool{}
+	limiter := session.RateLimiters[PASTE_SOURCE]
+	endpoint := strings.TrimRight(session.Config.Pastebin.ScrapeUrl, "/")
+	maxSize := int64(*session.Options.MaximumFileSize) * 1024
+
+	for poller := session.NewPoller(PollingPastebin); ; {
+		pastes := make([]PastebinPaste, 0)
+		req, err := http.NewRequest("GET", endpoint+"/api_scraping.php?limit=100", nil)
+		if err == nil {
+			err = GetJSON(limiter, req, &pastes)
+		}
+
+		if err != nil {
+			session.Log.Warn("Error getting Pastebin pastes: %s... trying again", err)
+		}
+
+		for _, paste := range pastes {
+			if observedKeys[paste.Key] {
+				continue
+			}
+
+			observedKeys[paste.Key] = true
+			if size, err := strconv.ParseInt(paste.Size, 10, 64); err == nil && size > maxSize {
+				continue
+			}
+
+			body, err := getRaw(limiter, endpoint+"/api_scrape_item.php?i="+url.QueryEscape(paste.Key))
+			if err != nil {
+				session.Log.Debug("Failed to retrieve paste %s: %s", paste.FullUrl, err)
+				continue
+			}
+
+			session.Pastes <- Paste{Site: "Pastebin", Url: paste.FullUrl, Contents: body}
+		}
+
+		select {
+		case <-poller.Next():
+			continue
+		case <-localCtx.Done():
+			cancel()
+			return
+		}
+	}
+}
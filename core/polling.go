@@ -0,0 +1,192 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	PollingGitHub      = "github"
+	PollingGitLab      = "gitlab"
+	PollingGitea       = "gitea"
+	PollingBitbucket   = "bitbucket"
+	PollingAzureDevOps = "azure_devops"
+	PollingDocker      = "docker"
+	PollingPastebin    = "pastebin"
+	PollingPackages    = "packages"
+
+	// seconds between polls unless configured
+	defaultPollingInterval = 30
+	// the longest interval is this many times the interval unless configured
+	defaultPollingMaxIntervalFactor = 10
+)
+
+// PollingSources are the providers whose polling can be configured under
+// polling, with the page size each asks for by default
+var PollingSources = map[string]int{
+	PollingGitHub:      300,
+	PollingGitLab:      100,
+	PollingGitea:       50,
+	PollingBitbucket:   100,
+	PollingAzureDevOps: 50,
+	PollingDocker:      100,
+	PollingPastebin:    100,
+	PollingPackages:    100,
+}
+
+// PollingConfig is how often a provider's feeds are polled. Intervals are
+// in seconds. Where the provider reports its rate limit, the interval is
+// stretched towards max_interval when the calls left wouldn't last until it
+// resets, and shortened towards min_interval when they'd be wasted
+type PollingConfig struct {
+	Interval    int `yaml:"interval"`
+	MinInterval int `yaml:"min_interval"`
+	MaxInterval int `yaml:"max_interval"`
+	PageSize    int `yaml:"page_size"`
+	// up to this many seconds are added to or taken from each interval at
+	// random, so instances polling the same feed drift apart
+	Jitter int `yaml:"jitter"`
+}
+
+// validatePolling fills in the defaults of every provider's polling
+func (c *Config) validatePolling() error {
+	if c.Polling == nil {
+		c.Polling = make(map[string]PollingConfig)
+	}
+
+	for source := range c.Polling {
+		if _, ok := PollingSources[source]; !ok {
+			return fmt.Errorf("polling can only be configured for %s, not %s", strings.Join(pollingSourceNames(), ", "), source)
+		}
+	}
+
+	for source, pageSize := range PollingSources {
+		polling := c.Polling[source]
+
+		if polling.Interval <= 0 {
+			polling.Interval = defaultPollingInterval
+		}
+
+		if polling.MinInterval <= 0 || polling.MinInterval > polling.Interval {
+			polling.MinInterval = polling.Interval
+		}
+
+		if polling.MaxInterval <= 0 {
+			polling.MaxInterval = polling.Interval * defaultPollingMaxIntervalFactor
+		} else if polling.MaxInterval < polling.Interval {
+			return fmt.Errorf("polling.%s.max_interval can't be less than its interval", source)
+		}
+
+		if polling.PageSize <= 0 {
+			polling.PageSize = pageSize
+		}
+
+		if polling.Jitter < 0 || polling.Jitter >= polling.MinInterval {
+			return fmt.Errorf("polling.%s.jitter must be less than its interval", source)
+		}
+
+		c.Polling[source] = polling
+	}
+
+	return nil
+}
+
+func pollingSourceNames() []string {
+	names := make([]string, 0, len(PollingSources))
+	for source := range PollingSources {
+		names = append(names, source)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Poller paces the polls of a feed. Next is waited on between polls, in
+// place of a time.Tick
+type Poller struct {
+	config PollingConfig
+	// when the poll being made started
+	started time.Time
+	// the calls left until the rate limit resets, nil if not reported
+	budget func() (int, time.Time)
+	// the budget as of the start of the poll
+	remaining int
+	reset     time.Time
+}
+
+// NewPoller paces the polls of a feed of source, starting with the first
+func (s *Session) NewPoller(source string) *Poller {
+	return &Poller{config: s.Config.Polling[source], started: time.Now()}
+}
+
+// NewGitHubPoller paces the polls of a feed of a GitHub instance by the
+// rate limit left across its tokens
+func (s *Session) NewGitHubPoller(instance *GitHubInstance) *Poller {
+	poller := s.NewPoller(PollingGitHub)
+	poller.budget = instance.RateBudget
+	poller.remaining, poller.reset = poller.budget()
+
+	return poller
+}
+
+// PageSize is how many items to ask for per page
+func (p *Poller) PageSize() int {
+	return p.config.PageSize
+}
+
+// Interval is how long to leave between the start of this poll and the
+// next. The calls made over the last interval, by this feed and any other
+// sharing the rate limit, are assumed to carry on at the same rate. If the
+// calls left would run out before the reset, the interval is stretched so
+// they last, or shortened if they'd be left over
+func (p *Poller) Interval() time.Duration {
+	interval := time.Duration(p.config.Interval) * time.Second
+	if p.budget == nil {
+		return interval
+	}
+
+	remaining, reset := p.budget()
+	used := p.remaining - remaining
+	sameWindow := reset.Equal(p.reset)
+	p.remaining, p.reset = remaining, reset
+
+	untilReset := time.Until(reset)
+	if !sameWindow || used <= 0 || untilReset <= 0 {
+		return interval
+	}
+
+	if remaining <= 0 {
+		return time.Duration(p.config.MaxInterval) * time.Second
+	}
+
+	// polls left at this rate, spread evenly until the reset
+	interval = time.Duration(float64(untilReset) * float64(used) / float64(remaining))
+
+	if min := time.Duration(p.config.MinInterval) * time.Second; interval < min {
+		return min
+	} else if max := time.Duration(p.config.MaxInterval) * time.Second; interval > max {
+		return max
+	}
+
+	return interval
+}
+
+// Next fires when the next poll is due: the interval after the start of
+// this one, give or take the jitter
+func (p *Poller) Next() <-chan time.Time {
+	interval := p.Interval()
+	if jitter := time.Duration(p.config.Jitter) * time.Second; jitter > 0 {
+		interval += time.Duration(rand.Int63n(int64(2*jitter))) - jitter
+	}
+
+	next := p.started.Add(interval)
+	if now := time.Now(); next.Before(now) {
+		next = now
+	}
+
+	p.started = next
+	return time.After(time.Until(next))
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// literals a regex requires are only used when there are no more than
+	// this many alternatives, i.e. (AKIA|ASIA|AGPA)
+	maximumPrefilterLiterals = 64
+	// shorter literals are in too many files to rule much out
+	minimumPrefilterLiteral = 3
+)
+
+// prefilter finds the contents signatures that could match a file in a
+// single pass over it, an Aho-Corasick automaton of the literals every
+// match of their regexes must contain. Literals are matched in ASCII lower
+// case, so finding one only means the regex has to be run. Signatures
+// without literals to look for are always run
+type prefilter struct {
+	nodes  []prefilterNode
+	always []int
+}
+
+type prefilterNode struct {
+	next map[byte]int32
+	fail int32
+	// indexes of the signatures with a literal ending here
+	signatures []int
+}
+
+// newPrefilter builds the automaton of the contents signatures at indexes
+// of signatures
+func newPrefilter(signatures []Signature, indexes []int) *prefilter {
+	p := &prefilter{nodes: []prefilterNode{{next: make(map[byte]int32)}}}
+
+	for _, i := range indexes {
+		signature, ok := signatures[i].(PatternSignature)
+		if !ok {
+			p.always = append(p.always, i)
+			continue
+		}
+
+		literals, ok := regexLiterals(signature.match.String())
+		if !ok {
+			p.always = append(p.always, i)
+			continue
+		}
+
+		for _, literal := range literals {
+			p.add(literal, i)
+		}
+	}
+
+	p.link()
+	return p
+}
+
+func (p *prefilter) add(literal string, signature int) {
+	node := int32(0)
+	for i := 0; i < len(literal); i++ {
+		next, ok := p.nodes[node].next[literal[i]]
+		if !ok {
+			next = int32(len(p.nodes))
+			p.nodes = append(p.nodes, prefilterNode{next: make(map[byte]int32)})
+			p.nodes[node].next[literal[i]] = next
+		}
+		node = next
+	}
+
+	p.nodes[node].signatures = append(p.nodes[node].signatures, signature)
+}
+
+// link sets the failure links breadth first, and merges in the signatures
+// of the literals that end at the node each links to
+func (p *prefilter) link() {
+	queue := make([]int32, 0, len(p.nodes))
+	for _, child := range p.nodes[0].next {
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		for b, child := range p.nodes[node].next {
+			fail := p.nodes[node].fail
+			for {
+				if next, ok := p.nodes[fail].next[b]; ok {
+					p.nodes[child].fail = next
+					break
+				}
+
+				if fail == 0 {
+					break
+				}
+				fail = p.nodes[fail].fail
+			}
+
+			p.nodes[child].signatures = append(p.nodes[child].signatures, p.nodes[p.nodes[child].fail].signatures...)
+			queue = append(queue, child)
+		}
+	}
+}
+
+// candidates returns which signatures could match contents, by index
+func (p *prefilter) candidates(contents []byte, size int) []bool {
+	found := make([]bool, size)
+	for _, i := range p.always {
+		found[i] = true
+	}
+
+	node := int32(0)
+	for _, b := range contents {
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+
+		for {
+			if next, ok := p.nodes[node].next[b]; ok {
+				node = next
+				break
+			}
+
+			if node == 0 {
+				break
+			}
+			node = p.nodes[node].fail
+		}
+
+		for _, i := range p.nodes[node].signatures {
+			found[i] = true
+		}
+	}
+
+	return found
+}
+
+// regexLiterals returns literals at least one of which every match of expr
+// contains, in ASCII lower case, or false if there aren't any worth
+// looking for
+func regexLiterals(expr string) ([]string, bool) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+
+	literals, ok := requiredLiterals(re.Simplify())
+	if !ok || len(literals) > maximumPrefilterLiterals {
+		return nil, false
+	}
+
+	for _, literal := range literals {
+		if len(literal) < minimumPrefilterLiteral {
+			return nil, false
+		}
+	}
+
+	return literals, true
+}
+
+func requiredLiterals(re *syntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		literal := string(re.Rune)
+		// lower casing anything but ASCII could change what matches
+		for _, r := range re.Rune {
+			if r >= utf8.RuneSelf {
+				return nil, false
+			}
+		}
+		return []string{strings.ToLower(literal)}, true
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min < 1 {
+			return nil, false
+		}
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpConcat:
+		// the longest literal in a sequence rules out the most files
+		var best []string
+		for _, sub := range re.Sub {
+			if literals, ok := requiredLiterals(sub); ok && shortestLiteral(literals) > shortestLiteral(best) {
+				best = literals
+			}
+		}
+		return best, best != nil
+	case syntax.OpAlternate:
+		var literals []string
+		for _, sub := range re.Sub {
+			alternative, ok := requiredLiterals(sub)
+			if !ok {
+				return nil, false
+			}
+			literals = append(literals, alternative...)
+		}
+		return literals, true
+	default:
+		return nil, false
+	}
+}
+
+func shortestLiteral(literals []string) int {
+	shortest := -1
+	for _, literal := range literals {
+		if shortest < 0 || len(literal) < shortest {
+			shortest = len(literal)
+		}
+	}
+
+	return shortest
+}
@@ -0,0 +1,307 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	PrivateKeyRsa     = "RSA"
+	PrivateKeyEcdsa   = "ECDSA"
+	PrivateKeyEd25519 = "Ed25519"
+	PrivateKeyDsa     = "DSA"
+
+	pemPrivateKeySuffix = "PRIVATE KEY"
+	openSshKeyMagic     = "openssh-key-v1\x00"
+	puttyKeyPrefix      = "PuTTY-User-Key-File-"
+)
+
+// PrivateKey describes a private key found in a file, so its owners can
+// match it against the authorized_keys and certificates it's trusted by.
+// Type, Bits and the fingerprints are unknown for keys whose public half is
+// encrypted along with them
+type PrivateKey struct {
+	Type      string `json:"type,omitempty"`
+	Bits      int    `json:"bits,omitempty"`
+	Encrypted bool   `json:"encrypted"`
+	// Fingerprint is of the public key as ssh-keygen -l shows it, i.e.
+	// SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// SpkiSha256 is the base64 SHA-256 of the public key's
+	// SubjectPublicKeyInfo, as pinned by HPKP and shown for certificates
+	SpkiSha256 string `json:"spki_sha256,omitempty"`
+}
+
+// ParsePrivateKeys returns the details of the private keys among matches, in
+// the same order, or nil if none of them are. Keys are read from the match
+// itself when it holds the whole key, or from the contents at its offset
+// when only the header was matched
+func ParsePrivateKeys(matches []ContentsMatch, contents []byte) []*PrivateKey {
+	var keys []*PrivateKey
+
+	for i, match := range matches {
+		key := ParsePrivateKey([]byte(match.Value))
+		if key == nil && match.Offset >= 0 && match.Offset < len(contents) {
+			key = ParsePrivateKey(contents[match.Offset:])
+		}
+
+		if key != nil {
+			if keys == nil {
+				keys = make([]*PrivateKey, len(matches))
+			}
+			keys[i] = key
+		}
+	}
+
+	return keys
+}
+
+// ParsePrivateKey parses the PEM, OpenSSH or PuTTY private key at the start
+// of data, returning nil if there isn't one or it can't be parsed
+func ParsePrivateKey(data []byte) *PrivateKey {
+	data = bytes.TrimLeft(data, " \t\r\n\"'")
+
+	if bytes.HasPrefix(data, []byte(puttyKeyPrefix)) {
+		return parsePuttyKey(data)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		block, _ = pem.Decode(dedentPem(data))
+	}
+
+	if block == nil || !strings.HasSuffix(block.Type, pemPrivateKeySuffix) {
+		return nil
+	}
+
+	key := &PrivateKey{Encrypted: strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED")}
+
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		return parseOpenSshKey(block.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		// encrypted PKCS #8 hides even the kind of key
+		key.Encrypted = true
+		return key
+	}
+
+	if key.Encrypted {
+		key.Type = strings.TrimSpace(strings.TrimSuffix(block.Type, pemPrivateKeySuffix))
+		return key
+	}
+
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "DSA PRIVATE KEY":
+		private, err = ssh.ParseDSAPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	switch private := private.(type) {
+	case *dsa.PrivateKey:
+		key.describe(&private.PublicKey)
+	case crypto.Signer:
+		key.describe(private.Public())
+	}
+
+	return key
+}
+
+// dedentPem returns the lines of a PEM block indented in to a YAML value or
+// the like without their indentation, up to the end of the block
+func dedentPem(data []byte) []byte {
+	var dedented bytes.Buffer
+
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		line = bytes.TrimLeft(line, " \t")
+		dedented.Write(line)
+
+		if bytes.HasPrefix(line, []byte("-----END ")) {
+			break
+		}
+	}
+
+	return dedented.Bytes()
+}
+
+// describe fills in the type, size and fingerprints of the key from its
+// public half
+func (key *PrivateKey) describe(public crypto.PublicKey) {
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		key.Type, key.Bits = PrivateKeyRsa, public.N.BitLen()
+	case *ecdsa.PublicKey:
+		key.Type, key.Bits = PrivateKeyEcdsa, public.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		key.Type, key.Bits = PrivateKeyEd25519, 256
+	case *dsa.PublicKey:
+		key.Type, key.Bits = PrivateKeyDsa, public.P.BitLen()
+	}
+
+	if sshKey, err := ssh.NewPublicKey(public); err == nil {
+		key.Fingerprint = ssh.FingerprintSHA256(sshKey)
+	}
+
+	// DSA keys have no SubjectPublicKeyInfo encoding in Go
+	if spki, err := x509.MarshalPKIXPublicKey(public); err == nil {
+		sum := sha256.Sum256(spki)
+		key.SpkiSha256 = base64.StdEncoding.EncodeToString(sum[:])
+	}
+}
+
+// describeSshPublicKey fills in the key from the public half an OpenSSH or
+// PuTTY key keeps in the clear, even when the private half is encrypted
+func (key *PrivateKey) describeSshPublicKey(blob []byte) bool {
+	public, err := ssh.ParsePublicKey(blob)
+	if err != nil {
+		return false
+	}
+
+	if cryptoKey, ok := public.(ssh.CryptoPublicKey); ok {
+		key.describe(cryptoKey.CryptoPublicKey())
+	}
+
+	return true
+}
+
+// parseOpenSshKey reads the cipher and public key of an OpenSSH private key,
+// which are stored ahead of the private key and its encryption
+func parseOpenSshKey(data []byte) *PrivateKey {
+	if !bytes.HasPrefix(data, []byte(openSshKeyMagic)) {
+		return nil
+	}
+	data = data[len(openSshKeyMagic):]
+
+	cipher, data, ok := readSshString(data)
+	if !ok {
+		return nil
+	}
+
+	// the KDF's name and options come next
+	for i := 0; i < 2; i++ {
+		if _, data, ok = readSshString(data); !ok {
+			return nil
+		}
+	}
+
+	if len(data) < 4 || binary.BigEndian.Uint32(data) < 1 {
+		return nil
+	}
+
+	public, _, ok := readSshString(data[4:])
+	if !ok {
+		return nil
+	}
+
+	key := &PrivateKey{Encrypted: string(cipher) != "none"}
+	if !key.describeSshPublicKey(public) {
+		return nil
+	}
+
+	return key
+}
+
+// readSshString reads a length prefixed string from the start of data,
+// returning it and what follows
+func readSshString(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+
+	length := binary.BigEndian.Uint32(data)
+	if uint64(length) > uint64(len(data)-4) {
+		return nil, nil, false
+	}
+
+	return data[4 : 4+length], data[4+length:], true
+}
+
+// parsePuttyKey reads the encryption and public key of a PuTTY .ppk file,
+// whose public lines are never encrypted
+func parsePuttyKey(data []byte) *PrivateKey {
+	key := &PrivateKey{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		separator := strings.Index(line, ": ")
+		if separator < 0 {
+			return nil
+		}
+
+		name, value := line[:separator], strings.TrimSpace(line[separator+2:])
+		switch name {
+		case "Encryption":
+			key.Encrypted = value != "none"
+		case "Public-Lines":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return nil
+			}
+
+			var encoded strings.Builder
+			for i := 0; i < count && scanner.Scan(); i++ {
+				encoded.WriteString(strings.TrimSpace(scanner.Text()))
+			}
+
+			blob, err := base64.StdEncoding.DecodeString(encoded.String())
+			if err != nil || !key.describeSshPublicKey(blob) {
+				return nil
+			}
+
+			return key
+		}
+	}
+
+	return nil
+}
+
+// String describes the key, i.e. RSA 2048 bits, unencrypted, SHA256:...
+func (key *PrivateKey) String() string {
+	details := make([]string, 0, 4)
+
+	if key.Type != "" && key.Bits > 0 {
+		details = append(details, fmt.Sprintf("%s %d bits", key.Type, key.Bits))
+	} else if key.Type != "" {
+		details = append(details, key.Type)
+	}
+
+	if key.Encrypted {
+		details = append(details, "encrypted")
+	} else {
+		details = append(details, "unencrypted")
+	}
+
+	if key.Fingerprint != "" {
+		details = append(details, key.Fingerprint)
+	}
+
+	return strings.Join(details, ", ")
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Priorities of the repositories, gists, images, packages and archives
+// waiting to be cloned. workers.priorities orders them, and repositories
+// deferred for their size always come last
+const (
+	// scans queued through the API
+	PriorityOnDemand = "on_demand"
+	// activity of the github_watch organizations and users, and github_search
+	// results
+	PriorityWatched = "watched"
+	// re-scans queued by the schedule
+	PriorityScheduled = "scheduled"
+	// everything else the event feeds turn up
+	PriorityFirehose = "firehose"
+)
+
+// Priorities are the default order of the queues, highest first
+var Priorities = []string{PriorityOnDemand, PriorityWatched, PriorityScheduled, PriorityFirehose}
+
+// validatePriorities checks workers.priorities, filling in the priorities
+// left out in their default order
+func (c *WorkersConfig) validatePriorities() error {
+	seen := make(map[string]bool)
+	for _, priority := range c.Priorities {
+		if !containsString(Priorities, priority) {
+			return fmt.Errorf("workers.priorities must be among %s, not %s", strings.Join(Priorities, ", "), priority)
+		} else if seen[priority] {
+			return fmt.Errorf("workers.priorities lists %s twice", priority)
+		}
+
+		seen[priority] = true
+	}
+
+	for _, priority := range Priorities {
+		if !seen[priority] {
+			c.Priorities = append(c.Priorities, priority)
+		}
+	}
+
+	return nil
+}
+
+// queue is the channel repositories of a priority wait on. The firehose
+// is Repositories, which the event feeds send to directly
+func (s *Session) queue(priority string) chan GitResource {
+	if queue, ok := s.queues[priority]; ok {
+		return queue
+	}
+
+	return s.Repositories
+}
+
+// QueueRepository waits to queue a repository at its Priority
+func (s *Session) QueueRepository(repository GitResource) {
+	s.queue(repository.Priority) <- repository
+}
+
+// TryQueueRepository queues a repository at its Priority without waiting,
+// returning false if its queue is full
+func (s *Session) TryQueueRepository(repository GitResource) bool {
+	select {
+	case s.queue(repository.Priority) <- repository:
+		return true
+	default:
+		return false
+	}
+}
+
+// NextRepository waits for a repository to clone, taking one of the highest
+// priority that's queued. One from the queue of large repositories is only
+// taken when nothing else is queued
+func (s *Session) NextRepository() GitResource {
+	if repository, ok := s.pollRepository(); ok {
+		return repository
+	}
+
+	select {
+	case repository := <-s.queue(PriorityOnDemand):
+		return repository
+	case repository := <-s.queue(PriorityWatched):
+		return repository
+	case repository := <-s.queue(PriorityScheduled):
+		return repository
+	case repository := <-s.Repositories:
+		return repository
+	case repository := <-s.LargeRepositories:
+		return repository
+	}
+}
+
+// pollRepository takes a repository of the highest priority that's queued
+// without waiting, in the order of workers.priorities
+func (s *Session) pollRepository() (GitResource, bool) {
+	for _, priority := range s.Config.Workers.Priorities {
+		select {
+		case repository := <-s.queue(priority):
+			return repository, true
+		default:
+		}
+	}
+
+	return GitResource{}, false
+}
+
+// QueuedRepositories counts the repositories waiting at every priority,
+// including the large ones deferred
+func (s *Session) QueuedRepositories() int {
+	queued := len(s.LargeRepositories)
+	for _, priority := range Priorities {
+		queued += len(s.queue(priority))
+	}
+
+	return queued
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimiter spaces out requests to a provider's API so that no more than
+// the configured number are made per minute, shared across all its pollers
+// and, with distributed.redis_url, every other instance
+type RateLimiter struct {
+	tick              <-chan time.Time
+	requestsPerMinute int
+	// the provider it limits, for sharing with other instances
+	name string
+}
+
+func NewRateLimiter(requestsPerMinute int) *RateLimiter {
+	if requestsPerMinute <= 0 {
+		requestsPerMinute = 60
+	}
+
+	return &RateLimiter{tick: time.Tick(time.Minute / time.Duration(requestsPerMinute)), requestsPerMinute: requestsPerMinute}
+}
+
+func (r *RateLimiter) Wait() {
+	<-r.tick
+
+	for r.name != "" && session.Shared != nil {
+		wait := session.Shared.Take(r.name, r.requestsPerMinute)
+		if wait <= 0 {
+			return
+		}
+
+		time.Sleep(wait)
+	}
+}
+
+var apiClient = &http.Client{Timeout: 30 * time.Second}
+
+// GetJSON performs a rate limited request and decodes the JSON response in
+// to dst. If the provider says we have been rate limited anyway, it sleeps
+// for the Retry-After period before returning an error
+func GetJSON(limiter *RateLimiter, req *http.Request, dst interface{}) error {
+	limiter.Wait()
+
+	req.Header.Set("User-Agent", fmt.Sprintf("%s v%s", Name, Version))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || retryAfter <= 0 {
+			retryAfter = 60
+		}
+
+		time.Sleep(time.Duration(retryAfter) * time.Second)
+		return fmt.Errorf("rate limited by %s, slept for %ds", req.URL.Host, retryAfter)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// getRaw fetches a plain text document, i.e. the raw contents of a snippet.
+// Documents larger than the maximum file size are skipped unread
+func getRaw(limiter *RateLimiter, url string) (string, error) {
+	limiter.Wait()
+
+	resp, err := apiClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	maxSize := GetMaximumFileSize(url)
+	if resp.ContentLength > maxSize {
+		return "", fmt.Errorf("%s exceeds the maximum file size of %d KB", url, maxSize/1024)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err == nil && int64(len(body)) > maxSize {
+		return "", fmt.Errorf("%s exceeds the maximum file size of %d KB", url, maxSize/1024)
+	}
+
+	return string(body), err
+}
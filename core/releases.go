@@ -0,0 +1,37 @@
+package core
+
+import (
+	"github.com/google/go-github/github"
+)
+
+// release assets larger than this many KB aren't downloaded unless
+// configured
+const defaultGitHubReleasesMaxAssetSize = 50 * 1024
+
+// GitHubReleasesConfig is which assets of published releases are
+// downloaded and scanned, i.e. with --process-releases
+type GitHubReleasesConfig struct {
+	// in KB
+	MaxAssetSize int `yaml:"max_asset_size"`
+}
+
+// queueReleaseAssets queues the assets of a published release to be
+// downloaded and scanned. Archives are extracted, and binaries are scanned
+// like any other. Those over github_releases.max_asset_size are skipped
+func queueReleaseAssets(session *Session, release *github.RepositoryRelease, priority string) {
+	maxSize := session.Config.GitHubReleases.MaxAssetSize * 1024
+
+	for _, asset := range release.Assets {
+		if asset.GetSize() > maxSize {
+			session.Log.Debug("[%s] Skipping release asset, %d KB is over github_releases.max_asset_size", asset.GetBrowserDownloadURL(), asset.GetSize()/1024)
+			continue
+		}
+
+		session.QueueRepository(GitResource{
+			Type:     RELEASE_SOURCE,
+			Url:      asset.GetBrowserDownloadURL(),
+			Size:     int64(asset.GetSize() / 1024),
+			Priority: priority,
+		})
+	}
+}
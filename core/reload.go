@@ -0,0 +1,157 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Reload reads config.yaml, the signature packs and YARA rules again and
+// applies the signatures, blacklists and GitHub tokens without a restart.
+// Files already being scanned finish with the signatures they started
+// with. Nothing changes if the new config is invalid
+func (s *Session) Reload() error {
+	config, err := ParseConfig(s.Options)
+	if err != nil {
+		return err
+	}
+
+	signatures, err := s.loadSignatures(config)
+	if err != nil {
+		return err
+	}
+
+	matcher := NewMatcher(signatures, *s.Options.MatchBackend)
+
+	s.reloading.Lock()
+	s.Signatures = signatures
+	s.Matcher = matcher
+	s.Config.Signatures = config.Signatures
+	s.Config.BlacklistedStrings = config.BlacklistedStrings
+	s.Config.BlacklistedExtensions = config.BlacklistedExtensions
+	s.Config.BlacklistedPaths = config.BlacklistedPaths
+	s.Config.BlacklistedEntropyExtensions = config.BlacklistedEntropyExtensions
+	s.reloading.Unlock()
+
+	s.reloadGitHubTokens(config)
+
+	s.Log.Info("[*] Reloaded config. Loaded %d signatures", len(signatures))
+	return nil
+}
+
+// reloadGitHubTokens pools the tokens added to each GitHub instance and
+// drops those removed, always keeping at least one
+func (s *Session) reloadGitHubTokens(config *Config) {
+	for _, instance := range s.GitHub {
+		apiUrl, uploadsUrl, tokens, found := "", "", config.GitHubAccessTokens, instance.Name == githubInstanceName
+		for _, enterprise := range config.GitHubEnterprise {
+			if enterprise.Name == instance.Name {
+				apiUrl, uploadsUrl, tokens, found = enterprise.ApiUrl, enterprise.UploadsUrl, enterprise.AccessTokens, true
+			}
+		}
+
+		if !found {
+			s.Log.Warn("%s was removed from the config. Restart to stop watching it", instance.Name)
+			continue
+		}
+
+		current := instance.Tokens()
+		for _, token := range tokens {
+			if strings.TrimSpace(token) == "" || containsString(current, token) {
+				continue
+			}
+
+			if err := s.addGitHubToken(instance, apiUrl, uploadsUrl, token); err != nil {
+				s.Log.Warn("Failed to validate %s token %.10s[..]: %s", instance.Name, token, err)
+				continue
+			}
+
+			s.Log.Info("[*] Added %s token %.10s[..]", instance.Name, token)
+		}
+
+		for _, token := range current {
+			if containsString(tokens, token) {
+				continue
+			}
+
+			if instance.Size() <= 1 {
+				s.Log.Warn("Not removing %s token %.10s[..], the only one left", instance.Name, token)
+				continue
+			}
+
+			instance.RemoveClient(token)
+			s.Log.Info("[*] Removed %s token %.10s[..]", instance.Name, token)
+		}
+
+		instance.updateMetrics()
+	}
+}
+
+// WatchConfig reloads the config when config.yaml, a signature pack or a
+// YARA rule changes, checking every --reload-interval seconds, and on SIGHUP
+func WatchConfig(s *Session) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval := *s.Options.ReloadInterval; interval > 0 {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	state := s.configState()
+	for {
+		select {
+		case <-hangup:
+			s.Log.Debug("Received SIGHUP, reloading config")
+		case <-tick:
+			if s.configState() == state {
+				continue
+			}
+			s.Log.Debug("Config changed, reloading")
+		}
+
+		state = s.configState()
+		if err := s.Reload(); err != nil {
+			s.Metrics.Inc(MetricConfigReloads, "result", "failure")
+			s.Log.Error("Failed to reload config, keeping the current one: %s", err)
+			continue
+		}
+
+		s.Metrics.Inc(MetricConfigReloads, "result", "success")
+	}
+}
+
+// configState identifies the current version of the files the config is
+// loaded from by their names, sizes and modification times
+func (s *Session) configState() string {
+	files := []string{ConfigFile(s.Options)}
+
+	for _, dir := range []string{*s.Options.SignaturesDirectory, *s.Options.YaraRulesDirectory} {
+		if dir == "" {
+			continue
+		}
+
+		for _, pattern := range []string{"*.yaml", "*.yml", "*.yar", "*.yara"} {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			files = append(files, matches...)
+		}
+	}
+
+	sort.Strings(files[1:])
+
+	var state strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&state, "%s:%d:%d\n", file, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+
+	return state.String()
+}
@@ -0,0 +1,319 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	RemediationActionAuto     = "auto"
+	RemediationActionIssue    = "issue"
+	RemediationActionAdvisory = "advisory"
+	RemediationActionNone     = "none"
+)
+
+// RemediationActions are what can be opened for a finding in a repository
+// we own
+var RemediationActions = []string{RemediationActionAuto, RemediationActionIssue, RemediationActionAdvisory, RemediationActionNone}
+
+// GitHubRemediationConfig is the token used to respond to findings in the
+// repositories of our own organizations, which needs the repo scope on them
+type GitHubRemediationConfig struct {
+	SinkFilter    `yaml:",inline"`
+	Token         string   `yaml:"token,omitempty"`
+	ApiUrl        string   `yaml:"api_url,omitempty"`
+	Organizations []string `yaml:"organizations"`
+	Action        string   `yaml:"action"`
+	PullRequest   bool     `yaml:"pull_request"`
+	Labels        []string `yaml:"labels"`
+}
+
+func (c GitHubRemediationConfig) Enabled() bool {
+	return c.Token != "" && len(c.Organizations) > 0
+}
+
+// GitHubRemediationSink responds to findings in repositories owned by the
+// configured organizations by opening a security advisory (public
+// repositories) or an issue (private ones), and optionally a pull request
+// removing the offending lines and ignoring the file
+type GitHubRemediationSink struct {
+	config GitHubRemediationConfig
+	client *github.Client
+	host   string
+
+	// findings already acted on, so a secret found again in another
+	// commit doesn't open another issue
+	handled sync.Map
+}
+
+func NewGitHubRemediationSink(config GitHubRemediationConfig) (*GitHubRemediationSink, error) {
+	if config.Action == "" {
+		config.Action = RemediationActionAuto
+	}
+
+	tc := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: config.Token}))
+	client := github.NewClient(tc)
+	host := "github.com"
+
+	if config.ApiUrl != "" {
+		var err error
+		if client, err = github.NewEnterpriseClient(config.ApiUrl, config.ApiUrl, tc); err != nil {
+			return nil, err
+		}
+
+		host = client.BaseURL.Hostname()
+	}
+
+	client.UserAgent = fmt.Sprintf("%s v%s", Name, Version)
+
+	return &GitHubRemediationSink{config: config, client: client, host: host}, nil
+}
+
+func (g *GitHubRemediationSink) Name() string {
+	return "GitHub remediation " + g.host
+}
+
+// ownedRepository returns the owner and name of the repository a finding
+// is in, if it is on this GitHub and owned by one of the organizations
+func (g *GitHubRemediationSink) ownedRepository(event *MatchEvent) (string, string, bool) {
+	u, err := url.Parse(event.Url)
+	if err != nil || !strings.EqualFold(u.Hostname(), g.host) {
+		return "", "", false
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	for _, organization := range g.config.Organizations {
+		if strings.EqualFold(organization, parts[0]) {
+			return parts[0], strings.TrimSuffix(parts[1], ".git"), true
+		}
+	}
+
+	return "", "", false
+}
+
+func (g *GitHubRemediationSink) Send(event *MatchEvent) error {
+	if !g.config.Accepts(event) {
+		return nil
+	}
+
+	owner, name, ok := g.ownedRepository(event)
+	if !ok {
+		return nil
+	}
+
+	if _, handled := g.handled.LoadOrStore(incidentDedupKey(event), true); handled {
+		return nil
+	}
+
+	ctx := context.Background()
+	repository, _, err := g.client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		return err
+	}
+
+	var pullRequest *github.PullRequest
+	if g.config.PullRequest {
+		if pullRequest, err = g.openPullRequest(ctx, repository, event); err != nil {
+			session.Log.Warn("Failed to open a pull request removing %s from %s: %s", event.File, event.Url, err)
+		}
+	}
+
+	action := g.config.Action
+	if action == RemediationActionAuto {
+		// advisories are only available to public repositories
+		action = RemediationActionAdvisory
+		if repository.GetPrivate() {
+			action = RemediationActionIssue
+		}
+	}
+
+	title := fmt.Sprintf("%s committed in %s", event.Signature, strings.TrimPrefix(event.File, "/"))
+	body := remediationBody(event, pullRequest)
+
+	switch action {
+	case RemediationActionIssue:
+		labels := g.config.Labels
+		_, _, err = g.client.Issues.Create(ctx, owner, name, &github.IssueRequest{Title: &title, Body: &body, Labels: &labels})
+	case RemediationActionAdvisory:
+		err = g.createAdvisory(ctx, owner, name, title, body, event.Severity)
+	}
+
+	return err
+}
+
+// createAdvisory opens a draft repository security advisory, which only
+// the repository's administrators and security managers can see
+func (g *GitHubRemediationSink) createAdvisory(ctx context.Context, owner string, name string, summary string, description string, severity string) error {
+	advisory := map[string]interface{}{
+		"summary":     summary,
+		"description": description,
+		"severity":    severity,
+		"vulnerabilities": []map[string]interface{}{{
+			"package": map[string]string{"ecosystem": "other", "name": owner + "/" + name},
+		}},
+	}
+
+	req, err := g.client.NewRequest("POST", fmt.Sprintf("repos/%s/%s/security-advisories", owner, name), advisory)
+	if err != nil {
+		return err
+	}
+
+	_, err = g.client.Do(ctx, req, nil)
+	return err
+}
+
+// openPullRequest removes the lines a finding matched from the default
+// branch, or the whole file if it matched on its path, and adds the file to
+// .gitignore. Nothing is changed if the file differs from the scanned commit,
+// as the line numbers may no longer point at the secret
+func (g *GitHubRemediationSink) openPullRequest(ctx context.Context, repository *github.Repository, event *MatchEvent) (*github.PullRequest, error) {
+	owner, name, base := repository.GetOwner().GetLogin(), repository.GetName(), repository.GetDefaultBranch()
+	path := strings.TrimPrefix(event.File, "/")
+
+	if event.Commit == "" {
+		return nil, fmt.Errorf("the commit %s was found in isn't known", path)
+	}
+
+	scanned, _, _, err := g.client.Repositories.GetContents(ctx, owner, name, path, &github.RepositoryContentGetOptions{Ref: event.Commit})
+	if err != nil {
+		return nil, err
+	}
+
+	current, _, _, err := g.client.Repositories.GetContents(ctx, owner, name, path, &github.RepositoryContentGetOptions{Ref: base})
+	if err != nil {
+		return nil, err
+	}
+
+	if scanned.GetSHA() != current.GetSHA() {
+		return nil, fmt.Errorf("%s has changed on %s since it was scanned", path, base)
+	}
+
+	ref, _, err := g.client.Git.GetRef(ctx, owner, name, "heads/"+base)
+	if err != nil {
+		return nil, err
+	}
+
+	branch := fmt.Sprintf("%s/remove-%s", Name, incidentDedupKey(event)[:12])
+	if _, _, err := g.client.Git.CreateRef(ctx, owner, name, &github.Reference{Ref: github.String("refs/heads/" + branch), Object: &github.GitObject{SHA: ref.Object.SHA}}); err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("Remove %s from %s", event.Signature, path)
+	if len(event.Lines) > 0 && event.Lines[0] > 0 {
+		contents, err := current.GetContent()
+		if err != nil {
+			return nil, err
+		}
+
+		remove := make(map[int]bool)
+		for _, line := range event.Lines {
+			remove[line] = true
+		}
+
+		var kept []string
+		for i, line := range strings.SplitAfter(contents, "\n") {
+			if !remove[i+1] {
+				kept = append(kept, line)
+			}
+		}
+
+		_, _, err = g.client.Repositories.UpdateFile(ctx, owner, name, path, &github.RepositoryContentFileOptions{Message: &message, Content: []byte(strings.Join(kept, "")), SHA: current.SHA, Branch: &branch})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		message = fmt.Sprintf("Remove %s", path)
+		if _, _, err := g.client.Repositories.DeleteFile(ctx, owner, name, path, &github.RepositoryContentFileOptions{Message: &message, SHA: current.SHA, Branch: &branch}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := g.ignore(ctx, owner, name, branch, path); err != nil {
+		return nil, err
+	}
+
+	title := fmt.Sprintf("Remove %s committed in %s", event.Signature, path)
+	body := fmt.Sprintf("%s found a secret (%s) in `%s`. This removes it and adds the file to `.gitignore`.\n\n"+
+		"**The secret is still in the repository's history, so revoke or rotate it as well.**", Name, event.Signature, path)
+
+	pullRequest, _, err := g.client.PullRequests.Create(ctx, owner, name, &github.NewPullRequest{Title: &title, Head: &branch, Base: &base, Body: &body})
+	return pullRequest, err
+}
+
+// ignore adds path to the .gitignore at the root of the repository, creating
+// it if need be
+func (g *GitHubRemediationSink) ignore(ctx context.Context, owner string, name string, branch string, path string) error {
+	message := fmt.Sprintf("Ignore %s", path)
+	options := &github.RepositoryContentFileOptions{Message: &message, Branch: &branch}
+
+	gitignore, _, resp, err := g.client.Repositories.GetContents(ctx, owner, name, ".gitignore", &github.RepositoryContentGetOptions{Ref: branch})
+	if err != nil && (resp == nil || resp.StatusCode != 404) {
+		return err
+	}
+
+	if gitignore == nil {
+		options.Content = []byte("/" + path + "\n")
+		_, _, err = g.client.Repositories.CreateFile(ctx, owner, name, ".gitignore", options)
+		return err
+	}
+
+	contents, err := gitignore.GetContent()
+	if err != nil {
+		return err
+	}
+
+	if contents != "" && !strings.HasSuffix(contents, "\n") {
+		contents += "\n"
+	}
+
+	options.Content = []byte(contents + "/" + path + "\n")
+	options.SHA = gitignore.SHA
+	_, _, err = g.client.Repositories.UpdateFile(ctx, owner, name, ".gitignore", options)
+
+	return err
+}
+
+func remediationBody(event *MatchEvent, pullRequest *github.PullRequest) string {
+	var body strings.Builder
+
+	fmt.Fprintf(&body, "%s found a secret committed to this repository.\n\n", Name)
+	fmt.Fprintf(&body, "* **Signature:** %s\n", event.Signature)
+	fmt.Fprintf(&body, "* **File:** `%s`\n", event.File)
+	fmt.Fprintf(&body, "* **Severity:** %s (%s confidence)\n", event.Severity, event.Confidence)
+
+	if event.Commit != "" {
+		fmt.Fprintf(&body, "* **Commit:** %s\n", event.Commit)
+	}
+
+	if len(event.Lines) > 0 && event.Lines[0] > 0 {
+		fmt.Fprintf(&body, "* **Lines:** %s\n", strings.Trim(fmt.Sprint(event.Lines), "[]"))
+	}
+
+	if snippet := notificationSnippet(event); snippet != "" {
+		fmt.Fprintf(&body, "* **Match:** `%s`\n", snippet)
+	}
+
+	if event.Verified {
+		body.WriteString("\nThe secret was confirmed to be **live**.\n")
+	}
+
+	body.WriteString("\nRevoke or rotate the secret, then remove it from the repository's history.\n")
+
+	if pullRequest != nil {
+		fmt.Fprintf(&body, "\n%s removes it from the default branch.\n", pullRequest.GetHTMLURL())
+	}
+
+	fmt.Fprintf(&body, "\n<sub>Fingerprint: %s</sub>\n", incidentDedupKey(event))
+
+	return body.String()
+}
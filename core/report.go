@@ -0,0 +1,400 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultReportPath = Name + "-report.html"
+
+	// findings fetched from the database per query
+	reportPageSize = 1000
+)
+
+// ReportFinding is a row of the findings table in the HTML report
+type ReportFinding struct {
+	Repository string
+	File       string
+	Commit     string
+	Signature  string
+	Severity   string
+	Confidence string
+	Source     string
+	Status     string
+	Verified   bool
+	Match      string
+	FoundAt    time.Time
+}
+
+// ReportCount is how many findings there are of a signature or in a
+// repository, broken down by severity
+type ReportCount struct {
+	Name       string
+	Findings   int
+	Verified   int
+	Severities map[string]int
+	// the share of the largest count, for bar widths
+	Percent float64
+}
+
+// Report summarises persisted findings for the HTML report
+type Report struct {
+	GeneratedAt  time.Time
+	Period       string
+	Findings     []ReportFinding
+	Signatures   []ReportCount
+	Repositories []ReportCount
+	Severities   map[string]int
+	Statuses     []string
+	Verified     int
+}
+
+// GetAllFindings returns every stored finding from the last days, or all of
+// them if days is 0, newest first
+func (d *Database) GetAllFindings(days int) ([]StoredFinding, error) {
+	query := FindingsQuery{Limit: reportPageSize}
+	if days > 0 {
+		query.Since = time.Now().AddDate(0, 0, -days)
+	}
+
+	var findings []StoredFinding
+	for ; ; query.Offset += reportPageSize {
+		page, err := d.GetFindings(query)
+		if err != nil {
+			return nil, err
+		}
+
+		findings = append(findings, page...)
+
+		if len(page) < reportPageSize {
+			return findings, nil
+		}
+	}
+}
+
+// ReadJsonlFindings reads the findings written to a --format jsonl file from
+// the last days, or all of them if days is 0
+func ReadJsonlFindings(path string, days int) ([]StoredFinding, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sources := make(map[string]GitResourceType, len(SourceNames))
+	for source, name := range SourceNames {
+		sources[name] = source
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var findings []StoredFinding
+	for scanner.Scan() {
+		line := JsonlFinding{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			continue
+		}
+
+		timestamp, _ := time.Parse(time.RFC3339, line.Timestamp)
+		if days > 0 && timestamp.Before(since) {
+			continue
+		}
+
+		finding := StoredFinding{CreatedAt: timestamp}
+		finding.Url = line.Repository
+		finding.File = line.Path
+		finding.Commit = line.Commit
+		finding.Source = sources[line.Source]
+		finding.Signature = line.Signature
+		finding.Severity = line.Severity
+		finding.Confidence = line.Confidence
+		finding.Entropy = line.Entropy
+		finding.Verified = line.Verified
+
+		if line.Match != "" {
+			finding.Matches = []string{line.Match}
+		}
+
+		findings = append(findings, finding)
+	}
+
+	// newest first, as from the database
+	for i, j := 0, len(findings)-1; i < j; i, j = i+1, j-1 {
+		findings[i], findings[j] = findings[j], findings[i]
+	}
+
+	return findings, scanner.Err()
+}
+
+// NewReport tallies findings by signature, repository and severity. Matches
+// are always redacted, as the report is meant to be shared
+func NewReport(findings []StoredFinding, period string) *Report {
+	report := &Report{GeneratedAt: time.Now().UTC(), Period: period, Severities: map[string]int{}}
+	signatures := map[string]*ReportCount{}
+	repositories := map[string]*ReportCount{}
+	statuses := map[string]bool{}
+
+	count := func(counts map[string]*ReportCount, name string, finding StoredFinding) {
+		c, ok := counts[name]
+		if !ok {
+			c = &ReportCount{Name: name, Severities: map[string]int{}}
+			counts[name] = c
+		}
+
+		c.Findings++
+		c.Severities[finding.Severity]++
+		if finding.Verified {
+			c.Verified++
+		}
+	}
+
+	for _, finding := range findings {
+		matches := make([]string, len(finding.Matches))
+		for i, match := range finding.Matches {
+			matches[i] = Redact(match)
+		}
+
+		report.Findings = append(report.Findings, ReportFinding{
+			Repository: finding.Url,
+			File:       finding.File,
+			Commit:     finding.Commit,
+			Signature:  finding.Signature,
+			Severity:   finding.Severity,
+			Confidence: finding.Confidence,
+			Source:     SourceNames[finding.Source],
+			Status:     finding.Status,
+			Verified:   finding.Verified,
+			Match:      strings.Join(matches, ", "),
+			FoundAt:    finding.CreatedAt,
+		})
+
+		count(signatures, finding.Signature, finding)
+		count(repositories, finding.Url, finding)
+		report.Severities[finding.Severity]++
+
+		if finding.Verified {
+			report.Verified++
+		}
+
+		if finding.Status != "" {
+			statuses[finding.Status] = true
+		}
+	}
+
+	report.Signatures = sortReportCounts(signatures)
+	report.Repositories = sortReportCounts(repositories)
+
+	for _, status := range FindingStatuses {
+		if statuses[status] {
+			report.Statuses = append(report.Statuses, status)
+		}
+	}
+
+	return report
+}
+
+// sortReportCounts orders counts with the most findings first
+func sortReportCounts(counts map[string]*ReportCount) []ReportCount {
+	sorted := make([]ReportCount, 0, len(counts))
+	for _, c := range counts {
+		sorted = append(sorted, *c)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Findings != sorted[j].Findings {
+			return sorted[i].Findings > sorted[j].Findings
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	for i := range sorted {
+		sorted[i].Percent = float64(sorted[i].Findings) / float64(sorted[0].Findings) * 100
+	}
+
+	return sorted
+}
+
+// Render writes the report as a standalone HTML page, with no external
+// assets so it can be attached to an email
+func (r *Report) Render(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"severities": func() []string {
+		descending := make([]string, len(Severities))
+		for i, severity := range Severities {
+			descending[len(Severities)-1-i] = severity
+		}
+		return descending
+	},
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02 15:04")
+	},
+	"short": func(commit string) string {
+		if len(commit) > 8 {
+			return commit[:8]
+		}
+		return commit
+	},
+	"width": func(percent float64) template.CSS {
+		return template.CSS(fmt.Sprintf("%.1f%%", percent))
+	},
+	"name":    func() string { return Name },
+	"version": func() string { return Version },
+}).Parse(reportHtml))
+
+const reportHtml = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{name}} report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { margin-bottom: 0; }
+h2 { margin-top: 2em; border-bottom: 1px solid #ddd; padding-bottom: .3em; }
+.meta { color: #777; }
+.cards { display: flex; flex-wrap: wrap; gap: 1em; margin-top: 1.5em; }
+.card { border: 1px solid #ddd; border-radius: 6px; padding: 1em 1.5em; min-width: 8em; }
+.card .value { font-size: 2em; font-weight: bold; }
+table { border-collapse: collapse; width: 100%; font-size: .9em; }
+th, td { text-align: left; padding: .4em .6em; border-bottom: 1px solid #eee; vertical-align: top; }
+th { background: #f6f6f6; position: sticky; top: 0; }
+td.number { text-align: right; }
+.bar { display: flex; height: 1.2em; min-width: 2px; }
+.bar span { display: block; }
+.critical { background: #b60205; color: #fff; }
+.high { background: #e36209; color: #fff; }
+.medium { background: #dbab09; }
+.low { background: #0366d6; color: #fff; }
+.badge { border-radius: 3px; padding: 0 .4em; font-size: .85em; white-space: nowrap; }
+.verified { background: #22863a; color: #fff; }
+code { word-break: break-all; }
+.filters { display: flex; flex-wrap: wrap; gap: 1em; margin: 1em 0; }
+.filters input[type=search] { width: 20em; }
+</style>
+</head>
+<body>
+<h1>{{name}} report</h1>
+<p class="meta">Findings from {{.Period}}, generated {{date .GeneratedAt}} UTC by {{name}} v{{version}}</p>
+
+<div class="cards">
+<div class="card"><div class="value">{{len .Findings}}</div>findings</div>
+<div class="card"><div class="value">{{.Verified}}</div>verified live</div>
+<div class="card"><div class="value">{{len .Repositories}}</div>repositories</div>
+<div class="card"><div class="value">{{len .Signatures}}</div>signatures</div>
+{{- range severities}}
+<div class="card"><div class="value">{{index $.Severities .}}</div><span class="badge {{.}}">{{.}}</span></div>
+{{- end}}
+</div>
+
+<h2>Findings by signature</h2>
+<table>
+<tr><th>Signature</th><th>Findings</th><th>Verified</th><th style="width: 50%"></th></tr>
+{{- range .Signatures}}
+{{- $count := .}}
+<tr>
+<td>{{.Name}}</td>
+<td class="number">{{.Findings}}</td>
+<td class="number">{{.Verified}}</td>
+<td><div class="bar" style="width: {{width .Percent}}">{{range $severity := severities}}{{with index $count.Severities $severity}}<span class="{{$severity}}" style="flex: {{.}}" title="{{.}} {{$severity}}"></span>{{end}}{{end}}</div></td>
+</tr>
+{{- end}}
+</table>
+
+<h2>Findings by repository</h2>
+<table>
+<tr><th>Repository</th><th>Findings</th><th>Verified</th>{{range severities}}<th>{{.}}</th>{{end}}</tr>
+{{- range .Repositories}}
+{{- $count := .}}
+<tr>
+<td>{{.Name}}</td>
+<td class="number">{{.Findings}}</td>
+<td class="number">{{.Verified}}</td>
+{{- range severities}}
+<td class="number">{{index $count.Severities .}}</td>
+{{- end}}
+</tr>
+{{- end}}
+</table>
+
+<h2>Findings</h2>
+<div class="filters">
+<input type="search" id="search" placeholder="Filter by repository, file or signature">
+<select id="severity"><option value="">Any severity</option>{{range severities}}<option>{{.}}</option>{{end}}</select>
+{{- if .Statuses}}
+<select id="status"><option value="">Any status</option>{{range .Statuses}}<option>{{.}}</option>{{end}}</select>
+{{- end}}
+<label><input type="checkbox" id="verified"> Verified only</label>
+<span id="shown"></span>
+</div>
+<table id="findings">
+<thead>
+<tr><th>Found</th><th>Severity</th><th>Signature</th><th>Repository</th><th>File</th><th>Commit</th><th>Match</th>{{if .Statuses}}<th>Status</th>{{end}}</tr>
+</thead>
+<tbody>
+{{- range .Findings}}
+<tr data-severity="{{.Severity}}" data-status="{{.Status}}" data-verified="{{.Verified}}">
+<td>{{date .FoundAt}}</td>
+<td><span class="badge {{.Severity}}">{{.Severity}}</span>{{if .Verified}} <span class="badge verified">live</span>{{end}}</td>
+<td>{{.Signature}}</td>
+<td>{{.Repository}}</td>
+<td><code>{{.File}}</code></td>
+<td><code>{{short .Commit}}</code></td>
+<td><code>{{.Match}}</code></td>
+{{- if $.Statuses}}
+<td>{{.Status}}</td>
+{{- end}}
+</tr>
+{{- end}}
+</tbody>
+</table>
+
+<script>
+(function () {
+  var search = document.getElementById('search'),
+      severity = document.getElementById('severity'),
+      status = document.getElementById('status'),
+      verified = document.getElementById('verified'),
+      shown = document.getElementById('shown'),
+      rows = document.querySelectorAll('#findings tbody tr');
+
+  function filter() {
+    var text = search.value.toLowerCase(), count = 0;
+
+    for (var i = 0; i < rows.length; i++) {
+      var row = rows[i],
+          visible = row.textContent.toLowerCase().indexOf(text) !== -1 &&
+            (!severity.value || row.dataset.severity === severity.value) &&
+            (!status || !status.value || row.dataset.status === status.value) &&
+            (!verified.checked || row.dataset.verified === 'true');
+
+      row.style.display = visible ? '' : 'none';
+      if (visible) count++;
+    }
+
+    shown.textContent = count + ' of ' + rows.length + ' shown';
+  }
+
+  [search, severity, status, verified].forEach(function (input) {
+    if (input) input.addEventListener(input === search ? 'input' : 'change', filter);
+  });
+  filter();
+})();
+</script>
+</body>
+</html>
+`
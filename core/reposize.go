@@ -0,0 +1,36 @@
+package core
+
+// RepositorySize returns the size in KB the provider reports for a
+// repository about to be cloned, or 0 if it's unknown. The clone workers
+// look GitHub repositories up themselves
+func (s *Session) RepositorySize(repository GitResource) int64 {
+	if repository.Size > 0 {
+		return repository.Size
+	}
+
+	// GitLab only reports sizes to tokens with access to the project
+	if repository.Type == GITLAB_SOURCE && repository.Id > 0 && s.Config.GitLab.AccessToken != "" {
+		size, err := GetGitLabProjectSize(s, repository.Id)
+		if err != nil {
+			s.Log.Debug("[%s] Failed to look up the repository size: %s", repository.Url, err)
+		}
+
+		return size
+	}
+
+	return 0
+}
+
+// DeferRepository puts a repository larger than --large-repository-size on
+// the low priority queue, to be cloned once nothing else is queued. It's
+// skipped if that queue is full
+func (s *Session) DeferRepository(repository GitResource) {
+	repository.Deferred = true
+
+	select {
+	case s.LargeRepositories <- repository:
+		s.Log.Debug("[%s] Deferring, %d KB is over the large repository size", repository.Url, repository.Size)
+	default:
+		s.Log.Debug("[%s] Skipping, the queue of large repositories is full", repository.Url)
+	}
+}
@@ -0,0 +1,281 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	awsStsUrl       = "https://sts.amazonaws.com/"
+	awsIamUrl       = "https://iam.amazonaws.com/"
+	awsStsVersion   = "2011-06-15"
+	awsIamVersion   = "2010-05-08"
+	githubApiUrl    = "https://api.github.com"
+	revokeUserAgent = Name + " v" + Version
+)
+
+var revokeClient = &http.Client{Timeout: 10 * time.Second}
+
+// RevocationConfig lists our own accounts whose verified secrets may be
+// revoked as soon as they are found, and how
+type RevocationConfig struct {
+	Aws      []AWSRevocationConfig  `yaml:"aws"`
+	GitHub   GitHubRevocationConfig `yaml:"github"`
+	Webhooks []WebhookConfig        `yaml:"webhooks"`
+}
+
+// AWSRevocationConfig is an AWS account whose access keys may be disabled,
+// with credentials allowed to iam:GetAccessKeyLastUsed and
+// iam:UpdateAccessKey in it. Blank credentials use the environment or role
+type AWSRevocationConfig struct {
+	AccountId       string `yaml:"account_id"`
+	AccessKeyId     string `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string `yaml:"secret_access_key,omitempty"`
+	SessionToken    string `yaml:"session_token,omitempty"`
+}
+
+// GitHubRevocationConfig are the users whose tokens may be revoked
+type GitHubRevocationConfig struct {
+	Users []string `yaml:"users"`
+}
+
+func (c RevocationConfig) Enabled() bool {
+	return len(c.Aws) > 0 || len(c.GitHub.Users) > 0 || len(c.Webhooks) > 0
+}
+
+// Revoker disables a live secret if it belongs to one of our accounts,
+// returning a description of what it did or an empty string if the secret
+// isn't ours
+type Revoker func(config RevocationConfig, match string, contents []byte) (string, error)
+
+var revokers = map[string]Revoker{
+	"aws":    revokeAWS,
+	"github": revokeGitHub,
+}
+
+// RevokeMatches revokes the verified matches of a finding that belong to
+// our accounts, and sends them to the revocation webhooks. It reports
+// whether any were revoked. The matches must not be redacted yet
+func (s *Session) RevokeMatches(verifier string, event *MatchEvent, contents []byte) bool {
+	config := s.Config.Revocation
+	signature := event.Signature
+	revoked := false
+
+	if revoker, ok := revokers[verifier]; ok {
+		for _, match := range event.Matches {
+			action, err := revoker(config, match, contents)
+			if err != nil {
+				s.Log.Warn("[%s] Failed to revoke %s in %s: %s", event.Url, signature, event.File, err)
+			} else if action != "" {
+				s.Log.Important("[%s] Revoked %s in %s: %s", event.Url, signature, event.File, action)
+				revoked = true
+			}
+		}
+	}
+
+	for _, webhook := range config.Webhooks {
+		for _, match := range event.Matches {
+			if err := sendRevocationWebhook(webhook, signature, match, event); err != nil {
+				s.Log.Warn("[%s] Failed to send %s in %s to revocation webhook %s: %s", event.Url, signature, event.File, webhook.Url, err)
+			}
+		}
+	}
+
+	return revoked
+}
+
+// sendRevocationWebhook POSTs the unredacted secret for the receiver to
+// revoke, signed like the webhook sink if a secret is configured
+func sendRevocationWebhook(webhook WebhookConfig, signature string, match string, event *MatchEvent) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"signature":  signature,
+		"secret":     match,
+		"repository": event.Url,
+		"file":       event.File,
+		"commit":     event.Commit,
+		"source":     SourceNames[event.Source],
+	})
+	if err != nil {
+		return err
+	}
+
+	maxRetries := webhook.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultWebhookRetries
+	}
+
+	return PostWithRetry(revokeClient, maxRetries, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", webhook.Url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", revokeUserAgent)
+
+		if webhook.Secret != "" {
+			req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(hmacSHA256([]byte(webhook.Secret), string(body))))
+		}
+
+		return req, nil
+	})
+}
+
+// revokeAWS looks up which account an access key belongs to and, if it is
+// one of ours, deactivates it
+func revokeAWS(config RevocationConfig, match string, contents []byte) (string, error) {
+	keyId := awsKeyIdRegex.FindString(match)
+	if keyId == "" || len(config.Aws) == 0 {
+		return "", nil
+	}
+
+	// any valid credentials can look up the account of a key
+	lookup := NewAWSCredentialsProvider(config.Aws[0].AccessKeyId, config.Aws[0].SecretAccessKey, config.Aws[0].SessionToken)
+	info := struct {
+		Account string `xml:"GetAccessKeyInfoResult>Account"`
+	}{}
+
+	if err := callAWSQuery(lookup, awsStsUrl, "sts", url.Values{"Action": {"GetAccessKeyInfo"}, "Version": {awsStsVersion}, "AccessKeyId": {keyId}}, &info); err != nil {
+		return "", err
+	}
+
+	for _, account := range config.Aws {
+		if account.AccountId != info.Account {
+			continue
+		}
+
+		credentials := NewAWSCredentialsProvider(account.AccessKeyId, account.SecretAccessKey, account.SessionToken)
+		lastUsed := struct {
+			UserName string `xml:"GetAccessKeyLastUsedResult>UserName"`
+		}{}
+
+		if err := callAWSQuery(credentials, awsIamUrl, "iam", url.Values{"Action": {"GetAccessKeyLastUsed"}, "Version": {awsIamVersion}, "AccessKeyId": {keyId}}, &lastUsed); err != nil {
+			return "", err
+		}
+
+		params := url.Values{"Action": {"UpdateAccessKey"}, "Version": {awsIamVersion}, "AccessKeyId": {keyId}, "Status": {"Inactive"}, "UserName": {lastUsed.UserName}}
+		if err := callAWSQuery(credentials, awsIamUrl, "iam", params, nil); err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("deactivated access key %s of IAM user %s in account %s", keyId, lastUsed.UserName, info.Account), nil
+	}
+
+	return "", nil
+}
+
+// callAWSQuery calls an action of a global AWS query API such as STS or IAM
+// and decodes the XML response in to dst
+func callAWSQuery(credentials *AWSCredentialsProvider, endpoint string, service string, params url.Values, dst interface{}) error {
+	creds, err := credentials.Get()
+	if err != nil {
+		return err
+	}
+
+	body := []byte(params.Encode())
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	req.Header.Set("User-Agent", revokeUserAgent)
+	SignAWSRequest(req, body, creds, defaultAwsRegion, service)
+
+	resp, err := revokeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		failure := struct {
+			Message string `xml:"Error>Message"`
+		}{}
+		xml.Unmarshal(data, &failure)
+
+		return fmt.Errorf("%s %s returned %s: %s", service, params.Get("Action"), resp.Status, failure.Message)
+	}
+
+	if dst == nil {
+		return nil
+	}
+
+	return xml.Unmarshal(data, dst)
+}
+
+// revokeGitHub finds who a token belongs to and, if it is one of our users,
+// submits it to GitHub's credential revocation API
+func revokeGitHub(config RevocationConfig, match string, contents []byte) (string, error) {
+	token := githubTokenRegex.FindString(match)
+	if token == "" || len(config.GitHub.Users) == 0 {
+		return "", nil
+	}
+
+	req, err := http.NewRequest("GET", githubApiUrl+"/user", nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("User-Agent", revokeUserAgent)
+
+	resp, err := revokeClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+
+	user := struct {
+		Login string `json:"login"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return "", err
+	}
+
+	owned := false
+	for _, login := range config.GitHub.Users {
+		owned = owned || strings.EqualFold(login, user.Login)
+	}
+
+	if !owned {
+		return "", nil
+	}
+
+	body, _ := json.Marshal(map[string][]string{"credentials": {token}})
+	err = PostWithRetry(revokeClient, defaultWebhookRetries, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", githubApiUrl+"/credentials/revoke", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", revokeUserAgent)
+
+		return req, nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("submitted the token of %s for revocation", user.Login), nil
+}
@@ -0,0 +1,252 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FormatSarif = "sarif"
+
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	// how often a long running scan's findings are written out
+	sarifFlushInterval = 30 * time.Second
+)
+
+type SarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []SarifRun `json:"runs"`
+}
+
+type SarifRun struct {
+	Tool    SarifTool     `json:"tool"`
+	Results []SarifResult `json:"results"`
+}
+
+type SarifTool struct {
+	Driver SarifDriver `json:"driver"`
+}
+
+type SarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationUri string      `json:"informationUri"`
+	Rules          []SarifRule `json:"rules"`
+}
+
+type SarifRule struct {
+	Id                   string            `json:"id"`
+	Name                 string            `json:"name"`
+	ShortDescription     SarifMessage      `json:"shortDescription"`
+	DefaultConfiguration SarifRuleDefaults `json:"defaultConfiguration"`
+}
+
+type SarifRuleDefaults struct {
+	Level string `json:"level"`
+}
+
+type SarifMessage struct {
+	Text string `json:"text"`
+}
+
+type SarifResult struct {
+	RuleId     string                 `json:"ruleId"`
+	RuleIndex  int                    `json:"ruleIndex"`
+	Level      string                 `json:"level"`
+	Message    SarifMessage           `json:"message"`
+	Locations  []SarifLocation        `json:"locations"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type SarifLocation struct {
+	PhysicalLocation SarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type SarifPhysicalLocation struct {
+	ArtifactLocation SarifArtifactLocation `json:"artifactLocation"`
+	Region           *SarifRegion          `json:"region,omitempty"`
+}
+
+type SarifArtifactLocation struct {
+	Uri string `json:"uri"`
+}
+
+type SarifRegion struct {
+	StartLine   int  `json:"startLine"`
+	StartColumn int  `json:"startColumn,omitempty"`
+	ByteOffset  *int `json:"byteOffset,omitempty"`
+}
+
+// SarifWriter accumulates findings in memory and rewrites the SARIF log when
+// flushed, so the file on disk is always a complete, valid document
+type SarifWriter struct {
+	sync.Mutex
+
+	path  string
+	log   *SarifLog
+	rules map[string]int
+	dirty bool
+}
+
+func NewSarifWriter(path string, signatures []Signature) (*SarifWriter, error) {
+	w := &SarifWriter{
+		path:  path,
+		rules: make(map[string]int),
+		log: &SarifLog{
+			Schema:  sarifSchema,
+			Version: sarifVersion,
+			Runs: []SarifRun{{
+				Tool: SarifTool{Driver: SarifDriver{
+					Name:           Name,
+					Version:        Version,
+					InformationUri: "https://github.com/eth0izzle/shhgit",
+					Rules:          make([]SarifRule, 0),
+				}},
+				Results: make([]SarifResult, 0),
+			}},
+		},
+	}
+
+	for _, signature := range signatures {
+		w.ruleIndex(signature.Name(), signature.Severity())
+	}
+
+	// so a scan without findings still leaves a log behind
+	if err := w.save(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for range time.Tick(sarifFlushInterval) {
+			LogIfError("Could not write to "+path, w.Flush())
+		}
+	}()
+
+	return w, nil
+}
+
+func (w *SarifWriter) Write(event *MatchEvent) error {
+	w.Lock()
+	defer w.Unlock()
+
+	run := &w.log.Runs[0]
+	index := w.ruleIndex(event.Signature, event.Severity)
+	rule := run.Tool.Driver.Rules[index]
+
+	newResult := func(text string, region *SarifRegion) SarifResult {
+		location := SarifPhysicalLocation{ArtifactLocation: SarifArtifactLocation{Uri: strings.TrimPrefix(event.File, "/")}, Region: region}
+
+		properties := map[string]interface{}{"repository": event.Url, "severity": event.Severity, "confidence": event.Confidence}
+		if event.Verified {
+			properties["verified"] = true
+		}
+
+		if event.Entropy > 0 {
+			properties["entropy"] = event.Entropy
+		}
+
+		if event.Metadata != nil {
+			properties["repository_metadata"] = event.Metadata
+		}
+
+		if event.LikelyCanary {
+			properties["likely_canary"] = true
+			properties["canary_reason"] = event.CanaryReason
+		}
+
+		return SarifResult{
+			RuleId:     rule.Id,
+			RuleIndex:  index,
+			Level:      sarifLevel(event.Severity),
+			Message:    SarifMessage{Text: text},
+			Locations:  []SarifLocation{{PhysicalLocation: location}},
+			Properties: properties,
+		}
+	}
+
+	if len(event.Matches) == 0 {
+		run.Results = append(run.Results, newResult(fmt.Sprintf("File matches signature: %s", event.Signature), nil))
+	}
+
+	for i, match := range event.Matches {
+		var region *SarifRegion
+		if i < len(event.Lines) && event.Lines[i] > 0 {
+			region = &SarifRegion{StartLine: event.Lines[i]}
+			if i < len(event.Columns) {
+				region.StartColumn = event.Columns[i]
+			}
+
+			if i < len(event.Offsets) && event.Offsets[i] >= 0 {
+				region.ByteOffset = &event.Offsets[i]
+			}
+		}
+
+		run.Results = append(run.Results, newResult(fmt.Sprintf("%s: %s", event.Signature, match), region))
+	}
+
+	w.dirty = true
+	return nil
+}
+
+// Flush rewrites the log if anything was written since the last flush
+func (w *SarifWriter) Flush() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if !w.dirty {
+		return nil
+	}
+
+	if err := w.save(); err != nil {
+		return err
+	}
+
+	w.dirty = false
+	return nil
+}
+
+func (w *SarifWriter) save() error {
+	data, err := json.MarshalIndent(w.log, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(w.path, data, 0644)
+}
+
+// sarifLevel maps a severity to one of SARIF's levels, which are coarser
+func sarifLevel(severity string) string {
+	switch severity {
+	case SeverityCritical, SeverityHigh:
+		return "error"
+	case SeverityLow:
+		return "note"
+	}
+
+	return "warning"
+}
+
+func (w *SarifWriter) ruleIndex(name string, severity string) int {
+	if index, ok := w.rules[name]; ok {
+		return index
+	}
+
+	driver := &w.log.Runs[0].Tool.Driver
+	driver.Rules = append(driver.Rules, SarifRule{
+		Id:                   GetSlug(name),
+		Name:                 name,
+		ShortDescription:     SarifMessage{Text: name},
+		DefaultConfiguration: SarifRuleDefaults{Level: sarifLevel(severity)},
+	})
+
+	w.rules[name] = len(driver.Rules) - 1
+
+	return w.rules[name]
+}
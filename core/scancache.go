@@ -0,0 +1,141 @@
+package core
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// the most commits the scan cache remembers, forgetting those scanned
+// longest ago first
+const scanCacheMaxCommits = 1000000
+
+// ScanCache remembers the commits of each repository that were scanned, so
+// repositories turning up again without new commits are skipped and pushes
+// are only scanned for the commits that weren't. Keys are kept in memory
+// and, with --scan-cache-path, appended to a file one hex encoded hash per
+// line
+type ScanCache struct {
+	sync.Mutex
+
+	file *os.File
+	seen map[string]bool
+	// keys in the order they were added, a ring once full
+	order []string
+	next  int
+}
+
+func NewScanCache(path string) (*ScanCache, error) {
+	cache := &ScanCache{seen: make(map[string]bool)}
+	if path == "" {
+		return cache, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+		if key := strings.TrimSpace(scanner.Text()); key != "" && !cache.seen[key] {
+			cache.add(key)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// the file only grows, so it's rewritten with what's remembered once
+	// it's mostly forgotten keys
+	if lines > 2*scanCacheMaxCommits {
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		writer := bufio.NewWriter(file)
+		for i := range cache.order {
+			writer.WriteString(cache.order[(cache.next+i)%len(cache.order)] + "\n")
+		}
+		writer.Flush()
+	}
+
+	cache.file = file
+	return cache, nil
+}
+
+// scanCacheRepository is how a repository is known to the scan cache
+// whatever URL it was queued or cloned by, i.e. its API URL from the event
+// feed or its clone URL: the host and path, without api., /api/v3, /repos
+// or .git
+func scanCacheRepository(repositoryUrl string) string {
+	u, err := url.Parse(repositoryUrl)
+	if err != nil || u.Host == "" {
+		return strings.ToLower(repositoryUrl)
+	}
+
+	path := strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+	path = strings.TrimPrefix(path, "/api/v3")
+	path = strings.TrimPrefix(path, "/repos")
+
+	return strings.ToLower(strings.TrimPrefix(u.Host, "api.") + path)
+}
+
+// Has reports whether a commit of a repository was scanned
+func (c *ScanCache) Has(repositoryUrl string, commit string) bool {
+	if c == nil || commit == "" {
+		return false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.seen[dedupKey(scanCacheRepository(repositoryUrl), commit)]
+}
+
+// Add remembers commits of a repository as scanned
+func (c *ScanCache) Add(repositoryUrl string, commits ...string) {
+	if c == nil {
+		return
+	}
+
+	repository := scanCacheRepository(repositoryUrl)
+
+	c.Lock()
+	defer c.Unlock()
+
+	for _, commit := range commits {
+		if commit == "" {
+			continue
+		}
+
+		key := dedupKey(repository, commit)
+		if c.seen[key] {
+			continue
+		}
+
+		c.add(key)
+		if c.file != nil {
+			c.file.WriteString(key + "\n")
+		}
+	}
+}
+
+func (c *ScanCache) add(key string) {
+	if len(c.order) < scanCacheMaxCommits {
+		c.order = append(c.order, key)
+	} else {
+		delete(c.seen, c.order[c.next])
+		c.order[c.next] = key
+		c.next = (c.next + 1) % scanCacheMaxCommits
+	}
+
+	c.seen[key] = true
+}
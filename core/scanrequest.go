@@ -0,0 +1,209 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	ScanRequestQueued = "queued"
+	ScanRequestDone   = "done"
+	ScanRequestFailed = "failed"
+
+	// finished requests are kept this long to be polled
+	scanRequestTtl = 24 * time.Hour
+	apiScanPath    = apiPrefix + "/scan"
+)
+
+// ScanRequest is a scan queued through the API, and what it has found so
+// far. Matches reported by earlier scans are left out, as they are for
+// every scan, but are kept in the findings with --database-url
+type ScanRequest struct {
+	Id       string         `json:"id"`
+	Url      string         `json:"url"`
+	Source   string         `json:"source"`
+	Status   string         `json:"status"`
+	Error    string         `json:"error,omitempty"`
+	Queued   time.Time      `json:"queued"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Findings []*LiveFinding `json:"findings"`
+}
+
+// ScanRequests tracks the scans queued through the API by the URL their
+// findings are reported under. Scanning the same URL again before the
+// first scan finishes adds to both requests
+type ScanRequests struct {
+	sync.Mutex
+
+	requests map[string]*ScanRequest
+	pending  map[string][]*ScanRequest
+}
+
+func NewScanRequests() *ScanRequests {
+	return &ScanRequests{requests: make(map[string]*ScanRequest), pending: make(map[string][]*ScanRequest)}
+}
+
+func newScanRequest(url string, source GitResourceType) *ScanRequest {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return &ScanRequest{
+		Id:       hex.EncodeToString(id),
+		Url:      url,
+		Source:   SourceNames[source],
+		Status:   ScanRequestQueued,
+		Queued:   time.Now().UTC(),
+		Findings: []*LiveFinding{},
+	}
+}
+
+// Add starts tracking a request, forgetting those that finished over a day
+// ago
+func (r *ScanRequests) Add(request *ScanRequest) {
+	r.Lock()
+	defer r.Unlock()
+
+	for id, old := range r.requests {
+		if old.Finished != nil && time.Since(*old.Finished) > scanRequestTtl {
+			delete(r.requests, id)
+		}
+	}
+
+	r.requests[request.Id] = request
+	r.pending[request.Url] = append(r.pending[request.Url], request)
+}
+
+// Get returns a copy of a request, safe to read while it's scanned
+func (r *ScanRequests) Get(id string) (*ScanRequest, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	request, ok := r.requests[id]
+	if !ok {
+		return nil, false
+	}
+
+	copied := *request
+	copied.Findings = append([]*LiveFinding{}, request.Findings...)
+	return &copied, true
+}
+
+// IsPending reports whether a requested scan of url hasn't finished
+func (r *ScanRequests) IsPending(url string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.pending[url]) > 0
+}
+
+// Record adds a finding to the requests scanning its URL
+func (r *ScanRequests) Record(event *MatchEvent) {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, request := range r.pending[event.Url] {
+		request.Findings = append(request.Findings, newLiveFinding(event))
+	}
+}
+
+// Finish marks the requests scanning url as done, or failed if scanErr
+// isn't nil
+func (r *ScanRequests) Finish(url string, scanErr error) {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now().UTC()
+	for _, request := range r.pending[url] {
+		request.Status, request.Finished = ScanRequestDone, &now
+		if scanErr != nil {
+			request.Status, request.Error = ScanRequestFailed, scanErr.Error()
+		}
+	}
+
+	delete(r.pending, url)
+}
+
+// remove forgets a request that couldn't be queued
+func (r *ScanRequests) remove(request *ScanRequest) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.requests, request.Id)
+
+	pending := r.pending[request.Url][:0]
+	for _, other := range r.pending[request.Url] {
+		if other != request {
+			pending = append(pending, other)
+		}
+	}
+
+	if len(pending) == 0 {
+		delete(r.pending, request.Url)
+	} else {
+		r.pending[request.Url] = pending
+	}
+}
+
+// RecordScan stores the outcome of a scan and finishes the requests
+// waiting on it
+func (s *Session) RecordScan(url string, source GitResourceType, stars int, status string, scanErr error) {
+	s.Database.RecordScan(url, source, stars, status, scanErr)
+	s.ScanRequests.Finish(url, scanErr)
+}
+
+// QueueScanFiles queues a paste or an archive posted to the API straight to
+// the scan workers, without waiting for the queue. Findings are reported
+// under a URL made of the source, the request's ID and filename
+func (s *Session) QueueScanFiles(source GitResourceType, filename string, contents []byte) (*ScanRequest, error) {
+	if !s.Options.IsPublicMode() {
+		return nil, ErrScanNotPublic
+	}
+
+	filename = path.Base(filepath.ToSlash(filename))
+	request := newScanRequest("", source)
+	request.Url = SourceNames[source] + ":" + request.Id + "/" + filename
+
+	dir := filepath.Join(*s.Options.TempDirectory, GetHash(request.Url))
+	files := []MatchFile{{
+		Path:      filepath.ToSlash(filepath.Join(dir, filename)),
+		Filename:  filename,
+		Extension: path.Ext(filename),
+		Contents:  contents,
+	}}
+
+	if source == ARCHIVE_SOURCE {
+		var err error
+		if files, err = expandArchiveFile(files[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	s.ScanRequests.Add(request)
+	s.scanning.Add(1)
+
+	select {
+	case s.ScanJobs <- ScanJob{Dir: dir, Url: request.Url, Stars: -1, Source: source, Files: files}:
+		s.Log.Info("Queued %s for scanning via the API", request.Url)
+		return request, nil
+	default:
+		s.scanning.Done()
+		s.ScanRequests.remove(request)
+		return nil, ErrScanQueueFull
+	}
+}
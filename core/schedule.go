@@ -0,0 +1,270 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// cronShortcuts are the @ names accepted in place of the five fields
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@nightly": "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ScheduleConfig is a re-scan queued whenever its cron expression matches,
+// such as the watched organizations every night. Re-scans are queued at the
+// scheduled priority and clone repositories again even if seen before
+type ScheduleConfig struct {
+	Name string `yaml:"name"`
+	// minute, hour, day of month, month and day of week, or @hourly, @daily,
+	// @nightly, @weekly or @monthly. In the local time zone
+	Cron string `yaml:"cron"`
+	// the organizations and users under github_watch
+	GitHubWatch bool `yaml:"github_watch"`
+	// every repository of these on each GitHub instance
+	Organizations []string `yaml:"organizations"`
+	Users         []string `yaml:"users"`
+	// repositories, gists, images, packages and archives, as accepted by the
+	// scan API
+	Targets []string `yaml:"targets"`
+
+	cron *cronSchedule
+}
+
+// cronSchedule is a parsed cron expression, each field the set of values
+// it matches
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek map[int]bool
+	// a day matches on either day field when both are restricted
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// validate parses the cron expression and infers the source of the targets
+func (c *ScheduleConfig) validate() error {
+	if c.Name == "" {
+		c.Name = c.Cron
+	}
+
+	var err error
+	if c.cron, err = parseCron(c.Cron); err != nil {
+		return fmt.Errorf("schedule %s: %s", c.Name, err)
+	}
+
+	for _, target := range c.Targets {
+		if !isScanSource(ScanTargetType(target)) {
+			return fmt.Errorf("schedule %s: can't tell the source of %s", c.Name, target)
+		}
+	}
+
+	return nil
+}
+
+// parseCron parses a five field cron expression or one of cronShortcuts
+func parseCron(expression string) (*cronSchedule, error) {
+	if shortcut, ok := cronShortcuts[strings.ToLower(strings.TrimSpace(expression))]; ok {
+		expression = shortcut
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression '%s' must have five fields: minute, hour, day of month, month and day of week", expression)
+	}
+
+	schedule := &cronSchedule{
+		anyDayOfMonth: fields[2] == "*",
+		anyDayOfWeek:  fields[4] == "*",
+	}
+
+	var err error
+	for _, field := range []struct {
+		values   *map[int]bool
+		min, max int
+	}{
+		{&schedule.minute, 0, 59},
+		{&schedule.hour, 0, 23},
+		{&schedule.dayOfMonth, 1, 31},
+		{&schedule.month, 1, 12},
+		{&schedule.dayOfWeek, 0, 7},
+	} {
+		if *field.values, err = parseCronField(fields[0], field.min, field.max); err != nil {
+			return nil, fmt.Errorf("cron expression '%s': %s", expression, err)
+		}
+		fields = fields[1:]
+	}
+
+	// 7 is Sunday too
+	if schedule.dayOfWeek[7] {
+		schedule.dayOfWeek[0] = true
+	}
+
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of *, values and ranges,
+// each optionally with a /step
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %s", part)
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %s", part)
+			}
+
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid range %s", part)
+				}
+			} else if step > 1 {
+				// 5/15 runs from 5 to the end
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("%s is out of range %d-%d", part, min, max)
+		}
+
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// Matches reports whether the schedule is due in the minute of t
+func (c *cronSchedule) Matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+
+	dayOfMonth, dayOfWeek := c.dayOfMonth[t.Day()], c.dayOfWeek[int(t.Weekday())]
+	switch {
+	case c.anyDayOfMonth:
+		return dayOfWeek
+	case c.anyDayOfWeek:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
+
+// RunSchedules checks the schedules at the start of every minute and queues
+// the re-scans of those due
+func RunSchedules(session *Session) {
+	if len(session.Config.Schedules) == 0 {
+		return
+	}
+
+	localCtx, cancel := context.WithCancel(session.PollContext)
+	defer cancel()
+
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+
+		select {
+		case <-time.After(next.Sub(now)):
+		case <-localCtx.Done():
+			return
+		}
+
+		for i := range session.Config.Schedules {
+			if schedule := &session.Config.Schedules[i]; schedule.cron.Matches(next) {
+				go queueSchedule(localCtx, session, schedule)
+			}
+		}
+	}
+}
+
+// queueSchedule queues every repository and target of a schedule
+func queueSchedule(ctx context.Context, session *Session, schedule *ScheduleConfig) {
+	organizations, users := schedule.Organizations, schedule.Users
+	if schedule.GitHubWatch {
+		organizations = append(append([]string{}, organizations...), session.Config.GitHubWatch.Organizations...)
+		users = append(append([]string{}, users...), session.Config.GitHubWatch.Users...)
+	}
+
+	queued := 0
+	for _, instance := range session.GitHub {
+		for _, organization := range organizations {
+			queued += queueOwnerRepositories(ctx, session, instance, organization, true)
+		}
+
+		for _, user := range users {
+			queued += queueOwnerRepositories(ctx, session, instance, user, false)
+		}
+	}
+
+	for _, target := range schedule.Targets {
+		session.QueueRepository(GitResource{Type: ScanTargetType(target), Url: target, Priority: PriorityScheduled})
+		queued++
+	}
+
+	session.Log.Info("Queued %d repositories to re-scan for schedule %s", queued, schedule.Name)
+}
+
+// queueOwnerRepositories queues every repository of an organization or user
+// to be re-scanned, returning how many were
+func queueOwnerRepositories(ctx context.Context, session *Session, instance *GitHubInstance, owner string, organization bool) int {
+	queued := 0
+
+	for page := 1; page != 0 && ctx.Err() == nil; {
+		var repositories []*github.Repository
+		err := callGitHub(session, instance, func(client *GitHubClientWrapper) (resp *github.Response, err error) {
+			opt := github.ListOptions{PerPage: 100, Page: page}
+			if organization {
+				repositories, resp, err = client.Repositories.ListByOrg(ctx, owner, &github.RepositoryListByOrgOptions{ListOptions: opt})
+			} else {
+				repositories, resp, err = client.Repositories.List(ctx, owner, &github.RepositoryListOptions{ListOptions: opt})
+			}
+
+			if resp != nil {
+				page = resp.NextPage
+			}
+			return resp, err
+		})
+
+		if err != nil {
+			session.Log.Warn("Error listing %s repositories of %s to re-scan: %s", instance.Name, owner, err)
+			return queued
+		}
+
+		for _, repository := range repositories {
+			session.QueueRepository(GitResource{
+				Id:       repository.GetID(),
+				Type:     GITHUB_SOURCE,
+				Url:      repository.GetHTMLURL(),
+				GitHub:   instance,
+				Watched:  true,
+				Priority: PriorityScheduled,
+			})
+			queued++
+		}
+	}
+
+	return queued
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SopsKey          = "sops"
+	secretFilePrefix = "file:"
+)
+
+// secretExpander resolves references in the values of credentials in
+// config.yaml, so it can be committed without them. ${NAME} is replaced by
+// the environment variable, and ${file:/path} by the contents of the file,
+// without the trailing newline, such as a Docker or Kubernetes secret. Any
+// other $ is kept as it is, and $${ is a literal ${. The first reference
+// that couldn't be resolved is kept in err
+type secretExpander struct {
+	err error
+}
+
+func (s *secretExpander) expand(value string) string {
+	expanded := strings.Builder{}
+
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			break
+		}
+
+		if start > 0 && value[start-1] == '$' {
+			expanded.WriteString(value[:start] + "{")
+			value = value[start+2:]
+			continue
+		}
+
+		end := strings.IndexByte(value[start:], '}')
+		if end < 0 {
+			break
+		}
+
+		expanded.WriteString(value[:start])
+		expanded.WriteString(s.resolve(value[start+2 : start+end]))
+		value = value[start+end+1:]
+	}
+
+	expanded.WriteString(value)
+	return expanded.String()
+}
+
+func (s *secretExpander) resolve(name string) string {
+	if !strings.HasPrefix(name, secretFilePrefix) {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			s.fail(fmt.Errorf("the environment variable %s isn't set", name))
+		}
+
+		return value
+	}
+
+	path := strings.TrimPrefix(name, secretFilePrefix)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		s.fail(fmt.Errorf("couldn't read the secret in %s: %s", path, err))
+		return ""
+	}
+
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+func (s *secretExpander) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// IsSopsEncrypted reports whether the config was encrypted with SOPS, going
+// by the metadata it adds under the sops key
+func IsSopsEncrypted(data []byte) bool {
+	metadata := struct {
+		Sops yaml.Node `yaml:"sops"`
+	}{}
+
+	return yaml.Unmarshal(data, &metadata) == nil && metadata.Sops.Kind != 0
+}
+
+// DecryptSops decrypts the config file at path with the sops binary, which
+// finds the key itself, i.e. an age key in SOPS_AGE_KEY_FILE or a cloud KMS
+func DecryptSops(path string) ([]byte, error) {
+	cmd := exec.Command("sops", "--decrypt", "--input-type", "yaml", "--output-type", "yaml", path)
+	data, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			err = errors.New(strings.TrimSpace(string(exitErr.Stderr)))
+		}
+
+		return nil, fmt.Errorf("couldn't decrypt %s with sops: %s", path, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,21 @@
+package core
+
+import (
+	"net/http"
+)
+
+func (s *Session) InitServer() {
+	s.Server = http.NewServeMux()
+	s.Server.Handle("/metrics", s.Metrics)
+
+	if *s.Options.Listen == "" {
+		return
+	}
+
+	go func() {
+		s.Log.Debug("Listening on %s", *s.Options.Listen)
+		if err := http.ListenAndServe(*s.Options.Listen, s.Server); err != nil {
+			s.Log.Fatal("Failed to listen on %s: %s", *s.Options.Listen, err)
+		}
+	}()
+}
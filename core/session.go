@@ -2,11 +2,12 @@ package core
 
 import (
 	"context"
-	"encoding/csv"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,18 +18,62 @@ import (
 type Session struct {
 	sync.Mutex
 
-	Version          string
-	Log              *Logger
-	Options          *Options
-	Config           *Config
-	Signatures       []Signature
-	Repositories     chan GitResource
-	Gists            chan string
-	Comments         chan string
-	Context          context.Context
-	Clients          chan *GitHubClientWrapper
-	ExhaustedClients chan *GitHubClientWrapper
-	CsvWriter        *csv.Writer
+	Version      string
+	Log          *Logger
+	Options      *Options
+	Config       *Config
+	Signatures   []Signature
+	Matcher      *Matcher
+	reloading    sync.RWMutex
+	Repositories chan GitResource
+	// repositories of the priorities above the firehose
+	queues map[string]chan GitResource
+	// repositories larger than --large-repository-size, cloned when
+	// nothing else is queued
+	LargeRepositories chan GitResource
+	Gists             chan string
+	Comments          chan string
+	Pastes            chan Paste
+	ScanJobs          chan ScanJob
+	findings          chan *MatchEvent
+	Context           context.Context // cancelled once in-flight work is given up on
+	cancel            context.CancelFunc
+	PollContext       context.Context // cancelled as soon as shhgit starts shutting down
+	stopPolling       context.CancelFunc
+	StartedAt         time.Time
+	GitHub            []*GitHubInstance
+	RateLimiters      map[GitResourceType]*RateLimiter
+	CsvWriter         *CsvWriter
+	OutputWriter      OutputWriter
+	Dedup             *DedupStore
+	ScanCache         *ScanCache
+	EventFilter       *EventFilter
+	Database          *Database
+	Jobs              *RedisQueue
+	Results           *RedisQueue
+	Shared            *SharedState
+	Allowlist         *Allowlist
+	Contexts          *ContextFilter
+	Canaries          *CanaryDetector
+	Forks             *ForkIndex
+	Tenants           map[string]*Tenant
+	WebAuth           *WebAuth
+	Live              *LiveSink
+	ScanRequests      *ScanRequests
+	Cloner            *Cloner
+	Baseline          *BaselineWriter
+	Sinks             []Sink
+	publishing        sync.WaitGroup
+	failures          int64
+	Metrics           *Metrics
+	Server            *http.ServeMux
+	Checkpoint        *Checkpoint
+	DiskQuota         *DiskQuota
+	MemoryBudget      *MemoryBudget
+	stopping          bool
+	working           sync.WaitGroup
+	scanning          sync.WaitGroup
+	requeued          pendingWork
 }
 
 var (
@@ -41,83 +86,207 @@ func (s *Session) Start() {
 	rand.Seed(time.Now().Unix())
 
 	s.InitLogger()
+	s.InitServer()
 	s.InitThreads()
+	s.InitQueues()
 	s.InitSignatures()
 	s.InitGitHubClients()
+	s.InitRateLimiters()
+	s.InitCloner()
 	s.InitCsvWriter()
+	s.InitOutputWriter()
+	s.InitDedupStore()
+	s.InitScanCache()
+	s.InitDatabase()
+	s.InitDistributed()
+	s.InitSharedState()
+	s.InitEventFilter()
+	s.InitWebAuth()
+	s.InitApi()
+	s.InitGrpc()
+	s.InitLive()
+	s.InitAllowlist()
+	s.InitContextFilter()
+	s.InitCanaries()
+	s.InitForkIndex()
+	s.InitTenants()
+	s.InitSinks()
+	s.InitCheckpoint()
+	s.InitDiskQuota()
+	s.InitMemoryBudget()
 }
 
 func (s *Session) InitLogger() {
-	s.Log = &Logger{}
+	s.Log = NewLogger()
 	s.Log.SetDebug(*s.Options.Debug)
 	s.Log.SetSilent(*s.Options.Silent)
+	s.Log.SetFormat(*s.Options.LogFormat)
+
+	if *s.Options.LogLevel != "" {
+		s.Log.SetLevel(*s.Options.LogLevel)
+	}
+
+	modules, _ := ParseLogModules(*s.Options.LogModules)
+	s.Log.SetModules(modules)
+
+	if *s.Options.LogFile != "" {
+		if err := s.Log.SetFile(*s.Options.LogFile, *s.Options.LogMaxSize, *s.Options.LogMaxBackups); err != nil {
+			s.Log.Fatal("Failed to open log file %s: %s", *s.Options.LogFile, err)
+		}
+	}
 }
 
 func (s *Session) InitSignatures() {
-	s.Signatures = GetSignatures(s)
+	signatures, err := s.loadSignatures(s.Config)
+	if err != nil {
+		s.Log.Fatal("%s", err)
+	}
+
+	s.Signatures = signatures
+	s.Matcher = NewMatcher(signatures, *s.Options.MatchBackend)
 }
 
-func (s *Session) InitGitHubClients() {
-	if len(*s.Options.Local) <= 0 {
-		chanSize := *s.Options.Threads * (len(s.Config.GitHubAccessTokens) + 1)
-		s.Clients = make(chan *GitHubClientWrapper, chanSize)
-		s.ExhaustedClients = make(chan *GitHubClientWrapper, chanSize)
-		for _, token := range s.Config.GitHubAccessTokens {
-			ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-			tc := oauth2.NewClient(s.Context, ts)
-
-			client := github.NewClient(tc)
-			client.UserAgent = fmt.Sprintf("%s v%s", Name, Version)
-			_, _, err := client.Users.Get(s.Context, "")
-
-			if err != nil {
-				if _, ok := err.(*github.ErrorResponse); ok {
-					s.Log.Warn("Failed to validate token %s[..]: %s", token[:10], err)
-					continue
-				}
-			}
-
-			for i := 0; i <= *s.Options.Threads; i++ {
-				s.Clients <- &GitHubClientWrapper{client, token, time.Now().Add(-1 * time.Second)}
-			}
+// loadSignatures merges the signature packs in to the signatures of config
+// and compiles them, along with any YARA rules
+func (s *Session) loadSignatures(config *Config) ([]Signature, error) {
+	if dir := *s.Options.SignaturesDirectory; dir != "" {
+		packs, err := LoadSignaturePacks(dir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load signature packs from %s: %s", dir, err)
 		}
 
-		if len(s.Clients) < 1 {
-			s.Log.Fatal("No valid GitHub tokens provided. Quitting!")
+		s.Log.Debug("Loaded %d signatures from packs in %s", len(packs), dir)
+		config.Signatures = append(config.Signatures, packs...)
+	}
+
+	signatures := GetSignatures(s, config)
+
+	if dir := *s.Options.YaraRulesDirectory; dir != "" {
+		rules, err := LoadYaraSignatures(s, dir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load YARA rules from %s: %s", dir, err)
 		}
+
+		s.Log.Debug("Loaded %d YARA rules from %s", len(rules), dir)
+		signatures = append(signatures, rules...)
 	}
+
+	return signatures, nil
+}
+
+// CurrentSignatures returns the signatures to match with, which change
+// when they're reloaded
+func (s *Session) CurrentSignatures() []Signature {
+	s.reloading.RLock()
+	defer s.reloading.RUnlock()
+
+	return s.Signatures
 }
 
-func (s *Session) GetClient() *GitHubClientWrapper {
-	for {
-		select {
+// CurrentMatcher returns the matcher of the current signatures
+func (s *Session) CurrentMatcher() *Matcher {
+	s.reloading.RLock()
+	defer s.reloading.RUnlock()
 
-		case client := <-s.Clients:
-			s.Log.Debug("Using client with token: %s", client.Token[:10])
-			return client
+	return s.Matcher
+}
 
-		case client := <-s.ExhaustedClients:
-			sleepTime := time.Until(client.RateLimitedUntil)
-			s.Log.Warn("All GitHub tokens exhausted/rate limited. Sleeping for %s", sleepTime.String())
-			time.Sleep(sleepTime)
-			s.Log.Debug("Returning client %s to pool", client.Token[:10])
-			s.FreeClient(client)
+// Blacklists are the blacklist options in effect when they were read.
+// Reloading replaces the slices rather than changing them, so they can be
+// used without holding the lock
+type Blacklists struct {
+	Strings           []string
+	Extensions        []string
+	Paths             []string
+	EntropyExtensions []string
+}
 
-		default:
-			s.Log.Debug("Available Clients: %d", len(s.Clients))
-			s.Log.Debug("Exhausted Clients: %d", len(s.ExhaustedClients))
-			time.Sleep(time.Millisecond * 1000)
+// CurrentBlacklists returns the blacklists, which change when they're
+// reloaded
+func (s *Session) CurrentBlacklists() Blacklists {
+	s.reloading.RLock()
+	defer s.reloading.RUnlock()
+
+	return Blacklists{
+		Strings:           s.Config.BlacklistedStrings,
+		Extensions:        s.Config.BlacklistedExtensions,
+		Paths:             s.Config.BlacklistedPaths,
+		EntropyExtensions: s.Config.BlacklistedEntropyExtensions,
+	}
+}
+
+func (s *Session) InitGitHubClients() {
+	if !s.Options.IsPublicMode() {
+		return
+	}
+
+	if len(s.Config.GitHubAccessTokens) > 0 {
+		s.GitHub = append(s.GitHub, s.newGitHubInstance(githubInstanceName, "", "", s.Config.GitHubAccessTokens))
+	}
+
+	for _, enterprise := range s.Config.GitHubEnterprise {
+		s.GitHub = append(s.GitHub, s.newGitHubInstance(enterprise.Name, enterprise.ApiUrl, enterprise.UploadsUrl, enterprise.AccessTokens))
+	}
+}
+
+// newGitHubInstance validates each token against the instance and pools a
+// client for it. apiUrl is empty for github.com
+func (s *Session) newGitHubInstance(name string, apiUrl string, uploadsUrl string, tokens []string) *GitHubInstance {
+	instance := NewGitHubInstance(name)
+
+	for _, token := range tokens {
+		if err := s.addGitHubToken(instance, apiUrl, uploadsUrl, token); err != nil {
+			s.Log.Warn("Failed to validate %s token %s[..]: %s", name, token[:10], err)
 		}
 	}
+
+	if instance.Size() < 1 {
+		s.Log.Fatal("No valid %s tokens provided. Quitting!", name)
+	}
+
+	return instance
 }
 
-// FreeClient returns the GitHub Client to the pool of available,
-// non-rate-limited channel of clients in the session
-func (s *Session) FreeClient(client *GitHubClientWrapper) {
-	if client.RateLimitedUntil.After(time.Now()) {
-		s.ExhaustedClients <- client
-	} else {
-		s.Clients <- client
+// addGitHubToken pools a client for a token unless the instance rejects it
+func (s *Session) addGitHubToken(instance *GitHubInstance, apiUrl string, uploadsUrl string, token string) error {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	tc := oauth2.NewClient(s.Context, ts)
+
+	client := github.NewClient(tc)
+	if apiUrl != "" {
+		var err error
+		if client, err = github.NewEnterpriseClient(apiUrl, uploadsUrl, tc); err != nil {
+			return fmt.Errorf("invalid API URL %s: %s", apiUrl, err)
+		}
+	}
+
+	client.UserAgent = fmt.Sprintf("%s v%s", Name, Version)
+	_, resp, err := client.Users.Get(s.Context, "")
+
+	if err != nil {
+		if _, ok := err.(*github.ErrorResponse); ok {
+			return err
+		}
+	}
+
+	instance.UpdateRate(instance.AddClient(client, token), resp)
+	return nil
+}
+
+func (s *Session) InitRateLimiters() {
+	s.RateLimiters = map[GitResourceType]*RateLimiter{
+		GITLAB_SOURCE:       NewRateLimiter(s.Config.GitLab.RequestsPerMinute),
+		BITBUCKET_SOURCE:    NewRateLimiter(s.Config.Bitbucket.RequestsPerMinute),
+		DOCKER_SOURCE:       NewRateLimiter(s.Config.Docker.RequestsPerMinute),
+		GITEA_SOURCE:        NewRateLimiter(s.Config.Gitea.RequestsPerMinute),
+		AZURE_DEVOPS_SOURCE: NewRateLimiter(s.Config.AzureDevOps.RequestsPerMinute),
+		PASTE_SOURCE:        NewRateLimiter(s.Config.Pastebin.RequestsPerMinute),
+		PACKAGE_SOURCE:      NewRateLimiter(s.Config.Packages.RequestsPerMinute),
+		BUCKET_SOURCE:       NewRateLimiter(s.Config.Buckets.RequestsPerMinute),
+	}
+
+	for source, limiter := range s.RateLimiters {
+		limiter.name = SourceNames[source]
 	}
 }
 
@@ -130,43 +299,266 @@ func (s *Session) InitThreads() {
 	runtime.GOMAXPROCS(*s.Options.Threads + 1)
 }
 
+// InitQueues sizes the worker pools (defaulting to --threads) and creates
+// the bounded channels connecting the pollers, clone, scan and output workers
+func (s *Session) InitQueues() {
+	workers := &s.Config.Workers
+
+	if workers.Clone <= 0 {
+		workers.Clone = *s.Options.Threads
+	}
+
+	if workers.Scan <= 0 {
+		workers.Scan = *s.Options.Threads
+	}
+
+	if workers.Output <= 0 {
+		workers.Output = 2
+	}
+
+	if workers.QueueSize <= 0 {
+		workers.QueueSize = 1000
+	}
+
+	if workers.ScanQueueSize <= 0 {
+		workers.ScanQueueSize = workers.Scan
+	}
+
+	s.Repositories = make(chan GitResource, workers.QueueSize)
+	s.queues = map[string]chan GitResource{PriorityFirehose: s.Repositories}
+	for _, priority := range Priorities {
+		if priority != PriorityFirehose {
+			s.queues[priority] = make(chan GitResource, workers.QueueSize)
+		}
+	}
+	s.LargeRepositories = make(chan GitResource, workers.QueueSize)
+	s.Gists = make(chan string, workers.QueueSize)
+	s.Comments = make(chan string, workers.QueueSize)
+	s.Pastes = make(chan Paste, workers.QueueSize)
+	s.ScanJobs = make(chan ScanJob, workers.ScanQueueSize)
+	s.findings = make(chan *MatchEvent, workers.QueueSize)
+}
+
 func (s *Session) InitCsvWriter() {
 	if *s.Options.CsvPath == "" {
 		return
 	}
 
-	writeHeader := false
-	if !PathExists(*s.Options.CsvPath) {
-		writeHeader = true
+	fields, err := ParseCsvFields(*s.Options.CsvFields)
+	if err != nil {
+		s.Log.Fatal("%s", err)
+	}
+
+	s.CsvWriter, err = NewCsvWriter(*s.Options.CsvPath, fields)
+	if err != nil {
+		s.Log.Fatal("Could not create/open CSV file: %s", err)
 	}
+}
 
-	file, err := os.OpenFile(*s.Options.CsvPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	LogIfError("Could not create/open CSV file", err)
+func (s *Session) WriteToCsv(event *MatchEvent) {
+	if s.CsvWriter == nil {
+		return
+	}
 
-	s.CsvWriter = csv.NewWriter(file)
+	LogIfError("Failed to write to CSV", s.CsvWriter.Write(event))
+}
+
+func (s *Session) InitOutputWriter() {
+	format := *s.Options.Format
+	if format == "" {
+		return
+	}
+
+	if !containsString(OutputFormats, format) {
+		s.Log.Fatal("Unknown --format %s. Available formats: %s", format, strings.Join(OutputFormats, ", "))
+	}
+
+	// workflow commands are read from stdout
+	if *s.Options.OutputPath == "" && format != FormatGitHubActions {
+		s.Log.Fatal("--output-path is required when using --format %s", format)
+	}
+
+	fields, err := ParseCsvFields(*s.Options.CsvFields)
+	if err != nil {
+		s.Log.Fatal("%s", err)
+	}
 
-	if writeHeader {
-		s.WriteToCsv([]string{"Repository name", "Signature name", "Matching file", "Matches"})
+	s.OutputWriter, err = NewOutputWriter(format, *s.Options.OutputPath, fields, s.Signatures)
+	if err != nil {
+		s.Log.Fatal("Failed to open %s: %s", *s.Options.OutputPath, err)
 	}
 }
 
-func (s *Session) WriteToCsv(line []string) {
-	if *s.Options.CsvPath == "" {
+func (s *Session) InitDedupStore() {
+	if *s.Options.DedupPath == "" {
+		return
+	}
+
+	dedup, err := NewDedupStore(*s.Options.DedupPath)
+	if err != nil {
+		s.Log.Fatal("Failed to open dedup store %s: %s", *s.Options.DedupPath, err)
+	}
+
+	s.Dedup = dedup
+}
+
+// InitScanCache remembers the commits scanned when watching public sources
+func (s *Session) InitScanCache() {
+	if !s.Options.IsPublicMode() {
+		return
+	}
+
+	cache, err := NewScanCache(*s.Options.ScanCachePath)
+	if err != nil {
+		s.Log.Fatal("Failed to open scan cache %s: %s", *s.Options.ScanCachePath, err)
+	}
+
+	s.ScanCache = cache
+}
+
+// InitEventFilter remembers the repositories and refs queued from the
+// event feeds when watching public sources, unless event_filter is disabled
+func (s *Session) InitEventFilter() {
+	config := s.Config.EventFilter
+	if config.Disabled || !s.Options.IsPublicMode() || *s.Options.Role == RoleWorker {
+		return
+	}
+
+	var shared *SharedState
+	if config.Redis {
+		shared = s.Shared
+	}
+
+	s.EventFilter = NewEventFilter(config, shared)
+}
+
+func (s *Session) InitDatabase() {
+	if *s.Options.DatabaseUrl == "" {
+		return
+	}
+
+	database, err := NewDatabase(*s.Options.DatabaseUrl)
+	if err != nil {
+		s.Log.Fatal("Failed to open database: %s", err)
+	}
+
+	s.Database = database
+	s.Sinks = append(s.Sinks, NewDatabaseSink(database))
+}
+
+func (s *Session) InitSinks() {
+	if s.Config.Revocation.Enabled() && !*s.Options.Verify {
+		s.Log.Warn("Secrets are only revoked once verified, so revocation is disabled without --verify")
+	}
+
+	s.Sinks = append(s.Sinks, s.newSinks(s.Config.SinksConfig)...)
+
+	if s.Config.Vault.Enabled() {
+		s.Sinks = append(s.Sinks, NewVaultSink(s.Config.Vault))
+	}
+
+	if s.Config.GitHubRemediation.Enabled() {
+		remediation, err := NewGitHubRemediationSink(s.Config.GitHubRemediation)
+		if err != nil {
+			s.Log.Fatal("Invalid github_remediation.api_url %s: %s", s.Config.GitHubRemediation.ApiUrl, err)
+		}
+
+		s.Sinks = append(s.Sinks, remediation)
+	}
+
+	for i := 0; i < s.Config.Workers.Output; i++ {
+		go s.processFindings()
+	}
+}
+
+// newSinks opens the outputs and sinks of the deployment or of a tenant
+func (s *Session) newSinks(config SinksConfig) []Sink {
+	var sinks []Sink
+
+	for _, output := range config.Outputs {
+		sink, err := NewOutputSink(output, s.Signatures)
+		if err != nil {
+			s.Log.Fatal("Failed to open %s output %s: %s", output.Format, output.Path, err)
+		}
+
+		sinks = append(sinks, sink)
+	}
+
+	for _, webhook := range config.Webhooks {
+		sinks = append(sinks, NewWebhookSink(webhook))
+	}
+
+	for _, slack := range config.Slack {
+		sinks = append(sinks, NewSlackSink(slack))
+	}
+
+	for _, discord := range config.Discord {
+		sinks = append(sinks, NewDiscordSink(discord))
+	}
+
+	for _, teams := range config.Teams {
+		sinks = append(sinks, NewTeamsSink(teams))
+	}
+
+	for _, mattermost := range config.Mattermost {
+		sinks = append(sinks, NewMattermostSink(mattermost))
+	}
+
+	for _, kafka := range config.Kafka {
+		sinks = append(sinks, NewKafkaSink(kafka))
+	}
+
+	for _, elasticsearch := range config.Elasticsearch {
+		sinks = append(sinks, NewElasticsearchSink(elasticsearch))
+	}
+
+	for _, sqs := range config.Sqs {
+		sinks = append(sinks, NewSQSSink(sqs))
+	}
+
+	for _, sns := range config.Sns {
+		sinks = append(sinks, NewSNSSink(sns))
+	}
+
+	for _, pagerDuty := range config.PagerDuty {
+		sinks = append(sinks, NewPagerDutySink(pagerDuty))
+	}
+
+	for _, opsgenie := range config.Opsgenie {
+		sinks = append(sinks, NewOpsgenieSink(opsgenie))
+	}
+
+	for _, email := range config.Email {
+		sinks = append(sinks, NewEmailSink(email))
+	}
+
+	for _, syslog := range config.Syslog {
+		sinks = append(sinks, NewSyslogSink(syslog))
+	}
+
+	for _, splunk := range config.Splunk {
+		sinks = append(sinks, NewSplunkSink(splunk))
+	}
+
+	return sinks
+}
+
+func (s *Session) WriteToOutput(event *MatchEvent) {
+	if s.OutputWriter == nil {
 		return
 	}
 
-	s.CsvWriter.Write(line)
-	s.CsvWriter.Flush()
+	LogIfError("Could not write to "+*s.Options.OutputPath, s.OutputWriter.Write(event))
 }
 
 func GetSession() *Session {
 	sessionSync.Do(func() {
 		session = &Session{
-			Context:      context.Background(),
-			Repositories: make(chan GitResource, 1000),
-			Gists:        make(chan string, 100),
-			Comments:     make(chan string, 1000),
+			StartedAt: time.Now(),
+			Metrics:   NewMetrics(),
 		}
+		session.Context, session.cancel = context.WithCancel(context.Background())
+		session.PollContext, session.stopPolling = context.WithCancel(session.Context)
 
 		if session.Options, err = ParseOptions(); err != nil {
 			fmt.Println(err)
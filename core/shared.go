@@ -0,0 +1,151 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultDistributedSeenTtl    = 60
+	defaultDistributedFindingTtl = 30
+)
+
+// SharedState is what instances sharing distributed.redis_url tell each
+// other, so they don't scan the same repositories, alert on the same
+// findings or use tokens another instance has exhausted
+type SharedState struct {
+	client *redisClient
+	prefix string
+	// how long a queued repository is remembered
+	seenTtl time.Duration
+	// how long a reported finding is remembered
+	findingTtl time.Duration
+}
+
+func NewSharedState(config DistributedConfig) (*SharedState, error) {
+	shared := &SharedState{
+		client:     &redisClient{url: config.RedisUrl},
+		prefix:     config.Queue + ":",
+		seenTtl:    time.Duration(config.SeenTtl) * time.Minute,
+		findingTtl: time.Duration(config.FindingTtl) * 24 * time.Hour,
+	}
+
+	if _, err := shared.client.Do(0, "PING"); err != nil {
+		return nil, err
+	}
+
+	return shared, nil
+}
+
+// setIfAbsent sets key and reports whether it didn't exist. Should Redis
+// be unreachable it reports true, so work is duplicated rather than lost
+func (s *SharedState) setIfAbsent(key string, ttl time.Duration) bool {
+	args := []string{"SET", s.prefix + key, "1", "NX"}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	}
+
+	_, err := s.client.Do(0, args...)
+	if err == errRedisNil {
+		return false
+	} else if err != nil {
+		session.Log.Debug("Failed to update shared state: %s", err)
+	}
+
+	return true
+}
+
+// IsNewResource records a repository, gist, image, package or archive
+// being queued and reports whether no instance has queued it in
+// the last seen_ttl minutes. Pushes are told apart by their head commit
+func (s *SharedState) IsNewResource(resource GitResource) bool {
+	return s.setIfAbsent("seen:"+dedupKey(fmt.Sprint(resource.Type), resource.Url, resource.Ref, resource.Head), s.seenTtl)
+}
+
+// IsNewFinding records a match and reports whether no instance has
+// reported it in the last finding_ttl days, either in the same repository
+// or, when network is given, in the same network of forks
+func (s *SharedState) IsNewFinding(url string, network string, path string, match string) bool {
+	isNew := true
+	for _, key := range findingKeys(url, network, path, match) {
+		if !s.setIfAbsent("finding:"+key, s.findingTtl) {
+			isNew = false
+		}
+	}
+
+	return isNew
+}
+
+// SetRateLimited tells the other instances a token can't be used until
+// reset. Tokens are only stored hashed
+func (s *SharedState) SetRateLimited(token string, reset time.Time) {
+	ttl := time.Until(reset)
+	if ttl <= 0 {
+		return
+	}
+
+	_, err := s.client.Do(0, "SET", s.prefix+"ratelimit:"+dedupKey(token), strconv.FormatInt(reset.Unix(), 10), "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	if err != nil {
+		session.Log.Debug("Failed to share rate limit of token %s[..]: %s", token[:10], err)
+	}
+}
+
+// RateLimitedUntil returns when another instance found a token will next
+// be usable, or the zero time if it isn't rate limited
+func (s *SharedState) RateLimitedUntil(token string) time.Time {
+	reply, err := s.client.Do(0, "GET", s.prefix+"ratelimit:"+dedupKey(token))
+	if err != nil {
+		return time.Time{}
+	}
+
+	reset, _ := strconv.ParseInt(fmt.Sprint(reply), 10, 64)
+	return time.Unix(reset, 0)
+}
+
+// Take counts a request against the requests per minute allowed across
+// every instance for a provider, and returns how long to wait if none are
+// left this minute
+func (s *SharedState) Take(name string, requestsPerMinute int) time.Duration {
+	now := time.Now()
+	window := now.Truncate(time.Minute)
+	key := s.prefix + "requests:" + name + ":" + strconv.FormatInt(window.Unix(), 10)
+
+	reply, err := s.client.Do(0, "INCR", key)
+	if err != nil {
+		return 0
+	}
+
+	if count, _ := reply.(int64); count == 1 {
+		s.client.Do(0, "EXPIRE", key, "120")
+	} else if count > int64(requestsPerMinute) {
+		return window.Add(time.Minute).Sub(now)
+	}
+
+	return 0
+}
+
+func (s *Session) InitSharedState() {
+	if s.Config.Distributed.RedisUrl == "" {
+		return
+	}
+
+	shared, err := NewSharedState(s.Config.Distributed)
+	if err != nil {
+		s.Log.Fatal("Failed to connect to Redis: %s", err)
+	}
+
+	s.Shared = shared
+}
+
+// IsNewResource reports whether a resource should be cloned and scanned,
+// as no other instance sharing state has done so recently. Workers take
+// what the coordinator already checked, buckets are meant to be scanned
+// again every interval and scheduled re-scans whenever they're due
+func (s *Session) IsNewResource(resource GitResource) bool {
+	if s.Shared == nil || *s.Options.Role == RoleWorker || resource.Type == BUCKET_SOURCE || resource.Priority == PriorityScheduled {
+		return true
+	}
+
+	return s.Shared.IsNewResource(resource)
+}
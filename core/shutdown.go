@@ -0,0 +1,248 @@
+package core
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// how long cancelled work has to requeue itself once the shutdown timeout
+// has passed
+const shutdownRequeueTimeout = 5 * time.Second
+
+func (s *Session) InitCheckpoint() {
+	if *s.Options.CheckpointPath == "" {
+		return
+	}
+
+	checkpoint, err := LoadCheckpoint(*s.Options.CheckpointPath)
+	if err != nil {
+		s.Log.Fatal("Failed to load checkpoint %s: %s", *s.Options.CheckpointPath, err)
+	}
+
+	s.Checkpoint = checkpoint
+	go saveCheckpoints(s)
+}
+
+// ResumeCheckpoint queues the work that was pending when shhgit last
+// stopped. Call it once the workers have started
+func (s *Session) ResumeCheckpoint() {
+	jobs, comments, pastes := s.Checkpoint.TakePending()
+	if len(jobs)+len(comments)+len(pastes) == 0 {
+		return
+	}
+
+	s.Log.Info("[*] Resuming %d repositories, %d comments and %d pastes queued before the last shutdown", len(jobs), len(comments), len(pastes))
+
+	go func() {
+		for _, job := range jobs {
+			if job.Type == GIST_SOURCE {
+				s.Gists <- job.Url
+			} else if repository, ok := s.jobResource(job); ok {
+				s.QueueRepository(repository)
+			}
+		}
+
+		for _, comment := range comments {
+			s.Comments <- comment
+		}
+
+		for _, paste := range pastes {
+			s.Pastes <- paste
+		}
+	}()
+}
+
+// StartWork marks a repository, gist, comment or paste taken off a queue as
+// being worked on, so shutting down waits for it. It returns false once
+// shhgit is shutting down, and the work should be handed to Requeue
+func (s *Session) StartWork() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.stopping {
+		return false
+	}
+
+	s.working.Add(1)
+	return true
+}
+
+func (s *Session) FinishWork() {
+	s.working.Done()
+}
+
+// Interrupted reports whether shutting down gave up waiting for the work in
+// progress, cancelling it. Work that was interrupted should be handed to
+// Requeue so it's done again after a restart
+func (s *Session) Interrupted() bool {
+	return s.Context.Err() != nil
+}
+
+// QueueScan queues a job for the scan workers, which call FinishScan once
+// it has been scanned
+func (s *Session) QueueScan(job ScanJob) {
+	s.scanning.Add(1)
+	s.ScanJobs <- job
+}
+
+func (s *Session) FinishScan() {
+	s.scanning.Done()
+}
+
+// pendingWork is what was queued but not started when shhgit shut down
+type pendingWork struct {
+	jobs     []DistributedJob
+	comments []string
+	pastes   []Paste
+}
+
+// Requeue keeps a repository, comment or paste that was taken off a queue
+// during shutdown for after the restart. Gists are GitResources of
+// GIST_SOURCE
+func (s *Session) Requeue(work interface{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch work := work.(type) {
+	case GitResource:
+		s.requeued.jobs = append(s.requeued.jobs, NewDistributedJob(work))
+	case string:
+		s.requeued.comments = append(s.requeued.comments, work)
+	case Paste:
+		s.requeued.pastes = append(s.requeued.pastes, work)
+	}
+}
+
+// HandleShutdown shuts down gracefully on SIGTERM or SIGINT, then exits
+func HandleShutdown(s *Session) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	received := <-signals
+	s.Log.Info("[*] Received %s, shutting down. Send it again to stop straight away", received)
+
+	// a second signal skips waiting
+	go func() {
+		<-signals
+		os.Exit(1)
+	}()
+
+	s.Shutdown(time.Duration(*s.Options.ShutdownTimeout) * time.Second)
+	os.Exit(0)
+}
+
+// Shutdown stops the pollers and workers from taking anything new, waits up
+// to timeout for the repositories being cloned and scanned, sends the
+// findings still buffered by sinks and saves what was queued but not started
+// to the checkpoint, or back to the jobs queue for a worker. Work still in
+// progress after timeout is cancelled and saved to be done again
+func (s *Session) Shutdown(timeout time.Duration) {
+	s.Lock()
+	s.stopping = true
+	s.Unlock()
+
+	if s.stopPolling != nil {
+		s.stopPolling()
+	}
+
+	deadline := time.Now().Add(timeout)
+	finished := waitUntil(s.working.Wait, deadline) && waitUntil(s.scanning.Wait, deadline)
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	if !finished {
+		s.Log.Warn("Gave up waiting for the scans in progress after %s", timeout)
+
+		// the work cancelled has a moment to requeue itself
+		waitUntil(s.working.Wait, time.Now().Add(shutdownRequeueTimeout))
+	}
+
+	s.WaitForSinks()
+	s.savePending()
+
+	if err := s.Checkpoint.Save(); err != nil {
+		s.Log.Error("Failed to save checkpoint to %s: %s", *s.Options.CheckpointPath, err)
+	}
+
+	// partial clones of repositories that weren't scanned in time
+	os.RemoveAll(filepath.Join(*s.Options.TempDirectory, ".clones"))
+}
+
+// savePending takes what's left on the queues, along with the work requeued
+// by the workers, and keeps it in the checkpoint. Workers push jobs back to
+// the jobs queue instead, for another worker to take
+func (s *Session) savePending() {
+	for _, priority := range Priorities {
+		for drained := false; !drained; {
+			select {
+			case repository := <-s.queue(priority):
+				s.Requeue(repository)
+			default:
+				drained = true
+			}
+		}
+	}
+
+	for drained := false; !drained; {
+		select {
+		case repository := <-s.LargeRepositories:
+			s.Requeue(repository)
+		case gistUrl := <-s.Gists:
+			s.Requeue(GitResource{Type: GIST_SOURCE, Url: gistUrl})
+		case comment := <-s.Comments:
+			s.Requeue(comment)
+		case paste := <-s.Pastes:
+			s.Requeue(paste)
+		default:
+			drained = true
+		}
+	}
+
+	s.Lock()
+	pending := s.requeued
+	s.Unlock()
+
+	if *s.Options.Role == RoleWorker {
+		for _, job := range pending.jobs {
+			if err := s.Jobs.Push(job); err != nil {
+				s.Log.Warn("Failed to return %s to the jobs queue: %s", job.Url, err)
+			}
+		}
+
+		pending.jobs = nil
+	}
+
+	count := len(pending.jobs) + len(pending.comments) + len(pending.pastes)
+	if count == 0 {
+		return
+	}
+
+	if s.Checkpoint == nil {
+		s.Log.Warn("%d queued repositories, gists, comments and pastes weren't scanned. Set --checkpoint-path to scan them after a restart", count)
+		return
+	}
+
+	s.Checkpoint.AddPending(pending.jobs, pending.comments, pending.pastes)
+	s.Log.Info("[*] Saved %d queued repositories, gists, comments and pastes to the checkpoint", count)
+}
+
+// waitUntil returns whether wait returned before deadline
+func waitUntil(wait func(), deadline time.Time) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
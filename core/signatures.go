@@ -1,9 +1,15 @@
 package core
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"regexp"
-	"regexp/syntax"
+	"sort"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -14,24 +20,172 @@ const (
 	PartFilename  = "filename"
 	PartPath      = "path"
 	PartContents  = "contents"
+
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityLow      = "low"
+
+	ConfidenceHigh   = "high"
+	ConfidenceMedium = "medium"
+	ConfidenceLow    = "low"
+
+	// how many lines apart a match and its near pattern can be by default
+	DefaultProximityLines = 5
 )
 
+// Severities are in ascending order
+var (
+	Severities  = []string{SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical}
+	Confidences = []string{ConfidenceLow, ConfidenceMedium, ConfidenceHigh}
+)
+
+// severityRank orders severities, with 0 for an unknown severity
+func severityRank(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+func IsSeverity(severity string) bool {
+	return severityRank(severity) > 0
+}
+
+func IsConfidence(confidence string) bool {
+	for _, c := range Confidences {
+		if c == confidence {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The rating of findings that don't come from a signature
+const (
+	EntropySeverity       = SeverityMedium
+	EntropyConfidence     = ConfidenceLow
+	SearchQuerySeverity   = SeverityMedium
+	SearchQueryConfidence = ConfidenceMedium
+)
+
+// AtLeastSeverity reports whether severity is minimum or worse. An empty
+// minimum allows everything
+func AtLeastSeverity(severity string, minimum string) bool {
+	return minimum == "" || severityRank(severity) >= severityRank(minimum)
+}
+
 type Signature interface {
 	Name() string
+	Verifier() string
+	Severity() string
+	Confidence() string
 	Match(file MatchFile) (bool, string)
-	GetContentsMatches(contents []byte) []string
+	GetContentsMatches(contents []byte) []ContentsMatch
+}
+
+// ContentsMatch is a value matched in a file's contents and the byte offset
+// it starts at, along with the dotted path of keys to it in config files
+type ContentsMatch struct {
+	Value  string
+	Offset int
+	Key    string
+}
+
+// FileSignature is a contents signature that matches depending on the kind
+// of file, i.e. by parsing config files, rather than on the contents alone
+type FileSignature interface {
+	GetFileMatches(file MatchFile) []ContentsMatch
+}
+
+// FindContentsMatches returns every match of a regex in contents. Large
+// contents are matched in chunks concurrently
+func FindContentsMatches(regex *regexp.Regexp, contents []byte) []ContentsMatch {
+	if len(contents) > matchChunkSize {
+		return findChunkedMatches(regex, contents)
+	}
+
+	matches := make([]ContentsMatch, 0)
+
+	for _, index := range regex.FindAllIndex(contents, -1) {
+		matches = append(matches, ContentsMatch{Value: string(contents[index[0]:index[1]]), Offset: index[0]})
+	}
+
+	return matches
+}
+
+// IsBlacklistedMatch reports whether a match contains one of the
+// blacklisted_strings
+func IsBlacklistedMatch(match string) bool {
+	for _, blacklistedString := range session.CurrentBlacklists().Strings {
+		if strings.Contains(strings.ToLower(match), strings.ToLower(blacklistedString)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ContentsMatchKeys returns the keys of matches in config files, or nil if
+// none of them have one
+func ContentsMatchKeys(matches []ContentsMatch) []string {
+	var keys []string
+	for i, match := range matches {
+		if match.Key != "" && keys == nil {
+			keys = make([]string, len(matches))
+		}
+
+		if keys != nil {
+			keys[i] = match.Key
+		}
+	}
+
+	return keys
+}
+
+// SplitContentsMatches returns the values and offsets of matches
+func SplitContentsMatches(matches []ContentsMatch) ([]string, []int) {
+	values := make([]string, len(matches))
+	offsets := make([]int, len(matches))
+
+	for i, match := range matches {
+		values[i], offsets[i] = match.Value, match.Offset
+	}
+
+	return values, offsets
+}
+
+// SignaturePack is a standalone YAML file of signatures using the same
+// schema as the signatures element of config.yaml
+type SignaturePack struct {
+	Signatures []ConfigSignature `yaml:"signatures"`
 }
 
 type SimpleSignature struct {
-	part  string
-	match string
-	name  string
+	part       string
+	match      string
+	name       string
+	verifier   string
+	severity   string
+	confidence string
 }
 
+// PatternSignature matches a regex. A contents signature with near set only
+// matches where near also matches within that many lines, so values that
+// are only secrets in pairs (a client ID and secret) aren't reported alone
 type PatternSignature struct {
-	part  string
-	match *regexp.Regexp
-	name  string
+	part       string
+	match      *regexp.Regexp
+	near       *regexp.Regexp
+	within     int
+	name       string
+	verifier   string
+	severity   string
+	confidence string
 }
 
 func (s SimpleSignature) Match(file MatchFile) (bool, string) {
@@ -57,7 +211,7 @@ func (s SimpleSignature) Match(file MatchFile) (bool, string) {
 	return (s.match == *haystack), matchPart
 }
 
-func (s SimpleSignature) GetContentsMatches(contents []byte) []string {
+func (s SimpleSignature) GetContentsMatches(contents []byte) []ContentsMatch {
 	return nil
 }
 
@@ -65,6 +219,18 @@ func (s SimpleSignature) Name() string {
 	return s.name
 }
 
+func (s SimpleSignature) Verifier() string {
+	return s.verifier
+}
+
+func (s SimpleSignature) Severity() string {
+	return s.severity
+}
+
+func (s SimpleSignature) Confidence() string {
+	return s.confidence
+}
+
 func (s PatternSignature) Match(file MatchFile) (bool, string) {
 	var (
 		haystack  *string
@@ -82,7 +248,7 @@ func (s PatternSignature) Match(file MatchFile) (bool, string) {
 		haystack = &file.Extension
 		matchPart = PartExtension
 	case PartContents:
-		return s.match.Match(file.Contents), PartContents
+		return s.match.Match(file.Contents) && (s.near == nil || s.near.Match(file.Contents)), PartContents
 	default:
 		return false, matchPart
 	}
@@ -90,50 +256,193 @@ func (s PatternSignature) Match(file MatchFile) (bool, string) {
 	return s.match.MatchString(*haystack), matchPart
 }
 
-func (s PatternSignature) GetContentsMatches(contents []byte) []string {
-	matches := make([]string, 0)
+func (s PatternSignature) GetContentsMatches(contents []byte) []ContentsMatch {
+	matches := make([]ContentsMatch, 0)
 
-	for _, match := range s.match.FindAllSubmatch(contents, -1) {
-		match := string(match[0])
-		blacklistedMatch := false
+	for _, match := range FindContentsMatches(s.match, contents) {
+		if !IsBlacklistedMatch(match.Value) {
+			matches = append(matches, match)
+		}
+	}
+
+	if s.near != nil && len(matches) > 0 {
+		matches = s.filterNear(contents, matches)
+	}
 
-		for _, blacklistedString := range session.Config.BlacklistedStrings {
-			if strings.Contains(strings.ToLower(match), strings.ToLower(blacklistedString)) {
-				blacklistedMatch = true
+	return matches
+}
+
+// filterNear keeps the matches with a match of near, that isn't part of
+// the match itself, no more than within lines before or after them
+func (s PatternSignature) filterNear(contents []byte, matches []ContentsMatch) []ContentsMatch {
+	near := FindContentsMatches(s.near, contents)
+	nearOffsets := make([]int, len(near))
+	for i := range near {
+		nearOffsets[i] = near[i].Offset
+	}
+	nearLines, _ := GetPositions(contents, nearOffsets)
+
+	_, offsets := SplitContentsMatches(matches)
+	lines, _ := GetPositions(contents, offsets)
+
+	filtered := make([]ContentsMatch, 0)
+	for i, match := range matches {
+		for j, nearMatch := range near {
+			overlaps := nearMatch.Offset < match.Offset+len(match.Value) && match.Offset < nearMatch.Offset+len(nearMatch.Value)
+			distance := lines[i] - nearLines[j]
+			if distance < 0 {
+				distance = -distance
 			}
-		}
 
-		if !blacklistedMatch {
-			matches = append(matches, match)
+			if !overlaps && distance <= s.within {
+				filtered = append(filtered, match)
+				break
+			}
 		}
 	}
 
-	return matches
+	return filtered
 }
 
 func (s PatternSignature) Name() string {
 	return s.name
 }
 
-func GetSignatures(s *Session) []Signature {
+func (s PatternSignature) Verifier() string {
+	return s.verifier
+}
+
+func (s PatternSignature) Severity() string {
+	return s.severity
+}
+
+func (s PatternSignature) Confidence() string {
+	return s.confidence
+}
+
+// signatureRating is the severity and confidence of a signature, defaulting
+// to high/medium for secrets in contents and medium/low for file names,
+// which only hint at a secret
+func signatureRating(s *Session, signature ConfigSignature) (string, string) {
+	severity, confidence := SeverityMedium, ConfidenceLow
+	if signature.Part == PartContents {
+		severity, confidence = SeverityHigh, ConfidenceMedium
+	}
+
+	if IsSeverity(signature.Severity) {
+		severity = signature.Severity
+	} else if signature.Severity != "" {
+		s.Log.Warn("Signature %s has unknown severity %s, using %s", signature.Name, signature.Severity, severity)
+	}
+
+	if IsConfidence(signature.Confidence) {
+		confidence = signature.Confidence
+	} else if signature.Confidence != "" {
+		s.Log.Warn("Signature %s has unknown confidence %s, using %s", signature.Name, signature.Confidence, confidence)
+	}
+
+	return severity, confidence
+}
+
+// NewSignature compiles a signature of config.yaml or a signature pack
+func NewSignature(s *Session, signature ConfigSignature) (Signature, error) {
+	severity, confidence := signatureRating(s, signature)
+
+	if signature.Match != "" {
+		return SimpleSignature{
+			name:       signature.Name,
+			part:       signature.Part,
+			match:      signature.Match,
+			verifier:   signature.Verifier,
+			severity:   severity,
+			confidence: confidence,
+		}, nil
+	}
+
+	regex, err := regexp.Compile(signature.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %s", err)
+	}
+
+	var near *regexp.Regexp
+	within := DefaultProximityLines
+	if signature.Near != "" {
+		if signature.Part != PartContents {
+			return nil, errors.New("near is only supported for contents signatures")
+		}
+
+		near, err = regexp.Compile(signature.Near)
+		if err != nil {
+			return nil, fmt.Errorf("invalid near regex: %s", err)
+		}
+
+		if signature.Within != nil && *signature.Within >= 0 {
+			within = *signature.Within
+		}
+	}
+
+	return PatternSignature{
+		name:       signature.Name,
+		part:       signature.Part,
+		match:      regex,
+		near:       near,
+		within:     within,
+		verifier:   signature.Verifier,
+		severity:   severity,
+		confidence: confidence,
+	}, nil
+}
+
+func GetSignatures(s *Session, config *Config) []Signature {
 	var signatures []Signature
-	for _, signature := range s.Config.Signatures {
-		if signature.Match != "" {
-			signatures = append(signatures, SimpleSignature{
-				name:  signature.Name,
-				part:  signature.Part,
-				match: signature.Match,
-			})
-		} else {
-			if _, err := syntax.Parse(signature.Match, syntax.FoldCase); err == nil {
-				signatures = append(signatures, PatternSignature{
-					name:  signature.Name,
-					part:  signature.Part,
-					match: regexp.MustCompile(signature.Regex),
-				})
-			}
+	for _, configured := range config.Signatures {
+		signature, err := NewSignature(s, configured)
+		if err != nil {
+			s.Log.Warn("Skipping signature %s: %s", configured.Name, err)
+			continue
 		}
+
+		signatures = append(signatures, signature)
+	}
+
+	if config.Generic.Enabled {
+		signatures = append(signatures, NewGenericSignature(s, config.Generic))
+	}
+
+	if config.Infrastructure.Enabled {
+		signatures = append(signatures, NewInfrastructureSignatures(s, config.Infrastructure)...)
 	}
 
 	return signatures
 }
+
+// LoadSignaturePacks reads every *.yaml/*.yml file in dir and returns the
+// signatures they contain, in file name order
+func LoadSignaturePacks(dir string) ([]ConfigSignature, error) {
+	var signatures []ConfigSignature
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	ymlFiles, _ := filepath.Glob(filepath.Join(dir, "*.yml"))
+	files = append(files, ymlFiles...)
+	sort.Strings(files)
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		pack := &SignaturePack{}
+		if err := yaml.Unmarshal(data, pack); err != nil {
+			return nil, fmt.Errorf("%s: %s", filepath.Base(file), err)
+		}
+
+		signatures = append(signatures, pack.Signatures...)
+	}
+
+	return signatures, nil
+}
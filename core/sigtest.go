@@ -0,0 +1,95 @@
+package core
+
+import (
+	"path"
+)
+
+// SignatureTests are fixtures defined alongside a signature: strings it
+// must match, and strings it mustn't. Fixtures of contents signatures are
+// file contents, and those of path signatures are paths
+type SignatureTests struct {
+	Positive []string `yaml:"positive"`
+	Negative []string `yaml:"negative"`
+}
+
+// SignatureTestResult is how a signature did against its fixtures
+type SignatureTestResult struct {
+	Signature string
+	Part      string
+	Positive  int
+	Negative  int
+	// positive fixtures it didn't match
+	Missed []string `json:",omitempty"`
+	// negative fixtures it matched
+	Matched []string `json:",omitempty"`
+	// why the signature couldn't be compiled, i.e. an invalid regex
+	Error string `json:",omitempty"`
+}
+
+func (r SignatureTestResult) Passed() bool {
+	return r.Error == "" && len(r.Missed) == 0 && len(r.Matched) == 0
+}
+
+// RunSignatureTests runs every signature that has fixtures against them,
+// returning the results in order and the names of the signatures without
+// any
+func RunSignatureTests(s *Session, signatures []ConfigSignature) ([]SignatureTestResult, []string) {
+	var (
+		results  []SignatureTestResult
+		untested []string
+	)
+
+	for _, config := range signatures {
+		if config.Tests == nil || len(config.Tests.Positive)+len(config.Tests.Negative) == 0 {
+			untested = append(untested, config.Name)
+			continue
+		}
+
+		result := SignatureTestResult{
+			Signature: config.Name,
+			Part:      config.Part,
+			Positive:  len(config.Tests.Positive),
+			Negative:  len(config.Tests.Negative),
+		}
+
+		signature, err := NewSignature(s, config)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		for _, fixture := range config.Tests.Positive {
+			if !matchesFixture(signature, config.Part, fixture) {
+				result.Missed = append(result.Missed, fixture)
+			}
+		}
+
+		for _, fixture := range config.Tests.Negative {
+			if matchesFixture(signature, config.Part, fixture) {
+				result.Matched = append(result.Matched, fixture)
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, untested
+}
+
+// matchesFixture reports whether a signature matches a fixture as it would
+// a file: the contents of one for contents signatures, where near and the
+// blacklisted_strings apply, or its path otherwise
+func matchesFixture(signature Signature, part string, fixture string) bool {
+	if part == PartContents {
+		return len(signature.GetContentsMatches([]byte(fixture))) > 0
+	}
+
+	matched, _ := signature.Match(MatchFile{
+		Path:      fixture,
+		Filename:  path.Base(fixture),
+		Extension: path.Ext(fixture),
+	})
+
+	return matched
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const snsApiVersion = "2010-03-31"
+
+// SNSSink publishes each finding as a JSON message to an SNS topic, to fan
+// it out to queues, Lambda functions and other subscribers. The signature,
+// severity and source are sent as message attributes for filter policies
+type SNSSink struct {
+	config      SNSConfig
+	endpoint    string
+	region      string
+	credentials *AWSCredentialsProvider
+	client      *http.Client
+}
+
+func NewSNSSink(config SNSConfig) *SNSSink {
+	// arn:aws:sns:<region>:<account>:<topic>
+	region := config.Region
+	if parts := strings.Split(config.TopicArn, ":"); region == "" && len(parts) == 6 {
+		region = parts[3]
+	}
+
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://sns.%s.amazonaws.com/", region)
+	}
+
+	return &SNSSink{
+		config:      config,
+		endpoint:    endpoint,
+		region:      region,
+		credentials: NewAWSCredentialsProvider(config.AccessKeyId, config.SecretAccessKey, config.SessionToken),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *SNSSink) Name() string {
+	return "SNS " + s.config.TopicArn
+}
+
+func (s *SNSSink) Send(event *MatchEvent) error {
+	if !s.config.Accepts(event) {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{
+		"Action":   {"Publish"},
+		"Version":  {snsApiVersion},
+		"TopicArn": {s.config.TopicArn},
+		"Message":  {string(body)},
+	}
+
+	for i, attribute := range findingAttributes(event) {
+		prefix := fmt.Sprintf("MessageAttributes.entry.%d.", i+1)
+		params.Set(prefix+"Name", attribute.Name)
+		params.Set(prefix+"Value.DataType", "String")
+		params.Set(prefix+"Value.StringValue", attribute.Value)
+	}
+
+	if strings.HasSuffix(s.config.TopicArn, ".fifo") {
+		params.Set("MessageGroupId", sha256Hex([]byte(event.Url)))
+		params.Set("MessageDeduplicationId", findingDeduplicationId(event))
+	}
+
+	return postAWSAction(s.client, s.credentials, s.endpoint, s.region, "sns", params)
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSplunkSourcetype    = "shhgit:finding"
+	defaultSplunkBatchSize     = 100
+	defaultSplunkFlushInterval = 5
+	splunkEventPath            = "/services/collector/event"
+)
+
+// SplunkSink buffers findings and sends them to a Splunk HTTP Event
+// Collector every batch_size findings or flush_interval seconds, whichever
+// comes first. Batches that fail are kept and retried with the next flush
+type SplunkSink struct {
+	sync.Mutex
+
+	config   SplunkConfig
+	client   *http.Client
+	hostname string
+	buffer   []*MatchEvent
+}
+
+func NewSplunkSink(config SplunkConfig) *SplunkSink {
+	if config.Sourcetype == "" {
+		config.Sourcetype = defaultSplunkSourcetype
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultSplunkBatchSize
+	}
+
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultSplunkFlushInterval
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if config.TLSSkipVerify {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	hostname, _ := os.Hostname()
+	sink := &SplunkSink{config: config, client: client, hostname: hostname}
+
+	go func() {
+		for range time.Tick(time.Duration(config.FlushInterval) * time.Second) {
+			if err := sink.Flush(); err != nil {
+				session.Log.Warn("Failed to send findings to %s: %s", sink.Name(), err)
+			}
+		}
+	}()
+
+	return sink
+}
+
+func (s *SplunkSink) Name() string {
+	return "Splunk " + s.config.Url
+}
+
+func (s *SplunkSink) Send(event *MatchEvent) error {
+	if !s.config.Accepts(event) {
+		return nil
+	}
+
+	s.Lock()
+	s.buffer = append(s.buffer, event)
+	full := len(s.buffer) >= s.config.BatchSize
+	s.Unlock()
+
+	if full {
+		return s.Flush()
+	}
+
+	return nil
+}
+
+// Flush sends everything buffered so far as one batch of events
+func (s *SplunkSink) Flush() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.buffer) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	body := &bytes.Buffer{}
+	encoder := json.NewEncoder(body)
+
+	// HEC takes a batch as event objects one after another
+	for _, event := range s.buffer {
+		entry := map[string]interface{}{
+			"time":       float64(now.UnixNano()/int64(time.Millisecond)) / 1000,
+			"host":       s.hostname,
+			"source":     Name,
+			"sourcetype": s.config.Sourcetype,
+			"event":      event,
+		}
+
+		// otherwise the token's default index
+		if s.config.Index != "" {
+			entry["index"] = s.config.Index
+		}
+
+		encoder.Encode(entry)
+	}
+
+	endpoint := strings.TrimRight(s.config.Url, "/") + splunkEventPath
+	err := PostWithRetry(s.client, defaultWebhookRetries, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", fmt.Sprintf("%s v%s", Name, Version))
+		req.Header.Set("Authorization", "Splunk "+s.config.Token)
+
+		return req, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.buffer = nil
+	return nil
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const sqsApiVersion = "2012-11-05"
+
+// SQSSink sends each finding as a JSON message to an SQS queue, i.e. for a
+// Lambda function to respond to. Messages to FIFO queues are deduplicated
+// on the finding's fingerprint
+type SQSSink struct {
+	config      SQSConfig
+	region      string
+	credentials *AWSCredentialsProvider
+	client      *http.Client
+}
+
+func NewSQSSink(config SQSConfig) *SQSSink {
+	region := config.Region
+	if region == "" {
+		region = awsRegionFromHost(config.QueueUrl)
+	}
+
+	return &SQSSink{
+		config:      config,
+		region:      region,
+		credentials: NewAWSCredentialsProvider(config.AccessKeyId, config.SecretAccessKey, config.SessionToken),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *SQSSink) Name() string {
+	return "SQS " + s.config.QueueUrl
+}
+
+func (s *SQSSink) Send(event *MatchEvent) error {
+	if !s.config.Accepts(event) {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{
+		"Action":      {"SendMessage"},
+		"Version":     {sqsApiVersion},
+		"MessageBody": {string(body)},
+	}
+
+	for i, attribute := range findingAttributes(event) {
+		prefix := fmt.Sprintf("MessageAttribute.%d.", i+1)
+		params.Set(prefix+"Name", attribute.Name)
+		params.Set(prefix+"Value.DataType", "String")
+		params.Set(prefix+"Value.StringValue", attribute.Value)
+	}
+
+	if strings.HasSuffix(s.config.QueueUrl, ".fifo") {
+		params.Set("MessageGroupId", sha256Hex([]byte(event.Url)))
+		params.Set("MessageDeduplicationId", findingDeduplicationId(event))
+	}
+
+	return postAWSAction(s.client, s.credentials, s.config.QueueUrl, s.region, "sqs", params)
+}
+
+// awsRegionFromHost finds the region in an endpoint such as
+// https://sqs.eu-west-1.amazonaws.com/, defaulting to us-east-1
+func awsRegionFromHost(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return defaultAwsRegion
+	}
+
+	parts := strings.Split(u.Hostname(), ".")
+	for i, part := range parts {
+		if i > 0 && part == "amazonaws" {
+			// sqs.<region>.amazonaws.com or the legacy <region>.queue.amazonaws.com
+			if parts[i-1] == "queue" && i > 1 {
+				return parts[i-2]
+			}
+			return parts[i-1]
+		}
+	}
+
+	return defaultAwsRegion
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// files on disk are read and scanned this much at a time
+	streamWindowSize = 1024 * 1024
+	// each window runs on in to the next by this much, so matches spanning
+	// the boundary, such as private keys, are still found whole
+	streamWindowOverlap = 64 * 1024
+)
+
+// MemoryBudget limits the bytes of file contents read from disk and held
+// by the scan workers at once, to --scan-memory-budget. A nil MemoryBudget
+// is unlimited
+type MemoryBudget struct {
+	sync.Mutex
+
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	b := &MemoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.Mutex)
+
+	return b
+}
+
+// Acquire waits until n bytes are free, returning how many were taken. A
+// request for more than the whole budget waits for all of it
+func (b *MemoryBudget) Acquire(n int64) int64 {
+	if b == nil {
+		return n
+	}
+
+	if n > b.limit {
+		n = b.limit
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	for b.used+n > b.limit {
+		b.cond.Wait()
+	}
+
+	b.used += n
+	return n
+}
+
+func (b *MemoryBudget) Release(n int64) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	b.used -= n
+	b.Unlock()
+
+	b.cond.Broadcast()
+}
+
+func (s *Session) InitMemoryBudget() {
+	if *s.Options.ScanMemoryBudget == 0 {
+		return
+	}
+
+	s.MemoryBudget = NewMemoryBudget(int64(*s.Options.ScanMemoryBudget) * 1024 * 1024)
+}
+
+// ListMatchingFiles is GetMatchingFiles without reading the files, which
+// are read from disk a window at a time by ReadWindows when scanned.
+// Archives, documents and notebooks are read whole when they're to be
+// expanded
+func ListMatchingFiles(dir string) []MatchFile {
+	fileList := make([]MatchFile, 0)
+	filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil || f.IsDir() || f.Size() > GetMaximumFileSize(path) || IsSkippableFile(path) {
+			return nil
+		}
+
+		if (IsArchive(path) && *session.Options.ScanArchives) || (IsDocument(path) && *session.Options.ExtractDocuments) || IsNotebook(path) {
+			fileList = append(fileList, NewMatchFile(path))
+			return nil
+		}
+
+		path = filepath.ToSlash(path)
+		fileList = append(fileList, MatchFile{
+			Path:      path,
+			Filename:  filepath.Base(path),
+			Extension: filepath.Ext(path),
+			Lazy:      true,
+		})
+		return nil
+	})
+
+	return fileList
+}
+
+// ReadWindows reads a lazy file from disk and calls scan with each window
+// of it in turn, holding no more than a window and its overlap in memory.
+// Windows end on a line, unless a line is longer than the window. The
+// contents of a window are reused for the next, so scan mustn't keep them
+func (s *Session) ReadWindows(file MatchFile, scan func(window MatchFile)) error {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := int64(streamWindowSize + streamWindowOverlap)
+	if info.Size() < size {
+		size = info.Size()
+	}
+
+	held := s.MemoryBudget.Acquire(size)
+	defer s.MemoryBudget.Release(held)
+
+	buffer := make([]byte, 0, size)
+	window := file
+	window.Lazy = false
+
+	for eof := false; ; {
+		for !eof && len(buffer) < cap(buffer) {
+			n, err := f.Read(buffer[len(buffer):cap(buffer)])
+			buffer = buffer[:len(buffer)+n]
+
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		// files smaller than a window are read whole, even if they've grown
+		if size < streamWindowSize+streamWindowOverlap {
+			eof = true
+		}
+
+		limit := len(buffer)
+		if !eof {
+			limit = streamWindowSize
+			if newline := bytes.LastIndexByte(buffer[:limit], '\n'); newline >= 0 {
+				limit = newline + 1
+			}
+		}
+		endsLine := limit > 0 && buffer[limit-1] == '\n'
+
+		window.Contents, window.Limit = buffer, limit
+		if eof {
+			window.Limit = 0
+		}
+		scan(window)
+
+		if eof {
+			return nil
+		}
+
+		// carry the overlap on to the start of the next window
+		window.Offset += limit
+		window.Lines += bytes.Count(buffer[:limit], []byte("\n"))
+		if endsLine {
+			window.Column = 0
+		} else {
+			window.Column += limit
+		}
+		buffer = buffer[:copy(buffer, buffer[limit:])]
+	}
+}
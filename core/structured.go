@@ -0,0 +1,365 @@
+package core
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ConfigFormatEnv  = "env"
+	ConfigFormatYaml = "yaml"
+	ConfigFormatToml = "toml"
+	ConfigFormatIni  = "ini"
+	ConfigFormatXml  = "xml"
+)
+
+// ConfigValue is a value in a structured config file, with the dotted path
+// of keys to it, i.e. database.password, and the offset it starts at
+type ConfigValue struct {
+	Key    string
+	Value  string
+	Offset int
+}
+
+// GetConfigFormat returns the format of a config file by its name, or an
+// empty string if it isn't one. JSON is parsed as YAML
+func GetConfigFormat(file MatchFile) string {
+	filename := strings.ToLower(file.Filename)
+	if filename == ".env" || strings.HasPrefix(filename, ".env.") {
+		return ConfigFormatEnv
+	}
+
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".env":
+		return ConfigFormatEnv
+	case ".yaml", ".yml", ".json":
+		return ConfigFormatYaml
+	case ".toml":
+		return ConfigFormatToml
+	case ".ini", ".cfg", ".properties":
+		return ConfigFormatIni
+	case ".xml", ".config":
+		return ConfigFormatXml
+	}
+
+	return ""
+}
+
+// ParseConfigFile returns the string values of a .env, YAML, JSON, TOML, INI
+// or XML file, or false if it isn't one or can't be parsed
+func ParseConfigFile(file MatchFile) ([]ConfigValue, bool) {
+	switch format := GetConfigFormat(file); format {
+	case ConfigFormatYaml:
+		return parseYamlValues(file.Contents)
+	case ConfigFormatXml:
+		return parseXmlValues(file.Contents)
+	case ConfigFormatEnv, ConfigFormatToml, ConfigFormatIni:
+		return parseAssignments(file.Contents, format), true
+	}
+
+	return nil, false
+}
+
+// ConfigKeyName returns the last key of a dotted path that isn't an index
+// in to a list, i.e. tokens for secrets.tokens.0
+func ConfigKeyName(key string) string {
+	keys := strings.Split(key, ".")
+	for i := len(keys) - 1; i >= 0; i-- {
+		if _, err := strconv.Atoi(keys[i]); err != nil {
+			return keys[i]
+		}
+	}
+
+	return key
+}
+
+func joinConfigKey(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+
+	return parent + "." + key
+}
+
+// parseAssignments reads the key = value lines of .env, TOML, INI and
+// .properties files, prefixing keys with the [section] they're in
+func parseAssignments(contents []byte, format string) []ConfigValue {
+	values := make([]ConfigValue, 0)
+	section := ""
+	inMultiline := false
+
+	for start := 0; start < len(contents); {
+		end := bytes.IndexByte(contents[start:], '\n')
+		if end < 0 {
+			end = len(contents)
+		} else {
+			end += start
+		}
+
+		lineStart := start
+		line := string(contents[start:end])
+		start = end + 1
+
+		// TOML's multi-line strings are left alone
+		if format == ConfigFormatToml && strings.Count(line, `"""`)+strings.Count(line, `'''`) == 1 {
+			inMultiline = !inMultiline
+			continue
+		} else if inMultiline {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';' || trimmed[0] == '!' {
+			continue
+		}
+
+		if format != ConfigFormatEnv && strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = strings.TrimSpace(strings.Trim(trimmed, "[]"))
+			continue
+		}
+
+		separator := strings.IndexByte(line, '=')
+		if format == ConfigFormatIni {
+			if colon := strings.IndexByte(line, ':'); colon >= 0 && (separator < 0 || colon < separator) {
+				separator = colon
+			}
+		}
+		if separator < 0 {
+			continue
+		}
+
+		key := strings.TrimSpace(line[:separator])
+		if format == ConfigFormatEnv {
+			key = strings.TrimSpace(strings.TrimPrefix(key, "export "))
+		}
+		key = strings.Trim(key, `"'`)
+
+		value := line[separator+1:]
+		offset := lineStart + separator + 1 + len(value) - len(strings.TrimLeft(value, " \t"))
+		value = strings.TrimSpace(value)
+
+		if quoted := len(value) >= 2 && (value[0] == '"' || value[0] == '\''); quoted {
+			if closing := strings.IndexByte(value[1:], value[0]); closing >= 0 {
+				value, offset = value[1:closing+1], offset+1
+			}
+		} else if format == ConfigFormatToml {
+			// only strings can be secrets
+			continue
+		} else if comment := strings.Index(value, " #"); comment >= 0 && format == ConfigFormatEnv {
+			value = strings.TrimSpace(value[:comment])
+		}
+
+		if value != "" && key != "" {
+			values = append(values, ConfigValue{Key: joinConfigKey(section, key), Value: value, Offset: offset})
+		}
+	}
+
+	return values
+}
+
+// parseYamlValues reads the string values of every document in a YAML or
+// JSON file, keyed by their path through the maps and lists above them
+func parseYamlValues(contents []byte) ([]ConfigValue, bool) {
+	documents, ok := parseYamlDocuments(contents)
+	if !ok {
+		return nil, false
+	}
+
+	lines := lineStarts(contents)
+	values := make([]ConfigValue, 0)
+	for _, document := range documents {
+		walkYamlStrings(document, "", func(key string, node *yaml.Node) {
+			values = append(values, ConfigValue{Key: key, Value: node.Value, Offset: yamlOffset(contents, lines, node)})
+		})
+	}
+
+	return values, true
+}
+
+// parseYamlDocuments parses every document in a YAML or JSON file
+func parseYamlDocuments(contents []byte) ([]*yaml.Node, bool) {
+	documents := make([]*yaml.Node, 0)
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	for {
+		var document yaml.Node
+		if err := decoder.Decode(&document); err == io.EOF {
+			return documents, true
+		} else if err != nil {
+			return nil, false
+		}
+
+		documents = append(documents, &document)
+	}
+}
+
+// walkYamlStrings calls f with each non-empty string under node and the
+// dotted path of keys, and indexes in to lists, to it from key. Values with
+// other tags, i.e. numbers or CloudFormation's !Ref, are left out
+func walkYamlStrings(node *yaml.Node, key string, f func(key string, node *yaml.Node)) {
+	if node == nil {
+		return
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			walkYamlStrings(child, key, f)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			walkYamlStrings(node.Content[i+1], joinConfigKey(key, node.Content[i].Value), f)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			walkYamlStrings(child, joinConfigKey(key, strconv.Itoa(i)), f)
+		}
+	case yaml.ScalarNode:
+		if node.Tag == "!!str" && node.Value != "" {
+			f(key, node)
+		}
+	}
+}
+
+// yamlMapValue returns the value of key in a mapping node, or nil
+func yamlMapValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// yamlOffset returns the offset a scalar's value starts at. Columns count
+// characters rather than bytes, so the value is looked for on its line from
+// its column, then from the line's start. Block scalars and escaped strings
+// are put at their column
+func yamlOffset(contents []byte, lines []int, node *yaml.Node) int {
+	if node.Line < 1 || node.Line > len(lines) {
+		return 0
+	}
+
+	lineStart := lines[node.Line-1]
+	lineEnd := len(contents)
+	if node.Line < len(lines) {
+		lineEnd = lines[node.Line]
+	}
+
+	column := lineStart + node.Column - 1
+	if column >= lineEnd {
+		column = lineStart
+	}
+
+	if index := bytes.Index(contents[column:lineEnd], []byte(node.Value)); index >= 0 {
+		return column + index
+	} else if index := bytes.Index(contents[lineStart:lineEnd], []byte(node.Value)); index >= 0 {
+		return lineStart + index
+	}
+
+	return column
+}
+
+// lineStarts returns the offset each line of contents starts at
+func lineStarts(contents []byte) []int {
+	lines := []int{0}
+	for i, b := range contents {
+		if b == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+
+	return lines
+}
+
+// parseXmlValues reads the text of elements and the values of attributes in
+// an XML file, keyed by the elements they're in. Elements with a key or name
+// attribute, as in <add key="ApiKey" value="..."/>, are keyed by it
+func parseXmlValues(contents []byte) ([]ConfigValue, bool) {
+	decoder := xml.NewDecoder(bytes.NewReader(contents))
+	decoder.Strict = false
+
+	values := make([]ConfigValue, 0)
+	keys := make([]string, 0)
+	var text strings.Builder
+	textOffset := 0
+
+	find := func(value string, from int) int {
+		if index := bytes.Index(contents[from:], []byte(value)); index >= 0 {
+			return from + index
+		}
+		return from
+	}
+
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return values, true
+		} else if err != nil {
+			return nil, false
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			name := token.Name.Local
+			named := false
+			for _, attr := range token.Attr {
+				if attr.Name.Local == "key" || attr.Name.Local == "name" {
+					name, named = attr.Value, true
+				}
+			}
+
+			keys = append(keys, name)
+			key := strings.Join(keys, ".")
+
+			for _, attr := range token.Attr {
+				if attr.Name.Local == "key" || attr.Name.Local == "name" || attr.Value == "" {
+					continue
+				}
+
+				attrKey := joinConfigKey(key, attr.Name.Local)
+				if named && attr.Name.Local == "value" {
+					attrKey = key
+				}
+
+				values = append(values, ConfigValue{Key: attrKey, Value: attr.Value, Offset: find(attr.Value, offset)})
+			}
+
+			text.Reset()
+		case xml.CharData:
+			if text.Len() == 0 {
+				textOffset = offset
+			}
+			text.Write(token)
+		case xml.EndElement:
+			if value := strings.TrimSpace(text.String()); value != "" && len(keys) > 0 {
+				values = append(values, ConfigValue{Key: strings.Join(keys, "."), Value: value, Offset: find(value, textOffset)})
+			}
+
+			text.Reset()
+			if len(keys) > 0 {
+				keys = keys[:len(keys)-1]
+			}
+		}
+	}
+}
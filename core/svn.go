@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var svnSchemes = []string{"svn", "svn+ssh", "http", "https"}
+
+// GetSvnFiles exports a Subversion repository at revision, or HEAD, with the
+// svn binary and reads its files in to memory as if they were in dir.
+// Repositories larger than --maximum-repository-size are skipped
+func GetSvnFiles(session *Session, repositoryUrl string, revision string, dir string) ([]MatchFile, error) {
+	if u, err := url.Parse(repositoryUrl); err != nil || !containsString(svnSchemes, u.Scheme) {
+		return nil, fmt.Errorf("unsupported Subversion URL %s", repositoryUrl)
+	}
+
+	exportDir, err := CloneDirectory(session, repositoryUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(exportDir)
+
+	timeout := time.Duration(*session.Options.CloneRepositoryTimeout) * time.Second
+	localCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	session.Log.Debug("[%s] Exporting %s", repositoryUrl, revision)
+
+	args, env, password := session.Cloner.SvnArgs(repositoryUrl)
+	args = append([]string{"export", "--quiet", "--force", "--ignore-externals"}, args...)
+	if revision != "" {
+		args = append(args, "--revision", revision)
+	}
+
+	cmd := exec.CommandContext(localCtx, "svn", append(args, repositoryUrl, exportDir)...)
+	cmd.Env = env
+	cmd.Stdin = strings.NewReader(password)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if localCtx.Err() != nil {
+			err = localCtx.Err()
+		} else if msg := strings.TrimSpace(string(out)); msg != "" {
+			err = errors.New(strings.SplitN(msg, "\n", 2)[0])
+		}
+
+		return nil, err
+	}
+
+	if maxSize := int64(*session.Options.MaximumRepositorySize) * 1024; maxSize > 0 && GetDirectorySize(exportDir) > maxSize {
+		return nil, fmt.Errorf("repository exceeds the maximum size of %d KB", maxSize/1024)
+	}
+
+	files := GetMatchingFiles(exportDir)
+	for i := range files {
+		files[i].Path = filepath.ToSlash(dir) + strings.TrimPrefix(files[i].Path, filepath.ToSlash(exportDir))
+		files[i].Commit = revision
+	}
+
+	return files, nil
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SyslogFormatCef  = "cef"
+	SyslogFormatLeef = "leef"
+
+	SyslogProtocolUdp = "udp"
+	SyslogProtocolTcp = "tcp"
+	SyslogProtocolTls = "tls"
+
+	// local0
+	syslogFacility = 16
+	syslogTimeout  = 10 * time.Second
+)
+
+// syslog severities of each finding severity
+var syslogSeverities = map[string]int{
+	SeverityCritical: 2,
+	SeverityHigh:     3,
+	SeverityMedium:   4,
+	SeverityLow:      5,
+}
+
+// CEF and LEEF severities of each finding severity, out of 10
+var siemSeverities = map[string]int{
+	SeverityCritical: 10,
+	SeverityHigh:     8,
+	SeverityMedium:   5,
+	SeverityLow:      3,
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, "|", `\|`)
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, "\r", `\r`, "\n", `\n`)
+	leefValueEscaper    = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+// SyslogSink sends a syslog message per finding, formatted as CEF for
+// ArcSight or LEEF for QRadar, over UDP, TCP or TLS. Messages are framed with
+// a trailing newline on streams
+type SyslogSink struct {
+	sync.Mutex
+
+	config   SyslogConfig
+	hostname string
+	conn     net.Conn
+}
+
+func NewSyslogSink(config SyslogConfig) *SyslogSink {
+	if config.Protocol == "" {
+		config.Protocol = SyslogProtocolUdp
+	}
+
+	if config.Format == "" {
+		config.Format = SyslogFormatCef
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	return &SyslogSink{config: config, hostname: hostname}
+}
+
+func (s *SyslogSink) Name() string {
+	return fmt.Sprintf("syslog %s://%s", s.config.Protocol, s.config.Address)
+}
+
+func (s *SyslogSink) Send(event *MatchEvent) error {
+	if !s.config.Accepts(event) {
+		return nil
+	}
+
+	message := s.Format(event)
+	priority := syslogFacility*8 + syslogSeverities[event.Severity]
+	// RFC 5424: <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - - %s\n", priority, time.Now().UTC().Format(time.RFC3339), s.hostname, Name, os.Getpid(), message)
+
+	s.Lock()
+	defer s.Unlock()
+
+	// reconnect once should the connection have been dropped
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			conn, err := s.dial()
+			if err != nil {
+				return err
+			}
+			s.conn = conn
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		_, err := s.conn.Write([]byte(line))
+		if err == nil || attempt > 0 {
+			return err
+		}
+
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	switch s.config.Protocol {
+	case SyslogProtocolTls:
+		host, _, _ := net.SplitHostPort(s.config.Address)
+		return tls.DialWithDialer(&net.Dialer{Timeout: syslogTimeout}, "tcp", s.config.Address, &tls.Config{ServerName: host, InsecureSkipVerify: s.config.TLSSkipVerify})
+	case SyslogProtocolTcp, SyslogProtocolUdp:
+		return net.DialTimeout(s.config.Protocol, s.config.Address, syslogTimeout)
+	}
+
+	return nil, fmt.Errorf("unsupported syslog protocol %s. Use udp, tcp or tls", s.config.Protocol)
+}
+
+// Format renders a finding as a CEF or LEEF event
+func (s *SyslogSink) Format(event *MatchEvent) string {
+	fingerprint := ""
+	if len(event.Fingerprints) > 0 {
+		fingerprint = event.Fingerprints[0]
+	}
+
+	if s.config.Format == SyslogFormatLeef {
+		attributes := [][2]string{
+			{"devTime", strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)},
+			{"devTimeFormat", "epoch"},
+			{"cat", event.Signature},
+			{"sev", strconv.Itoa(siemSeverities[event.Severity])},
+			{"url", event.Url},
+			{"filePath", event.File},
+			{"confidence", event.Confidence},
+			{"verified", strconv.FormatBool(event.Verified)},
+			{"source", SourceNames[event.Source]},
+			{"commit", event.Commit},
+			{"match", notificationSnippet(event)},
+			{"fingerprint", fingerprint},
+		}
+
+		pairs := make([]string, len(attributes))
+		for i, attribute := range attributes {
+			pairs[i] = attribute[0] + "=" + leefValueEscaper.Replace(attribute[1])
+		}
+
+		return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s", Name, Name, Version, cefHeaderEscaper.Replace(GetSlug(event.Signature)), strings.Join(pairs, "\t"))
+	}
+
+	extensions := [][2]string{
+		{"rt", strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)},
+		{"request", event.Url},
+		{"filePath", event.File},
+		{"cs1Label", "confidence"},
+		{"cs1", event.Confidence},
+		{"cs2Label", "match"},
+		{"cs2", notificationSnippet(event)},
+		{"cs3Label", "fingerprint"},
+		{"cs3", fingerprint},
+		{"cs4Label", "source"},
+		{"cs4", SourceNames[event.Source]},
+		{"cs5Label", "commit"},
+		{"cs5", event.Commit},
+		{"cs6Label", "verified"},
+		{"cs6", strconv.FormatBool(event.Verified)},
+	}
+
+	pairs := make([]string, len(extensions))
+	for i, extension := range extensions {
+		pairs[i] = extension[0] + "=" + cefExtensionEscaper.Replace(extension[1])
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s", Name, Name, Version, cefHeaderEscaper.Replace(GetSlug(event.Signature)), cefHeaderEscaper.Replace(event.Signature), siemSeverities[event.Severity], strings.Join(pairs, " "))
+}
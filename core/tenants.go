@@ -0,0 +1,164 @@
+package core
+
+import (
+	"errors"
+	"strings"
+)
+
+// TenantConfig is a business unit served by the same deployment. Findings in
+// files mentioning its watch_terms are its own, and are sent to its sinks as
+// well as the deployment's, rated by its signature overrides and left out
+// if its allowlist suppresses them
+type TenantConfig struct {
+	Name               string              `yaml:"name"`
+	WatchTerms         []WatchTerm         `yaml:"watch_terms"`
+	SignatureOverrides []SignatureOverride `yaml:"signature_overrides"`
+	// a .shhgitignore-style file of the tenant's own false positives
+	Allowlist   string `yaml:"allowlist,omitempty"`
+	SinksConfig `yaml:",inline"`
+}
+
+// SignatureOverride changes the severity and confidence of a signature's
+// findings for a tenant, or disables them
+type SignatureOverride struct {
+	Name       string `yaml:"name"`
+	Severity   string `yaml:"severity,omitempty"`
+	Confidence string `yaml:"confidence,omitempty"`
+	Disabled   bool   `yaml:"disabled"`
+}
+
+// Tenant is a tenant's config along with its allowlist and sinks
+type Tenant struct {
+	TenantConfig
+
+	allowlist *Allowlist
+	sinks     []Sink
+}
+
+// validate checks the tenant's settings and compiles its watch terms
+func (config *TenantConfig) validate(secrets *secretExpander) error {
+	if config.Name == "" {
+		return errors.New("tenants need a name")
+	}
+
+	if len(config.WatchTerms) == 0 {
+		return errors.New("tenant " + config.Name + " needs watch_terms to tell its findings apart")
+	}
+
+	for i := range config.WatchTerms {
+		if err := config.WatchTerms[i].compile(); err != nil {
+			return errors.New("tenant " + config.Name + ": " + err.Error())
+		}
+	}
+
+	for _, override := range config.SignatureOverrides {
+		if override.Severity != "" && !IsSeverity(override.Severity) {
+			return errors.New("tenant " + config.Name + " signature_overrides severity must be one of " + strings.Join(Severities, ", "))
+		} else if override.Confidence != "" && !IsConfidence(override.Confidence) {
+			return errors.New("tenant " + config.Name + " signature_overrides confidence must be one of " + strings.Join(Confidences, ", "))
+		}
+	}
+
+	config.Allowlist = secrets.expand(config.Allowlist)
+
+	return config.SinksConfig.expand(secrets)
+}
+
+func (s *Session) InitTenants() {
+	s.Tenants = make(map[string]*Tenant)
+
+	for _, config := range s.Config.Tenants {
+		tenant := &Tenant{TenantConfig: config}
+
+		if config.Allowlist != "" {
+			tenant.allowlist = NewAllowlist()
+			if err := tenant.allowlist.Load(config.Allowlist); err != nil {
+				s.Log.Fatal("Failed to load the allowlist of tenant %s: %s", config.Name, err)
+			}
+		}
+
+		tenant.sinks = s.newSinks(config.SinksConfig)
+		s.Tenants[config.Name] = tenant
+		s.Log.Debug("Loaded tenant %s with %d %s", config.Name, len(tenant.sinks), Pluralize(len(tenant.sinks), "sink", "sinks"))
+	}
+}
+
+// FindingTenants returns the names of the tenants whose watch terms a file
+// mentions, leaving out those whose allowlist suppresses every match of a
+// finding in it at path
+func (s *Session) FindingTenants(watched []WatchTermMatch, path string, matches []string) []string {
+	var tenants []string
+
+	for _, match := range watched {
+		tenant := s.Tenants[match.Tenant]
+		if tenant == nil || containsString(tenants, tenant.Name) || tenant.allowsAll(path, matches) {
+			continue
+		}
+
+		tenants = append(tenants, tenant.Name)
+	}
+
+	return tenants
+}
+
+func (tenant *Tenant) allowsAll(path string, matches []string) bool {
+	if tenant.allowlist == nil {
+		return false
+	}
+
+	for _, match := range matches {
+		if !tenant.allowlist.IsAllowed(path, match) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rate returns the finding as the tenant sees it, with the severity and
+// confidence of its signature overrides, or nil if it disabled the
+// signature
+func (tenant *Tenant) rate(event *MatchEvent) *MatchEvent {
+	for _, override := range tenant.SignatureOverrides {
+		if !strings.EqualFold(override.Name, event.Signature) {
+			continue
+		}
+
+		if override.Disabled {
+			return nil
+		}
+
+		rated := *event
+		if override.Severity != "" {
+			rated.Severity = override.Severity
+		}
+		if override.Confidence != "" {
+			rated.Confidence = override.Confidence
+		}
+
+		return &rated
+	}
+
+	return event
+}
+
+// sendToTenants sends a finding to the sinks of each of its tenants
+func (s *Session) sendToTenants(event *MatchEvent) {
+	for _, name := range event.Tenants {
+		tenant := s.Tenants[name]
+		if tenant == nil {
+			continue
+		}
+
+		rated := tenant.rate(event)
+		if rated == nil {
+			continue
+		}
+
+		for _, sink := range tenant.sinks {
+			if err := sink.Send(rated); err != nil {
+				s.Log.Warn("Failed to send finding to %s of tenant %s: %s", sink.Name(), name, err)
+			}
+		}
+	}
+}
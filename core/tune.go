@@ -0,0 +1,163 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	TuneDisable = "disable"
+	TuneTighten = "tighten"
+	TuneUnused  = "unused"
+	TuneKeep    = "keep"
+
+	defaultTuneDays = 30
+
+	// triaged findings needed before false positive rates are trusted
+	tuneMinimumTriaged = 5
+	// findings of a contents signature needed before entropy is trusted
+	tuneMinimumFindings = 20
+	// average Shannon entropy below which matches are unlikely to be keys
+	tuneLowEntropy = 3.0
+)
+
+// tuneOrder sorts the report with the signatures needing attention first
+var tuneOrder = map[string]int{TuneDisable: 0, TuneTighten: 1, TuneUnused: 2, TuneKeep: 3}
+
+// SignatureStats are the findings of a signature over a period, how they
+// were triaged and what should be done about it
+type SignatureStats struct {
+	Signature      string
+	Severity       string
+	Findings       int
+	LastWeek       int
+	FalsePositives int
+	Confirmed      int
+	Revoked        int
+	AverageEntropy float64
+	FirstSeen      *time.Time `json:",omitempty"`
+	LastSeen       *time.Time `json:",omitempty"`
+	Suggestion     string
+	Reason         string
+}
+
+// FalsePositiveRate is the share of triaged findings marked as false
+// positives
+func (s SignatureStats) FalsePositiveRate() float64 {
+	triaged := s.FalsePositives + s.Confirmed + s.Revoked
+	if triaged == 0 {
+		return 0
+	}
+
+	return float64(s.FalsePositives) / float64(triaged)
+}
+
+// GetSignatureStats counts findings per signature in the last days, or all
+// of them if days is 0
+func (d *Database) GetSignatureStats(days int) (map[string]SignatureStats, error) {
+	rows, err := d.db.Query(`SELECT signature, count(*),
+			count(*) FILTER (WHERE created_at > now() - interval '7 days'),
+			count(*) FILTER (WHERE status = 'false_positive'),
+			count(*) FILTER (WHERE status = 'confirmed'),
+			count(*) FILTER (WHERE status = 'revoked'),
+			COALESCE(avg(NULLIF(entropy, 0)), 0),
+			min(created_at), max(created_at)
+		FROM findings WHERE $1 = 0 OR created_at > now() - make_interval(days => $1) GROUP BY signature`, days)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	stats := make(map[string]SignatureStats)
+	for rows.Next() {
+		s := SignatureStats{}
+		var first, last time.Time
+
+		err := rows.Scan(&s.Signature, &s.Findings, &s.LastWeek, &s.FalsePositives, &s.Confirmed, &s.Revoked, &s.AverageEntropy, &first, &last)
+		if err != nil {
+			return nil, err
+		}
+
+		first, last = first.UTC(), last.UTC()
+		s.FirstSeen, s.LastSeen = &first, &last
+		stats[s.Signature] = s
+	}
+
+	return stats, rows.Err()
+}
+
+// TuneSignatures reports the stats of every loaded signature, and of
+// signatures with findings that are no longer loaded, with a suggestion
+func TuneSignatures(stats map[string]SignatureStats, signatures []Signature) []SignatureStats {
+	report := make([]SignatureStats, 0, len(stats))
+	kinds := make(map[string]bool)
+
+	for _, signature := range signatures {
+		if _, ok := kinds[signature.Name()]; ok {
+			continue
+		}
+
+		s := stats[signature.Name()]
+		s.Signature, s.Severity = signature.Name(), signature.Severity()
+		kinds[signature.Name()] = isContentsSignature(signature)
+		report = append(report, s)
+	}
+
+	for name, s := range stats {
+		if _, ok := kinds[name]; !ok {
+			report = append(report, s)
+		}
+	}
+
+	for i := range report {
+		report[i].Suggestion, report[i].Reason = suggestTuning(report[i], kinds[report[i].Signature])
+	}
+
+	sort.SliceStable(report, func(i, j int) bool {
+		if tuneOrder[report[i].Suggestion] != tuneOrder[report[j].Suggestion] {
+			return tuneOrder[report[i].Suggestion] < tuneOrder[report[j].Suggestion]
+		}
+
+		if report[i].Findings != report[j].Findings {
+			return report[i].Findings > report[j].Findings
+		}
+
+		return report[i].Signature < report[j].Signature
+	})
+
+	return report
+}
+
+func suggestTuning(s SignatureStats, contents bool) (string, string) {
+	triaged := s.FalsePositives + s.Confirmed + s.Revoked
+	rate := s.FalsePositiveRate()
+
+	switch {
+	case s.Findings == 0:
+		return TuneUnused, "no findings"
+	case triaged >= tuneMinimumTriaged && rate >= 0.9:
+		return TuneDisable, fmt.Sprintf("%.0f%% of %d triaged findings are false positives", rate*100, triaged)
+	case triaged >= tuneMinimumTriaged && rate >= 0.5:
+		return TuneTighten, fmt.Sprintf("%.0f%% of %d triaged findings are false positives", rate*100, triaged)
+	case contents && s.Findings >= tuneMinimumFindings && s.AverageEntropy > 0 && s.AverageEntropy < tuneLowEntropy:
+		return TuneTighten, fmt.Sprintf("average entropy of %.2f suggests matches aren't random keys", s.AverageEntropy)
+	case triaged == 0:
+		return TuneKeep, "no findings triaged yet"
+	}
+
+	return TuneKeep, fmt.Sprintf("%.0f%% of %d triaged findings are false positives", rate*100, triaged)
+}
+
+func isContentsSignature(signature Signature) bool {
+	switch s := signature.(type) {
+	case PatternSignature:
+		return s.part == PartContents
+	case YaraSignature, GenericSignature:
+		return true
+	}
+
+	return false
+}
@@ -1,25 +1,17 @@
 package core
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
 	"math"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
-func GetTempDir(suffix string) string {
-	dir := filepath.Join(*session.Options.TempDirectory, suffix)
-
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		os.MkdirAll(dir, os.ModePerm)
-	} else {
-		os.RemoveAll(dir)
-	}
-
-	return dir
-}
+var nonAlphanumericRegex = regexp.MustCompile(`[^a-z0-9]+`)
 
 func PathExists(path string) bool {
 	_, err := os.Stat(path)
@@ -34,6 +26,19 @@ func PathExists(path string) bool {
 	return false
 }
 
+// GetDirectorySize returns the total size of the files under dir in bytes
+func GetDirectorySize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
+
 func LogIfError(text string, err error) {
 	if err != nil {
 		GetSession().Log.Error("%s (%s", text, err.Error())
@@ -69,3 +74,73 @@ func GetEntropy(data string) (entropy float64) {
 
 	return entropy
 }
+
+// GetAverageEntropy is the mean entropy of matches
+func GetAverageEntropy(matches []string) float64 {
+	if len(matches) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, match := range matches {
+		total += GetEntropy(match)
+	}
+
+	return total / float64(len(matches))
+}
+
+// GetPositions returns the 1-based line number and byte column of each
+// offset in contents
+func GetPositions(contents []byte, offsets []int) ([]int, []int) {
+	lines := make([]int, len(offsets))
+	columns := make([]int, len(offsets))
+
+	for i, offset := range offsets {
+		if offset < 0 || offset > len(contents) {
+			continue
+		}
+
+		lines[i] = bytes.Count(contents[:offset], []byte("\n")) + 1
+		columns[i] = offset - bytes.LastIndexByte(contents[:offset], '\n')
+	}
+
+	return lines, columns
+}
+
+// GetOffset is the inverse of GetPositions, returning the byte offset of a
+// 1-based line and column, or -1 if contents has fewer lines
+func GetOffset(contents []byte, line int, column int) int {
+	offset := 0
+
+	for ; line > 1; line-- {
+		next := bytes.IndexByte(contents[offset:], '\n')
+		if next < 0 {
+			return -1
+		}
+
+		offset += next + 1
+	}
+
+	return offset + column - 1
+}
+
+// GetSlug turns a signature name into a stable, URL-safe identifier
+func GetSlug(name string) string {
+	return strings.Trim(nonAlphanumericRegex.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// Redact masks the middle of a secret, keeping enough of either end to
+// recognise it, i.e. AKIA************WXYZ
+func Redact(secret string) string {
+	runes := []rune(secret)
+	keep := len(runes) / 5
+	if keep > 4 {
+		keep = 4
+	}
+
+	if len(runes) <= 2*keep {
+		return strings.Repeat("*", len(runes))
+	}
+
+	return string(runes[:keep]) + strings.Repeat("*", len(runes)-2*keep) + string(runes[len(runes)-keep:])
+}
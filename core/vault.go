@@ -0,0 +1,162 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultVaultMount     = "secret"
+	defaultVaultPath      = Name + "/exposures"
+	defaultVaultTeam      = "unowned"
+	defaultVaultKvVersion = 2
+	vaultTokenHeader      = "X-Vault-Token"
+	vaultNamespaceHeader  = "X-Vault-Namespace"
+)
+
+// VaultConfig is where verified findings are recorded in Vault, for the
+// secrets inventory to track exposures. Teams maps organizations, or
+// repository URL prefixes, to the team owning them
+type VaultConfig struct {
+	SinkFilter  `yaml:",inline"`
+	Address     string            `yaml:"address,omitempty"`
+	Token       string            `yaml:"token,omitempty"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Mount       string            `yaml:"mount"`
+	Path        string            `yaml:"path"`
+	KvVersion   int               `yaml:"kv_version"`
+	Teams       map[string]string `yaml:"teams"`
+	DefaultTeam string            `yaml:"default_team"`
+}
+
+func (c VaultConfig) Enabled() bool {
+	return c.Address != ""
+}
+
+// VaultSink writes a record of each verified finding to a KV secrets
+// engine at <mount>/<path>/<team>/<fingerprint>. Only metadata and the
+// redacted match are stored, never the secret itself
+type VaultSink struct {
+	config VaultConfig
+	client *http.Client
+}
+
+func NewVaultSink(config VaultConfig) *VaultSink {
+	// as the Vault CLI does
+	if config.Token == "" {
+		config.Token = os.Getenv("VAULT_TOKEN")
+	}
+
+	if config.Namespace == "" {
+		config.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+
+	if config.Mount == "" {
+		config.Mount = defaultVaultMount
+	}
+
+	if config.Path == "" {
+		config.Path = defaultVaultPath
+	}
+
+	if config.KvVersion == 0 {
+		config.KvVersion = defaultVaultKvVersion
+	}
+
+	if config.DefaultTeam == "" {
+		config.DefaultTeam = defaultVaultTeam
+	}
+
+	return &VaultSink{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (v *VaultSink) Name() string {
+	return "Vault " + v.config.Address
+}
+
+// Team returns who owns the repository a finding is in: the longest
+// matching repository URL prefix or organization in teams
+func (v *VaultSink) Team(event *MatchEvent) string {
+	team, longest := v.config.DefaultTeam, 0
+	repository := strings.TrimPrefix(strings.TrimPrefix(event.Url, "https://"), "http://")
+
+	for owner, name := range v.config.Teams {
+		prefix := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSuffix(owner, "/"), "https://"), "http://")
+		if !strings.Contains(prefix, "/") {
+			// an organization, on any host
+			if parts := strings.Split(repository, "/"); len(parts) > 1 && strings.EqualFold(parts[1], prefix) && len(prefix) > longest {
+				team, longest = name, len(prefix)
+			}
+			continue
+		}
+
+		matched := strings.EqualFold(repository, prefix) || strings.HasPrefix(strings.ToLower(repository), strings.ToLower(prefix)+"/")
+		if matched && len(prefix) > longest {
+			team, longest = name, len(prefix)
+		}
+	}
+
+	return team
+}
+
+func (v *VaultSink) Send(event *MatchEvent) error {
+	if !event.Verified || !v.config.Accepts(event) {
+		return nil
+	}
+
+	matches := make([]string, len(event.Matches))
+	for i, match := range event.Matches {
+		matches[i] = Redact(match)
+	}
+
+	team := v.Team(event)
+	record := map[string]interface{}{
+		"signature":    event.Signature,
+		"severity":     event.Severity,
+		"repository":   event.Url,
+		"file":         event.File,
+		"branch":       event.Branch,
+		"commit":       event.Commit,
+		"source":       SourceNames[event.Source],
+		"matches":      matches,
+		"fingerprints": event.Fingerprints,
+		"revoked":      event.Revoked,
+		"team":         team,
+		"found_at":     time.Now().UTC().Format(time.RFC3339),
+	}
+
+	path := strings.Trim(v.config.Path, "/") + "/" + GetSlug(team) + "/" + incidentDedupKey(event)
+	endpoint := fmt.Sprintf("%s/v1/%s/%s", strings.TrimRight(v.config.Address, "/"), strings.Trim(v.config.Mount, "/"), path)
+
+	var payload interface{} = record
+	if v.config.KvVersion == 2 {
+		endpoint = fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimRight(v.config.Address, "/"), strings.Trim(v.config.Mount, "/"), path)
+		payload = map[string]interface{}{"data": record}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return PostWithRetry(v.client, defaultWebhookRetries, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(vaultTokenHeader, v.config.Token)
+
+		if v.config.Namespace != "" {
+			req.Header.Set(vaultNamespaceHeader, v.config.Namespace)
+		}
+
+		return req, nil
+	})
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Verifier attempts a harmless authenticated call with a matched secret to
+// check whether it is still live. contents is the file the match came from,
+// for secrets that only work in pairs (i.e. AWS key ID and secret).
+type Verifier func(match string, contents []byte) (bool, error)
+
+var (
+	verifiers = map[string]Verifier{
+		"aws":    verifyAWS,
+		"github": verifyGitHub,
+		"slack":  verifySlack,
+		"stripe": verifyStripe,
+	}
+
+	verifyClient = &http.Client{Timeout: 10 * time.Second}
+
+	awsKeyIdRegex     = regexp.MustCompile(`(A3T[A-Z0-9]|AKIA|AGPA|AIDA|AROA|AIPA|ANPA|ANVA|ASIA)[A-Z0-9]{16}`)
+	awsSecretKeyRegex = regexp.MustCompile(`(?i)secret[^\n]{0,50}?(?:=|:|=>)\s*["'` + "`" + `]?([A-Za-z0-9/+]{40})`)
+	githubTokenRegex  = regexp.MustCompile(`[0-9a-zA-Z_]{35,40}`)
+	slackTokenRegex   = regexp.MustCompile(`xox[pboa]-[0-9A-Za-z-]+`)
+	stripeKeyRegex    = regexp.MustCompile(`[rs]k_(live|test)_[0-9a-zA-Z]{24,}`)
+)
+
+// VerifyMatches runs the named verifier over each match and reports whether
+// any of them is live. Unknown verifiers never verify.
+func VerifyMatches(name string, matches []string, contents []byte) bool {
+	verifier, ok := verifiers[name]
+	if !ok {
+		return false
+	}
+
+	for _, match := range matches {
+		verified, err := verifier(match, contents)
+		if err != nil {
+			session.Log.Debug("Verifier %s failed: %s", name, err)
+			continue
+		}
+
+		if verified {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifyAwsCredentials reports whether any of the access key IDs among
+// matches is live with one of secrets, found alongside it by
+// PairAwsCredentials
+func VerifyAwsCredentials(matches []string, secrets []string) bool {
+	for _, match := range matches {
+		verified, err := verifyAWSKey(awsKeyIdRegex.FindString(match), secrets)
+		if err != nil {
+			session.Log.Debug("Verifier %s failed: %s", VerifierAws, err)
+			continue
+		}
+
+		if verified {
+			return true
+		}
+	}
+
+	return false
+}
+
+func verifyAWS(match string, contents []byte) (bool, error) {
+	return verifyAWSKey(awsKeyIdRegex.FindString(match), FindAwsSecretKeys(contents))
+}
+
+// verifyAWSKey tries an access key ID with each of the first few secrets
+func verifyAWSKey(keyId string, secrets []string) (bool, error) {
+	if keyId == "" {
+		return false, nil
+	}
+
+	if len(secrets) > maxAwsSecretKeys {
+		secrets = secrets[:maxAwsSecretKeys]
+	}
+
+	for _, secret := range secrets {
+		body := []byte("Action=GetCallerIdentity&Version=2011-06-15")
+		req, err := http.NewRequest("POST", "https://sts.amazonaws.com/", strings.NewReader(string(body)))
+		if err != nil {
+			return false, err
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		SignAWSRequest(req, body, AWSCredentials{AccessKeyId: keyId, SecretAccessKey: secret}, "us-east-1", "sts")
+
+		if ok, err := doVerifyRequest(req); ok || err != nil {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+func verifyGitHub(match string, contents []byte) (bool, error) {
+	token := githubTokenRegex.FindString(match)
+	if token == "" {
+		return false, nil
+	}
+
+	req, err := http.NewRequest("GET", "https://api.github.com/user", nil)
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Authorization", "token "+token)
+
+	return doVerifyRequest(req)
+}
+
+func verifySlack(match string, contents []byte) (bool, error) {
+	token := slackTokenRegex.FindString(match)
+	if token == "" {
+		return false, nil
+	}
+
+	req, err := http.NewRequest("POST", "https://slack.com/api/auth.test", nil)
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := verifyClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	// Slack always responds 200 and reports failures in the body
+	result := struct {
+		Ok bool `json:"ok"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+
+	return result.Ok, nil
+}
+
+func verifyStripe(match string, contents []byte) (bool, error) {
+	key := stripeKeyRegex.FindString(match)
+	if key == "" {
+		return false, nil
+	}
+
+	req, err := http.NewRequest("GET", "https://api.stripe.com/v1/account", nil)
+	if err != nil {
+		return false, err
+	}
+
+	req.SetBasicAuth(key, "")
+
+	return doVerifyRequest(req)
+}
+
+func doVerifyRequest(req *http.Request) (bool, error) {
+	req.Header.Set("User-Agent", Name+" v"+Version)
+
+	resp, err := verifyClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK, nil
+}
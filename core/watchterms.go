@@ -0,0 +1,179 @@
+package core
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const WatchTermSignatureName = "Watch term"
+
+var wordCharacterRegex = regexp.MustCompile(`^\w$`)
+
+// WatchTerm is a customer, team or product whose internal hostnames, product
+// names and email domains mark the findings that belong to it, so one
+// instance can watch for many of them and route each one's findings to
+// its own sinks
+type WatchTerm struct {
+	Name string `yaml:"name"`
+	// hostnames match their subdomains too, i.e. corp.example.com matches
+	// vpn.corp.example.com
+	Hostnames []string `yaml:"hostnames"`
+	// product names match as whole words, whatever their case
+	Products     []string `yaml:"products"`
+	EmailDomains []string `yaml:"email_domains"`
+	// regular expressions, as --search-query takes
+	Patterns []string `yaml:"patterns"`
+	// report mentions of the term on their own, not only tag findings in
+	// the files they're in
+	Report     bool   `yaml:"report"`
+	Severity   string `yaml:"severity,omitempty"`
+	Confidence string `yaml:"confidence,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// WatchTermMatch is a watch term and where it's mentioned in a file
+type WatchTermMatch struct {
+	Term *WatchTerm
+	// name of the tenant the term is one of, if any
+	Tenant  string
+	Matches []ContentsMatch
+}
+
+// compile validates the term and builds a single case insensitive regular
+// expression out of everything it matches
+func (term *WatchTerm) compile() error {
+	if term.Name == "" {
+		return errors.New("watch_terms need a name")
+	}
+
+	if term.Severity != "" && !IsSeverity(term.Severity) {
+		return errors.New("watch_terms " + term.Name + " severity must be one of " + strings.Join(Severities, ", "))
+	} else if term.Confidence != "" && !IsConfidence(term.Confidence) {
+		return errors.New("watch_terms " + term.Name + " confidence must be one of " + strings.Join(Confidences, ", "))
+	}
+
+	alternatives := make([]string, 0)
+	for _, hostname := range term.Hostnames {
+		if hostname = strings.Trim(hostname, "."); hostname != "" {
+			alternatives = append(alternatives, `\b(?:[a-z0-9-]+\.)*`+regexp.QuoteMeta(hostname)+`\b`)
+		}
+	}
+
+	for _, product := range term.Products {
+		if product != "" {
+			alternatives = append(alternatives, wholeWord(product))
+		}
+	}
+
+	for _, domain := range term.EmailDomains {
+		if domain = strings.TrimPrefix(domain, "@"); domain != "" {
+			alternatives = append(alternatives, `\b[a-z0-9._%+-]+@(?:[a-z0-9-]+\.)*`+regexp.QuoteMeta(domain)+`\b`)
+		}
+	}
+
+	for _, pattern := range term.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.New("watch_terms " + term.Name + " pattern " + pattern + " is invalid: " + err.Error())
+		}
+		alternatives = append(alternatives, "(?:"+pattern+")")
+	}
+
+	if len(alternatives) == 0 {
+		return errors.New("watch_terms " + term.Name + " needs hostnames, products, email_domains or patterns")
+	}
+
+	regex, err := regexp.Compile("(?i)" + strings.Join(alternatives, "|"))
+	if err != nil {
+		return err
+	}
+
+	term.regex = regex
+	return nil
+}
+
+// wholeWord matches a literal that isn't part of a longer word. Ends that
+// aren't word characters, i.e. the plusses of C++, are matched as they are
+func wholeWord(literal string) string {
+	pattern := regexp.QuoteMeta(literal)
+	if wordCharacterRegex.MatchString(literal[:1]) {
+		pattern = `\b` + pattern
+	}
+	if wordCharacterRegex.MatchString(literal[len(literal)-1:]) {
+		pattern += `\b`
+	}
+
+	return pattern
+}
+
+// FindingSeverity is the severity mentions of the term are reported with,
+// medium unless it sets one
+func (term *WatchTerm) FindingSeverity() string {
+	if term.Severity == "" {
+		return SearchQuerySeverity
+	}
+
+	return term.Severity
+}
+
+func (term *WatchTerm) FindingConfidence() string {
+	if term.Confidence == "" {
+		return SearchQueryConfidence
+	}
+
+	return term.Confidence
+}
+
+// MatchWatchTerms returns the watch_terms mentioned in a file, then those of
+// each tenant, in the order they're configured
+func (s *Session) MatchWatchTerms(file MatchFile) []WatchTermMatch {
+	s.reloading.RLock()
+	defer s.reloading.RUnlock()
+
+	var watched []WatchTermMatch
+	match := func(terms []WatchTerm, tenant string) {
+		for i := range terms {
+			term := &terms[i]
+			if term.regex == nil {
+				continue
+			}
+
+			if matches := file.InWindow(FindContentsMatches(term.regex, file.Contents)); len(matches) > 0 {
+				watched = append(watched, WatchTermMatch{Term: term, Tenant: tenant, Matches: matches})
+			}
+		}
+	}
+
+	match(s.Config.WatchTerms, "")
+	for _, tenant := range s.Config.Tenants {
+		match(tenant.WatchTerms, tenant.Name)
+	}
+
+	return watched
+}
+
+func containsAnyFold(list []string, values []string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if strings.EqualFold(item, value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// WatchTermNames returns the names of the watch terms mentioned in a file, to
+// tag its findings with, or nil if there are none
+func WatchTermNames(watched []WatchTermMatch) []string {
+	var names []string
+	for _, match := range watched {
+		if !containsString(names, match.Term.Name) {
+			names = append(names, match.Term.Name)
+		}
+	}
+
+	return names
+}
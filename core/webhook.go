@@ -0,0 +1,112 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	WebhookSignatureHeader = "X-Shhgit-Signature"
+	defaultWebhookRetries  = 3
+)
+
+// WebhookSink POSTs each finding as JSON to a URL, retrying with exponential
+// backoff. If a secret is configured the body is signed with HMAC-SHA256 so
+// receivers can authenticate it, i.e. X-Shhgit-Signature: sha256=<hex>
+type WebhookSink struct {
+	url        string
+	secret     string
+	maxRetries int
+	client     *http.Client
+}
+
+func NewWebhookSink(config WebhookConfig) *WebhookSink {
+	maxRetries := config.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultWebhookRetries
+	}
+
+	return &WebhookSink{
+		url:        config.Url,
+		secret:     config.Secret,
+		maxRetries: maxRetries,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *WebhookSink) Name() string {
+	return "webhook " + w.url
+}
+
+func (w *WebhookSink) Send(event *MatchEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return PostWithRetry(w.client, w.maxRetries, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", fmt.Sprintf("%s v%s", Name, Version))
+
+		if w.secret != "" {
+			req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(hmacSHA256([]byte(w.secret), string(body))))
+		}
+
+		return req, nil
+	})
+}
+
+// PostWithRetry sends the request built by newRequest, retrying network
+// errors, 429s and 5xx responses with exponential backoff (1s, 2s, 4s...)
+func PostWithRetry(client *http.Client, maxRetries int, newRequest func() (*http.Request, error)) error {
+	return PostWithRetryChecked(client, maxRetries, newRequest, nil)
+}
+
+// PostWithRetryChecked is PostWithRetry for APIs that report failures in
+// the body of a successful response, which check reads to return them
+func PostWithRetryChecked(client *http.Client, maxRetries int, newRequest func() (*http.Request, error), check func(*http.Response) error) error {
+	var lastErr error
+
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<uint(attempt-1)) * time.Second)
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode < 300 {
+			if check != nil {
+				err = check(resp)
+			}
+			resp.Body.Close()
+
+			return err
+		}
+		resp.Body.Close()
+
+		lastErr = fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return lastErr
+		}
+	}
+
+	return lastErr
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The live feed's WebSocket handling, on top of gorilla/websocket
+const (
+	wsMaximumMessage  = 64 * 1024
+	wsWriteTimeout    = 10 * time.Second
+	wsCloseNormal     = websocket.CloseNormalClosure
+	wsCloseGoingAway  = websocket.CloseGoingAway
+	wsClosePolicy     = websocket.ClosePolicyViolation
+	wsCloseUnexpected = websocket.CloseInternalServerErr
+)
+
+// webSocketUpgrader speaks the live feed's subprotocol when it's offered.
+// Requests from pages on other hosts are refused, as the browser sends them
+// with the user's cookies
+var webSocketUpgrader = &websocket.Upgrader{
+	Subprotocols: []string{LiveProtocol},
+	CheckOrigin:  sameOrigin,
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		writeApiError(w, status, reason.Error())
+	},
+}
+
+// sameOrigin reports whether a request has no Origin or one on the host it
+// was sent to
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// webSocket is an upgraded connection. Messages are read by one goroutine,
+// while any number may write
+type webSocket struct {
+	conn *websocket.Conn
+
+	sync.Mutex
+	closed bool
+}
+
+// upgradeWebSocket answers a WebSocket handshake. On failure the response
+// has already been written
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*webSocket, error) {
+	conn, err := webSocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetReadLimit(wsMaximumMessage)
+	return &webSocket{conn: conn}, nil
+}
+
+// WriteJSON sends v as a text message
+func (ws *webSocket) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	ws.Lock()
+	defer ws.Unlock()
+
+	if ws.closed {
+		return websocket.ErrCloseSent
+	}
+
+	ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return ws.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Ping sends a ping, which the client answers with a pong
+func (ws *webSocket) Ping() error {
+	ws.Lock()
+	defer ws.Unlock()
+
+	if ws.closed {
+		return websocket.ErrCloseSent
+	}
+
+	return ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+}
+
+// Close sends a close message with code and reason and closes the
+// connection. Closing more than once does nothing
+func (ws *webSocket) Close(code int, reason string) {
+	ws.Lock()
+	defer ws.Unlock()
+
+	if ws.closed {
+		return
+	}
+
+	ws.closed = true
+	ws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+	ws.conn.Close()
+}
+
+// ReadMessage returns the next text or binary message, waiting at most
+// timeout for anything, a pong included, to arrive
+func (ws *webSocket) ReadMessage(timeout time.Duration) ([]byte, error) {
+	ws.conn.SetReadDeadline(time.Now().Add(timeout))
+	ws.conn.SetPongHandler(func(string) error {
+		return ws.conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	_, data, err := ws.conn.ReadMessage()
+	return data, err
+}
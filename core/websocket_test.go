@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestLiveServer(t *testing.T) *httptest.Server {
+	s := &Session{Config: &Config{}, Log: NewLogger(), Live: NewLiveSink()}
+	s.Log.SetSilent(true)
+
+	var cancel context.CancelFunc
+	s.Context, cancel = context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	server := httptest.NewServer(http.HandlerFunc(s.handleLive))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestLiveWebSocket(t *testing.T) {
+	server := newTestLiveServer(t)
+	dialer := &websocket.Dialer{Subprotocols: []string{LiveProtocol}}
+
+	conn, response, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), http.Header{"Origin": {server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if protocol := response.Header.Get("Sec-WebSocket-Protocol"); protocol != LiveProtocol {
+		t.Errorf("negotiated subprotocol %q, want %s", protocol, LiveProtocol)
+	}
+
+	hello := &LiveMessage{}
+	if err := conn.ReadJSON(hello); err != nil || hello.Type != LiveMessageHello || hello.Version != LiveProtocolVersion {
+		t.Fatalf("first message is %+v with %v", hello, err)
+	}
+
+	conn.WriteJSON(&LiveMessage{Type: LiveMessageSubscribe, Filter: &LiveFilter{}})
+
+	subscribed := &LiveMessage{}
+	if err := conn.ReadJSON(subscribed); err != nil || subscribed.Type != LiveMessageSubscribed {
+		t.Errorf("reply to subscribing is %+v with %v", subscribed, err)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("not json"))
+
+	reply := &LiveMessage{}
+	if err := conn.ReadJSON(reply); err != nil || reply.Type != LiveMessageError {
+		t.Errorf("reply to a malformed message is %+v with %v", reply, err)
+	}
+}
+
+func TestLiveWebSocketHandshake(t *testing.T) {
+	server := newTestLiveServer(t)
+	address := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, test := range []struct {
+		origin    string
+		protocols []string
+		status    int
+	}{
+		{"", nil, http.StatusSwitchingProtocols},
+		{strings.ToUpper(server.URL), nil, http.StatusSwitchingProtocols},
+		{"https://evil.example", nil, http.StatusForbidden},
+		{"", []string{"shhgit.live.v0"}, http.StatusBadRequest},
+	} {
+		header := http.Header{}
+		if test.origin != "" {
+			header.Set("Origin", test.origin)
+		}
+
+		dialer := &websocket.Dialer{Subprotocols: test.protocols}
+		conn, response, err := dialer.Dial(address, header)
+		if conn != nil {
+			conn.Close()
+		}
+
+		if response == nil {
+			t.Fatalf("handshake from %q failed with %v", test.origin, err)
+		} else if response.StatusCode != test.status {
+			t.Errorf("handshake from %q offering %v returned %d, want %d", test.origin, test.protocols, response.StatusCode, test.status)
+		}
+	}
+}
@@ -0,0 +1,802 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// YaraSignature is a rule in a pure-Go subset of YARA, so rules written for
+// other secret scanners can be reused. Text, hex and regex strings, their
+// nocase, ascii, fullword and private modifiers, and conditions over string
+// matches and counts are supported. Modules, includes, wide strings and
+// string offsets are not, and rules using them fail to load
+type YaraSignature struct {
+	name       string
+	strings    []yaraString
+	condition  yaraCondition
+	verifier   string
+	severity   string
+	confidence string
+}
+
+type yaraString struct {
+	id      string
+	regex   *regexp.Regexp
+	private bool
+}
+
+// yaraScan is what a condition is evaluated against: the matches of each
+// string in a file, and its size
+type yaraScan struct {
+	matches map[string][]ContentsMatch
+	size    int
+}
+
+type (
+	yaraCondition func(scan *yaraScan) bool
+	yaraValue     func(scan *yaraScan) int
+)
+
+func (s YaraSignature) scan(contents []byte) (*yaraScan, bool) {
+	scan := &yaraScan{matches: make(map[string][]ContentsMatch), size: len(contents)}
+	for _, str := range s.strings {
+		scan.matches[str.id] = FindContentsMatches(str.regex, contents)
+	}
+
+	return scan, s.condition(scan)
+}
+
+func (s YaraSignature) Match(file MatchFile) (bool, string) {
+	_, matched := s.scan(file.Contents)
+	return matched, PartContents
+}
+
+// GetContentsMatches returns the matches of every string that isn't private
+// if the rule's condition holds. A rule matching on its condition alone,
+// i.e. filesize, has nothing to report
+func (s YaraSignature) GetContentsMatches(contents []byte) []ContentsMatch {
+	matches := make([]ContentsMatch, 0)
+
+	scan, matched := s.scan(contents)
+	if !matched {
+		return matches
+	}
+
+	for _, str := range s.strings {
+		if str.private {
+			continue
+		}
+
+		for _, match := range scan.matches[str.id] {
+			if !IsBlacklistedMatch(match.Value) {
+				matches = append(matches, match)
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Offset < matches[j].Offset
+	})
+
+	return matches
+}
+
+func (s YaraSignature) Name() string {
+	return s.name
+}
+
+func (s YaraSignature) Verifier() string {
+	return s.verifier
+}
+
+func (s YaraSignature) Severity() string {
+	return s.severity
+}
+
+func (s YaraSignature) Confidence() string {
+	return s.confidence
+}
+
+// LoadYaraSignatures parses every *.yar/*.yara file in dir, in file name
+// order. The severity, confidence and verifier of a rule are read from its
+// meta section
+func LoadYaraSignatures(s *Session, dir string) ([]Signature, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yar"))
+	if err != nil {
+		return nil, err
+	}
+
+	yaraFiles, _ := filepath.Glob(filepath.Join(dir, "*.yara"))
+	files = append(files, yaraFiles...)
+	sort.Strings(files)
+
+	var signatures []Signature
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		rules, err := parseYaraRules(filepath.Base(file), string(data))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rule := range rules {
+			rule.severity, rule.confidence = signatureRating(s, ConfigSignature{
+				Name:       rule.name,
+				Part:       PartContents,
+				Severity:   rule.severity,
+				Confidence: rule.confidence,
+			})
+
+			signatures = append(signatures, rule)
+		}
+	}
+
+	return signatures, nil
+}
+
+// yaraError aborts parsing, and is recovered in parseYaraRules
+type yaraError struct {
+	err error
+}
+
+type yaraParser struct {
+	file    string
+	src     string
+	pos     int
+	strings map[string]bool
+	order   []string
+}
+
+func parseYaraRules(file string, src string) (rules []YaraSignature, err error) {
+	p := &yaraParser{file: file, src: src}
+
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(yaraError)
+			if !ok {
+				panic(r)
+			}
+			rules, err = nil, e.err
+		}
+	}()
+
+	for {
+		p.skip()
+		if p.pos >= len(p.src) {
+			return rules, nil
+		}
+
+		private := false
+		keyword := p.word()
+		switch keyword {
+		case "private":
+			private = true
+			p.expectWord("rule")
+		case "rule":
+		case "import", "include", "global":
+			p.fail("%s is not supported", keyword)
+		default:
+			p.fail("expected rule, found %q", keyword)
+		}
+
+		rule := p.rule()
+		// private rules only exist to be referenced by other rules, which
+		// isn't supported, so there is nothing to do with them
+		if !private {
+			rules = append(rules, rule)
+		}
+	}
+}
+
+func (p *yaraParser) fail(format string, args ...interface{}) {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	panic(yaraError{fmt.Errorf("%s:%d: %s", p.file, line, fmt.Sprintf(format, args...))})
+}
+
+// skip moves past whitespace and comments
+func (p *yaraParser) skip() {
+	for p.pos < len(p.src) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])):
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			if end := strings.IndexByte(p.src[p.pos:], '\n'); end >= 0 {
+				p.pos += end
+			} else {
+				p.pos = len(p.src)
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				p.fail("unterminated comment")
+			}
+			p.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next character, or 0 at the end
+func (p *yaraParser) peek() byte {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+func isYaraWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// word reads an identifier, keyword or number, returning "" if there isn't one
+func (p *yaraParser) word() string {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) && isYaraWordByte(p.src[p.pos]) {
+		p.pos++
+	}
+
+	return p.src[start:p.pos]
+}
+
+// peekWord reads the next word without consuming it
+func (p *yaraParser) peekWord() string {
+	pos := p.pos
+	word := p.word()
+	p.pos = pos
+
+	return word
+}
+
+func (p *yaraParser) accept(token string) bool {
+	p.skip()
+	if strings.HasPrefix(p.src[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+
+	return false
+}
+
+func (p *yaraParser) expect(token string) {
+	if !p.accept(token) {
+		p.fail("expected %q", token)
+	}
+}
+
+func (p *yaraParser) expectWord(word string) {
+	if found := p.word(); found != word {
+		p.fail("expected %s, found %q", word, found)
+	}
+}
+
+func (p *yaraParser) rule() YaraSignature {
+	rule := YaraSignature{name: p.word()}
+	if rule.name == "" {
+		p.fail("expected a rule name")
+	}
+
+	// tags aren't used
+	if p.accept(":") {
+		for p.peek() != '{' {
+			if p.word() == "" {
+				p.fail("expected a tag")
+			}
+		}
+	}
+
+	p.expect("{")
+	p.strings, p.order = make(map[string]bool), nil
+
+	for {
+		section := p.word()
+		p.expect(":")
+
+		switch section {
+		case "meta":
+			for p.peekWord() != "strings" && p.peekWord() != "condition" {
+				key := p.word()
+				if key == "" {
+					p.fail("expected a meta key")
+				}
+				p.expect("=")
+				value := p.metaValue()
+
+				switch key {
+				case "severity":
+					rule.severity = value
+				case "confidence":
+					rule.confidence = value
+				case "verifier":
+					rule.verifier = value
+				}
+			}
+		case "strings":
+			for p.peek() == '$' {
+				rule.strings = append(rule.strings, p.stringDefinition())
+			}
+		case "condition":
+			rule.condition = p.expression()
+			p.expect("}")
+			return rule
+		default:
+			p.fail("expected meta, strings or condition, found %q", section)
+		}
+	}
+}
+
+func (p *yaraParser) metaValue() string {
+	if p.peek() == '"' {
+		return p.text()
+	}
+
+	value := p.word()
+	if value == "" {
+		p.fail("expected a meta value")
+	}
+
+	return value
+}
+
+// text reads a double quoted string with YARA's escapes
+func (p *yaraParser) text() string {
+	p.expect(`"`)
+
+	var value strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.fail("unterminated string")
+		}
+
+		c := p.src[p.pos]
+		p.pos++
+
+		switch c {
+		case '"':
+			return value.String()
+		case '\\':
+			if p.pos >= len(p.src) {
+				p.fail("unterminated string")
+			}
+
+			escape := p.src[p.pos]
+			p.pos++
+
+			switch escape {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case 'x':
+				if p.pos+2 > len(p.src) {
+					p.fail("invalid \\x escape")
+				}
+				b, err := strconv.ParseUint(p.src[p.pos:p.pos+2], 16, 8)
+				if err != nil {
+					p.fail("invalid \\x escape")
+				}
+				value.WriteByte(byte(b))
+				p.pos += 2
+			default:
+				value.WriteByte(escape)
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+}
+
+// stringId reads $name. Anonymous strings ($) are given a name
+func (p *yaraParser) stringId() string {
+	p.expect("$")
+	id := p.word()
+	if id == "" {
+		id = fmt.Sprintf("anonymous%d", len(p.order))
+	}
+
+	return id
+}
+
+func (p *yaraParser) stringDefinition() yaraString {
+	str := yaraString{id: p.stringId()}
+	if p.strings[str.id] {
+		p.fail("duplicate string $%s", str.id)
+	}
+	p.expect("=")
+
+	var (
+		pattern  string
+		wordEdge bool
+	)
+
+	switch p.peek() {
+	case '"':
+		value := p.text()
+		pattern = regexp.QuoteMeta(value)
+		wordEdge = value != "" && isYaraWordByte(value[0]) && isYaraWordByte(value[len(value)-1])
+	case '{':
+		pattern = p.hex()
+	case '/':
+		pattern = p.regex()
+		wordEdge = true
+	default:
+		p.fail("expected a text, hex or regex string")
+	}
+
+	for {
+		modifier := p.peekWord()
+		switch modifier {
+		case "nocase":
+			pattern = "(?i)" + pattern
+		case "fullword":
+			if wordEdge {
+				pattern = `\b(?:` + pattern + `)\b`
+			}
+		case "private":
+			str.private = true
+		case "ascii":
+		case "wide", "xor", "base64", "base64wide":
+			p.fail("the %s modifier is not supported", modifier)
+		default:
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				p.fail("$%s: %s", str.id, err)
+			}
+
+			str.regex = regex
+			p.strings[str.id] = true
+			p.order = append(p.order, str.id)
+
+			return str
+		}
+
+		p.word()
+	}
+}
+
+// regex reads /pattern/flags. Go's RE2 syntax lacks backreferences and
+// lookarounds, so rules using them fail to compile
+func (p *yaraParser) regex() string {
+	p.expect("/")
+
+	var pattern strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.fail("unterminated regex")
+		}
+
+		c := p.src[p.pos]
+		p.pos++
+
+		if c == '/' {
+			break
+		}
+
+		if c == '\\' && p.pos < len(p.src) && p.src[p.pos] == '/' {
+			c = '/'
+			p.pos++
+		} else if c == '\\' && p.pos < len(p.src) {
+			pattern.WriteByte(c)
+			c = p.src[p.pos]
+			p.pos++
+		}
+
+		pattern.WriteByte(c)
+	}
+
+	flags := ""
+	for p.pos < len(p.src) && (p.src[p.pos] == 'i' || p.src[p.pos] == 's') {
+		flags += string(p.src[p.pos])
+		p.pos++
+	}
+
+	if flags != "" {
+		return "(?" + flags + ")" + pattern.String()
+	}
+
+	return pattern.String()
+}
+
+// hex converts a hex string, with wildcards, jumps and alternatives, to a
+// regex. Only bytes up to 0x7f are supported as contents are matched as text
+func (p *yaraParser) hex() string {
+	p.expect("{")
+
+	pattern := "(?s)"
+	for {
+		c := p.peek()
+		switch {
+		case c == '}':
+			p.pos++
+			return pattern
+		case c == '(':
+			p.pos++
+			pattern += "(?:"
+		case c == '|' || c == ')':
+			p.pos++
+			pattern += string(c)
+		case c == '[':
+			end := strings.IndexByte(p.src[p.pos:], ']')
+			if end < 0 {
+				p.fail("unterminated jump")
+			}
+
+			jump := strings.Replace(p.src[p.pos+1:p.pos+end], " ", "", -1)
+			p.pos += end + 1
+
+			bounds := strings.SplitN(jump, "-", 2)
+			for _, bound := range bounds {
+				if _, err := strconv.Atoi(bound); err != nil && bound != "" {
+					p.fail("invalid jump [%s]", jump)
+				}
+			}
+
+			switch {
+			case len(bounds) == 1:
+				pattern += ".{" + bounds[0] + "}"
+			case bounds[0] == "" && bounds[1] == "":
+				pattern += ".*?"
+			case bounds[0] == "":
+				pattern += ".{0," + bounds[1] + "}?"
+			default:
+				pattern += ".{" + bounds[0] + "," + bounds[1] + "}?"
+			}
+		case c == 0:
+			p.fail("unterminated hex string")
+		default:
+			if p.pos+2 > len(p.src) {
+				p.fail("unterminated hex string")
+			}
+
+			pattern += p.hexByte(p.src[p.pos : p.pos+2])
+			p.pos += 2
+		}
+	}
+}
+
+func (p *yaraParser) hexByte(digits string) string {
+	if digits == "??" {
+		return "."
+	}
+
+	high, low := digits[:1], digits[1:]
+	if high == "?" || low == "?" {
+		class := "["
+		for nibble := 0; nibble < 16; nibble++ {
+			b := fmt.Sprintf("%x", nibble)
+			if high == "?" {
+				b = b + low
+			} else {
+				b = high + b
+			}
+
+			value, err := strconv.ParseUint(b, 16, 8)
+			if err != nil {
+				p.fail("invalid hex byte %s", digits)
+			}
+			if value <= 0x7f {
+				class += fmt.Sprintf(`\x%02x`, value)
+			}
+		}
+
+		return class + "]"
+	}
+
+	value, err := strconv.ParseUint(digits, 16, 8)
+	if err != nil {
+		p.fail("invalid hex byte %s", digits)
+	}
+	if value > 0x7f {
+		p.fail("hex byte %s is above 0x7f, which is not supported", digits)
+	}
+
+	return fmt.Sprintf(`\x%02x`, value)
+}
+
+func (p *yaraParser) expression() yaraCondition {
+	left := p.and()
+	for p.peekWord() == "or" {
+		p.word()
+		a, b := left, p.and()
+		left = func(scan *yaraScan) bool { return a(scan) || b(scan) }
+	}
+
+	return left
+}
+
+func (p *yaraParser) and() yaraCondition {
+	left := p.not()
+	for p.peekWord() == "and" {
+		p.word()
+		a, b := left, p.not()
+		left = func(scan *yaraScan) bool { return a(scan) && b(scan) }
+	}
+
+	return left
+}
+
+func (p *yaraParser) not() yaraCondition {
+	if p.peekWord() == "not" {
+		p.word()
+		condition := p.not()
+		return func(scan *yaraScan) bool { return !condition(scan) }
+	}
+
+	return p.primary()
+}
+
+func (p *yaraParser) primary() yaraCondition {
+	if p.accept("(") {
+		condition := p.expression()
+		p.expect(")")
+		return condition
+	}
+
+	if p.peek() == '$' {
+		id := p.stringReference()
+		if word := p.peekWord(); word == "at" || word == "in" {
+			p.fail("string offsets are not supported")
+		}
+
+		return func(scan *yaraScan) bool { return len(scan.matches[id]) > 0 }
+	}
+
+	switch word := p.peekWord(); word {
+	case "true", "false":
+		p.word()
+		return func(*yaraScan) bool { return word == "true" }
+	case "any", "all", "none":
+		p.word()
+		return p.quantifier(word, 0)
+	}
+
+	pos := p.pos
+	if count, err := strconv.Atoi(p.word()); err == nil && p.peekWord() == "of" {
+		return p.quantifier("", count)
+	}
+	p.pos = pos
+
+	left := p.value()
+
+	operator := ""
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			operator = op
+			break
+		}
+	}
+
+	right := p.value()
+
+	switch operator {
+	case "==":
+		return func(scan *yaraScan) bool { return left(scan) == right(scan) }
+	case "!=":
+		return func(scan *yaraScan) bool { return left(scan) != right(scan) }
+	case "<=":
+		return func(scan *yaraScan) bool { return left(scan) <= right(scan) }
+	case ">=":
+		return func(scan *yaraScan) bool { return left(scan) >= right(scan) }
+	case "<":
+		return func(scan *yaraScan) bool { return left(scan) < right(scan) }
+	case ">":
+		return func(scan *yaraScan) bool { return left(scan) > right(scan) }
+	}
+
+	p.fail("expected a comparison")
+	return nil
+}
+
+// stringReference reads $name and checks the rule defines it
+func (p *yaraParser) stringReference() string {
+	id := p.stringId()
+	if !p.strings[id] {
+		p.fail("undefined string $%s", id)
+	}
+
+	return id
+}
+
+// value reads a number, filesize or a string's match count (#name)
+func (p *yaraParser) value() yaraValue {
+	if p.accept("#") {
+		id := p.word()
+		if !p.strings[id] {
+			p.fail("undefined string #%s", id)
+		}
+
+		return func(scan *yaraScan) int { return len(scan.matches[id]) }
+	}
+
+	word := p.word()
+	if word == "filesize" {
+		return func(scan *yaraScan) int { return scan.size }
+	}
+
+	multiplier := 1
+	if strings.HasSuffix(word, "KB") {
+		word, multiplier = strings.TrimSuffix(word, "KB"), 1024
+	} else if strings.HasSuffix(word, "MB") {
+		word, multiplier = strings.TrimSuffix(word, "MB"), 1024*1024
+	}
+
+	number, err := strconv.ParseInt(word, 0, 64)
+	if err != nil {
+		p.fail("expected a condition, found %q", word)
+	}
+
+	value := int(number) * multiplier
+	return func(*yaraScan) int { return value }
+}
+
+// quantifier reads "of them" or "of ($a, $b*)" after any, all, none or a
+// number of strings that must match
+func (p *yaraParser) quantifier(quantity string, count int) yaraCondition {
+	p.expectWord("of")
+
+	var ids []string
+	if p.peekWord() == "them" {
+		p.word()
+		ids = p.order
+	} else {
+		p.expect("(")
+		for {
+			p.expect("$")
+			prefix := p.word()
+
+			if p.accept("*") {
+				for _, id := range p.order {
+					if strings.HasPrefix(id, prefix) {
+						ids = append(ids, id)
+					}
+				}
+			} else if p.strings[prefix] {
+				ids = append(ids, prefix)
+			} else {
+				p.fail("undefined string $%s", prefix)
+			}
+
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.expect(")")
+	}
+
+	if len(ids) == 0 {
+		p.fail("no strings to match")
+	}
+
+	return func(scan *yaraScan) bool {
+		matched := 0
+		for _, id := range ids {
+			if len(scan.matches[id]) > 0 {
+				matched++
+			}
+		}
+
+		switch quantity {
+		case "any":
+			return matched > 0
+		case "all":
+			return matched == len(ids)
+		case "none":
+			return matched == 0
+		}
+
+		return matched >= count
+	}
+}
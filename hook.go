@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/eth0izzle/shhgit/core"
+	"github.com/fatih/color"
+)
+
+// runHook scans staged changes (pre-commit) or pushed commits (pre-receive)
+// and returns the exit code: non-zero blocks the commit or push
+func runHook() int {
+	root := core.GetRepositoryRoot()
+	matchedAny := false
+
+	if *session.Options.PreReceive {
+		scanner := bufio.NewScanner(os.Stdin)
+
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 3 {
+				continue
+			}
+
+			files, err := core.GetPushedFiles(root, fields[0], fields[1])
+			if err != nil {
+				session.Log.Error("Failed to read pushed files for %s: %s", fields[2], err)
+				return 2
+			}
+
+			job := core.ScanJob{Dir: root, Url: fields[2], Stars: -1, Source: core.LOCAL_SOURCE, Branch: strings.TrimPrefix(fields[2], "refs/heads/")}
+			matchedAny = checkFiles(files, job) || matchedAny
+		}
+	} else {
+		files, err := core.GetStagedFiles(root)
+		if err != nil {
+			session.Log.Error("Failed to read staged files: %s", err)
+			return 2
+		}
+
+		matchedAny = checkFiles(files, core.ScanJob{Dir: root, Url: "staged", Stars: -1, Source: core.LOCAL_SOURCE})
+	}
+
+	if matchedAny && !session.Failed() {
+		logWithinExitPolicy(root)
+		return 0
+	}
+
+	if matchedAny {
+		session.Log.Error("[!] %s found potential secrets. Remove them or add them to blacklisted_strings to continue.", core.Name)
+		return *session.Options.ExitCode
+	}
+
+	session.Log.Info("[*] No matching secrets found in %s", color.BlueString(root))
+	return 0
+}
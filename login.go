@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eth0izzle/shhgit/core"
+	"github.com/fatih/color"
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+// runLogin gets a GitHub token through the OAuth device flow and adds it to
+// github_access_tokens in config.yaml, returning the exit code
+func runLogin() int {
+	if *session.Options.ClientId == "" {
+		session.Log.Error("login needs the client ID of a GitHub OAuth app with device flow enabled. Set --client-id")
+		return 2
+	}
+
+	// public repositories, Gists and events need no scopes
+	token, err := core.GitHubDeviceLogin(*session.Options.ClientId, "", func(code *core.GitHubDeviceCode) {
+		fmt.Printf("Open %s and enter the code %s\n", color.BlueString(code.VerificationUri), color.YellowString(code.UserCode))
+	})
+
+	if err != nil {
+		session.Log.Error("Failed to log in to GitHub: %s", err)
+		return 2
+	}
+
+	client := github.NewClient(oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})))
+	user, _, err := client.Users.Get(context.Background(), "")
+	if err != nil {
+		session.Log.Error("Failed to validate the new token: %s", err)
+		return 2
+	}
+
+	file := core.ConfigFile(session.Options)
+	if err := core.AddGitHubToken(file, token); err != nil {
+		session.Log.Error("Failed to save the token: %s", err)
+		return 2
+	}
+
+	session.Log.Info("[*] Logged in as %s. Added the token to %s", color.BlueString(user.GetLogin()), color.BlueString(file))
+	return 0
+}
@@ -3,9 +3,8 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"context"
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,185 +14,831 @@ import (
 
 	"github.com/eth0izzle/shhgit/core"
 	"github.com/fatih/color"
+	"github.com/google/go-github/github"
+	"gopkg.in/src-d/go-git.v4"
 )
 
-type MatchEvent struct {
-	Url       string
-	Matches   []string
-	Signature string
-	File      string
-	Stars     int
-	Source    core.GitResourceType
-}
-
 var session = core.GetSession()
 
+// ProcessRepositories starts the clone workers, which resolve repositories
+// from the event feeds, clone them and queue them for the scan workers.
+// Once the scan queue is full they block, so no more than
+// workers.clone + workers.scan_queue_size checkouts exist on disk at once
 func ProcessRepositories() {
-	threadNum := *session.Options.Threads
-
-	for i := 0; i < threadNum; i++ {
+	for i := 0; i < session.Config.Workers.Clone; i++ {
 		go func(tid int) {
 			for {
-				timeout := time.Duration(*session.Options.CloneRepositoryTimeout) * time.Second
-				_, cancel := context.WithTimeout(context.Background(), timeout)
-				defer cancel()
-
-				repository := <-session.Repositories
-
-				repo, err := core.GetRepository(session, repository.Id)
-
-				if err != nil {
-					session.Log.Warn("Failed to retrieve repository %d: %s", repository.Id, err)
-					continue
+				repository := session.NextRepository()
+				if !session.StartWork() {
+					session.Requeue(repository)
+					return
 				}
 
-				if repo.GetPermissions()["pull"] &&
-					uint(repo.GetStargazersCount()) >= *session.Options.MinimumStars &&
-					uint(repo.GetSize()) < *session.Options.MaximumRepositorySize {
-
-					processRepositoryOrGist(repo.GetCloneURL(), repository.Ref, repo.GetStargazersCount(), core.GITHUB_SOURCE)
+				processRepository(repository)
+				if session.Interrupted() {
+					session.Requeue(repository)
 				}
+				session.FinishWork()
 			}
 		}(i)
 	}
 }
 
-func ProcessGists() {
-	threadNum := *session.Options.Threads
+// processRepository clones, downloads or fetches the diff of a repository
+// and queues it to be scanned
+func processRepository(repository core.GitResource) {
+	// scans requested through the API run even if another instance has one
+	// queued
+	if !repository.Deferred && !session.ScanRequests.IsPending(repository.Url) && !session.IsNewResource(repository) {
+		session.Log.Debug("[%s] Skipping, already queued by another instance", repository.Url)
+		return
+	}
 
-	for i := 0; i < threadNum; i++ {
+	// pushes of commits already scanned, such as a branch pushed again or
+	// a tag of a commit on one, are skipped before any API call
+	if session.ScanCache.Has(repository.Url, repository.Head) && !session.ScanRequests.IsPending(repository.Url) {
+		session.Log.Debug("[%s] Skipping, %s was already scanned", repository.Url, repository.Head)
+		return
+	}
+
+	if repository.Type == core.DOCKER_SOURCE {
+		pullImage(repository.Url)
+		return
+	}
+
+	if repository.Type == core.PACKAGE_SOURCE || repository.Type == core.ARCHIVE_SOURCE || repository.Type == core.RELEASE_SOURCE {
+		downloadArchive(repository.Url, repository.Type)
+		return
+	}
+
+	if repository.Type == core.SVN_SOURCE {
+		exportSvnRepository(repository.Url, repository.Ref)
+		return
+	}
+
+	if repository.Type == core.BUCKET_SOURCE {
+		scanBucket(repository.Url)
+		return
+	}
+
+	// repositories queued through the API have no GitHub ID to look up
+	if repository.Type != core.GITHUB_SOURCE || repository.Id == 0 {
+		session.Forks.Link(repository.Url, repository.Parent)
+		if checkRepositorySize(repository, session.RepositorySize(repository)) {
+			cloneRepositoryOrGist(repository.Url, repository.Ref, nil, repository.Type, repository.Priority == core.PriorityScheduled)
+		}
+		return
+	}
+
+	repo, err := core.GetRepository(session, repository.GitHub, repository.Id)
+
+	if err != nil {
+		session.Log.Warn("Failed to retrieve repository %d: %s", repository.Id, err)
+		return
+	}
+
+	if repo.GetPermissions()["pull"] &&
+		(repository.Watched || uint(repo.GetStargazersCount()) >= *session.Options.MinimumStars) &&
+		checkRepositorySize(repository, int64(repo.GetSize())) {
+
+		// the source is the repository at the root of a network of forks
+		if repo.GetFork() && repo.GetSource() != nil {
+			session.Forks.Link(repo.GetCloneURL(), repo.GetSource().GetCloneURL())
+		} else if repo.GetMirrorURL() != "" {
+			session.Forks.Link(repo.GetCloneURL(), repo.GetMirrorURL())
+		}
+
+		if *session.Options.ScanPushDiffs && scanPushDiff(repository, repo) {
+			return
+		}
+
+		cloneRepositoryOrGist(repo.GetCloneURL(), repository.Ref, core.NewRepositoryMetadata(repo), core.GITHUB_SOURCE, repository.Priority == core.PriorityScheduled)
+	}
+}
+
+// checkRepositorySize returns whether to clone a repository now, given the
+// size in KB its provider reports, rather than find out after a long clone
+// that it's too large. Those over --large-repository-size are deferred to
+// be cloned once nothing else is queued
+func checkRepositorySize(repository core.GitResource, size int64) bool {
+	if size <= 0 {
+		return true
+	}
+
+	if uint(size) >= *session.Options.MaximumRepositorySize {
+		session.Log.Debug("[%s] Skipping, %d KB is over the maximum repository size", repository.Url, size)
+		session.ScanRequests.Finish(repository.Url, fmt.Errorf("%d KB is over the maximum repository size", size))
+		return false
+	}
+
+	if large := *session.Options.LargeRepositorySize; large > 0 && uint(size) >= large && !repository.Deferred {
+		repository.Size = size
+		session.DeferRepository(repository)
+		return false
+	}
+
+	return true
+}
+
+func ProcessGists() {
+	for i := 0; i < session.Config.Workers.Clone; i++ {
 		go func(tid int) {
 			for {
 				gistUrl := <-session.Gists
-				processRepositoryOrGist(gistUrl, "", -1, core.GIST_SOURCE)
+				if !session.StartWork() {
+					session.Requeue(core.GitResource{Type: core.GIST_SOURCE, Url: gistUrl})
+					return
+				}
+
+				if session.IsNewResource(core.GitResource{Type: core.GIST_SOURCE, Url: gistUrl}) {
+					cloneRepositoryOrGist(gistUrl, "", nil, core.GIST_SOURCE, false)
+					if session.Interrupted() {
+						session.Requeue(core.GitResource{Type: core.GIST_SOURCE, Url: gistUrl})
+					}
+				}
+				session.FinishWork()
 			}
 		}(i)
 	}
 }
 
 func ProcessComments() {
-	threadNum := *session.Options.Threads
+	go func() {
+		for {
+			commentBody := <-session.Comments
+			if !session.StartWork() {
+				session.Requeue(commentBody)
+				return
+			}
+
+			dir := filepath.Join(*session.Options.TempDirectory, core.GetHash(commentBody))
+			file := core.MatchFile{
+				Path:      filepath.ToSlash(filepath.Join(dir, "comment.ignore")),
+				Filename:  "comment.ignore",
+				Extension: ".ignore",
+				Contents:  []byte(commentBody),
+			}
+
+			session.QueueScan(core.ScanJob{Dir: dir, Url: "ISSUE", Stars: 0, Source: core.GITHUB_COMMENT, Files: []core.MatchFile{file}})
+			session.FinishWork()
+		}
+	}()
+}
+
+func ProcessPastes() {
+	go func() {
+		for {
+			paste := <-session.Pastes
+			if !session.StartWork() {
+				session.Requeue(paste)
+				return
+			}
+
+			dir := filepath.Join(*session.Options.TempDirectory, core.GetHash(paste.Url))
+			file := core.MatchFile{
+				Path:      filepath.ToSlash(filepath.Join(dir, "paste.ignore")),
+				Filename:  "paste.ignore",
+				Extension: ".ignore",
+				Contents:  []byte(paste.Contents),
+			}
+
+			session.QueueScan(core.ScanJob{Dir: dir, Url: paste.Url, Stars: -1, Source: core.PASTE_SOURCE, Files: []core.MatchFile{file}})
+			session.FinishWork()
+		}
+	}()
+}
+
+func pullImage(reference string) {
+	image, err := core.ParseDockerImage(reference)
+	if err != nil {
+		session.Log.Debug("%s", err)
+		session.ScanRequests.Finish(reference, err)
+		return
+	}
+
+	dir := filepath.Join(*session.Options.TempDirectory, core.GetHash(reference))
+	files, err := core.GetImageFiles(session, image, dir)
+
+	if err != nil {
+		session.Metrics.Inc(core.MetricCloneFailures)
+		session.Log.Debug("[%s] Pulling failed: %s", reference, err)
+		session.RecordScan(reference, core.DOCKER_SOURCE, -1, core.ScanStatusFailed, err)
+		return
+	}
+
+	session.Metrics.Inc(core.MetricRepositoriesCloned)
+	session.Log.Debug("[%s] Pulled %d files in to memory", reference, len(files))
+	session.QueueScan(core.ScanJob{Dir: dir, Url: reference, Stars: -1, Source: core.DOCKER_SOURCE, Files: files})
+}
+
+// downloadArchive downloads a package, a release asset or an archive
+// submitted through the API and queues its contents to be scanned
+func downloadArchive(archiveUrl string, source core.GitResourceType) {
+	var (
+		dir   = filepath.Join(*session.Options.TempDirectory, core.GetHash(archiveUrl))
+		files []core.MatchFile
+		err   error
+	)
+
+	if source == core.PACKAGE_SOURCE {
+		files, err = core.GetPackageFiles(session, archiveUrl, dir)
+	} else {
+		files, err = core.GetArchiveFiles(session, archiveUrl, source, dir)
+	}
+
+	if err != nil {
+		session.Metrics.Inc(core.MetricCloneFailures)
+		session.Log.Debug("[%s] Downloading failed: %s", archiveUrl, err)
+		session.RecordScan(archiveUrl, source, -1, core.ScanStatusFailed, err)
+		return
+	}
+
+	session.Metrics.Inc(core.MetricRepositoriesCloned)
+	session.Log.Debug("[%s] Downloaded %d files in to memory", archiveUrl, len(files))
+	session.QueueScan(core.ScanJob{Dir: dir, Url: archiveUrl, Stars: -1, Source: source, Files: files})
+}
 
-	for i := 0; i < threadNum; i++ {
+func exportSvnRepository(url string, revision string) {
+	dir := filepath.Join(*session.Options.TempDirectory, core.GetHash(url))
+	files, err := core.GetSvnFiles(session, url, revision, dir)
+
+	if err != nil {
+		session.Metrics.Inc(core.MetricCloneFailures)
+		session.Log.Debug("[%s] Exporting failed: %s", url, err)
+		session.RecordScan(url, core.SVN_SOURCE, -1, core.ScanStatusFailed, err)
+		return
+	}
+
+	session.Metrics.Inc(core.MetricRepositoriesCloned)
+	session.Log.Debug("[%s] Exported %d files in to memory", url, len(files))
+	session.QueueScan(core.ScanJob{Dir: dir, Url: url, Stars: -1, Source: core.SVN_SOURCE, Head: revision, Files: files})
+}
+
+// scanBucket downloads the objects in a bucket added or changed since it
+// was last scanned and queues them to be scanned
+func scanBucket(url string) {
+	dir := filepath.Join(*session.Options.TempDirectory, core.GetHash(url))
+	files, err := core.GetBucketFiles(session, url, dir)
+
+	if err != nil && len(files) == 0 {
+		session.Metrics.Inc(core.MetricCloneFailures)
+		session.Log.Debug("[%s] Listing failed: %s", url, err)
+		session.RecordScan(url, core.BUCKET_SOURCE, -1, core.ScanStatusFailed, err)
+		return
+	}
+
+	if len(files) == 0 {
+		session.Log.Debug("[%s] No new objects", url)
+		session.ScanRequests.Finish(url, nil)
+		return
+	}
+
+	session.Metrics.Inc(core.MetricRepositoriesCloned)
+	session.Log.Debug("[%s] Downloaded %d objects in to memory", url, len(files))
+	session.QueueScan(core.ScanJob{Dir: dir, Url: url, Stars: -1, Source: core.BUCKET_SOURCE, Files: files})
+}
+
+// ProcessFindings writes the findings reported by the workers to the
+// outputs and sinks, as if they had been found here
+func ProcessFindings() {
+	for {
+		event, err := session.ReceiveFinding()
+		if err != nil {
+			session.Log.Warn("Failed to receive a finding from the workers: %s... trying again", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if len(event.Matches) > 0 {
+			session.Log.Important("[%s] %d %s for %s in file %s: %s%s%s", event.Url, len(event.Matches), core.Pluralize(len(event.Matches), "match", "matches"), color.GreenString(event.Signature), event.File, color.YellowString(locateMatches(event.Matches, event.Keys, event.Lines, event.Columns)), severityTag(event.Severity), verifiedTag(event.Verified))
+			logPrivateKeys(event.Url, event.File, event.PrivateKeys)
+			logJsonWebTokens(event.Url, event.File, event.Tokens)
+			logAttributions(event.Url, event.File, event.Attributions)
+		} else {
+			session.Log.Important("[%s] Matching file %s for %s%s", event.Url, color.YellowString(event.File), color.GreenString(event.Signature), severityTag(event.Severity))
+		}
+
+		publish(event)
+	}
+}
+
+// ProcessScanJobs starts the scan workers, which check cloned repositories
+// and comments against the signatures
+func ProcessScanJobs() {
+	for i := 0; i < session.Config.Workers.Scan; i++ {
 		go func(tid int) {
 			for {
-				commentBody := <-session.Comments
-				dir := core.GetTempDir(core.GetHash(commentBody))
-				ioutil.WriteFile(filepath.Join(dir, "comment.ignore"), []byte(commentBody), 0644)
+				job := <-session.ScanJobs
+				matched := false
 
-				if !checkSignatures(dir, "ISSUE", 0, core.GITHUB_COMMENT) {
-					os.RemoveAll(dir)
+				if job.Repository != nil {
+					job.Branch, job.Head = core.GetHead(job.Repository)
 				}
+
+				// scans requested through the API and scheduled re-scans run
+				// even if the commit was scanned before
+				scanned := !job.Rescan && session.ScanCache.Has(job.Url, job.Head) && !session.ScanRequests.IsPending(job.Url)
+				if scanned {
+					session.Log.Debug("[%s] Skipping, %s was already scanned", job.Url, job.Head)
+				} else {
+					if job.Repository != nil {
+						matched = checkHistory(&job)
+
+						files, err := core.GetTreeFiles(job.Repository, job.Dir)
+						if err != nil {
+							session.Log.Debug("[%s] Failed to read files: %s", job.Url, err)
+						}
+
+						job.Files = files
+					}
+
+					if checkFiles(job.Files, job) {
+						matched = true
+					}
+
+					session.ScanCache.Add(job.Url, append(job.Commits, job.Head)...)
+				}
+
+				if job.CloneDir != "" {
+					os.RemoveAll(job.CloneDir)
+				}
+
+				if job.Source != core.GITHUB_COMMENT && !scanned {
+					status := core.ScanStatusScanned
+					if matched {
+						status = core.ScanStatusMatched
+					}
+
+					session.RecordScan(job.Url, job.Source, job.Stars, status, nil)
+				}
+
+				session.FinishScan()
 			}
 		}(i)
 	}
 }
 
-func processRepositoryOrGist(url string, ref string, stars int, source core.GitResourceType) {
+// cloneRepositoryOrGist clones a repository or gist and queues it to be
+// scanned, along with its GitHub metadata if it was looked up. Re-scans
+// scan the commits already in the scan cache again
+func cloneRepositoryOrGist(url string, ref string, metadata *core.RepositoryMetadata, source core.GitResourceType, rescan bool) {
 	var (
-		matchedAny bool = false
+		dir        = filepath.Join(*session.Options.TempDirectory, core.GetHash(url))
+		stars      = -1
+		cloneDir   string
+		repository *git.Repository
+		err        error
 	)
 
-	dir := core.GetTempDir(core.GetHash(url))
-	_, err := core.CloneRepository(session, url, ref, dir)
+	if metadata != nil {
+		stars = metadata.Stars
+	}
+
+	if *session.Options.PartialClone {
+		cloneDir, err = core.CloneDirectory(session, url)
+		if err == nil {
+			repository, err = core.PartialCloneRepository(session, url, ref, source, cloneDir)
+		}
+	} else {
+		repository, err = core.CloneRepository(session, url, ref, source)
+	}
 
 	if err != nil {
+		if cloneDir != "" {
+			os.RemoveAll(cloneDir)
+		}
+
 		session.Log.Debug("[%s] Cloning failed: %s", url, err.Error())
-		os.RemoveAll(dir)
+		session.RecordScan(url, source, stars, core.ScanStatusFailed, err)
 		return
 	}
 
-	session.Log.Debug("[%s] Cloning %s in to %s", url, ref, strings.Replace(dir, *session.Options.TempDirectory, "", -1))
-	matchedAny = checkSignatures(dir, url, stars, source)
-	if !matchedAny {
-		os.RemoveAll(dir)
+	if cloneDir != "" {
+		session.Log.Debug("[%s] Partially cloned %s in to %s", url, ref, cloneDir)
+	} else {
+		session.Log.Debug("[%s] Cloned %s in to memory", url, ref)
 	}
+
+	session.QueueScan(core.ScanJob{Dir: dir, Url: url, Stars: stars, Metadata: metadata, Source: source, Repository: repository, CloneDir: cloneDir, Rescan: rescan})
 }
 
-func checkSignatures(dir string, url string, stars int, source core.GitResourceType) (matchedAny bool) {
-	for _, file := range core.GetMatchingFiles(dir) {
-		var (
-			matches          []string
-			relativeFileName string
-		)
-		if strings.Contains(dir, *session.Options.TempDirectory) {
-			relativeFileName = strings.Replace(file.Path, *session.Options.TempDirectory, "", -1)
-		} else {
-			relativeFileName = strings.Replace(file.Path, dir, "", -1)
+// scanPushDiff queues the lines added by a push event to be scanned,
+// returning false if the repository should be cloned instead
+func scanPushDiff(repository core.GitResource, repo *github.Repository) bool {
+	url := repo.GetCloneURL()
+	dir := filepath.Join(*session.Options.TempDirectory, core.GetHash(url))
+	files, commits, err := core.GetPushDiffFiles(session, repository.GitHub, repo, repository.Before, repository.Head, dir)
+
+	if err != nil {
+		session.Log.Debug("[%s] Cloning instead of scanning push diff: %s", url, err)
+		return false
+	}
+
+	if len(commits) == 0 {
+		session.Log.Debug("[%s] Skipping, every commit in %s..%s was already scanned", url, repository.Before, repository.Head)
+		return true
+	}
+
+	session.Log.Debug("[%s] Fetched %d changed files in %s..%s", url, len(files), repository.Before, repository.Head)
+	session.QueueScan(core.ScanJob{
+		Dir:      dir,
+		Url:      url,
+		Stars:    repo.GetStargazersCount(),
+		Metadata: core.NewRepositoryMetadata(repo),
+		Source:   core.GITHUB_SOURCE,
+		Branch:   strings.TrimPrefix(repository.Ref, "refs/heads/"),
+		Head:     repository.Head,
+		Files:    files,
+		Commits:  commits,
+	})
+
+	return true
+}
+
+// checkHistory scans files added in the commit history of the repository,
+// if enabled with --history-depth, passing over the commits already
+// scanned and adding the rest to the job's. It must run before
+// checkSignatures, which removes unmatched files (including the .git
+// directory) as it goes
+func checkHistory(job *core.ScanJob) bool {
+	if *session.Options.HistoryDepth == 0 {
+		return false
+	}
+
+	var scanned func(commit string) bool
+	if !job.Rescan {
+		scanned = func(commit string) bool {
+			return session.ScanCache.Has(job.Url, commit)
 		}
+	}
 
-		if *session.Options.SearchQuery != "" {
-			queryRegex := regexp.MustCompile(*session.Options.SearchQuery)
-			for _, match := range queryRegex.FindAllSubmatch(file.Contents, -1) {
-				matches = append(matches, string(match[0]))
+	files, commits, err := core.GetHistoryFiles(job.Repository, job.Dir, *session.Options.HistoryDepth, scanned)
+	if err != nil {
+		session.Log.Debug("[%s] Failed to walk commit history: %s", job.Url, err)
+	}
+
+	job.Commits = append(job.Commits, commits...)
+	return checkFiles(files, *job)
+}
+
+func checkSignatures(job core.ScanJob) bool {
+	return checkFiles(core.ListMatchingFiles(job.Dir), job)
+}
+
+func checkFiles(files []core.MatchFile, job core.ScanJob) (matchedAny bool) {
+	if *session.Options.ScanArchives {
+		files = core.ExpandArchives(files)
+	}
+	files = core.ExpandNotebooks(files)
+
+	// access key IDs are paired with secrets in other files of their commit
+	if session.Config.AwsPairs.Enabled || *session.Options.Verify {
+		job.AwsSecretKeys = core.IndexAwsSecretKeys(files)
+	}
+	job.Attributor = core.NewAttributor(job.Repository)
+
+	for _, file := range files {
+		if !file.Lazy {
+			matchedAny = checkFile(file, job) || matchedAny
+			continue
+		}
+
+		// files on disk are read and checked a window at a time
+		err := session.ReadWindows(file, func(window core.MatchFile) {
+			matchedAny = checkFile(window, job) || matchedAny
+		})
+
+		if err != nil {
+			session.Log.Debug("[%s] Failed to read %s: %s", job.Url, file.Path, err)
+		}
+	}
+
+	return
+}
+
+// checkFile checks a file, or a window of one, against the signatures
+func checkFile(file core.MatchFile, job core.ScanJob) (matchedAny bool) {
+	dir, url, stars, metadata, source := job.Dir, job.Url, job.Stars, job.Metadata, job.Source
+
+	// matching files are saved for review as they are, not as their text
+	original := file
+	if *session.Options.ExtractDocuments && core.IsDocument(file.Path) {
+		text, err := file.ExtractText()
+		if err != nil {
+			session.Log.Debug("[%s] Failed to extract the text of %s: %s", url, file.Path, err)
+		}
+		file.Contents = text
+	} else if *session.Options.ExtractStrings && file.IsBinary() {
+		file.Contents = file.ExtractStrings()
+	}
+
+	var (
+		relativeFileName string
+		displayFileName  string
+		matchedFile      bool
+	)
+	// findings are deduplicated and allowlisted on the path within the repository
+	repositoryPath := strings.TrimPrefix(file.Path, filepath.ToSlash(dir))
+	if repositoryPath == "" {
+		repositoryPath = "/" + file.Filename
+	}
+
+	if strings.Contains(dir, *session.Options.TempDirectory) {
+		relativeFileName = strings.Replace(file.Path, *session.Options.TempDirectory, "", -1)
+	} else {
+		relativeFileName = strings.Replace(file.Path, dir, "", -1)
+	}
+
+	// scanning a single file rather than a directory
+	if relativeFileName == "" {
+		relativeFileName = "/" + file.Filename
+	}
+
+	scanned := len(file.Contents)
+	if file.Limit > 0 {
+		scanned = file.Limit
+	}
+
+	if file.Offset == 0 {
+		session.Metrics.Inc(core.MetricFilesScanned)
+	}
+	session.Metrics.Add(core.MetricBytesProcessed, float64(scanned))
+	core.QueueDiscoveredBuckets(session, file.Contents)
+
+	displayFileName = relativeFileName
+	if file.Commit != "" {
+		displayFileName = fmt.Sprintf("%s (commit %.7s)", relativeFileName, file.Commit)
+	}
+
+	// the commit that added the file for history, otherwise the one scanned
+	commit := file.Commit
+	if commit == "" {
+		commit = job.Head
+	}
+
+	// findings are tagged with the watch_terms the file mentions, to route
+	// them by
+	watched := session.MatchWatchTerms(file)
+	watchTerms := core.WatchTermNames(watched)
+
+	if *session.Options.SearchQuery != "" {
+		queryRegex := regexp.MustCompile(*session.Options.SearchQuery)
+
+		if found := session.FilterNewFindings(url, repositoryPath, file.InWindow(core.FindContentsMatches(queryRegex, file.Contents))); found != nil {
+			matchedAny, matchedFile = true, true
+			matches, offsets := core.SplitContentsMatches(found)
+			count := len(matches)
+			lines, columns, offsets := file.GetPositions(offsets)
+			fingerprints := core.Fingerprints(repositoryPath, matches)
+			entropy := core.GetAverageEntropy(matches)
+			matches = session.RedactMatches(matches)
+			m := locateMatches(matches, nil, lines, columns)
+			session.Log.Important("[%s] %d %s for %s in file %s: %s", url, count, core.Pluralize(count, "match", "matches"), color.GreenString("Search Query"), displayFileName, color.YellowString(m))
+
+			publish(&core.MatchEvent{Source: source, Url: url, Matches: matches, Lines: lines, Columns: columns, Offsets: offsets, Fingerprints: fingerprints, WatchTerms: watchTerms, Tenants: session.FindingTenants(watched, repositoryPath, matches), Signature: "Search Query", File: relativeFileName, Stars: stars, Metadata: metadata, Branch: job.Branch, Commit: commit, Entropy: entropy, Severity: core.SearchQuerySeverity, Confidence: core.SearchQueryConfidence})
+		}
+	} else if context := session.Contexts.FileContext(file, repositoryPath); context != "" {
+		session.Log.Debug("[%s] Skipping %s, which is in the %s context", url, displayFileName, context)
+	} else {
+		for _, watchedTerm := range watched {
+			term := watchedTerm.Term
+			if !term.Report || !session.IsReportable(term.FindingSeverity()) {
+				continue
 			}
 
-			if matches != nil {
+			if found := session.FilterNewFindings(url, repositoryPath, watchedTerm.Matches); found != nil {
+				matchedAny, matchedFile = true, true
+				matches, offsets := core.SplitContentsMatches(found)
 				count := len(matches)
-				m := strings.Join(matches, ", ")
-				session.Log.Important("[%s] %d %s for %s in file %s: %s", url, count, core.Pluralize(count, "match", "matches"), color.GreenString("Search Query"), relativeFileName, color.YellowString(m))
-				session.WriteToCsv([]string{url, "Search Query", relativeFileName, m})
+				lines, columns, offsets := file.GetPositions(offsets)
+				fingerprints := core.Fingerprints(repositoryPath, matches)
+				entropy := core.GetAverageEntropy(matches)
+				m := locateMatches(matches, nil, lines, columns)
+				publish(&core.MatchEvent{Source: source, Url: url, Matches: matches, Lines: lines, Columns: columns, Offsets: offsets, Fingerprints: fingerprints, WatchTerms: []string{term.Name}, Tenants: session.FindingTenants([]core.WatchTermMatch{watchedTerm}, repositoryPath, matches), Signature: core.WatchTermSignatureName, File: relativeFileName, Stars: stars, Metadata: metadata, Branch: job.Branch, Commit: commit, Entropy: entropy, Severity: term.FindingSeverity(), Confidence: term.FindingConfidence()})
+				session.Log.Important("[%s] %d %s for %s %s in file %s: %s%s", url, count, core.Pluralize(count, "mention", "mentions"), color.GreenString(core.WatchTermSignatureName), term.Name, displayFileName, color.YellowString(m), severityTag(term.FindingSeverity()))
+			}
+		}
+
+		checkEntropy := false
+		for _, result := range session.CurrentMatcher().Match(file, func(signature core.Signature) bool { return session.IsReportable(signature.Severity()) }) {
+			signature := result.Signature
+			if result.Part != core.PartContents {
+				// a file's path is the same in every window of it
+				if *session.Options.PathChecks && file.Offset == 0 && session.IsReportable(signature.Severity()) && session.IsNewPathFinding(url, repositoryPath, signature.Name()) {
+					matchedAny, matchedFile = true, true
+					publish(&core.MatchEvent{Source: source, Url: url, Fingerprints: []string{core.Fingerprint(repositoryPath, signature.Name())}, WatchTerms: watchTerms, Tenants: session.FindingTenants(watched, repositoryPath, []string{signature.Name()}), Signature: signature.Name(), File: relativeFileName, Stars: stars, Metadata: metadata, Branch: job.Branch, Commit: commit, Severity: signature.Severity(), Confidence: signature.Confidence()})
+					session.Log.Important("[%s] Matching file %s for %s%s%s%s", url, color.YellowString(displayFileName), color.GreenString(signature.Name()), severityTag(signature.Severity()), watchTermsTag(watchTerms), newRepositoryTag(metadata))
+				}
+
+				checkEntropy = true
+				continue
 			}
-		} else {
-			for _, signature := range session.Signatures {
-				if matched, part := signature.Match(file); matched {
-					matchedAny = true
-
-					if part == core.PartContents {
-						if matches = signature.GetContentsMatches(file.Contents); matches != nil {
-							count := len(matches)
-							m := strings.Join(matches, ", ")
-							publish(&MatchEvent{Source: source, Url: url, Matches: matches, Signature: signature.Name(), File: relativeFileName, Stars: stars})
-							session.Log.Important("[%s] %d %s for %s in file %s: %s", url, count, core.Pluralize(count, "match", "matches"), color.GreenString(signature.Name()), relativeFileName, color.YellowString(m))
-							session.WriteToCsv([]string{url, signature.Name(), relativeFileName, m})
-						}
-					} else {
-						if *session.Options.PathChecks {
-							publish(&MatchEvent{Source: source, Url: url, Matches: matches, Signature: signature.Name(), File: relativeFileName, Stars: stars})
-							session.Log.Important("[%s] Matching file %s for %s", url, color.YellowString(relativeFileName), color.GreenString(signature.Name()))
-							session.WriteToCsv([]string{url, signature.Name(), relativeFileName, ""})
-						}
 
-						if *session.Options.EntropyThreshold > 0 && file.CanCheckEntropy() {
-							scanner := bufio.NewScanner(bytes.NewReader(file.Contents))
+			severity, confidence := signature.Severity(), signature.Confidence()
+			var awsSecrets []string
+			if signature.Verifier() == core.VerifierAws {
+				// access key IDs are rated by whether their secret is nearby
+				severity, confidence, awsSecrets = session.PairAwsCredentials(file, job.AwsSecretKeys, severity, confidence)
+				if !session.IsReportable(severity) {
+					continue
+				}
+			}
 
-							for scanner.Scan() {
-								line := scanner.Text()
+			if found := session.FilterNewFindings(url, repositoryPath, session.FilterExpiredTokens(session.Contexts.Filter(file, result.Matches))); found != nil {
+				matchedAny, matchedFile = true, true
+				matches, offsets := core.SplitContentsMatches(found)
+				count := len(matches)
+				lines, columns, offsets := file.GetPositions(offsets)
+				keys := core.ContentsMatchKeys(found)
+				privateKeys := core.ParsePrivateKeys(found, file.Contents)
+				tokens := core.ParseJsonWebTokens(found)
+				attributions := job.Attributor.Attribute(file, repositoryPath, lines)
+				fingerprints := core.Fingerprints(repositoryPath, matches)
+				entropy := core.GetAverageEntropy(matches)
+				// canaries alert whoever planted them once they're used, so
+				// aren't verified
+				canary := session.Canaries.Check(file, repositoryPath, matches)
+				var verified bool
+				if canary != "" {
+					session.Log.Debug("[%s] Not verifying %s in %s, likely a canary: %s", url, signature.Name(), displayFileName, canary)
+				} else if *session.Options.Verify && signature.Verifier() == core.VerifierAws {
+					verified = core.VerifyAwsCredentials(matches, awsSecrets)
+				} else if *session.Options.Verify {
+					verified = core.VerifyMatches(signature.Verifier(), matches, file.Contents)
+				}
+				severity, confidence = session.RateExpiredTokens(tokens, count, severity, confidence)
+				if verified {
+					confidence = core.ConfidenceHigh
+				}
+				event := &core.MatchEvent{Source: source, Url: url, Matches: matches, Lines: lines, Columns: columns, Offsets: offsets, Keys: keys, PrivateKeys: privateKeys, Tokens: tokens, Attributions: attributions, Fingerprints: fingerprints, WatchTerms: watchTerms, Tenants: session.FindingTenants(watched, repositoryPath, matches), Signature: signature.Name(), File: relativeFileName, Stars: stars, Metadata: metadata, Branch: job.Branch, Commit: commit, Entropy: entropy, Verified: verified, Severity: severity, Confidence: confidence}
+				if verified && session.Config.Revocation.Enabled() {
+					event.Revoked = session.RevokeMatches(signature.Verifier(), event, file.Contents)
+				}
+				if !session.TagCanary(event, canary) {
+					continue
+				}
+				matches = session.RedactMatches(matches)
+				event.Matches = matches
+				m := locateMatches(matches, keys, lines, columns)
+				publish(event)
+				session.Log.Important("[%s] %d %s for %s in file %s: %s%s%s%s%s%s", url, count, core.Pluralize(count, "match", "matches"), color.GreenString(signature.Name()), displayFileName, color.YellowString(m), severityTag(event.Severity), verifiedTag(verified), canaryTag(event), watchTermsTag(watchTerms), newRepositoryTag(metadata))
+				logPrivateKeys(url, displayFileName, privateKeys)
+				logJsonWebTokens(url, displayFileName, tokens)
+				logAttributions(url, displayFileName, attributions)
+			}
+		}
 
-								if len(line) > 6 && len(line) < 100 {
-									entropy := core.GetEntropy(line)
+		// files matching a path signature are checked for high entropy strings
+		if checkEntropy && *session.Options.EntropyThreshold > 0 && session.IsReportable(core.EntropySeverity) && file.CanCheckEntropy() {
+			scanner := bufio.NewScanner(bytes.NewReader(file.Contents))
 
-									if entropy >= *session.Options.EntropyThreshold {
-										blacklistedMatch := false
+			for lineNumber, lineStart := 1, 0; scanner.Scan() && (file.Limit <= 0 || lineStart < file.Limit); lineNumber++ {
+				line := scanner.Text()
+				lineStart += len(line) + 1
 
-										for _, blacklistedString := range session.Config.BlacklistedStrings {
-											if strings.Contains(strings.ToLower(line), strings.ToLower(blacklistedString)) {
-												blacklistedMatch = true
-											}
-										}
+				for _, finding := range core.FindHighEntropyTokens(line, session.Config.Entropy) {
+					blacklistedMatch := core.IsBlacklistedMatch(line)
+					column := strings.Index(line, finding.Token) + 1
+					offset := core.GetOffset(file.Contents, lineNumber, column)
+					if lineNumber == 1 {
+						column += file.Column
+					}
 
-										if !blacklistedMatch {
-											publish(&MatchEvent{Source: source, Url: url, Matches: []string{line}, Signature: "High entropy string", File: relativeFileName, Stars: stars})
-											session.Log.Important("[%s] Potential secret in %s = %s", url, color.YellowString(relativeFileName), color.GreenString(line))
-											session.WriteToCsv([]string{url, "High entropy string", relativeFileName, line})
-										}
-									}
-								}
-							}
+					if !blacklistedMatch && session.Contexts.MatchContext(file, core.ContentsMatch{Value: finding.Token, Offset: offset}) == "" && session.IsNewFinding(url, repositoryPath, finding.Token) {
+						token := session.RedactMatches([]string{finding.Token})[0]
+						attributions := job.Attributor.Attribute(file, repositoryPath, []int{file.Lines + lineNumber})
+						event := &core.MatchEvent{Source: source, Url: url, Matches: []string{token}, Lines: []int{file.Lines + lineNumber}, Columns: []int{column}, Offsets: []int{file.Offset + offset}, Attributions: attributions, Fingerprints: []string{core.Fingerprint(repositoryPath, finding.Token)}, WatchTerms: watchTerms, Tenants: session.FindingTenants(watched, repositoryPath, []string{finding.Token}), Signature: "High entropy string", File: relativeFileName, Stars: stars, Metadata: metadata, Branch: job.Branch, Commit: commit, Entropy: finding.Entropy, Severity: core.EntropySeverity, Confidence: core.EntropyConfidence}
+						if !session.TagCanary(event, session.Canaries.Check(file, repositoryPath, []string{finding.Token})) {
+							continue
 						}
+						matchedAny, matchedFile = true, true
+						publish(event)
+						session.Log.Important("[%s] Potential secret in %s = %s (%s entropy %.2f)%s%s%s", url, color.YellowString(displayFileName), color.GreenString(token), finding.Charset, finding.Entropy, canaryTag(event), watchTermsTag(watchTerms), newRepositoryTag(metadata))
+						logAttributions(url, displayFileName, attributions)
 					}
 				}
 			}
 		}
+	}
 
-		if !matchedAny && len(*session.Options.Local) <= 0 {
-			os.Remove(file.Path)
+	// clones only exist in memory, so write out matching files for review.
+	// Never touch the user's own files, nor notebooks kept without contents
+	if matchedFile && file.Commit == "" && len(original.Contents) > 0 && strings.HasPrefix(file.Path, filepath.ToSlash(*session.Options.TempDirectory)) {
+		if err := core.SaveMatchFile(original); err != nil {
+			session.Log.Debug("[%s] Failed to save %s: %s", url, relativeFileName, err)
 		}
 	}
+
 	return
 }
 
-func publish(event *MatchEvent) {
+// logPrivateKeys shows the type, size and fingerprint of private keys found
+// in a file, to look for in authorized_keys and certificates
+func logPrivateKeys(url string, file string, keys []*core.PrivateKey) {
+	for _, key := range keys {
+		if key != nil {
+			session.Log.Important("[%s] Private key in file %s is %s", url, file, key)
+		}
+	}
+}
+
+// logJsonWebTokens shows who issued JWTs found in a file, for whom and when
+// they expire
+func logJsonWebTokens(url string, file string, tokens []*core.JsonWebToken) {
+	for _, token := range tokens {
+		if token != nil {
+			session.Log.Important("[%s] JWT in file %s is %s", url, file, token)
+		}
+	}
+}
+
+// logAttributions shows who committed the findings in a file, once each
+func logAttributions(url string, file string, attributions []*core.Attribution) {
+	logged := make(map[string]bool)
+	for _, attribution := range attributions {
+		if attribution != nil && !logged[attribution.Commit] {
+			logged[attribution.Commit] = true
+			session.Log.Important("[%s] Committed to %s by %s", url, file, attribution)
+		}
+	}
+}
+
+// locateMatches lists matches with the line and column each was found at,
+// and the key of those in config files
+func locateMatches(matches []string, keys []string, lines []int, columns []int) string {
+	located := make([]string, len(matches))
+	for i, match := range matches {
+		if i < len(keys) && keys[i] != "" {
+			located[i] = fmt.Sprintf("%s (%s at %d:%d)", match, keys[i], lines[i], columns[i])
+		} else {
+			located[i] = fmt.Sprintf("%s (%d:%d)", match, lines[i], columns[i])
+		}
+	}
+
+	return strings.Join(located, ", ")
+}
+
+func severityTag(severity string) string {
+	switch severity {
+	case core.SeverityCritical:
+		return color.RedString(" [CRITICAL]")
+	case core.SeverityHigh:
+		return color.MagentaString(" [HIGH]")
+	}
+
+	return ""
+}
+
+func watchTermsTag(watchTerms []string) string {
+	if len(watchTerms) > 0 {
+		return color.CyanString(" [%s]", strings.Join(watchTerms, ", "))
+	}
+
+	return ""
+}
+
+// newRepositoryTag marks findings in GitHub repositories created minutes
+// before they were scanned, with what else is known about them
+func newRepositoryTag(metadata *core.RepositoryMetadata) string {
+	if metadata != nil && metadata.New {
+		return color.RedString(" [NEW REPOSITORY: %s]", metadata)
+	}
+
+	return ""
+}
+
+func canaryTag(event *core.MatchEvent) string {
+	if event.LikelyCanary {
+		return color.CyanString(" [LIKELY CANARY: %s]", event.CanaryReason)
+	}
+
+	return ""
+}
+
+func verifiedTag(verified bool) string {
+	if verified {
+		return color.RedString(" [VERIFIED]")
+	}
+
+	return ""
+}
+
+func publish(event *core.MatchEvent) {
+	if event.Parent == "" {
+		event.Parent = session.Forks.Root(event.Url)
+	}
+	session.Metrics.Inc(core.MetricMatches, "signature", event.Signature)
+	session.RecordFailure(event)
+
+	if *session.Options.Role == core.RoleWorker {
+		session.ReportFinding(event)
+		return
+	}
+
+	session.WriteToCsv(event)
+	session.WriteToOutput(event)
+	session.Publish(event)
+
 	// todo: implement a modular plugin system to handle the various outputs (console, live, csv, webhooks, etc)
 	if len(*session.Options.Live) > 0 {
 		data, _ := json.Marshal(event)
@@ -201,36 +846,193 @@ func publish(event *MatchEvent) {
 	}
 }
 
+// logWithinExitPolicy explains why a scan that found something still passes
+func logWithinExitPolicy(path string) {
+	severity := "any"
+	if *session.Options.FailOn != "" {
+		severity = "at least " + *session.Options.FailOn
+	}
+
+	failures := session.Failures()
+	session.Log.Info("[*] %d %s of %s severity in %s, which only fails with more than %d", failures, core.Pluralize(failures, "finding", "findings"), severity, color.BlueString(path), *session.Options.MaxFindings)
+}
+
+func reportBaseline() {
+	if session.Baseline != nil {
+		session.Log.Info("[*] Wrote %d findings to baseline %s", session.Baseline.Count(), color.BlueString(*session.Options.Baseline))
+	}
+}
+
+// showSpinner spins on stderr while waiting, unless logging for a log
+// pipeline that would collect it
+func showSpinner() {
+	if *session.Options.LogFormat == core.LogFormatText && *session.Options.LogFile == "" {
+		core.ShowSpinner()
+	}
+}
+
 func main() {
-	session.Log.Info(color.HiBlueString(core.Banner))
-	session.Log.Info("\t%s\n", color.HiCyanString(core.Author))
+	switch session.Options.Command {
+	case core.CommandHook:
+		rc := runHook()
+		reportBaseline()
+		session.WaitForSinks()
+		os.Exit(rc)
+	case core.CommandTune:
+		os.Exit(runTune())
+	case core.CommandReport:
+		os.Exit(runReport())
+	case core.CommandLogin:
+		os.Exit(runLogin())
+	case core.CommandTestSignatures:
+		os.Exit(runTestSignatures())
+	case core.CommandBench:
+		os.Exit(runBench())
+	}
+
+	if *session.Options.LogFormat == core.LogFormatText && *session.Options.LogFile == "" {
+		session.Log.Info("%s", color.HiBlueString(core.Banner))
+		session.Log.Info("\t%s\n", color.HiCyanString(core.Author))
+	}
 	session.Log.Info("[*] Loaded %s signatures. Using %s worker threads. Temp work dir: %s\n", color.BlueString("%d", len(session.Signatures)), color.BlueString("%d", *session.Options.Threads), color.BlueString(*session.Options.TempDirectory))
 
 	if len(*session.Options.Local) > 0 {
-		session.Log.Info("[*] Scanning local directory: %s - skipping public repository checks...", color.BlueString(*session.Options.Local))
+		session.Log.Info("[*] Scanning local path: %s - skipping public repository checks...", color.BlueString(*session.Options.Local))
 		rc := 0
-		if checkSignatures(*session.Options.Local, *session.Options.Local, -1, core.LOCAL_SOURCE) {
-			rc = 1
+		matchedAny := false
+
+		job := core.ScanJob{Dir: *session.Options.Local, Url: *session.Options.Local, Stars: -1, Source: core.LOCAL_SOURCE}
+		repository, err := core.OpenRepository(*session.Options.Local)
+		if err == nil {
+			job.Branch, job.Head = core.GetHead(repository)
+			job.Repository = repository
+		}
+
+		if *session.Options.HistoryDepth != 0 {
+			if err == nil {
+				matchedAny = checkHistory(&job)
+			} else {
+				session.Log.Warn("Not scanning history, %s is not a git repository: %s", *session.Options.Local, err)
+			}
+		}
+
+		if checkSignatures(job) || matchedAny {
+			if session.Failed() {
+				rc = *session.Options.ExitCode
+			} else {
+				logWithinExitPolicy(*session.Options.Local)
+			}
 		} else {
 			session.Log.Info("[*] No matching secrets found in %s!", color.BlueString(*session.Options.Local))
 		}
+		reportBaseline()
+		session.WaitForSinks()
 		os.Exit(rc)
 	} else {
 		if *session.Options.SearchQuery != "" {
 			session.Log.Important("Search Query '%s' given. Only returning matching results.", *session.Options.SearchQuery)
 		}
 
-		go core.GetRepositories(session)
-		go ProcessRepositories()
+		session.CleanTempDirectory()
+		go core.EnforceDiskQuota(session)
+		go core.WatchConfig(session)
+
+		// workers only clone and scan what the coordinator queues
+		if *session.Options.Role == core.RoleWorker {
+			session.Log.Info("[*] Running as a worker. Waiting for jobs on %s", color.BlueString(session.Config.Distributed.Queue))
+			go core.ReceiveJobs(session)
+			go ProcessRepositories()
+			go ProcessScanJobs()
+			go core.HandleShutdown(session)
+
+			showSpinner()
+			select {}
+		}
+
+		for _, instance := range session.GitHub {
+			if *session.Options.GitHubFirehose {
+				go core.GetRepositories(session, instance)
+			}
+			go core.WatchGitHub(session, instance)
+			go core.SearchGitHub(session, instance)
+		}
+		go core.RunSchedules(session)
 		go ProcessComments()
+		go ProcessScanJobs()
+
+		if *session.Options.Role == core.RoleCoordinator {
+			go core.DispatchJobs(session)
+			go ProcessFindings()
+		} else {
+			go ProcessRepositories()
+		}
 
 		if *session.Options.ProcessGists {
-			go core.GetGists(session)
-			go ProcessGists()
+			for _, instance := range session.GitHub {
+				if *session.Options.GitHubFirehose {
+					go core.GetGists(session, instance)
+				}
+				go core.WatchGitHubGists(session, instance)
+			}
+
+			if *session.Options.Role != core.RoleCoordinator {
+				go ProcessGists()
+			}
+		}
+
+		if *session.Options.ProcessDocker {
+			go core.GetDockerHubImages(session)
+			go core.GetRegistryImages(session)
 		}
 
-		spinny := core.ShowSpinner()
+		if *session.Options.ProcessBuckets {
+			go core.GetBuckets(session)
+		}
+
+		if *session.Options.ProcessGitLab {
+			go core.GetGitLabProjects(session)
+			go core.GetGitLabSnippets(session)
+			go core.SearchGitLab(session)
+		}
+
+		if *session.Options.ProcessGitea {
+			go core.GetGiteaRepositories(session)
+			go core.GetGiteaActivities(session)
+		}
+
+		if *session.Options.ProcessAzureDevOps {
+			go core.GetAzureDevOpsRepositories(session)
+		}
+
+		if *session.Options.ProcessPastes {
+			go core.GetPastebinPastes(session)
+			go ProcessPastes()
+		}
+
+		if *session.Options.ProcessPackages {
+			if session.Config.Packages.Watches(core.NpmRegistry) {
+				go core.GetNpmPackages(session)
+			}
+
+			if session.Config.Packages.Watches(core.PypiRegistry) {
+				go core.GetPypiPackages(session)
+			}
+
+			if session.Config.Packages.Watches(core.RubygemsRegistry) {
+				go core.GetRubygemsPackages(session)
+			}
+		}
+
+		if *session.Options.ProcessBitbucket {
+			go core.GetBitbucketRepositories(session)
+			go core.GetBitbucketSnippets(session)
+			go core.SearchBitbucket(session)
+		}
+
+		session.ResumeCheckpoint()
+		go core.HandleShutdown(session)
+
+		showSpinner()
 		select {}
-		spinny()
 	}
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/eth0izzle/shhgit/core"
+)
+
+// runReport renders the findings stored in the database, or else written to
+// a jsonl --output-path, as a standalone HTML report and returns the exit
+// code
+func runReport() int {
+	var findings []core.StoredFinding
+	var err error
+
+	switch {
+	case session.Database != nil:
+		findings, err = session.Database.GetAllFindings(*session.Options.Days)
+	case *session.Options.OutputPath != "" && *session.Options.Format == core.FormatJsonl:
+		findings, err = core.ReadJsonlFindings(*session.Options.OutputPath, *session.Options.Days)
+	default:
+		session.Log.Error("report renders the findings stored in a database or jsonl file. Set --database-url, or --output-path with --format jsonl")
+		return 2
+	}
+
+	if err != nil {
+		session.Log.Error("Failed to read findings: %s", err)
+		return 2
+	}
+
+	period := "all time"
+	if *session.Options.Days > 0 {
+		period = fmt.Sprintf("the last %d days", *session.Options.Days)
+	}
+
+	file, err := os.Create(*session.Options.ReportPath)
+	if err != nil {
+		session.Log.Error("Failed to create %s: %s", *session.Options.ReportPath, err)
+		return 2
+	}
+	defer file.Close()
+
+	if err := core.NewReport(findings, period).Render(file); err != nil {
+		session.Log.Error("Failed to write report: %s", err)
+		return 2
+	}
+
+	session.Log.Info("[*] Wrote a report of %d findings from %s to %s", len(findings), period, *session.Options.ReportPath)
+	return 0
+}
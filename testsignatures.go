@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eth0izzle/shhgit/core"
+)
+
+// runTestSignatures runs the signatures of config.yaml and any signature
+// packs against the fixtures defined alongside them, and returns the exit
+// code: 1 if any signature failed
+func runTestSignatures() int {
+	results, untested := core.RunSignatureTests(session, session.Config.Signatures)
+
+	failed := 0
+	for _, result := range results {
+		if !result.Passed() {
+			failed++
+		}
+	}
+
+	if *session.Options.Json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(struct {
+			Results  []core.SignatureTestResult
+			Untested []string
+		}{results, untested})
+
+		if err != nil {
+			session.Log.Error("Failed to write results: %s", err)
+			return 2
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RESULT\tSIGNATURE\tPART\tPOSITIVE\tNEGATIVE")
+
+		for _, result := range results {
+			status := "ok"
+			if !result.Passed() {
+				status = "FAIL"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", status, result.Signature, result.Part, result.Positive, result.Negative)
+		}
+		w.Flush()
+
+		for _, result := range results {
+			if result.Passed() {
+				continue
+			}
+
+			fmt.Printf("\n%s:\n", result.Signature)
+			if result.Error != "" {
+				fmt.Printf("  %s\n", result.Error)
+			}
+			for _, fixture := range result.Missed {
+				fmt.Printf("  didn't match positive fixture %q\n", fixture)
+			}
+			for _, fixture := range result.Matched {
+				fmt.Printf("  matched negative fixture %q\n", fixture)
+			}
+		}
+
+		fmt.Printf("\n%d of %d signatures with fixtures passed. %d %s no fixtures\n", len(results)-failed, len(results), len(untested), core.Pluralize(len(untested), "signature has", "signatures have"))
+	}
+
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
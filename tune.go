@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eth0izzle/shhgit/core"
+)
+
+// runTune prints how each signature has performed, from the findings and
+// their triage in the database, and returns the exit code
+func runTune() int {
+	if session.Database == nil {
+		session.Log.Error("tune reports on the findings stored in a database. Set --database-url or database_url in config.yaml")
+		return 2
+	}
+
+	stats, err := session.Database.GetSignatureStats(*session.Options.Days)
+	if err != nil {
+		session.Log.Error("Failed to get signature stats from database: %s", err)
+		return 2
+	}
+
+	report := core.TuneSignatures(stats, session.CurrentSignatures())
+
+	if *session.Options.Json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			session.Log.Error("Failed to write report: %s", err)
+			return 2
+		}
+
+		return 0
+	}
+
+	period := "all time"
+	if *session.Options.Days > 0 {
+		period = fmt.Sprintf("the last %d days", *session.Options.Days)
+	}
+	fmt.Printf("Signature performance over %s\n\n", period)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SUGGESTION\tSIGNATURE\tSEVERITY\tFINDINGS\tLAST 7 DAYS\tFALSE POSITIVES\tCONFIRMED\tAVG ENTROPY\tREASON")
+
+	for _, s := range report {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%.2f\t%s\n", s.Suggestion, s.Signature, s.Severity, s.Findings, s.LastWeek, s.FalsePositives, s.Confirmed, s.AverageEntropy, s.Reason)
+	}
+
+	w.Flush()
+	return 0
+}